@@ -5,7 +5,7 @@ import (
 )
 
 func TestSimpleSumAgg(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
@@ -34,7 +34,7 @@ func TestSimpleSumAgg(t *testing.T) {
 }
 
 func TestMinStringAgg(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	sa := MinAggState[string]{}
@@ -62,7 +62,7 @@ func TestMinStringAgg(t *testing.T) {
 }
 
 func TestSimpleCountAgg(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	sa := CountAggState{}
@@ -90,7 +90,7 @@ func TestSimpleCountAgg(t *testing.T) {
 }
 
 func TestMultiAgg(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	ca := CountAggState{}
@@ -127,7 +127,7 @@ func TestMultiAgg(t *testing.T) {
 }
 
 func TestGbyCountAgg(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	hf.insertTuple(&t2, tid)
@@ -167,7 +167,7 @@ func TestGbyCountAgg(t *testing.T) {
 }
 
 func TestGbySumAgg(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	hf.insertTuple(&t1, tid)
@@ -207,7 +207,7 @@ func TestGbySumAgg(t *testing.T) {
 }
 
 func TestFilterCountAgg(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 
@@ -245,7 +245,7 @@ func TestFilterCountAgg(t *testing.T) {
 }
 
 func TestRepeatedIteration(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	sa := CountAggState{}
@@ -22,7 +22,8 @@ type AggState interface {
 	// Makes an copy of the aggregation state.
 	Copy() AggState
 
-	// Adds an tuple to the aggregation state.
+	// Adds an tuple to the aggregation state.  Tuples for which the expr is
+	// NULL are skipped, as in SQL.
 	AddTuple(*Tuple)
 
 	// Returns the final result of the aggregation as a tuple.
@@ -32,7 +33,8 @@ type AggState interface {
 	GetTupleDesc() *TupleDesc
 }
 
-// Implements the aggregation state for COUNT
+// Implements the aggregation state for COUNT, which counts the tuples for which
+// expr is not NULL, or all tuples if expr is nil, as for COUNT(*)
 type CountAggState struct {
 	alias string
 	expr  Expr
@@ -51,6 +53,12 @@ func (a *CountAggState) Init(alias string, expr Expr, getter func(DBValue) any)
 }
 
 func (a *CountAggState) AddTuple(t *Tuple) {
+	if a.expr != nil {
+		v, err := a.expr.EvalExpr(t)
+		if err != nil || isNull(v) {
+			return
+		}
+	}
 	a.count++
 }
 
@@ -70,19 +78,21 @@ func (a *CountAggState) GetTupleDesc() *TupleDesc {
 	return &td
 }
 
-// Implements the aggregation state for SUM
+// Implements the aggregation state for SUM, which is NULL if there are no
+// values that are not NULL
 type SumAggState[T Number] struct {
 	// TODO: some code goes here
 	// TODO add fields that can help implement the aggregation state
 	alias  string
 	expr   Expr
 	sum    T
+	null   bool // whether the agg state has not added any value yet
 	getter func(DBValue) any
 }
 
 func (a *SumAggState[T]) Copy() AggState {
 	// TODO: some code goes here
-	return &SumAggState[T]{a.alias, a.expr, a.sum, a.getter}
+	return &SumAggState[T]{a.alias, a.expr, a.sum, a.null, a.getter}
 }
 
 func intAggGetter(v DBValue) any {
@@ -96,9 +106,64 @@ func stringAggGetter(v DBValue) any {
 	return stringV.Value
 }
 
+func floatAggGetter(v DBValue) any {
+	return numericFloat(v)
+}
+
+// Return a getter that reads a decimal or an integer as an integer, multiplied
+// by 10^scale
+func decimalAggGetter(scale int) func(DBValue) any {
+	return func(v DBValue) any {
+		return scaledInt64(v, scale)
+	}
+}
+
+// Return a getter that reads a date or a timestamp as an integer, its number of
+// days or microseconds
+func timeAggGetter(v DBValue) any {
+	switch v := v.(type) {
+	case DateField:
+		return v.Value
+	case TimestampField:
+		return v.Value
+	}
+	return int64(0)
+}
+
+func boolAggGetter(v DBValue) any {
+	return v.(BoolField).Value
+}
+
+// Return the type of the values of expr that MIN and MAX return, and that SUM
+// adds up: a float, a decimal, a date, a timestamp, or otherwise an integer
+func aggValueType(expr Expr) DBType {
+	switch t := expr.GetExprType().Ftype; t.kind() {
+	case FloatType, DecimalType, DateType, TimestampType:
+		return t
+	}
+	return IntType
+}
+
+// Return v, a value read by the getter of a MIN or MAX of values of type t, as
+// a DBValue
+func aggValue(v any, t DBType) DBValue {
+	switch t.kind() {
+	case FloatType:
+		return FloatField{v.(float64)}
+	case DecimalType:
+		return DecimalField{v.(int64), t.scale()}
+	case DateType:
+		return DateField{v.(int64)}
+	case TimestampType:
+		return TimestampField{v.(int64)}
+	}
+	return IntField{v.(int64)}
+}
+
 func (a *SumAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any) error {
 	// TODO: some code goes here
 	a.sum = 0
+	a.null = true
 	a.expr = expr
 	a.alias = alias
 	a.getter = getter
@@ -108,16 +173,23 @@ func (a *SumAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any)
 func (a *SumAggState[T]) AddTuple(t *Tuple) {
 	// TODO: some code goes here
 	v, err := a.expr.EvalExpr(t)
-	if err != nil {
+	if err != nil || isNull(v) {
 		return
 	}
-	val := intAggGetter(v).(T)
+	val := a.getter(v).(T)
 	a.sum += val
+	a.null = false
 }
 
+// The sum of decimals has the scale of the decimals, and as many digits as
+// decimals can have
 func (a *SumAggState[T]) GetTupleDesc() *TupleDesc {
 	// TODO: some code goes here
-	ft := FieldType{a.alias, "", IntType}
+	t := aggValueType(a.expr)
+	if t.kind() == DecimalType {
+		t = decimalType(maxDecimalPrecision, t.scale())
+	}
+	ft := FieldType{a.alias, "", t}
 	fts := []FieldType{ft}
 	td := TupleDesc{}
 	td.Fields = fts
@@ -127,15 +199,23 @@ func (a *SumAggState[T]) GetTupleDesc() *TupleDesc {
 func (a *SumAggState[T]) Finalize() *Tuple {
 	// TODO: some code goes here
 	td := a.GetTupleDesc()
-	f := IntField{int64(a.sum)}
+	var f DBValue = IntField{int64(a.sum)}
+	switch t := td.Fields[0].Ftype; t.kind() {
+	case FloatType:
+		f = FloatField{float64(a.sum)}
+	case DecimalType:
+		f = DecimalField{int64(a.sum), t.scale()}
+	}
+	if a.null {
+		f = NullField{}
+	}
 	fs := []DBValue{f}
 	t := Tuple{*td, fs, nil}
 	return &t
 }
 
-// Implements the aggregation state for AVG
-// Note that we always AddTuple() at least once before Finalize()
-// so no worries for divide-by-zero
+// Implements the aggregation state for AVG, which is NULL if there are no
+// values that are not NULL, so no worries for divide-by-zero
 type AvgAggState[T Number] struct {
 	// TODO: some code goes here
 	// TODO add fields that can help implement the aggregation state
@@ -163,17 +243,25 @@ func (a *AvgAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any)
 
 func (a *AvgAggState[T]) AddTuple(t *Tuple) {
 	// TODO: some code goes here
-	a.count++
 	v, err := a.expr.EvalExpr(t)
-	if err != nil {
+	if err != nil || isNull(v) {
 		return
 	}
-	val := intAggGetter(v).(T)
+	a.count++
+	val := a.getter(v).(T)
 	a.sum += val
 }
+
+// The average of integers is truncated to an integer, while that of decimals
+// has divisionScaleIncrement more digits after the decimal point than the
+// decimals
 func (a *AvgAggState[T]) GetTupleDesc() *TupleDesc {
 	// TODO: some code goes here
-	ft := FieldType{a.alias, "", IntType}
+	t := aggValueType(a.expr)
+	if t.kind() == DecimalType {
+		t = decimalType(maxDecimalPrecision, arithScale("/", t.scale(), 0))
+	}
+	ft := FieldType{a.alias, "", t}
 	fts := []FieldType{ft}
 	td := TupleDesc{}
 	td.Fields = fts
@@ -183,15 +271,28 @@ func (a *AvgAggState[T]) GetTupleDesc() *TupleDesc {
 func (a *AvgAggState[T]) Finalize() *Tuple {
 	// TODO: some code goes here
 	td := a.GetTupleDesc()
-	f := IntField{int64(a.sum / a.count)}
+	if a.count == 0 {
+		return &Tuple{*td, []DBValue{NullField{}}, nil}
+	}
+	var f DBValue = IntField{int64(a.sum / a.count)}
+	switch t := td.Fields[0].Ftype; t.kind() {
+	case FloatType:
+		f = FloatField{float64(a.sum) / float64(a.count)}
+	case DecimalType:
+		sum := DecimalField{int64(a.sum), a.expr.GetExprType().Ftype.scale()}
+		avg, err := arith("/", sum, IntField{int64(a.count)})
+		if err != nil {
+			avg = NullField{}
+		}
+		f = avg
+	}
 	fs := []DBValue{f}
 	t := Tuple{*td, fs, nil}
 	return &t
 }
 
-// Implements the aggregation state for MAX
-// Note that we always AddTuple() at least once before Finalize()
-// so no worries for NaN max
+// Implements the aggregation state for MAX, which is NULL if there are no
+// values that are not NULL
 type MaxAggState[T constraints.Ordered] struct {
 	alias  string
 	expr   Expr
@@ -208,12 +309,13 @@ func (a *MaxAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any)
 	a.expr = expr
 	a.getter = getter
 	a.alias = alias
+	a.null = true
 	return nil
 }
 
 func (a *MaxAggState[T]) AddTuple(t *Tuple) {
 	v, err := a.expr.EvalExpr(t)
-	if err != nil {
+	if err != nil || isNull(v) {
 		return
 	}
 	val := a.getter(v).(T)
@@ -231,7 +333,7 @@ func (a *MaxAggState[T]) GetTupleDesc() *TupleDesc {
 	case string:
 		ft = FieldType{a.alias, "", StringType}
 	default:
-		ft = FieldType{a.alias, "", IntType}
+		ft = FieldType{a.alias, "", aggValueType(a.expr)}
 	}
 	fts := []FieldType{ft}
 	td := TupleDesc{}
@@ -242,20 +344,22 @@ func (a *MaxAggState[T]) GetTupleDesc() *TupleDesc {
 func (a *MaxAggState[T]) Finalize() *Tuple {
 	td := a.GetTupleDesc()
 	var f any
+	if a.null {
+		return &Tuple{*td, []DBValue{NullField{}}, nil}
+	}
 	switch any(a.max).(type) {
 	case string:
 		f = StringField{any(a.max).(string)}
 	default:
-		f = IntField{any(a.max).(int64)}
+		f = aggValue(any(a.max), td.Fields[0].Ftype)
 	}
 	fs := []DBValue{f}
 	t := Tuple{*td, fs, nil}
 	return &t
 }
 
-// Implements the aggregation state for MIN
-// Note that we always AddTuple() at least once before Finalize()
-// so no worries for NaN min
+// Implements the aggregation state for MIN, which is NULL if there are no
+// values that are not NULL
 type MinAggState[T constraints.Ordered] struct {
 	// TODO: some code goes here
 	// TODO add fields that can help implement the aggregation state
@@ -276,13 +380,14 @@ func (a *MinAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any)
 	a.expr = expr
 	a.getter = getter
 	a.alias = alias
+	a.null = true
 	return nil
 }
 
 func (a *MinAggState[T]) AddTuple(t *Tuple) {
 	// TODO: some code goes here
 	v, err := a.expr.EvalExpr(t)
-	if err != nil {
+	if err != nil || isNull(v) {
 		return
 	}
 	val := a.getter(v).(T)
@@ -301,7 +406,7 @@ func (a *MinAggState[T]) GetTupleDesc() *TupleDesc {
 	case string:
 		ft = FieldType{a.alias, "", StringType}
 	default:
-		ft = FieldType{a.alias, "", IntType}
+		ft = FieldType{a.alias, "", aggValueType(a.expr)}
 	}
 	fts := []FieldType{ft}
 	td := TupleDesc{}
@@ -313,13 +418,65 @@ func (a *MinAggState[T]) Finalize() *Tuple {
 	// TODO: some code goes here
 	td := a.GetTupleDesc()
 	var f any
+	if a.null {
+		return &Tuple{*td, []DBValue{NullField{}}, nil}
+	}
 	switch any(a.min).(type) {
 	case string:
 		f = StringField{any(a.min).(string)}
 	default:
-		f = IntField{any(a.min).(int64)}
+		f = aggValue(any(a.min), td.Fields[0].Ftype)
 	}
 	fs := []DBValue{f}
 	t := Tuple{*td, fs, nil}
 	return &t
 }
+
+// Implements the aggregation state for BOOL_AND and BOOL_OR, whether all or
+// any of the values that are not NULL are true, which are NULL if there are no
+// such values
+type BoolAggState struct {
+	alias string
+	expr  Expr
+	or    bool // whether this is BOOL_OR, rather than BOOL_AND
+	value bool
+	null  bool
+}
+
+func (a *BoolAggState) Copy() AggState {
+	return &BoolAggState{a.alias, a.expr, a.or, !a.or, true}
+}
+
+func (a *BoolAggState) Init(alias string, expr Expr, getter func(DBValue) any) error {
+	a.alias = alias
+	a.expr = expr
+	a.value = !a.or
+	a.null = true
+	return nil
+}
+
+func (a *BoolAggState) AddTuple(t *Tuple) {
+	v, err := a.expr.EvalExpr(t)
+	if err != nil || isNull(v) {
+		return
+	}
+	b := boolAggGetter(v).(bool)
+	if a.or {
+		a.value = a.value || b
+	} else {
+		a.value = a.value && b
+	}
+	a.null = false
+}
+
+func (a *BoolAggState) GetTupleDesc() *TupleDesc {
+	return &TupleDesc{[]FieldType{{a.alias, "", BoolType}}}
+}
+
+func (a *BoolAggState) Finalize() *Tuple {
+	td := a.GetTupleDesc()
+	if a.null {
+		return &Tuple{*td, []DBValue{NullField{}}, nil}
+	}
+	return &Tuple{*td, []DBValue{BoolField{a.value}}, nil}
+}
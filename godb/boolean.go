@@ -0,0 +1,193 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fields of type BoolType hold TRUE or FALSE, stored as a byte.
+//
+// Besides being the predicates of filters, comparisons, AND, OR, NOT, IS and
+// LIKE are functions (see funcs) that return booleans, so that they may appear
+// anywhere an expression does, e.g., SELECT a > b.  They follow SQL's three
+// valued logic: a comparison with NULL is NULL, and so is AND or OR when its
+// result depends on an operand that is NULL, so that FALSE AND NULL is FALSE
+// but TRUE AND NULL is NULL.  IS tests are never NULL.  A WHERE clause keeps
+// the tuples for which its expression is true, filtering out those for which it
+// is false or NULL.
+
+// The functions that are passed their NULL arguments, as a NullField, rather
+// than returning NULL whenever an argument is NULL
+var nullArgFuncs = map[string]bool{
+	"and":          true,
+	"or":           true,
+	"not":          true,
+	"is null":      true,
+	"is not null":  true,
+	"is true":      true,
+	"is not true":  true,
+	"is false":     true,
+	"is not false": true,
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compareInt64(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Compare a and b, two values that are not NULL, returning -1, 0 or 1 as a is
+// less than, equal to or greater than b.  Numbers of different types are
+// compared as the more general of their types, and dates with timestamps as
+// timestamps.  A string compared with a date, timestamp or interval is read as
+// a value of its type.  FALSE is less than TRUE.  Returns an error if a and b
+// cannot be compared.
+func compareDBValues(a DBValue, b DBValue) (int, error) {
+	if _, ok := a.(StringField); ok && isTemporalValue(b) {
+		cmp, err := compareDBValues(b, a)
+		return -cmp, err
+	}
+	if s, ok := b.(StringField); ok && isTemporalValue(a) {
+		t := TimestampType
+		if _, ok := a.(IntervalField); ok {
+			t = IntervalType
+		}
+		v, err := castTemporal(s, t)
+		if err != nil {
+			return 0, err
+		}
+		b = v
+	}
+	_, aInterval := a.(IntervalField)
+	_, bInterval := b.(IntervalField)
+	switch {
+	case isNumber(a) && isNumber(b):
+		x, xInt := a.(IntField)
+		y, yInt := b.(IntField)
+		if xInt && yInt {
+			return compareInt64(x.Value, y.Value), nil
+		}
+		return compareNumeric(a, b), nil
+	case isTemporalValue(a) && isTemporalValue(b) && aInterval == bInterval:
+		return compareInt64(timeKey(a), timeKey(b)), nil
+	}
+	switch a := a.(type) {
+	case StringField:
+		if b, ok := b.(StringField); ok {
+			return strings.Compare(a.Value, b.Value), nil
+		}
+	case BytesField:
+		if b, ok := b.(BytesField); ok {
+			return bytes.Compare(a.Value, b.Value), nil
+		}
+	case BoolField:
+		if b, ok := b.(BoolField); ok {
+			return compareInt64(boolInt(a.Value), boolInt(b.Value)), nil
+		}
+	}
+	return 0, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compare %v with %v", a, b)}
+}
+
+// Return a function that compares its two arguments with op (see
+// [compareDBValues]), or returns an error if they cannot be compared
+func comparisonFunc(op BoolOp) func([]any) any {
+	return func(args []any) any {
+		cmp, err := compareDBValues(args[0].(DBValue), args[1].(DBValue))
+		if err != nil {
+			return err
+		}
+		return compareValues(cmp, 0, op)
+	}
+}
+
+func likeFunc(args []any) any {
+	return compareValues(args[0].(string), args[1].(string), OpLike)
+}
+
+func notLikeFunc(args []any) any {
+	return !compareValues(args[0].(string), args[1].(string), OpLike)
+}
+
+// Return the value of a boolean argument of one of nullArgFuncs, and whether it
+// is known, i.e., not NULL
+func boolArg(arg any) (value bool, known bool) {
+	value, known = arg.(bool)
+	return value, known
+}
+
+func andFunc(args []any) any {
+	x, xKnown := boolArg(args[0])
+	y, yKnown := boolArg(args[1])
+	switch {
+	case xKnown && !x, yKnown && !y:
+		return false
+	case !xKnown, !yKnown:
+		return NullField{}
+	}
+	return true
+}
+
+func orFunc(args []any) any {
+	x, xKnown := boolArg(args[0])
+	y, yKnown := boolArg(args[1])
+	switch {
+	case xKnown && x, yKnown && y:
+		return true
+	case !xKnown, !yKnown:
+		return NullField{}
+	}
+	return false
+}
+
+func notFunc(args []any) any {
+	x, known := boolArg(args[0])
+	if !known {
+		return NullField{}
+	}
+	return !x
+}
+
+// Return a function that tests whether its argument is NULL, or not NULL if not
+// is set
+func isNullFunc(not bool) func([]any) any {
+	return func(args []any) any {
+		return isNull(args[0]) != not
+	}
+}
+
+// Return a function that tests whether its boolean argument is value, or is not
+// value (or NULL) if not is set
+func isBoolFunc(value bool, not bool) func([]any) any {
+	return func(args []any) any {
+		x, known := boolArg(args[0])
+		return (known && x == value) != not
+	}
+}
+
+// Return v converted to a boolean: a string like true, false, t, f, 1 or 0 read
+// as a boolean, or v itself if it is a boolean or NULL.  Returns an error if v
+// is not a boolean.
+func castBool(v DBValue) (DBValue, error) {
+	switch f := v.(type) {
+	case BoolField, NullField:
+		return v, nil
+	case StringField:
+		if b, err := strconv.ParseBool(strings.TrimSpace(f.Value)); err == nil {
+			return BoolField{b}, nil
+		}
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("%v is not a boolean", v)}
+}
@@ -0,0 +1,141 @@
+package godb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Return a catalog with the table f (name string, age int, member bool),
+// holding a member, a non member, and a tuple with a NULL age and membership
+func makeBoolTestCatalog(t *testing.T) *Catalog {
+	return makeQueryTestCatalog(t, "f (name string, age int, member bool)",
+		"insert into f values ('sam', 25, true), ('joe', 30, 'false'), ('bob', null, null)")
+}
+
+func TestBoolValues(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{"a", "", BoolType}, {"b", "", BoolType}}}
+	t1 := Tuple{Desc: td, Fields: []DBValue{BoolField{true}, BoolField{false}}}
+	b := new(bytes.Buffer)
+	if err := t1.writeTo(b); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if b.Len() != 1+2 {
+		t.Errorf("expected two booleans to take 3 bytes, got %d", b.Len())
+	}
+	t2, err := readTupleFrom(b, &td)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !t2.equals(&t1) {
+		t.Errorf("expected %v, got %v", t1.Fields, t2.Fields)
+	}
+
+	for _, c := range []struct {
+		a, b DBValue
+		want int
+	}{
+		{BoolField{false}, BoolField{true}, -1},
+		{IntField{2}, DecimalField{200, 2}, 0},
+		{StringField{"2024-01-02"}, DateField{19723}, 1},
+		{DateField{19723}, StringField{"2024-01-01 00:00:01"}, -1},
+		{IntervalField{0, dayMicros}, StringField{"24 hours"}, 0},
+		{StringField{"b"}, StringField{"a"}, 1},
+	} {
+		if got, err := compareDBValues(c.a, c.b); err != nil || got != c.want {
+			t.Errorf("comparing %v with %v: expected %d, got %d (%v)", c.a, c.b, c.want, got, err)
+		}
+	}
+	if _, err := compareDBValues(StringField{"1"}, IntField{1}); err == nil {
+		t.Errorf("expected a string not to be compared with an integer")
+	}
+	if _, err := castBool(StringField{"maybe"}); err == nil {
+		t.Errorf("expected maybe not to be a boolean")
+	}
+}
+
+func TestBoolExprs(t *testing.T) {
+	c := makeBoolTestCatalog(t)
+	null := NullField{}
+	for query, want := range map[string][]DBValue{
+		"select age > 26, member, not member, age is null from f where name = 'sam'":                               {BoolField{false}, BoolField{true}, BoolField{false}, BoolField{false}},
+		"select age > 26, member and true, false and member, true or member from f where name = 'bob'":             {null, null, BoolField{false}, BoolField{true}},
+		"select member is true, member is not false, age = 30 or member, name like 'b%' from f where name = 'bob'": {BoolField{false}, BoolField{true}, null, BoolField{true}},
+		"select bool_and(member), bool_or(member), count(member) from f":                                           {BoolField{false}, BoolField{true}, IntField{2}},
+		"select bool_or(member) from f where name = 'bob'":                                                         {null},
+	} {
+		got := runTestQuery(t, c, query)
+		if len(got) != 1 {
+			t.Errorf("%q: expected one tuple, got %d", query, len(got))
+			continue
+		}
+		for i, v := range want {
+			if got[0].Fields[i] != v {
+				t.Errorf("%q: expression %d: expected %v, got %v", query, i, v, got[0].Fields[i])
+			}
+		}
+	}
+	if s := runTestQuery(t, c, "select name, member from f where name = 'joe'")[0].PrettyPrintString(false); s != "joe,false" {
+		t.Errorf("unexpected printed tuple %s", s)
+	}
+	for _, query := range []string{
+		"select sum(member) from f",
+		"select bool_and(age) from f",
+		"select a.name from f as a, f as b where a.member or b.member",
+		"select name from f where age in (1, 2)",
+	} {
+		if _, _, err := Parse(c, query); err == nil {
+			t.Errorf("expected %q not to parse", query)
+		}
+	}
+}
+
+func TestBoolFilters(t *testing.T) {
+	c := makeBoolTestCatalog(t)
+	for query, want := range map[string]int{
+		"select name from f where member":                            1,
+		"select name from f where not member":                        1,
+		"select name from f where member = false":                    1,
+		"select name from f where member is not true":                2,
+		"select name from f where age > 26 or member":                2,
+		"select name from f where (age < 26 or name = 'bob')":        2,
+		"select name from f where (age > 20) = member":               1,
+		"select name from f where name like 's%' or age is null":     2,
+		"select name from f where not (age > 26 or member) is true":  1,
+		"select name from f where age > 20 and (member or age = 30)": 2,
+	} {
+		if got := runTestQuery(t, c, query); len(got) != want {
+			t.Errorf("%q: expected %d tuples, got %d", query, want, len(got))
+		}
+	}
+
+	got := runTestQuery(t, c, "select name, member from f order by member desc, name")
+	var names []string
+	for _, tup := range got {
+		names = append(names, tup.Fields[0].(StringField).Value)
+	}
+	if len(names) != 3 || names[0] != "bob" || names[1] != "sam" || names[2] != "joe" {
+		t.Errorf("expected NULL, true and false members in order, got %v", names)
+	}
+
+	if _, _, err := Parse(c, "create table g (a bool, b boolean, c int)"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	desc := c.tables[len(c.tables)-1].desc
+	for i, w := range []DBType{BoolType, BoolType, IntType} {
+		if desc.Fields[i].Ftype != w {
+			t.Errorf("column %d: expected %s, got %s", i, typeName(w), typeName(desc.Fields[i].Ftype))
+		}
+	}
+	if s := c.CatalogString(); s != "f (name string, age int, member bool)\ng (a bool, b bool, c int)\n" {
+		t.Errorf("unexpected catalog %q", s)
+	}
+}
+
+func TestBoolColumnName(t *testing.T) {
+	// BOOL is only read as a type in the column list of CREATE TABLE
+	c := makeQueryTestCatalog(t, "t (name string, bool int)", "insert into t values ('a', 1), ('b', 2)")
+	got := runTestQuery(t, c, "select bool, name from t where bool > 1")
+	if len(got) != 1 || got[0].Fields[0] != (IntField{2}) || got[0].Fields[1] != (StringField{"b"}) {
+		t.Errorf("expected the tuple of b from a column named bool, got %v", got)
+	}
+}
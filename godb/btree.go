@@ -0,0 +1,678 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sort"
+	"sync"
+)
+
+// BTreeFile is a B+ tree secondary index over one column of a table, mapping
+// the values of the column (its keys) to the Rids of the tuples holding them.
+// It is a DBFile whose tuples are its entries, a key and the page and slot of
+// a Rid, in order.  Pages are read and written through the BufferPool like
+// those of a HeapFile.
+//
+// Page 0 of the file is a meta page holding the number of the root page and
+// the head of the list of free pages.  The other pages are leaves, holding
+// entries, internal pages, holding separators, or free pages.  Every page
+// begins with a header with a 16 bit magic number, a 16 bit format version, the
+// 16 bit kind of page, the 16 bit number of entries, and a 32 bit link: the
+// root page for the meta page, the leftmost child of an internal page, and the
+// next free page of a free page.  The meta page follows it with the first free
+// page.  Entries are serialized like tuples (see [Tuple.writeTo]): a key and a
+// Rid for entries of leaves, and a key, a Rid and the child page holding the
+// entries at least as large as it for separators.
+//
+// Entries are ordered by key, then by Rid, so that every entry is distinct and
+// duplicate keys are split across pages like any other.  NULL keys are not
+// indexed, and strings and byte strings are indexed by their first
+// maxIndexKeyLength bytes, so that the number of entries of a page is bounded
+// (see [BTreeFile.maxEntries]).  Pages are split when they have more entries,
+// and merged with, or take entries from, a sibling when they have fewer than
+// half as many.
+//
+// Index pages are not locked on behalf of transactions.  Instead, each page has
+// a latch, held only while a page is read or updated, and operations couple
+// latches down the tree (crabbing): a reader latches a child before releasing
+// its parent, and a writer releases the latches it holds on the ancestors of a
+// page once it is safe, i.e., cannot be split or merged by the operation.
+// Pages are pinned in the buffer pool while they are used, so that the copy
+// that is latched is never evicted.  Scans do not hold latches from one leaf to
+// the next, but descend again from the root to find the leaf that follows the
+// last one they read, so that leaves can be split and merged in between.
+//
+// Changes to an index are neither logged for undo nor versioned: an index may
+// hold entries that no longer describe their tuple, which readers ignore (see
+// [IndexScan]), and entries are only removed once no transaction can see their
+// tuple (see index.go).
+type BTreeFile struct {
+	bufPool  *BufferPool
+	Filename string
+	keyType  DBType
+	// serializes the allocation and freeing of pages
+	allocLock sync.Mutex
+	// see [indexFile.updates]
+	updateLock sync.RWMutex
+}
+
+type btreePageKind uint16
+
+const (
+	btreeMetaPage     btreePageKind = iota
+	btreeLeafPage     btreePageKind = iota
+	btreeInternalPage btreePageKind = iota
+	btreeFreePage     btreePageKind = iota
+)
+
+const (
+	btreeMagic         = 0x4749
+	btreeFormatVersion = 1
+	// the meta page and the root of a new index
+	btreeMetaPageNo = 0
+	btreeFirstRoot  = 1
+	// strings and byte strings are indexed by at most this many bytes
+	maxIndexKeyLength = 64
+)
+
+// An entry of an index (see index.go), or a separator of an internal page of a
+// BTreeFile
+type indexEntry struct {
+	key DBValue
+	rid Rid
+	// separators only: the page of the subtree holding the entries at least as
+	// large as the separator, and smaller than the next one
+	child int
+}
+
+// A page of a BTreeFile, whose entries are those of a leaf, or the separators
+// of an internal page, in order, and whose link is the root page of the meta
+// page, the leftmost child of an internal page, or the next free page of a free
+// page
+type btreePage struct {
+	indexPage
+	file *BTreeFile
+	kind btreePageKind
+}
+
+// Create a BTreeFile for keys of type keyType, stored in fromFile, which is
+// created with an empty root if it does not exist
+func NewBTreeFile(fromFile string, keyType DBType, bp *BufferPool) (*BTreeFile, error) {
+	file, err := os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	f := &BTreeFile{bufPool: bp, Filename: fromFile, keyType: keyType}
+	if info.Size() == 0 {
+		var meta, root Page = f.newPage(btreeMetaPageNo, btreeMetaPage), f.newPage(btreeFirstRoot, btreeLeafPage)
+		meta.(*btreePage).link = btreeFirstRoot
+		for _, p := range []*Page{&meta, &root} {
+			if err := f.flushPage(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *BTreeFile) newPage(pageNo int, kind btreePageKind) *btreePage {
+	return &btreePage{indexPage: indexPage{pageNo: pageNo, link: -1, free: -1}, file: f, kind: kind}
+}
+
+// Return the number of pages in the file
+func (f *BTreeFile) NumPages() int {
+	return indexNumPages(f.Filename)
+}
+
+// Return the descriptor of separators, which is that of entries (see
+// [BTreeFile.Descriptor]) followed by their child page
+func (f *BTreeFile) separatorDesc() *TupleDesc {
+	desc := f.Descriptor().copy()
+	desc.Fields = append(desc.Fields, FieldType{Fname: "child", Ftype: IntType})
+	return desc
+}
+
+// Return the largest number of entries a page holds, which is the number of
+// separators with the longest keys that fit on it
+func (f *BTreeFile) maxEntries() int {
+	// the meta page follows the header with the first free page
+	return maxIndexEntries(f.keyType, 3, PageSize-indexHeaderSize-4)
+}
+
+// Return the key v is indexed by: its first maxIndexKeyLength bytes if it is a
+// string or byte string, or v itself
+func indexKey(v DBValue) DBValue {
+	switch v := v.(type) {
+	case StringField:
+		if len(v.Value) > maxIndexKeyLength {
+			return StringField{v.Value[:maxIndexKeyLength]}
+		}
+	case BytesField:
+		if len(v.Value) > maxIndexKeyLength {
+			return BytesField{v.Value[:maxIndexKeyLength]}
+		}
+	}
+	return v
+}
+
+// Compare two entries by key, then by Rid.  A nil key is smaller than every
+// other key.  Keys must be comparable (see [compareDBValues]).
+func compareEntries(a *indexEntry, b *indexEntry) int {
+	switch {
+	case a.key == nil && b.key == nil:
+	case a.key == nil:
+		return -1
+	case b.key == nil:
+		return 1
+	default:
+		if cmp, _ := compareDBValues(a.key, b.key); cmp != 0 {
+			return cmp
+		}
+	}
+	if cmp := compareInt64(int64(a.rid.pageid), int64(b.rid.pageid)); cmp != 0 {
+		return cmp
+	}
+	return compareInt64(int64(a.rid.slotid), int64(b.rid.slotid))
+}
+
+// Return an error unless v may be compared with the keys of f
+func (f *BTreeFile) checkKey(v DBValue) error {
+	return checkIndexKey(f.keyType, v)
+}
+
+// Fetch a page through the buffer pool, pinned, and latch it for reading
+// (ReadPerm) or updating (WritePerm)
+func (f *BTreeFile) fetch(pageNo int, perm RWPerm) (*btreePage, error) {
+	return fetchIndexPage[*btreePage](f.bufPool, f, pageNo, perm)
+}
+
+func (f *BTreeFile) updates() *sync.RWMutex {
+	return &f.updateLock
+}
+
+// Return the number of separators of an internal page that are no larger than
+// e, which is the position of the child whose subtree holds e
+func (p *btreePage) childIndex(e *indexEntry) int {
+	return sort.Search(len(p.entries), func(i int) bool {
+		return compareEntries(&p.entries[i], e) > 0
+	})
+}
+
+// Return the i-th child of an internal page
+func (p *btreePage) childAt(i int) int {
+	if i == 0 {
+		return p.link
+	}
+	return p.entries[i-1].child
+}
+
+// Return the position of the first entry of a leaf that is at least as large
+// as e, and whether it is e
+func (p *btreePage) search(e *indexEntry) (int, bool) {
+	i := sort.Search(len(p.entries), func(i int) bool {
+		return compareEntries(&p.entries[i], e) >= 0
+	})
+	return i, i < len(p.entries) && compareEntries(&p.entries[i], e) == 0
+}
+
+// Return the leaf whose range holds e, read latched, along with the upper
+// bound of its range, the smallest separator larger than e, or nil if it is
+// the last leaf
+func (f *BTreeFile) findLeaf(e *indexEntry) (*btreePage, *indexEntry, error) {
+	meta, err := f.fetch(btreeMetaPageNo, ReadPerm)
+	if err != nil {
+		return nil, nil, err
+	}
+	page, err := f.fetch(meta.link, ReadPerm)
+	meta.release(ReadPerm)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fence *indexEntry
+	for page.kind == btreeInternalPage {
+		i := page.childIndex(e)
+		if i < len(page.entries) {
+			sep := page.entries[i]
+			fence = &sep
+		}
+		child, err := f.fetch(page.childAt(i), ReadPerm)
+		page.release(ReadPerm)
+		if err != nil {
+			return nil, nil, err
+		}
+		page = child
+	}
+	return page, fence, nil
+}
+
+// Return a function that iterates through the entries whose keys are at least
+// lo and at most hi, in order, where a nil bound is unbounded.  The bounds are
+// compared with keys as they are indexed (see indexKey), so the entries of
+// strings that only share their first maxIndexKeyLength bytes with a bound are
+// returned as well.
+func (f *BTreeFile) scan(lo DBValue, hi DBValue) (func() (*indexEntry, error), error) {
+	for _, bound := range []DBValue{lo, hi} {
+		if bound != nil {
+			if err := f.checkKey(bound); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if lo != nil {
+		lo = indexKey(lo)
+	}
+	if hi != nil {
+		hi = indexKey(hi)
+	}
+	pos := &indexEntry{key: lo, rid: Rid{-1, -1}}
+	var entries []indexEntry
+	return func() (*indexEntry, error) {
+		for len(entries) == 0 {
+			if pos == nil {
+				return nil, nil
+			}
+			leaf, fence, err := f.findLeaf(pos)
+			if err != nil {
+				return nil, err
+			}
+			i, _ := leaf.search(pos)
+			entries = append(entries, leaf.entries[i:]...)
+			leaf.release(ReadPerm)
+			pos = fence
+		}
+		e := entries[0]
+		entries = entries[1:]
+		if hi != nil {
+			if cmp, _ := compareDBValues(e.key, hi); cmp > 0 {
+				entries, pos = nil, nil
+				return nil, nil
+			}
+		}
+		return &e, nil
+	}, nil
+}
+
+// Return whether an insert into page cannot split it
+func (f *BTreeFile) safeForInsert(p *btreePage) bool {
+	return len(p.entries) < f.maxEntries()
+}
+
+// Return whether a delete from page cannot merge it with a sibling (or remove
+// the root)
+func (f *BTreeFile) safeForDelete(p *btreePage, root bool) bool {
+	switch {
+	case root && p.kind == btreeLeafPage:
+		return true
+	case root:
+		return len(p.entries) > 1
+	}
+	return len(p.entries) > f.maxEntries()/2
+}
+
+// Add an entry to the index, unless it is already there
+func (f *BTreeFile) insertEntry(e indexEntry) error {
+	return updateIndex(f.bufPool, f, func(restructure bool) (bool, error) {
+		return f.addEntry(e, restructure)
+	})
+}
+
+// Add an entry to the index, as for insertEntry, and return true, unless it
+// may split pages and restructure is false (see updateIndex)
+func (f *BTreeFile) addEntry(e indexEntry, restructure bool) (bool, error) {
+	held, err := f.descend(&e, func(p *btreePage, _ bool) bool { return f.safeForInsert(p) })
+	defer func() { releaseAll(held, WritePerm) }()
+	if err != nil || (len(held) > 1 && !restructure) {
+		return false, err
+	}
+	leaf := held[len(held)-1]
+	i, found := leaf.search(&e)
+	if found {
+		return true, nil
+	}
+	leaf.entries = append(leaf.entries[:i], append([]indexEntry{e}, leaf.entries[i:]...)...)
+	leaf.setDirty(true)
+
+	// split full pages, from the leaf up
+	for level := len(held) - 1; level > 0 && len(held[level].entries) > f.maxEntries(); level-- {
+		page, parent := held[level], held[level-1]
+		sep, err := f.split(page)
+		if err != nil {
+			return false, err
+		}
+		if parent.kind == btreeMetaPage {
+			root, err := f.allocPage(btreeInternalPage)
+			if err != nil {
+				return false, err
+			}
+			root.link = page.pageNo
+			root.entries = []indexEntry{sep}
+			parent.link = root.pageNo
+			parent.setDirty(true)
+			root.release(WritePerm)
+			break
+		}
+		j := parent.childIndex(&sep)
+		parent.entries = append(parent.entries[:j], append([]indexEntry{sep}, parent.entries[j:]...)...)
+		parent.setDirty(true)
+	}
+	return true, nil
+}
+
+// Move the upper half of the entries of a page that is over full to a new page,
+// and return the separator that the parent of the page should hold for it
+func (f *BTreeFile) split(p *btreePage) (indexEntry, error) {
+	right, err := f.allocPage(p.kind)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	defer right.release(WritePerm)
+	mid := len(p.entries) / 2
+	sep := p.entries[mid]
+	if p.kind == btreeLeafPage {
+		right.entries = append([]indexEntry(nil), p.entries[mid:]...)
+	} else {
+		right.link = sep.child
+		right.entries = append([]indexEntry(nil), p.entries[mid+1:]...)
+	}
+	p.entries = p.entries[:mid:mid]
+	sep.child = right.pageNo
+	p.setDirty(true)
+	right.setDirty(true)
+	return sep, nil
+}
+
+// Latch the meta page, then the pages on the path from the root to the leaf
+// whose range holds e for updating, releasing the latches on the ancestors of
+// a page whenever safe returns true for it.  Returns the pages that are still
+// latched, from the top down.
+func (f *BTreeFile) descend(e *indexEntry, safe func(p *btreePage, root bool) bool) ([]*btreePage, error) {
+	meta, err := f.fetch(btreeMetaPageNo, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	held := []*btreePage{meta}
+	pageNo, root := meta.link, true
+	for {
+		page, err := f.fetch(pageNo, WritePerm)
+		if err != nil {
+			return held, err
+		}
+		if safe(page, root) {
+			releaseAll(held, WritePerm)
+			held = held[:0]
+		}
+		held = append(held, page)
+		if page.kind != btreeInternalPage {
+			return held, nil
+		}
+		pageNo, root = page.childAt(page.childIndex(e)), false
+	}
+}
+
+// Remove an entry from the index, if it is there and keep, which is called
+// with the leaf holding the entry latched, returns false
+func (f *BTreeFile) deleteEntry(e indexEntry, keep func() bool) error {
+	return updateIndex(f.bufPool, f, func(restructure bool) (bool, error) {
+		return f.removeEntry(e, keep, restructure)
+	})
+}
+
+// Remove an entry from the index, as for deleteEntry, and return true, unless
+// it may merge pages and restructure is false (see updateIndex)
+func (f *BTreeFile) removeEntry(e indexEntry, keep func() bool, restructure bool) (bool, error) {
+	held, err := f.descend(&e, f.safeForDelete)
+	defer func() { releaseAll(held, WritePerm) }()
+	if err != nil || (len(held) > 1 && !restructure) {
+		return false, err
+	}
+	leaf := held[len(held)-1]
+	i, found := leaf.search(&e)
+	if !found || (keep != nil && keep()) {
+		return true, nil
+	}
+	leaf.entries = append(leaf.entries[:i], leaf.entries[i+1:]...)
+	leaf.setDirty(true)
+
+	// merge or rebalance pages that are less than half full, from the leaf up
+	for level := len(held) - 1; level > 0; level-- {
+		page, parent := held[level], held[level-1]
+		if parent.kind == btreeMetaPage {
+			if page.kind == btreeInternalPage && len(page.entries) == 0 {
+				parent.link = page.link
+				parent.setDirty(true)
+				if err := f.freePage(page); err != nil {
+					return false, err
+				}
+			}
+			break
+		}
+		if len(page.entries) >= f.maxEntries()/2 {
+			break
+		}
+		if err := f.rebalance(parent, page); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Merge a page that is less than half full with a sibling, or move entries
+// from the sibling to it if they do not fit on one page, updating their parent
+func (f *BTreeFile) rebalance(parent *btreePage, page *btreePage) error {
+	i := 0
+	for i < len(parent.entries) && parent.childAt(i) != page.pageNo {
+		i++
+	}
+	sepIdx := i
+	if i == len(parent.entries) {
+		sepIdx = i - 1
+	}
+	siblingNo := parent.childAt(sepIdx + 1)
+	if sepIdx < i {
+		siblingNo = parent.childAt(sepIdx)
+	}
+	sibling, err := f.fetch(siblingNo, WritePerm)
+	if err != nil {
+		return err
+	}
+	left, right := page, sibling
+	if sepIdx < i {
+		left, right = sibling, page
+	}
+
+	sep := parent.entries[sepIdx]
+	all := append([]indexEntry(nil), left.entries...)
+	if left.kind == btreeInternalPage {
+		all = append(all, indexEntry{sep.key, sep.rid, right.link})
+	}
+	all = append(all, right.entries...)
+	left.setDirty(true)
+	right.setDirty(true)
+	parent.setDirty(true)
+
+	if len(all) <= f.maxEntries() {
+		left.entries = all
+		parent.entries = append(parent.entries[:sepIdx], parent.entries[sepIdx+1:]...)
+		if right == sibling {
+			err = f.freePage(right)
+			sibling.release(WritePerm)
+			return err
+		}
+		// page itself is freed, and stays latched until the operation ends
+		sibling.release(WritePerm)
+		return f.freePage(right)
+	}
+	mid := len(all) / 2
+	newSep := all[mid]
+	if left.kind == btreeLeafPage {
+		left.entries, right.entries = all[:mid:mid], append([]indexEntry(nil), all[mid:]...)
+	} else {
+		left.entries, right.entries = all[:mid:mid], append([]indexEntry(nil), all[mid+1:]...)
+		right.link = newSep.child
+	}
+	newSep.child = right.pageNo
+	parent.entries[sepIdx] = newSep
+	sibling.release(WritePerm)
+	return nil
+}
+
+// Return a page of the specified kind, taken from the free list or appended to
+// the file, pinned and latched for updating
+func (f *BTreeFile) allocPage(kind btreePageKind) (*btreePage, error) {
+	f.allocLock.Lock()
+	defer f.allocLock.Unlock()
+	p, err := f.bufPool.fetchPage(f, btreeMetaPageNo)
+	if err != nil {
+		return nil, err
+	}
+	meta := (*p).(*btreePage)
+	defer meta.unpin()
+
+	pageNo := meta.free
+	if pageNo < 0 {
+		pageNo = f.NumPages()
+		var empty Page = f.newPage(pageNo, btreeFreePage)
+		if err := f.flushPage(&empty); err != nil {
+			return nil, err
+		}
+	}
+	page, err := f.fetch(pageNo, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	if meta.free >= 0 {
+		meta.free = page.link
+		meta.setDirty(true)
+	}
+	page.kind, page.entries, page.link = kind, nil, -1
+	page.setDirty(true)
+	return page, nil
+}
+
+// Add a page that is no longer part of the tree, which the caller holds
+// latched for updating, to the free list
+func (f *BTreeFile) freePage(page *btreePage) error {
+	f.allocLock.Lock()
+	defer f.allocLock.Unlock()
+	p, err := f.bufPool.fetchPage(f, btreeMetaPageNo)
+	if err != nil {
+		return err
+	}
+	meta := (*p).(*btreePage)
+	defer meta.unpin()
+	page.kind, page.entries, page.link = btreeFreePage, nil, meta.free
+	page.setDirty(true)
+	meta.free = page.pageNo
+	meta.setDirty(true)
+	return nil
+}
+
+// Add the entry t, a tuple of the descriptor of f, to the index.  Entries are
+// not locked on behalf of tid (see BTreeFile).
+func (f *BTreeFile) insertTuple(t *Tuple, tid TransactionID) error {
+	return insertIndexTuple(f, t)
+}
+
+// Remove the entry t, a tuple of the descriptor of f, from the index
+func (f *BTreeFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	return deleteIndexTuple(f, t)
+}
+
+// [Operator] descriptor method: entries are a key and the page and slot of the
+// Rid of a tuple holding it
+func (f *BTreeFile) Descriptor() *TupleDesc {
+	return indexEntryDesc(f.keyType)
+}
+
+// [Operator] iterator method: return a function that iterates through the
+// entries of the index in order
+func (f *BTreeFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	next, err := f.scan(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return entryTuples(f.Descriptor(), next), nil
+}
+
+// Read the specified page of the file from disk
+func (f *BTreeFile) readPage(pageNo int) (*Page, error) {
+	return readIndexPage(f.Filename, f.newPage(pageNo, btreeFreePage))
+}
+
+// Write a page back to its place in the file
+func (f *BTreeFile) flushPage(p *Page) error {
+	return flushIndexPage(f.Filename, p)
+}
+
+func (f *BTreeFile) pageKey(pgNo int) any {
+	return heapHash{f.Filename, pgNo}
+}
+
+func (f *BTreeFile) fileName() string {
+	return f.Filename
+}
+
+func (p *btreePage) getFile() *DBFile {
+	var f DBFile = p.file
+	return &f
+}
+
+// Return the on-disk image of the page as of before its unflushed changes, or
+// the image of a free page if it has never been on disk
+func (p *btreePage) getBeforeImage() []byte {
+	return indexBeforeImage(p, func() indexFilePage { return p.file.newPage(p.pageNo, btreeFreePage) })
+}
+
+func (p *btreePage) setBeforeImage() error {
+	return setIndexBeforeImage(p)
+}
+
+// Write the page to a new buffer of PageSize bytes, as described for BTreeFile
+func (p *btreePage) toBuffer() (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	if err := p.writeHeader(b, btreeMagic, btreeFormatVersion, uint16(p.kind)); err != nil {
+		return nil, err
+	}
+	if p.kind == btreeMetaPage {
+		if err := binary.Write(b, binary.LittleEndian, int32(p.free)); err != nil {
+			return nil, err
+		}
+	}
+	internal := p.kind == btreeInternalPage
+	desc := p.file.Descriptor()
+	if internal {
+		desc = p.file.separatorDesc()
+	}
+	if err := p.writeEntries(b, desc, internal); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Read the contents of the page from a buffer written by toBuffer
+func (p *btreePage) initFromBuffer(buf *bytes.Buffer) error {
+	kind, n, err := p.readHeader(buf, p.file.Filename, btreeMagic, btreeFormatVersion)
+	if err != nil {
+		return err
+	}
+	p.kind = btreePageKind(kind)
+	if p.kind == btreeMetaPage {
+		var free int32
+		if err := binary.Read(buf, binary.LittleEndian, &free); err != nil {
+			return err
+		}
+		p.free = int(free)
+	}
+	internal := p.kind == btreeInternalPage
+	desc := p.file.Descriptor()
+	if internal {
+		desc = p.file.separatorDesc()
+	}
+	return p.readEntries(buf, desc, n, internal)
+}
@@ -0,0 +1,351 @@
+package godb
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Return an empty BTreeFile of keys of type keyType, in a buffer pool of
+// numPages pages
+func makeBTreeTestFile(t *testing.T, keyType DBType, numPages int) *BTreeFile {
+	f, err := NewBTreeFile(filepath.Join(t.TempDir(), "test.idx"), keyType, NewBufferPool(numPages))
+	if err != nil {
+		t.Fatalf("failed to create index: %s", err)
+	}
+	return f
+}
+
+// Return the entries of f whose keys are at least lo and at most hi
+func scanBTree(t *testing.T, f *BTreeFile, lo DBValue, hi DBValue) []indexEntry {
+	next, err := f.scan(lo, hi)
+	if err != nil {
+		t.Fatalf("scan failed: %s", err)
+	}
+	var entries []indexEntry
+	for {
+		e, err := next()
+		if err != nil {
+			t.Fatalf("scan failed: %s", err)
+		}
+		if e == nil {
+			return entries
+		}
+		entries = append(entries, *e)
+	}
+}
+
+// Check that the entries of the subtree rooted at pageNo are in order, within
+// [lo, hi), and that its pages other than the root are at least half full.
+// Returns the depth of the subtree, which must be the same for every child.
+func checkBTree(t *testing.T, f *BTreeFile, pageNo int, lo *indexEntry, hi *indexEntry, root bool) int {
+	page, err := f.fetch(pageNo, ReadPerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer page.release(ReadPerm)
+	if !root && len(page.entries) < f.maxEntries()/2 {
+		t.Errorf("page %d has only %d entries", pageNo, len(page.entries))
+	}
+	for i, e := range page.entries {
+		if (lo != nil && compareEntries(&e, lo) < 0) || (hi != nil && compareEntries(&e, hi) >= 0) {
+			t.Errorf("entry %v of page %d is out of the range of the page", e, pageNo)
+		}
+		if i > 0 && compareEntries(&page.entries[i-1], &e) >= 0 {
+			t.Errorf("entries of page %d are out of order", pageNo)
+		}
+	}
+	if page.kind == btreeLeafPage {
+		return 1
+	}
+	depth := -1
+	for i := 0; i <= len(page.entries); i++ {
+		childLo, childHi := lo, hi
+		if i > 0 {
+			childLo = &page.entries[i-1]
+		}
+		if i < len(page.entries) {
+			childHi = &page.entries[i]
+		}
+		d := checkBTree(t, f, page.childAt(i), childLo, childHi, false)
+		if depth >= 0 && d != depth {
+			t.Errorf("children of page %d have different depths", pageNo)
+		}
+		depth = d
+	}
+	return depth + 1
+}
+
+func rootPage(t *testing.T, f *BTreeFile) int {
+	meta, err := f.fetch(btreeMetaPageNo, ReadPerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer meta.release(ReadPerm)
+	return meta.link
+}
+
+func TestBTreeInsertAndScan(t *testing.T) {
+	f := makeBTreeTestFile(t, IntType, 50)
+	n := f.maxEntries() * f.maxEntries()
+	for _, i := range rand.Perm(n) {
+		// every key is held by two tuples
+		for slot := 0; slot < 2; slot++ {
+			if err := f.insertEntry(indexEntry{key: IntField{int64(i / 2)}, rid: Rid{i, slot}}); err != nil {
+				t.Fatalf("insert failed: %s", err)
+			}
+		}
+	}
+	if depth := checkBTree(t, f, rootPage(t, f), nil, nil, true); depth < 3 {
+		t.Errorf("expected the tree to have split into at least 3 levels, got %d", depth)
+	}
+	// inserting an entry twice does nothing
+	f.insertEntry(indexEntry{key: IntField{0}, rid: Rid{0, 0}})
+
+	all := scanBTree(t, f, nil, nil)
+	if len(all) != 2*n {
+		t.Fatalf("expected %d entries, got %d", 2*n, len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if compareEntries(&all[i-1], &all[i]) >= 0 {
+			t.Fatalf("entries are out of order")
+		}
+	}
+	got := scanBTree(t, f, IntField{100}, IntField{109})
+	if len(got) != 40 {
+		t.Errorf("expected 40 entries with keys from 100 to 109, got %d", len(got))
+	}
+	for _, e := range got {
+		if k := e.key.(IntField).Value; k < 100 || k > 109 {
+			t.Errorf("key %d is out of range", k)
+		}
+	}
+	if got := scanBTree(t, f, IntField{7}, IntField{7}); len(got) != 4 {
+		t.Errorf("expected 4 entries for key 7, got %d", len(got))
+	}
+	// numbers of other types are compared with the keys
+	if got := scanBTree(t, f, FloatField{6.5}, DecimalField{750, 2}); len(got) != 4 {
+		t.Errorf("expected 4 entries between 6.5 and 7.5, got %d", len(got))
+	}
+	if _, err := f.scan(StringField{"a"}, nil); err == nil {
+		t.Errorf("expected a string not to be compared with integer keys")
+	}
+}
+
+func TestBTreeDeleteAndMerge(t *testing.T) {
+	f := makeBTreeTestFile(t, IntType, 50)
+	n := f.maxEntries() * 20
+	for i := 0; i < n; i++ {
+		f.insertEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, 0}})
+	}
+	pages := f.NumPages()
+	for _, i := range rand.Perm(n) {
+		if i%10 == 0 {
+			continue
+		}
+		if err := f.deleteEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, 0}}, nil); err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+	}
+	checkBTree(t, f, rootPage(t, f), nil, nil, true)
+	got := scanBTree(t, f, nil, nil)
+	if len(got) != n/10 {
+		t.Fatalf("expected %d entries, got %d", n/10, len(got))
+	}
+	for i, e := range got {
+		if e.key.(IntField).Value != int64(i*10) {
+			t.Fatalf("expected key %d, got %v", i*10, e.key)
+		}
+	}
+	// deleting an entry that is not there, or that keep retains, does nothing
+	f.deleteEntry(indexEntry{key: IntField{5}, rid: Rid{5, 0}}, nil)
+	f.deleteEntry(indexEntry{key: IntField{10}, rid: Rid{10, 0}}, func() bool { return true })
+	if got := scanBTree(t, f, IntField{10}, IntField{10}); len(got) != 1 {
+		t.Errorf("expected the entry to be kept")
+	}
+
+	// merged pages are reused before the file grows
+	for i := 0; i < n; i++ {
+		if i%10 != 0 {
+			f.insertEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, 0}})
+		}
+	}
+	if f.NumPages() > pages+1 {
+		t.Errorf("expected freed pages to be reused, file grew from %d to %d pages", pages, f.NumPages())
+	}
+	for i := 0; i < n; i++ {
+		f.deleteEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, 0}}, nil)
+	}
+	if got := scanBTree(t, f, nil, nil); len(got) != 0 {
+		t.Errorf("expected an empty index, got %d entries", len(got))
+	}
+	root, err := f.fetch(rootPage(t, f), ReadPerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if root.kind != btreeLeafPage {
+		t.Errorf("expected the root of an empty index to be a leaf")
+	}
+	root.release(ReadPerm)
+}
+
+func TestBTreeStringKeys(t *testing.T) {
+	f := makeBTreeTestFile(t, StringType, 50)
+	long := strings.Repeat("x", 2*maxIndexKeyLength)
+	for i := 0; i < 500; i++ {
+		key := StringField{long[:rand.Intn(len(long))] + string(rune('a'+i%26))}
+		if err := f.insertEntry(indexEntry{key: indexKey(key), rid: Rid{i, 0}}); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	checkBTree(t, f, rootPage(t, f), nil, nil, true)
+	for _, e := range scanBTree(t, f, nil, nil) {
+		if len(e.key.(StringField).Value) > maxIndexKeyLength {
+			t.Fatalf("expected keys to be cut to %d bytes", maxIndexKeyLength)
+		}
+	}
+	if got := scanBTree(t, f, StringField{long}, nil); len(got) == 0 {
+		t.Errorf("expected the keys cut from strings larger than the bound to be scanned")
+	}
+}
+
+func TestBTreePersistence(t *testing.T) {
+	f := makeBTreeTestFile(t, IntType, 50)
+	n := f.maxEntries() * 5
+	for i := 0; i < n; i++ {
+		f.insertEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, i}})
+	}
+	f.bufPool.FlushAllPages()
+
+	reopened, err := NewBTreeFile(f.Filename, IntType, NewBufferPool(10))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	tid := NewTID()
+	iter, err := reopened.Iterator(tid)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	count := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if tup == nil {
+			break
+		}
+		if tup.Fields[0].(IntField).Value != int64(count) || tup.Fields[2].(IntField).Value != int64(count) {
+			t.Errorf("expected entry %d, got %v", count, tup.Fields)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("expected %d entries after reopening the index, got %d", n, count)
+	}
+}
+
+func TestBTreeSplitRecoveredTogether(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	f := makeBTreeTestFile(t, IntType, 50)
+	if err := f.bufPool.OpenLog(logFile); err != nil {
+		t.Fatalf("failed to open log: %s", err)
+	}
+	leaf := rootPage(t, f)
+	n := f.maxEntries() + 1
+	for i := 0; i < n; i++ {
+		if err := f.insertEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, i}}); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	if rootPage(t, f) == leaf {
+		t.Fatalf("expected the root leaf to be split")
+	}
+	// write back the leaf that was split, as eviction would, but not the
+	// other pages of the split, then crash
+	if err := f.bufPool.writeBackPage(heapHash{f.Filename, leaf}); err != nil {
+		t.Fatalf("write back failed: %s", err)
+	}
+	f.bufPool.logFile.Close()
+
+	bp := NewBufferPool(50)
+	if err := bp.OpenLog(logFile); err != nil {
+		t.Fatalf("recovery failed: %s", err)
+	}
+	defer bp.logFile.Close()
+	reopened, err := NewBTreeFile(f.Filename, IntType, bp)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if entries := scanBTree(t, reopened, nil, nil); len(entries) != n {
+		t.Errorf("expected %d entries after recovery, got %d", n, len(entries))
+	}
+	checkBTree(t, reopened, rootPage(t, reopened), nil, nil, true)
+}
+
+func TestBTreeConcurrent(t *testing.T) {
+	f := makeBTreeTestFile(t, IntType, 100)
+	const workers, perWorker = 8, 1000
+	// keys of even workers are deleted again as they are inserted, while odd
+	// workers insert theirs and readers scan
+	var wg sync.WaitGroup
+	errs := make(chan error, workers+1)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				e := indexEntry{key: IntField{int64(i*workers + w)}, rid: Rid{w, i}}
+				if err := f.insertEntry(e); err != nil {
+					errs <- err
+					return
+				}
+				if w%2 == 0 && i > 0 {
+					prev := indexEntry{key: IntField{int64((i-1)*workers + w)}, rid: Rid{w, i - 1}}
+					if err := f.deleteEntry(prev, nil); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			next, err := f.scan(nil, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var last *indexEntry
+			for {
+				e, err := next()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if e == nil {
+					break
+				}
+				if last != nil && compareEntries(last, e) >= 0 {
+					t.Errorf("concurrent scan returned entries out of order")
+				}
+				last = e
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("%s", err)
+	}
+	checkBTree(t, f, rootPage(t, f), nil, nil, true)
+	want := workers/2*perWorker + workers/2
+	if got := scanBTree(t, f, nil, nil); len(got) != want {
+		t.Errorf("expected %d entries, got %d", want, len(got))
+	}
+}
@@ -1,10 +1,8 @@
 package godb
 
 import (
+	"bytes"
 	"container/list"
-	"errors"
-	"fmt"
-	"math/rand"
 	"sync"
 	"time"
 )
@@ -37,12 +35,58 @@ type BufferPool struct {
 	lst  *list.List
 	Cap  int
 	//lab3
-	poolLock              sync.Mutex
-	aliveTransactions     map[TransactionID]struct{}
-	transactionReadLocks  map[TransactionID](map[any]struct{})
-	transactionWriteLocks map[TransactionID](map[any]struct{})
+	poolLock          sync.Mutex
+	aliveTransactions map[TransactionID]struct{}
+	lockManager       *LockManager
+	transactions      *TransactionManager
+	// running transactions whose changes could not be undone when they were
+	// aborted (see AbortTransaction)
+	failedAborts map[TransactionID]struct{}
 
-	adjacencyList map[TransactionID](map[TransactionID]struct{})
+	// write-ahead log; nil if logging is disabled (see OpenLog)
+	logFile *LogFile
+	// log offsets of the insert and delete records written on behalf of each
+	// transaction that has not committed yet, used to roll it back on abort
+	transactionUpdates map[TransactionID][]int64
+	// log offset of the first image of each dirty page logged since the page
+	// was last written back (its recLSN)
+	pageRecLSN map[heapHash]int64
+	// data files written since the last checkpoint, which may not be synced
+	unsyncedFiles map[string]struct{}
+	// log offset of the last checkpoint record, or -1 if there is none
+	lastCheckpointLSN int64
+	// serializes checkpoints (see Checkpoint)
+	checkpointLock sync.Mutex
+
+	// version store (see mvcc.go): number of transactions committed so far
+	commitSeq int64
+	// the value of commitSeq when each running transaction began
+	snapshots map[TransactionID]int64
+	// the value of commitSeq each transaction committed with
+	committedAt map[int64]int64
+	// the versions of rows that are not visible to every snapshot
+	versions map[rowLockKey][]*tupleVersion
+	// the inserts and deletes of each running transaction, oldest first
+	transactionChanges map[TransactionID][]*logRecord
+	// the savepoints of each running transaction (see savepoint.go)
+	savepoints map[TransactionID][]savepoint
+	// the isolation level of each running transaction (see isolation.go)
+	isolation map[TransactionID]IsolationLevel
+
+	// the entries each running transaction added to or removed from indexes,
+	// oldest first (see index.go)
+	indexChanges map[TransactionID][]*indexChange
+	// the index entries that are dead, which Vacuum removes once no snapshot
+	// can see their tuples
+	deadIndexEntries []*indexChange
+
+	// the number of rows committed to each heap file that has been read or
+	// changed, by file name (see row_count.go)
+	tableRows map[string]*tableRowCount
+
+	// the number of bytes of tuples each ORDER BY planned for queries on the
+	// pool's tables sorts in memory (see [NewOrderByBuffered])
+	sortMemory int
 }
 
 type pair struct {
@@ -50,8 +94,55 @@ type pair struct {
 	value *Page
 }
 
-// Create a new BufferPool with the specified number of pages
-func NewBufferPool(numPages int) *BufferPool {
+// Optional settings for NewBufferPool
+type BufferPoolOption func(*BufferPool)
+
+// Handle deadlocks between transactions with the given policy, instead of
+// DetectYoungest (see [DeadlockPolicy])
+func WithDeadlockPolicy(policy DeadlockPolicy) BufferPoolOption {
+	return func(bp *BufferPool) {
+		bp.lockManager.policy = policy
+	}
+}
+
+// Handle deadlocks by aborting transactions that wait longer than timeout for
+// a lock (the LockTimeout policy)
+func WithLockTimeout(timeout time.Duration) BufferPoolOption {
+	return func(bp *BufferPool) {
+		bp.lockManager.policy = LockTimeout
+		bp.lockManager.timeout = timeout
+	}
+}
+
+// Record the status of transactions in tm, instead of
+// DefaultTransactionManager
+func WithTransactionManager(tm *TransactionManager) BufferPoolOption {
+	return func(bp *BufferPool) {
+		bp.transactions = tm
+	}
+}
+
+// Escalate the row locks a transaction holds on a page to a lock on the page
+// once it holds more than n of them, instead of DefaultMaxRowLocksPerPage
+func WithMaxRowLocksPerPage(n int) BufferPoolOption {
+	return func(bp *BufferPool) {
+		bp.lockManager.maxRowLocks = n
+	}
+}
+
+// Sort the input of each ORDER BY in runs of at most bytes bytes of tuples,
+// instead of DefaultSortMemory
+func WithSortMemory(bytes int) BufferPoolOption {
+	return func(bp *BufferPool) {
+		bp.sortMemory = bytes
+	}
+}
+
+// Create a new BufferPool with the specified number of pages.  Until a log is
+// attached (see [BufferPool.OpenLog]), the pages running transactions have
+// changed cannot be evicted, so getting a page returns a BufferPoolFullError
+// once every page of the pool holds such changes.
+func NewBufferPool(numPages int, opts ...BufferPoolOption) *BufferPool {
 	// TODO: some code goes here
 	var bp BufferPool
 	bp.Cap = numPages
@@ -59,10 +150,25 @@ func NewBufferPool(numPages int) *BufferPool {
 	bp.pool = make(map[heapHash]*list.Element)
 	//lab3
 	bp.aliveTransactions = make(map[TransactionID]struct{})
-	bp.transactionReadLocks = make(map[TransactionID](map[any]struct{}))
-	bp.transactionWriteLocks = make(map[TransactionID](map[any]struct{}))
-
-	bp.adjacencyList = make(map[TransactionID](map[TransactionID]struct{}))
+	bp.failedAborts = make(map[TransactionID]struct{})
+	bp.lockManager = NewLockManager(DetectYoungest, DefaultLockTimeout)
+	bp.transactions = DefaultTransactionManager
+	bp.transactionUpdates = make(map[TransactionID][]int64)
+	bp.pageRecLSN = make(map[heapHash]int64)
+	bp.unsyncedFiles = make(map[string]struct{})
+	bp.lastCheckpointLSN = -1
+	bp.snapshots = make(map[TransactionID]int64)
+	bp.committedAt = make(map[int64]int64)
+	bp.versions = make(map[rowLockKey][]*tupleVersion)
+	bp.transactionChanges = make(map[TransactionID][]*logRecord)
+	bp.savepoints = make(map[TransactionID][]savepoint)
+	bp.isolation = make(map[TransactionID]IsolationLevel)
+	bp.indexChanges = make(map[TransactionID][]*indexChange)
+	bp.tableRows = make(map[string]*tableRowCount)
+	bp.sortMemory = DefaultSortMemory
+	for _, opt := range opts {
+		opt(&bp)
+	}
 	return &bp
 }
 
@@ -72,76 +178,434 @@ func (bp *BufferPool) FlushAllPages() {
 	// TODO: some code goes here
 	for bp.lst.Len() != 0 {
 		e := bp.lst.Front()
-		page := e.Value.(pair).value
-		pg := (*page).(*heapPage)
-		pg.file.flushPage(page)
+		if (*e.Value.(pair).value).isDirty() {
+			bp.writePage(e.Value.(pair))
+		}
 		delete(bp.pool, e.Value.(pair).key)
 		bp.lst.Remove(e)
 	}
 }
 
-// Abort the transaction, releasing locks. Because GoDB is FORCE/NO STEAL, none
-// of the pages tid has dirtired will be on disk so it is sufficient to just
-// release locks to abort. You do not need to implement this for lab 1.
-func (bp *BufferPool) AbortTransaction(tid TransactionID) {
-	// TODO: some code goes here
+// Log the image of the page, if it has changed since it was last logged or
+// read from disk.
+func (bp *BufferPool) logPage(p pair) error {
+	after, err := (*p.value).toBuffer()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal((*p.value).getBeforeImage(), after.Bytes()) {
+		return nil
+	}
+	off, err := bp.logFile.logPageImage(p.key, after.Bytes())
+	if err != nil {
+		return err
+	}
+	if _, ok := bp.pageRecLSN[p.key]; !ok {
+		bp.pageRecLSN[p.key] = off
+	}
+	return (*p.value).setBeforeImage()
+}
 
+// Write a dirty page back to its file.  With a log attached, the image of the
+// page is logged and forced first; the changes of transactions that have not
+// committed yet can be undone from their insert and delete records, which were
+// logged as the changes were made.
+func (bp *BufferPool) writePage(p pair) error {
+	if bp.logFile != nil {
+		if err := bp.logPage(p); err != nil {
+			return err
+		}
+		if err := bp.logFile.force(); err != nil {
+			return err
+		}
+	}
+	if err := (*(*p.value).getFile()).flushPage(p.value); err != nil {
+		return err
+	}
+	if bp.logFile != nil {
+		delete(bp.pageRecLSN, p.key)
+		bp.unsyncedFiles[p.key.FileName] = struct{}{}
+	}
+	(*p.value).setDirty(false)
+	return (*p.value).setBeforeImage()
 }
 
-// Commit the transaction, releasing locks. Because GoDB is FORCE/NO STEAL, none
-// of the pages tid has dirtied will be on disk, so prior to releasing locks you
-// should iterate through pages and write them to disk.  In GoDB lab3 we assume
-// that the system will not crash while doing this, allowing us to avoid using a
-// WAL. You do not need to implement this for lab 1.
-func (bp *BufferPool) CommitTransaction(tid TransactionID) {
-	// TODO: some code goes here
+// Make room for one more page.  Clean pages are evicted first, least recently
+// used first.  If every page is dirty, the least recently used one is written
+// back and evicted (STEAL), which is only possible when a log is attached to
+// record the undo information of its uncommitted changes, or if it is an index
+// page, whose changes are never undone, unless its file is being restructured
+// (see writeIndexPage).  Pinned pages are never evicted.  If only the pages of
+// files being restructured could be, none is, and the pool holds more pages
+// than its capacity until they can be evicted.
+//
+// Without a log, the before images of dirty pages are only kept in memory, so
+// a BufferPoolFullError is returned rather than writing back uncommitted
+// changes that could then not be undone: transactions may dirty no more pages
+// than fit in the pool at once (see NewBufferPool).
+func (bp *BufferPool) evictPage() error {
+	for e := bp.lst.Back(); e != nil; e = e.Prev() {
+		p := e.Value.(pair).value
+		if !(*p).isDirty() && !isPinned(p) {
+			bp.lst.Remove(e)
+			delete(bp.pool, e.Value.(pair).key)
+			return nil
+		}
+	}
+	needsLog, restructuring := false, false
+	for e := bp.lst.Back(); e != nil; e = e.Prev() {
+		p := e.Value.(pair).value
+		if isPinned(p) {
+			continue
+		}
+		if _, index := (*p).(indexFilePage); index {
+			written, err := bp.writeIndexPage(e.Value.(pair))
+			if err != nil {
+				return err
+			}
+			if !written {
+				restructuring = true
+				continue
+			}
+		} else if bp.logFile == nil {
+			needsLog = true
+			continue
+		} else if err := bp.writePage(e.Value.(pair)); err != nil {
+			return err
+		}
+		bp.lst.Remove(e)
+		delete(bp.pool, e.Value.(pair).key)
+		return nil
+	}
+	if restructuring {
+		// the pool holds more pages than it should until the change is done
+		return nil
+	}
+	if needsLog {
+		return GoDBError{BufferPoolFullError, "all pages in the buffer pool hold uncommitted changes, which are only evicted when a log is attached (see OpenLog)"}
+	}
+	return GoDBError{BufferPoolFullError, "all pages in the buffer pool are dirty"}
+}
+
+// Attach the write-ahead log stored in fileName to the buffer pool, creating it
+// if it does not exist.  Any records left in the log by a previous run are
+// recovered first (see [LogFile.Recover]), so this should be called before the
+// pool is used to read pages.  Once a log is attached, further calls are no-ops.
+func (bp *BufferPool) OpenLog(fileName string) error {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	if bp.logFile != nil {
+		return nil
+	}
+	lf, err := NewLogFile(fileName)
+	if err != nil {
+		return err
+	}
+	err = lf.Recover()
+	if err != nil {
+		lf.Close()
+		return err
+	}
+	// the ids of new transactions must not be confused with those in the log
+	bp.transactions.observe(lf.maxTid)
+	// recovery may have rewritten pages on disk, so drop any clean copies
+	for key, e := range bp.pool {
+		if !(*e.Value.(pair).value).isDirty() {
+			bp.lst.Remove(e)
+			delete(bp.pool, key)
+		}
+	}
+	bp.logFile = lf
+	return nil
+}
 
+// Return the pages in the buffer pool that tid may have dirtied: the dirty
+// pages it holds locks on that allow it to update them, or some of their rows.
+func (bp *BufferPool) dirtyPages(tid TransactionID) []pair {
+	pages := make([]pair, 0)
+	for _, key := range bp.lockManager.WritePages(tid) {
+		node, ok := bp.pool[key]
+		if ok && (*node.Value.(pair).value).isDirty() {
+			pages = append(pages, node.Value.(pair))
+		}
+	}
+	return pages
 }
 
-func (bp *BufferPool) BeginTransaction(tid TransactionID) error {
-	// TODO: some code goes here
+// Insert a tuple into (rtype InsertRecord), or delete it from (DeleteRecord)
+// the specified slot of a page on behalf of tid, by calling apply, and mark the
+// page dirty.  tuple is the serialized tuple to insert.  The new version of the
+// row is added to the version store, and with a log attached, the change is
+// logged first, so that it is on disk before the page can be.  The caller must
+// hold a lock on the row.  Deleting a row that was changed by a transaction
+// tid cannot see returns a SerializationError (see mvcc.go), and inserting a
+// tuple that no longer fits on the page, because other transactions inserted
+// into it since the slot was picked, returns a PageFullError.
+func (bp *BufferPool) updateTuple(tid TransactionID, rtype LogRecordType, page *heapPage, slot int, tuple []byte, apply func() error) error {
 	bp.poolLock.Lock()
 	defer bp.poolLock.Unlock()
+	key := page.file.pageKey(page.pageNo).(heapHash)
+	row := rowLockKey{key, slot}
+	if rtype == InsertRecord && !page.fits(slot, len(tuple)) {
+		return GoDBError{PageFullError, "tuple does not fit on the page"}
+	}
+	if rtype == DeleteRecord {
+		if bp.isolationLevel(tid) == RepeatableRead {
+			if err := bp.checkNewestVisible(tid, row); err != nil {
+				return err
+			}
+		}
+		var err error
+		if tuple, err = page.slotBytes(slot); err != nil {
+			return err
+		}
+	}
+	bp.addVersion(tid, rtype, row, tuple)
+	if bp.logFile != nil {
+		off, err := bp.logFile.logTupleUpdate(rtype, tid, key, slot, tuple)
+		if err != nil {
+			return err
+		}
+		bp.transactionUpdates[tid] = append(bp.transactionUpdates[tid], off)
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	page.setDirty(true)
+	return nil
+}
 
-	bp.aliveTransactions[tid] = struct{}{}
-	bp.transactionReadLocks[tid] = make(map[any]struct{})
-	bp.transactionWriteLocks[tid] = make(map[any]struct{})
-	bp.adjacencyList[tid] = make(map[TransactionID]struct{})
+// Undo an insert or delete record on a page in the buffer pool.  Undoing a
+// record that has already been undone does nothing.
+func undoOnPage(hp *heapPage, r *logRecord) error {
+	if r.rtype == InsertRecord {
+		if _, err := hp.slotBytes(r.slot); err != nil {
+			return nil
+		}
+		return hp.deleteTuple(Rid{hp.pageNo, r.slot})
+	}
+	if _, err := hp.slotBytes(r.slot); err == nil {
+		return nil
+	}
+	tup, err := hp.decodeTuple(r.tuple)
+	if err != nil {
+		return err
+	}
+	_, err = hp.insertTupleAt(tup, r.slot)
+	return err
+}
+
+// Undo the changes tid has made to pages.
+//
+// Without a log, tid holds exclusive locks on the pages it has changed, none of
+// which has been written back, so they are restored from their before images.
+// Restored images are written to disk and the cached copies are dropped from
+// the pool.
+//
+// With a log, other transactions may have changed the same pages, so the
+// changes of tid are undone one tuple at a time from its insert and delete
+// records, in reverse order.  Pages in the buffer pool are updated in place,
+// and pages that have been stolen are updated on disk; either way, their new
+// images are logged and forced before they are written back.
+func (bp *BufferPool) rollbackPages(tid TransactionID) error {
+	if bp.logFile == nil {
+		for _, p := range bp.dirtyPages(tid) {
+			if err := writePageImage(p.key, (*p.value).getBeforeImage()); err != nil {
+				return err
+			}
+			bp.lst.Remove(bp.pool[p.key])
+			delete(bp.pool, p.key)
+		}
+		return nil
+	}
+
+	cached, err := bp.undoLoggedUpdates(tid, 0)
+	if err != nil {
+		return err
+	}
+	for _, p := range cached {
+		if err := bp.writePage(p); err != nil {
+			return err
+		}
+	}
+	delete(bp.transactionUpdates, tid)
 	return nil
 }
 
-func (bp *BufferPool) dfs(tid TransactionID, visited map[TransactionID]bool, visitedThisIter map[TransactionID]bool) bool {
-	visited[tid] = true
-	visitedThisIter[tid] = true
-	for next, _ := range bp.adjacencyList[tid] {
-		if !visited[next] {
-			cycleFound := bp.dfs(next, visited, visitedThisIter)
-			if cycleFound {
-				return true
+// Undo the logged inserts and deletes of tid from its from-th one on, newest
+// first, as described for rollbackPages, and forget them.  Returns the pages in
+// the buffer pool that were updated; they are left dirty.
+func (bp *BufferPool) undoLoggedUpdates(tid TransactionID, from int) (map[heapHash]pair, error) {
+	cached := make(map[heapHash]pair)
+	stolen := make(map[heapHash][]byte)
+	updates := bp.transactionUpdates[tid]
+	for i := len(updates) - 1; i >= from; i-- {
+		r, err := bp.logFile.readRecordAt(updates[i])
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := bp.pool[r.page]; ok {
+			p := e.Value.(pair)
+			if err := undoOnPage((*p.value).(*heapPage), r); err != nil {
+				return nil, err
+			}
+			(*p.value).setDirty(true)
+			cached[r.page] = p
+			continue
+		}
+		img, ok := stolen[r.page]
+		if !ok {
+			if img, err = readPageImage(r.page); err != nil {
+				return nil, err
+			}
+			if img == nil {
+				continue
 			}
-		} else if visitedThisIter[next] {
-			return true
 		}
+		if err := undoTupleUpdate(img, r); err != nil {
+			return nil, err
+		}
+		stolen[r.page] = img
+	}
+	for key, img := range stolen {
+		if _, err := bp.logFile.logPageImage(key, img); err != nil {
+			return nil, err
+		}
+	}
+	if len(stolen) > 0 {
+		if err := bp.logFile.force(); err != nil {
+			return nil, err
+		}
+	}
+	for key, img := range stolen {
+		if err := writePageImage(key, img); err != nil {
+			return nil, err
+		}
+		bp.unsyncedFiles[key.FileName] = struct{}{}
 	}
-	visitedThisIter[tid] = false
-	return false
+	bp.transactionUpdates[tid] = updates[:from]
+	return cached, nil
 }
 
-func (bp *BufferPool) findCycle() bool {
-	visited := make(map[TransactionID]bool)
-	visitedThisIter := make(map[TransactionID]bool)
-	for tid, _ := range bp.aliveTransactions {
-		visited[tid] = false
-		visitedThisIter[tid] = false
+// Abort the transaction, undoing its changes to pages (see rollbackPages) and
+// releasing locks.  Once aborted, the transaction can no longer read pages;
+// aborting it again does nothing.
+//
+// If its changes cannot be undone, the error is returned and the transaction
+// keeps running, with its locks held, so that no other transaction sees the
+// changes left.  It can no longer commit, and aborting it again retries the
+// rollback, which does not undo the same change twice.
+func (bp *BufferPool) AbortTransaction(tid TransactionID) error {
+	// TODO: some code goes here
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	if _, ok := bp.aliveTransactions[tid]; !ok {
+		return nil
 	}
-	for tid, _ := range bp.aliveTransactions {
-		if !visited[tid] {
-			if bp.dfs(tid, visited, visitedThisIter) {
-				return true
+	if err := bp.rollbackPages(tid); err != nil {
+		bp.failedAborts[tid] = struct{}{}
+		return err
+	}
+	delete(bp.failedAborts, tid)
+	bp.rollbackVersions(tid, 0)
+	bp.rollbackIndexChanges(tid, 0)
+	delete(bp.indexChanges, tid)
+	delete(bp.transactionChanges, tid)
+	delete(bp.savepoints, tid)
+	bp.endSnapshot(tid)
+	if bp.logFile != nil {
+		bp.logFile.logAbort(tid)
+	}
+	bp.transactions.finish(tid, TransactionAborted)
+	delete(bp.aliveTransactions, tid)
+	delete(bp.isolation, tid)
+	bp.lockManager.ReleaseAll(tid)
+	removeTransactionSpills(tid)
+	return nil
+}
+
+// Commit the transaction, releasing locks.  Returns an IllegalTransactionError
+// if the transaction is not running, or failed to abort.
+//
+// Without a log, GoDB is FORCE/NO STEAL: none of the pages tid has dirtied are
+// on disk, so prior to releasing locks we iterate through them, write them to
+// disk and mark them clean.
+//
+// With a write-ahead log attached, GoDB is STEAL/NO FORCE: the image of each
+// page tid may have changed since it was last logged is appended to the log,
+// followed by a commit record, and the transaction is committed once they are
+// forced.  The pages themselves stay
+// dirty in the pool and are written back whenever they are evicted.
+//
+// Either way, the index pages tid has changed are logged or written back
+// first, along with the pages themselves (see index.go), and the row counts of
+// the tables tid has changed are updated (see row_count.go).
+func (bp *BufferPool) CommitTransaction(tid TransactionID) error {
+	// TODO: some code goes here
+	bp.poolLock.Lock()
+	_, failed := bp.failedAborts[tid]
+	bp.poolLock.Unlock()
+	if failed {
+		return GoDBError{IllegalTransactionError, "transaction failed to abort, and can only be aborted again"}
+	}
+	if err := bp.flushIndexes(tid); err != nil {
+		return err
+	}
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	if _, ok := bp.aliveTransactions[tid]; !ok {
+		return GoDBError{IllegalTransactionError, "transaction is not running"}
+	}
+
+	pages := bp.dirtyPages(tid)
+	if bp.logFile == nil {
+		for _, p := range pages {
+			if err := bp.writePage(p); err != nil {
+				return err
 			}
 		}
+	} else {
+		for _, p := range pages {
+			if err := bp.logPage(p); err != nil {
+				return err
+			}
+		}
+		if err := bp.logFile.logCommit(tid); err != nil {
+			return err
+		}
+		if err := bp.logFile.force(); err != nil {
+			return err
+		}
+	}
+	bp.commitRowCounts(tid, bp.commitSeq+1)
+	bp.commitVersions(tid)
+	bp.commitIndexChanges(tid)
+	delete(bp.savepoints, tid)
+	bp.transactions.finish(tid, TransactionCommitted)
+	delete(bp.transactionUpdates, tid)
+	delete(bp.aliveTransactions, tid)
+	delete(bp.isolation, tid)
+	bp.lockManager.ReleaseAll(tid)
+	removeTransactionSpills(tid)
+	return nil
+}
+
+// Begin a transaction, at DefaultIsolationLevel unless opts select another
+// isolation level (see [WithIsolationLevel])
+func (bp *BufferPool) BeginTransaction(tid TransactionID, opts ...TransactionOption) error {
+	// TODO: some code goes here
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+
+	options := transactionOptions{DefaultIsolationLevel}
+	for _, opt := range opts {
+		opt(&options)
 	}
-	return false
+	bp.aliveTransactions[tid] = struct{}{}
+	bp.isolation[tid] = options.level
+	bp.transactions.begin(tid)
+	bp.takeSnapshot(tid)
+	return nil
 }
 
 // Retrieve the specified page from the specified DBFile (e.g., a HeapFile), on
@@ -170,95 +634,130 @@ func (bp *BufferPool) findCycle() bool {
 */
 func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm) (*Page, error) {
 	// TODO: some code goes here
+	return bp.getPage(file, pageNo, tid, perm, lockWholePage)
+}
+
+// Retrieve a page like GetPage, on behalf of a transaction that reads
+// (ReadPerm) or updates (WritePerm) some of its rows, which it must lock with
+// lockRow first.  With a log attached, the page is only locked in an intention
+// mode, so that transactions touching different rows of the page do not
+// conflict.  Without one the page is locked as a whole, since the changes of a
+// transaction can then only be rolled back by restoring the page.
+func (bp *BufferPool) getPageForRows(file DBFile, pageNo int, tid TransactionID, perm RWPerm) (*Page, error) {
+	return bp.getPage(file, pageNo, tid, perm, lockRows)
+}
+
+// Retrieve a page on behalf of a transaction that scans its table.  Under
+// Serializable, the table is locked in shared mode.  Under the other isolation
+// levels, the transaction reads the page through its snapshot (see mvcc.go) or
+// reads uncommitted rows, so only an intention lock is taken on the table, and
+// readers do not wait for writers or make them wait.
+func (bp *BufferPool) getPageForScan(file DBFile, pageNo int, tid TransactionID) (*Page, error) {
+	return bp.getPage(file, pageNo, tid, ReadPerm, scanTable)
+}
+
+// Lock a row on behalf of tid in mode (Shared or Exclusive), see
+// [LockManager.LockRow].  If the lock cannot be acquired, tid is aborted.
+func (bp *BufferPool) lockRow(file DBFile, rid Rid, tid TransactionID, mode LockMode) error {
+	key := rowLockKey{file.pageKey(rid.pageid).(heapHash), rid.slotid}
+	if err := bp.lockManager.LockRow(tid, key, mode); err != nil {
+		return bp.abortOnError(tid, err)
+	}
+	return nil
+}
+
+// Lock a row like lockRow, but return false instead of waiting if another
+// transaction holds a conflicting lock on it.
+func (bp *BufferPool) tryLockRow(file DBFile, rid Rid, tid TransactionID, mode LockMode) (bool, error) {
+	key := rowLockKey{file.pageKey(rid.pageid).(heapHash), rid.slotid}
+	ok, err := bp.lockManager.TryLockRow(tid, key, mode)
+	if err != nil {
+		return false, bp.abortOnError(tid, err)
+	}
+	return ok, nil
+}
+
+// Abort tid, which failed with err, and return err, or the error of the abort
+// if it failed, in which case tid is still running (see AbortTransaction)
+func (bp *BufferPool) abortOnError(tid TransactionID, err error) error {
+	if aerr := bp.AbortTransaction(tid); aerr != nil {
+		return aerr
+	}
+	return err
+}
+
+// How getPage locks a page
+type pageAccess int
+
+const (
+	lockWholePage pageAccess = iota // see GetPage
+	lockRows      pageAccess = iota // see getPageForRows
+	scanTable     pageAccess = iota // see getPageForScan
+)
+
+// Retrieve a page on behalf of tid after locking it with perm, as described by
+// access.
+func (bp *BufferPool) getPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm, access pageAccess) (*Page, error) {
 	key := file.pageKey(pageNo).(heapHash)
 	bp.poolLock.Lock()
 	_, ok := bp.aliveTransactions[tid]
 	if !ok {
 		bp.poolLock.Unlock()
-		return nil, errors.New("transaction is not alive")
+		return nil, GoDBError{IllegalTransactionError, "transaction is not running"}
+	}
+	mode := permMode(perm)
+	if access == lockRows && bp.logFile != nil {
+		mode = intention(mode)
 	}
+	level := bp.isolationLevel(tid)
 	bp.poolLock.Unlock()
 
-	for {
-		bp.poolLock.Lock()
-		bad := false
-		if perm == ReadPerm {
-			// check write locks
-			for other_tid, _ := range bp.aliveTransactions {
-				if other_tid == tid {
-					continue
-				}
-				writeLocks := bp.transactionWriteLocks[other_tid]
-				for lock, _ := range writeLocks {
-					if lock == key {
-						bp.adjacencyList[tid][other_tid] = struct{}{}
-						bad = true
-					}
-				}
-			}
-		} else if perm == WritePerm {
-			// check read and write locks
-			for other_tid, _ := range bp.aliveTransactions {
-				if other_tid == tid {
-					continue
-				}
-				readLocks := bp.transactionReadLocks[other_tid]
-				for lock, _ := range readLocks {
-					if lock == key {
-						bp.adjacencyList[tid][other_tid] = struct{}{}
-						bad = true
-					}
-				}
-				writeLocks := bp.transactionWriteLocks[other_tid]
-				for lock, _ := range writeLocks {
-					if lock == key {
-						bp.adjacencyList[tid][other_tid] = struct{}{}
-						bad = true
-					}
-				}
-			}
+	var err error
+	if access == scanTable {
+		tableMode := IntentionShared
+		if level == Serializable {
+			tableMode = Shared
 		}
-		randTime := rand.Intn(30) - 15
-		if bp.findCycle() {
-			bp.poolLock.Unlock()
-			bp.AbortTransaction(tid)
-			time.Sleep(time.Duration(15+randTime) * time.Millisecond)
-			return nil, errors.New("transaction aborted")
-		}
-		if bad {
-			bp.poolLock.Unlock()
-			time.Sleep(time.Duration(15+randTime) * time.Millisecond)
-		} else {
-			break
+		err = bp.lockManager.Acquire(tid, tableLockKey{key.FileName}, tableMode)
+	} else {
+		_, held := bp.lockManager.Holds(tid, key)
+		err = bp.lockManager.LockPage(tid, key, mode)
+		if err == nil && !held && mode == Shared && level.shortReadLocks() {
+			// the lock only makes the read wait for writers of the page
+			bp.lockManager.Release(tid, key)
 		}
 	}
+	if err != nil {
+		return nil, bp.abortOnError(tid, err)
+	}
 
+	bp.poolLock.Lock()
 	defer bp.poolLock.Unlock()
-
-	if perm == ReadPerm {
-		bp.transactionReadLocks[tid][key] = struct{}{}
-	} else if perm == WritePerm {
-		bp.transactionWriteLocks[tid][key] = struct{}{}
+	if _, ok := bp.aliveTransactions[tid]; !ok {
+		// aborted while waiting for the lock
+		bp.lockManager.ReleaseAll(tid)
+		return nil, GoDBError{IllegalTransactionError, "transaction is not running"}
 	}
 
+	return bp.cachedPage(file, pageNo)
+}
+
+// Return the specified page from the pool, reading it from disk (and evicting
+// another page if the pool is full) if it is not cached.  The caller must hold
+// the pool lock.
+func (bp *BufferPool) cachedPage(file DBFile, pageNo int) (*Page, error) {
+	key := file.pageKey(pageNo).(heapHash)
 	node, ok := bp.pool[key]
 	if ok {
 		bp.lst.MoveToFront(node)
 		return node.Value.(pair).value, nil
 	}
-	cnt := 0
-	for bp.lst.Len() == bp.Cap {
-		if cnt == bp.Cap {
-			return nil, fmt.Errorf("all is dirty")
+	for n := bp.lst.Len(); n >= bp.Cap; n-- {
+		if err := bp.evictPage(); err != nil {
+			return nil, err
 		}
-		last := bp.lst.Back()
-		p := last.Value.(pair).value
-		if (*p).isDirty() {
-			bp.lst.MoveToFront(last)
-			cnt++
-		} else {
-			bp.lst.Remove(last)
-			delete(bp.pool, last.Value.(pair).key)
+		if bp.lst.Len() == n {
+			// see evictPage
 			break
 		}
 	}
@@ -271,3 +770,36 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 	bp.pool[key] = e
 	return e.Value.(pair).value, nil
 }
+
+// Pages of files that are not locked on behalf of transactions, such as index
+// pages (see BTreeFile), which are pinned instead while they are used so that
+// they are not evicted
+type pinnedPage interface {
+	pin()
+	unpin()
+	pinned() bool
+}
+
+// Retrieve the specified page of file, whose pages must be pinnedPages, without
+// locking it on behalf of any transaction, and pin it.  The caller unpins the
+// page once it is done with it.
+func (bp *BufferPool) fetchPage(file DBFile, pageNo int) (*Page, error) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	p, err := bp.cachedPage(file, pageNo)
+	if err != nil {
+		return nil, err
+	}
+	pp, ok := (*p).(pinnedPage)
+	if !ok {
+		return nil, GoDBError{IllegalOperationError, "page cannot be pinned"}
+	}
+	pp.pin()
+	return p, nil
+}
+
+// Return true if the page is pinned (see pinnedPage)
+func isPinned(p *Page) bool {
+	pp, ok := (*p).(pinnedPage)
+	return ok && pp.pinned()
+}
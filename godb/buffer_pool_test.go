@@ -1,11 +1,16 @@
 package godb
 
 import (
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetPage(t *testing.T) {
-	_, t1, t2, hf, bp, _ := makeTestVars()
+	_, t1, t2, hf, bp, _ := makeTestVars(t)
+	// about 88 tuples with strings of StringLength bytes fit on a page
+	t1, t2 = wideTuple(t1), wideTuple(t2)
 	tid := NewTID()
 	for i := 0; i < 300; i++ {
 		bp.BeginTransaction(tid)
@@ -46,3 +51,236 @@ func TestGetPage(t *testing.T) {
 		t.Fatalf("No error when getting page 7 from a file with 6 pages.")
 	}
 }
+
+// count the tuples in the heap file as seen by a fresh buffer pool with no log
+func countOnDisk(t *testing.T, td *TupleDesc) int {
+	bp := NewBufferPool(20)
+	hf, err := NewHeapFile(TestingFile, td, bp)
+	if err != nil {
+		t.Fatalf("failed to reopen heap file: %s", err)
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := hf.Iterator(tid)
+	cnt := 0
+	for tup, err := iter(); tup != nil || err != nil; tup, err = iter() {
+		if err != nil {
+			t.Fatalf("iterator failed: %s", err)
+		}
+		cnt++
+	}
+	return cnt
+}
+
+func TestNoStealWithoutLog(t *testing.T) {
+	td, t1, _, hf, bp, tid := makeTestVars(t)
+	t1 = wideTuple(t1)
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = hf.insertTuple(&t1, tid)
+	}
+	if gerr, ok := err.(GoDBError); !ok || gerr.code != BufferPoolFullError || !strings.Contains(gerr.errString, "log") {
+		t.Fatalf("expected a BufferPoolFullError asking for a log, got %v", err)
+	}
+	if cnt := countOnDisk(t, &td); cnt != 0 {
+		t.Errorf("expected no uncommitted tuple to be written back, found %d on disk", cnt)
+	}
+	bp.AbortTransaction(tid)
+}
+
+func TestStealEvictsDirtyPages(t *testing.T) {
+	td, t1, _, _ := makeLoggedTestVars(t)
+	bp := NewBufferPool(3)
+	bp.OpenLog(TestingLogFile)
+	hf, _ := NewHeapFile(TestingFile, &td, bp)
+
+	// far more dirty pages than fit in the buffer pool
+	insertAndCommit(t, hf, bp, wideTuple(t1), 1000)
+	if hf.NumPages() < 3*bp.Cap {
+		t.Fatalf("expected at least %d pages, got %d", 3*bp.Cap, hf.NumPages())
+	}
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 1000 {
+		t.Errorf("expected 1000 tuples, got %d", cnt)
+	}
+}
+
+func TestStealAbortRestoresPages(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 50)
+	bp.FlushAllPages()
+	bp.logFile.Close()
+
+	bp = NewBufferPool(3)
+	bp.OpenLog(TestingLogFile)
+	hf, _ = NewHeapFile(TestingFile, &td, bp)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 1000; i++ {
+		if err := hf.insertTuple(&t1, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	bp.AbortTransaction(tid)
+	if cnt := countOnDisk(t, &td); cnt != 50 {
+		t.Errorf("expected abort to restore 50 tuples on disk, got %d", cnt)
+	}
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 50 {
+		t.Errorf("expected 50 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestStealUndoneByRecovery(t *testing.T) {
+	td, t1, _, _ := makeLoggedTestVars(t)
+	bp := NewBufferPool(3)
+	bp.OpenLog(TestingLogFile)
+	hf, _ := NewHeapFile(TestingFile, &td, bp)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 1000; i++ {
+		if err := hf.insertTuple(&t1, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	if countOnDisk(t, &td) == 0 {
+		t.Fatalf("expected uncommitted pages to have been stolen")
+	}
+	// crash before commit
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 0 {
+		t.Errorf("expected recovery to undo stolen pages, got %d tuples", cnt)
+	}
+}
+
+func TestNoForceCommitRedoneByRecovery(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 100)
+	if cnt := countOnDisk(t, &td); cnt != 0 {
+		t.Fatalf("expected commit not to force pages, found %d tuples on disk", cnt)
+	}
+	// crash before the dirty pages are written back
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 100 {
+		t.Errorf("expected recovery to redo 100 tuples, got %d", cnt)
+	}
+}
+
+func TestAbortReleasesLocks(t *testing.T) {
+	_, t1, _, hf, bp, tid := makeTestVars(t)
+	if err := hf.insertTuple(&t1, tid); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	tid2 := NewTID()
+	bp.BeginTransaction(tid2)
+	done := make(chan error, 1)
+	go func() {
+		_, err := bp.GetPage(hf, 0, tid2, WritePerm)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatalf("expected tid2 to wait for the write lock held by tid")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	bp.AbortTransaction(tid)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected tid2 to get the page after the abort, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected abort to release the write lock")
+	}
+	if bp.lst.Len() != 1 {
+		t.Errorf("expected the aborted page to be reread, found %d pages in the pool", bp.lst.Len())
+	}
+
+	_, err := bp.GetPage(hf, 0, tid, ReadPerm)
+	if gerr, ok := err.(GoDBError); !ok || gerr.code != IllegalTransactionError {
+		t.Errorf("expected IllegalTransactionError for an aborted transaction, got %v", err)
+	}
+	// aborting twice is harmless
+	bp.AbortTransaction(tid)
+}
+
+func TestFailedAbortKeepsLocks(t *testing.T) {
+	_, t1, _, hf, bp, tid := makeTestVars(t)
+	if err := hf.insertTuple(&t1, tid); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	// the before image cannot be written back while the file is a directory
+	moved := hf.Filename + ".moved"
+	if err := os.Rename(hf.Filename, moved); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := os.Mkdir(hf.Filename, 0777); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := bp.AbortTransaction(tid); err == nil {
+		t.Fatalf("expected the abort to fail")
+	}
+	if err := bp.CommitTransaction(tid); err == nil {
+		t.Errorf("expected a transaction that failed to abort not to commit")
+	}
+	tid2 := NewTID()
+	bp.BeginTransaction(tid2)
+	done := make(chan error, 1)
+	go func() {
+		_, err := bp.GetPage(hf, 0, tid2, ReadPerm)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatalf("expected tid2 to wait for the lock kept by the failed abort")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	os.Remove(hf.Filename)
+	if err := os.Rename(moved, hf.Filename); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := bp.AbortTransaction(tid); err != nil {
+		t.Fatalf("expected the abort to be retried, got %s", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected tid2 to get the page after the abort, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the retried abort to release the lock")
+	}
+	bp.CommitTransaction(tid2)
+}
+
+func TestCommitReleasesLocks(t *testing.T) {
+	_, t1, _, hf, bp, tid := makeTestVars(t)
+	if err := hf.insertTuple(&t1, tid); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	for _, e := range bp.pool {
+		if (*e.Value.(pair).value).isDirty() {
+			t.Errorf("expected commit to flush dirty pages")
+		}
+	}
+	if len(bp.lockManager.WritePages(tid)) != 0 {
+		t.Errorf("expected commit to release locks")
+	}
+	if cnt := countOnDisk(t, &t1.Desc); cnt != 1 {
+		t.Errorf("expected 1 tuple on disk, got %d", cnt)
+	}
+
+	tid2 := NewTID()
+	bp.BeginTransaction(tid2)
+	if _, err := bp.GetPage(hf, 0, tid2, WritePerm); err != nil {
+		t.Fatalf("expected lock to be free after commit, got %s", err)
+	}
+	if err := bp.CommitTransaction(tid); err == nil {
+		t.Errorf("expected error committing a transaction twice")
+	}
+}
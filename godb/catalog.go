@@ -4,12 +4,23 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
 type Table struct {
-	name string
-	desc TupleDesc
+	name    string
+	desc    TupleDesc
+	indexes []*Index
+}
+
+// An index listed in a catalog file: index <name> on <table> (<column>),
+// followed by using <method> unless it is a B+ tree
+type indexSpec struct {
+	name   string
+	table  string
+	column string
+	method IndexMethod
 }
 
 type Catalog struct {
@@ -34,10 +45,15 @@ func (c *Catalog) SaveToFile(catalogFile string, rootPath string) error {
 func (c *Catalog) dropTable(table string) error {
 	for i, t := range c.tables {
 		if t.name == table {
+			for _, idx := range t.indexes {
+				idx.remove(c.bp)
+			}
 			c.tableMap[table] = nil
 			c.columnMap[table] = nil
 			c.tables = append(c.tables[:i], c.tables[i+1:]...)
 			os.Remove(c.tableNameToFile(table))
+			removeOverflowFile(c.tableNameToFile(table))
+			c.bp.forgetRows(c.tableNameToFile(table))
 			return nil
 		}
 	}
@@ -69,32 +85,45 @@ func ImportCatalogFromCSVs(catalogFile string, bp *BufferPool, rootPath string,
 	return nil
 }
 
-func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []string, error) {
+func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []string, []indexSpec, error) {
 	var tables []TupleDesc
 	var names []string
+	var indexes []indexSpec
 	f, err := os.Open(rootPath + "/" + catalogFile)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	scanner := bufio.NewScanner(f)
 
 	for scanner.Scan() {
 		// code to read each line
 		line := strings.ToLower(scanner.Text())
-		sep := strings.Split(line, "(")
-		if len(sep) != 2 {
-			return nil, nil, GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
+		open, close := strings.Index(line, "("), strings.LastIndex(line, ")")
+		if open < 0 || close < open {
+			return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("expected parenthesized fields in catalog entry (%s)", line)}
 		}
-		tableName := strings.TrimSpace(sep[0])
-		rest := strings.Trim(sep[1], "()")
-		fields := strings.Split(rest, ",")
+		tableName := strings.TrimSpace(line[:open])
+		if words := strings.Fields(tableName); len(words) == 4 && words[0] == "index" && words[2] == "on" {
+			method := BTreeIndex
+			if using := strings.Fields(line[close+1:]); len(using) > 0 {
+				m, ok := indexMethodNamed(using[len(using)-1])
+				if len(using) != 2 || using[0] != "using" || !ok {
+					return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown index method in catalog entry (%s)", line)}
+				}
+				method = m
+			}
+			indexes = append(indexes, indexSpec{words[1], words[3], strings.TrimSpace(line[open+1 : close]), method})
+			continue
+		}
+		fields := splitCatalogFields(line[open+1 : close])
 		var fieldArray []FieldType
 		for _, f := range fields {
 			f := strings.TrimSpace(f)
-			nameType := strings.Split(f, " ")
+			nameType := strings.SplitN(f, " ", 2)
 			if len(nameType) != 2 {
-				return nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
 			}
+			nameType[1] = strings.ReplaceAll(nameType[1], " ", "")
 			switch nameType[1] {
 			case "int":
 				fallthrough
@@ -106,19 +135,91 @@ func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []strin
 				fallthrough
 			case "text":
 				fieldArray = append(fieldArray, FieldType{nameType[0], "", StringType})
+			case "bytes":
+				fallthrough
+			case "blob":
+				fieldArray = append(fieldArray, FieldType{nameType[0], "", BytesType})
+			case "float", "double", "real":
+				fieldArray = append(fieldArray, FieldType{nameType[0], "", FloatType})
+			case "date":
+				fieldArray = append(fieldArray, FieldType{nameType[0], "", DateType})
+			case "timestamp", "datetime":
+				fieldArray = append(fieldArray, FieldType{nameType[0], "", TimestampType})
+			case "interval":
+				fieldArray = append(fieldArray, FieldType{nameType[0], "", IntervalType})
+			case "bool", "boolean":
+				fieldArray = append(fieldArray, FieldType{nameType[0], "", BoolType})
 			default:
-				return nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
+				// decimal or numeric, with an optional (precision) or
+				// (precision,scale)
+				name, args, _ := strings.Cut(strings.TrimSuffix(nameType[1], ")"), "(")
+				if name != "decimal" && name != "numeric" {
+					return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
+				}
+				precision, scale, _ := strings.Cut(args, ",")
+				t, err := parseDecimalType(precision, scale)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				fieldArray = append(fieldArray, FieldType{nameType[0], "", t})
 			}
 		}
 		tables = append(tables, TupleDesc{fieldArray})
 		names = append(names, tableName)
 	}
-	return tables, names, nil
+	return tables, names, indexes, nil
 
 }
 
+// Split the fields of a catalog entry on the commas between them, which are
+// not those in the parentheses of types like decimal(10,2)
+func splitCatalogFields(fields string) []string {
+	var split []string
+	depth, start := 0, 0
+	for i, c := range fields {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				split = append(split, fields[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(split, fields[start:])
+}
+
+// Return the type of DECIMAL(precision, scale) fields, where precision and
+// scale may be empty, for the default precision and scale
+func parseDecimalType(precision string, scale string) (DBType, error) {
+	p, s := defaultDecimalPrecision, defaultDecimalScale
+	var err error
+	if precision != "" {
+		if p, err = strconv.Atoi(strings.TrimSpace(precision)); err != nil {
+			return UnknownType, GoDBError{ParseError, fmt.Sprintf("malformed decimal precision %s", precision)}
+		}
+	}
+	if scale != "" {
+		if s, err = strconv.Atoi(strings.TrimSpace(scale)); err != nil {
+			return UnknownType, GoDBError{ParseError, fmt.Sprintf("malformed decimal scale %s", scale)}
+		}
+	}
+	if p < 1 || p > maxDecimalPrecision || s < 0 || s > p {
+		return UnknownType, GoDBError{ParseError, fmt.Sprintf("decimal(%d,%d) must have between 1 and %d digits, and no more after the decimal point", p, s, maxDecimalPrecision)}
+	}
+	return decimalType(p, s), nil
+}
+
 func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*Catalog, error) {
-	tabs, names, err := parseCatalogFile(catalogFile, rootPath)
+	tabs, names, indexes, err := parseCatalogFile(catalogFile, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	//recover the tables from the log before anything reads them
+	err = bp.OpenLog(rootPath + "/" + LogFileName)
 	if err != nil {
 		return nil, err
 	}
@@ -126,15 +227,49 @@ func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*C
 	for i, t := range tabs {
 		c.addTable(names[i], t)
 	}
+	err = c.migrateTables()
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range indexes {
+		if err := c.openIndex(spec); err != nil {
+			return nil, err
+		}
+	}
 
 	return c, nil
 
 }
 
+// Rewrite the files of tables written in an older page format in the current
+// one (see [MigrateHeapFile]), before anything reads them.  Then take a
+// checkpoint, so that recovery never applies log records written before the
+// migration to the rewritten files.
+func (c *Catalog) migrateTables() error {
+	migrated := false
+	for _, t := range c.tables {
+		rewritten, err := MigrateHeapFile(c.tableNameToFile(t.name), &t.desc)
+		if err != nil {
+			return err
+		}
+		migrated = migrated || rewritten
+	}
+	if !migrated {
+		return nil
+	}
+	return c.bp.Checkpoint()
+}
+
+// Take a checkpoint of the buffer pool the catalog's tables are read through
+// (see [BufferPool.Checkpoint])
+func (c *Catalog) Checkpoint() error {
+	return c.bp.Checkpoint()
+}
+
 func (c *Catalog) addTable(named string, desc TupleDesc) error {
 	_, err := c.GetTable(named)
 	if err != nil {
-		t := &Table{named, desc}
+		t := &Table{name: named, desc: desc}
 		c.tables = append(c.tables, t)
 		c.tableMap[named] = t
 		for _, f := range desc.Fields {
@@ -155,12 +290,103 @@ func (c *Catalog) tableNameToFile(tableName string) string {
 	return c.rootPath + "/" + tableName + ".dat"
 
 }
+
+func (c *Catalog) indexNameToFile(indexName string) string {
+	return c.rootPath + "/" + indexName + ".idx"
+}
+
+// Open the index of a catalog file, building it from its table if its file is
+// missing
+func (c *Catalog) openIndex(spec indexSpec) error {
+	t := c.tableMap[spec.table]
+	if t == nil {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found for index '%s'", spec.table, spec.name)}
+	}
+	_, err := os.Stat(c.indexNameToFile(spec.name))
+	if os.IsNotExist(err) {
+		return c.CreateIndex(spec.name, spec.table, spec.column, spec.method)
+	}
+	idx, err := NewIndex(spec.name, spec.column, spec.method, &t.desc, c.indexNameToFile(spec.name), c.bp)
+	if err != nil {
+		return err
+	}
+	t.indexes = append(t.indexes, idx)
+	return nil
+}
+
+// Return the table the index called name is on, and its position among the
+// indexes of the table
+func (c *Catalog) findIndex(name string) (*Table, int) {
+	for _, t := range c.tables {
+		for i, idx := range t.indexes {
+			if idx.Name == name {
+				return t, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// Create an index called name over a column of table with the specified
+// method, and build it from the tuples of the table in a transaction of its
+// own.  Tuples inserted by transactions that are running while it is built may
+// not be indexed.
+func (c *Catalog) CreateIndex(name string, table string, column string, method IndexMethod) error {
+	if t, _ := c.findIndex(name); t != nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("an index named '%s' already exists", name)}
+	}
+	t := c.tableMap[table]
+	if t == nil {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", table)}
+	}
+	os.Remove(c.indexNameToFile(name))
+	idx, err := NewIndex(name, column, method, &t.desc, c.indexNameToFile(name), c.bp)
+	if err != nil {
+		return err
+	}
+	hf, err := NewHeapFile(c.tableNameToFile(table), t.desc.copy(), c.bp)
+	if err != nil {
+		return err
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	if err := idx.build(hf, tid); err != nil {
+		err = c.bp.abortOnError(tid, err)
+		idx.remove(c.bp)
+		return err
+	}
+	if err := c.bp.CommitTransaction(tid); err != nil {
+		idx.remove(c.bp)
+		return err
+	}
+	t.indexes = append(t.indexes, idx)
+	return nil
+}
+
+// Drop the index called name, and remove its file
+func (c *Catalog) DropIndex(name string) error {
+	t, i := c.findIndex(name)
+	if t == nil {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("no index '%s' found", name)}
+	}
+	idx := t.indexes[i]
+	t.indexes = append(t.indexes[:i:i], t.indexes[i+1:]...)
+	return idx.remove(c.bp)
+}
+
 func (c *Catalog) GetTable(named string) (DBFile, error) {
 	t := c.tableMap[named]
 	if t == nil {
 		return nil, GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", named)}
 	}
-	return NewHeapFile(c.tableNameToFile(named), t.desc.copy(), c.bp)
+	hf, err := NewHeapFile(c.tableNameToFile(named), t.desc.copy(), c.bp)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range t.indexes {
+		hf.addIndex(idx)
+	}
+	return hf, nil
 
 }
 
@@ -187,9 +413,18 @@ func (c *Catalog) CatalogString() string {
 			if i != 0 {
 				fieldStr = fieldStr + ", "
 			}
-			fieldStr = fieldStr + f.Fname + " " + typeNames[f.Ftype]
+			fieldStr = fieldStr + f.Fname + " " + typeName(f.Ftype)
 		}
 		outStr = outStr + t.name + " " + fieldStr + ")\n"
 	}
+	for _, t := range c.tables {
+		for _, idx := range t.indexes {
+			outStr = outStr + "index " + idx.Name + " on " + t.name + " (" + idx.Column + ")"
+			if idx.Method != BTreeIndex {
+				outStr = outStr + " using " + idx.Method.String()
+			}
+			outStr = outStr + "\n"
+		}
+	}
 	return outStr
 }
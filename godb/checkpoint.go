@@ -0,0 +1,204 @@
+package godb
+
+import (
+	"os"
+	"time"
+)
+
+// Log size above which the background checkpointer started by
+// [BufferPool.StartCheckpointer] takes a checkpoint, by default.
+const DefaultCheckpointLogSize int64 = 16 << 20
+
+// How often the background checkpointer checks the size of the log, by default.
+const DefaultCheckpointInterval = 5 * time.Second
+
+// Take a fuzzy checkpoint and truncate the log.
+//
+// The checkpoint record holds the active transaction table (the first update
+// record of every running transaction) and the dirty page table (the first
+// update record of every dirty page since it was last written back).  Taking
+// it only holds the pool lock long enough to copy these tables, so
+// transactions keep running while it is taken:
+//   - dirty pages that have stayed dirty since before the previous checkpoint
+//     are written back one at a time, so that hot pages do not pin the log;
+//   - the tables and the end of the log are recorded under the pool lock;
+//   - the data files written since the last checkpoint are synced, so that
+//     every page that was clean when the tables were recorded is on disk;
+//   - the checkpoint record is appended and forced.
+//
+// Recovery then only needs to redo records from the oldest entry of the dirty
+// page table, and to undo the records of running transactions, so the log
+// before both of them is discarded.
+func (bp *BufferPool) Checkpoint() error {
+	bp.checkpointLock.Lock()
+	defer bp.checkpointLock.Unlock()
+
+	bp.poolLock.Lock()
+	if bp.logFile == nil {
+		bp.poolLock.Unlock()
+		return GoDBError{IllegalOperationError, "checkpoint requires a log"}
+	}
+	var old []heapHash
+	for key, lsn := range bp.pageRecLSN {
+		if lsn < bp.lastCheckpointLSN {
+			old = append(old, key)
+		}
+	}
+	bp.poolLock.Unlock()
+
+	for _, key := range old {
+		if err := bp.writeBackPage(key); err != nil {
+			return err
+		}
+	}
+
+	bp.poolLock.Lock()
+	lf := bp.logFile
+	redoLSN := lf.Size()
+	cp := &checkpointTables{transactions: make(map[int64]int64), dirtyPages: make(map[heapHash]int64)}
+	for tid := range bp.aliveTransactions {
+		cp.transactions[logTid(tid)] = -1
+		if updates := bp.transactionUpdates[tid]; len(updates) > 0 {
+			cp.transactions[logTid(tid)] = updates[0]
+		}
+	}
+	for key, lsn := range bp.pageRecLSN {
+		cp.dirtyPages[key] = lsn
+		if lsn < redoLSN {
+			redoLSN = lsn
+		}
+	}
+	unsynced := bp.unsyncedFiles
+	bp.unsyncedFiles = make(map[string]struct{})
+	bp.poolLock.Unlock()
+
+	if err := syncFiles(unsynced); err != nil {
+		// the files must be synced by the next checkpoint instead
+		bp.poolLock.Lock()
+		for name := range unsynced {
+			bp.unsyncedFiles[name] = struct{}{}
+		}
+		bp.poolLock.Unlock()
+		return err
+	}
+
+	// the checkpoint record is written under the pool lock so that no update
+	// record can come between it and its position as known to the pool
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	ckptLSN := lf.Size()
+	cp.redoBack = ckptLSN - redoLSN
+	for tid, first := range cp.transactions {
+		if first >= 0 {
+			cp.transactions[tid] = ckptLSN - first
+		}
+	}
+	for key, lsn := range cp.dirtyPages {
+		cp.dirtyPages[key] = ckptLSN - lsn
+	}
+	if _, err := lf.logCheckpoint(cp); err != nil {
+		return err
+	}
+	if err := lf.force(); err != nil {
+		return err
+	}
+	bp.lastCheckpointLSN = ckptLSN
+
+	// transactions that started updating after the tables were recorded
+	// begin after redoLSN, so only those in the table can hold the log back
+	cut := redoLSN
+	for tid := range bp.aliveTransactions {
+		if updates := bp.transactionUpdates[tid]; len(updates) > 0 && updates[0] < cut {
+			cut = updates[0]
+		}
+	}
+	if cut == 0 {
+		return nil
+	}
+	if err := lf.truncateBefore(cut); err != nil {
+		return err
+	}
+	for tid, updates := range bp.transactionUpdates {
+		for i := range updates {
+			updates[i] -= cut
+		}
+		bp.transactionUpdates[tid] = updates
+	}
+	for key := range bp.pageRecLSN {
+		bp.pageRecLSN[key] -= cut
+	}
+	bp.lastCheckpointLSN -= cut
+	return nil
+}
+
+// Write the page back to disk if it is still cached and dirty, unless it is
+// pinned, and may be in the middle of an update (see pinnedPage), or belongs to
+// an index file that is being restructured (see writeIndexPage).
+func (bp *BufferPool) writeBackPage(key heapHash) error {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	e, ok := bp.pool[key]
+	if !ok || !(*e.Value.(pair).value).isDirty() || isPinned(e.Value.(pair).value) {
+		return nil
+	}
+	if _, index := (*e.Value.(pair).value).(indexFilePage); index {
+		_, err := bp.writeIndexPage(e.Value.(pair))
+		return err
+	}
+	return bp.writePage(e.Value.(pair))
+}
+
+func syncFiles(names map[string]struct{}) error {
+	for name := range names {
+		file, err := os.OpenFile(name, os.O_RDWR, 0666)
+		if os.IsNotExist(err) {
+			// the table was dropped
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = file.Sync()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start a goroutine that checks the size of the log every interval, and takes
+// a checkpoint (see Checkpoint) whenever it has grown beyond maxLogSize bytes.
+// A checkpoint that fails is tried again at the next interval.  The returned
+// function stops the goroutine, waiting for a running checkpoint to finish, and
+// returns the error of the last checkpoint that failed, if any.
+func (bp *BufferPool) StartCheckpointer(maxLogSize int64, interval time.Duration) (stop func() error) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	var lastErr error
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				bp.poolLock.Lock()
+				lf := bp.logFile
+				bp.poolLock.Unlock()
+				if lf != nil && lf.Size() > maxLogSize {
+					if err := bp.Checkpoint(); err != nil {
+						lastErr = err
+					}
+				}
+			}
+		}
+	}()
+	return func() error {
+		close(done)
+		<-stopped
+		return lastErr
+	}
+}
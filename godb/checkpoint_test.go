@@ -0,0 +1,137 @@
+package godb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckpointRequiresLog(t *testing.T) {
+	bp := NewBufferPool(3)
+	if err := bp.Checkpoint(); err == nil {
+		t.Errorf("expected checkpoint without a log to fail")
+	}
+}
+
+func TestCheckpointTruncatesLog(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 500)
+
+	// the committed pages are still dirty, so the first checkpoint cannot
+	// discard their images; the second one writes them back first
+	if err := bp.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed: %s", err)
+	}
+	if bp.logFile.Size() < int64(PageSize) {
+		t.Fatalf("expected log of dirty pages to be kept")
+	}
+	if err := bp.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed: %s", err)
+	}
+	if bp.logFile.Size() >= int64(PageSize) {
+		t.Errorf("expected log to be truncated, but it has %d bytes", bp.logFile.Size())
+	}
+	if cnt := countOnDisk(t, &td); cnt != 500 {
+		t.Errorf("expected checkpoint to write back 500 tuples, got %d", cnt)
+	}
+
+	// crash after the checkpoint
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 500 {
+		t.Errorf("expected 500 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestCheckpointKeepsActiveTransaction(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 50)
+	bp.FlushAllPages()
+	bp.logFile.Close()
+
+	bp = NewBufferPool(3)
+	bp.OpenLog(TestingLogFile)
+	hf, _ = NewHeapFile(TestingFile, &td, bp)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 1000; i++ {
+		if err := hf.insertTuple(&t1, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := bp.Checkpoint(); err != nil {
+			t.Fatalf("checkpoint failed: %s", err)
+		}
+	}
+
+	// crash before commit; the stolen pages must still be undone
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 50 {
+		t.Errorf("expected recovery to undo the active transaction, got %d tuples", cnt)
+	}
+}
+
+func TestCheckpointAbortAfterTruncation(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 50)
+	bp.FlushAllPages()
+	bp.logFile.Close()
+
+	bp = NewBufferPool(3)
+	bp.OpenLog(TestingLogFile)
+	hf, _ = NewHeapFile(TestingFile, &td, bp)
+	// log records that can be discarded, followed by a transaction whose
+	// update records move when the log is truncated
+	for i := 0; i < 10; i++ {
+		bp.logFile.logCommit(NewTID())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 1000; i++ {
+		if err := hf.insertTuple(&t1, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	if err := bp.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed: %s", err)
+	}
+	bp.AbortTransaction(tid)
+	if cnt := countOnDisk(t, &td); cnt != 50 {
+		t.Errorf("expected abort to restore 50 tuples on disk, got %d", cnt)
+	}
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 50 {
+		t.Errorf("expected 50 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestCheckpointer(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 500)
+	stop := bp.StartCheckpointer(0, time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for bp.logFile.Size() >= int64(PageSize) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("unexpected checkpoint error %s", err)
+	}
+	if bp.logFile.Size() >= int64(PageSize) {
+		t.Errorf("expected background checkpoints to truncate the log")
+	}
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 500 {
+		t.Errorf("expected 500 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestCheckpointerReportsErrors(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 10)
+	// checkpoints cannot be appended to a closed log
+	bp.logFile.Close()
+	stop := bp.StartCheckpointer(0, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if err := stop(); err == nil {
+		t.Errorf("expected the failed checkpoints to be reported")
+	}
+}
@@ -0,0 +1,504 @@
+package godb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Besides numbers, fields may hold dates (DateType), timestamps (TimestampType)
+// and intervals of time (IntervalType).
+//
+// A DateField holds the number of days since 1970-01-01, and a TimestampField
+// the number of microseconds since 1970-01-01 00:00:00, both in UTC.  An
+// IntervalField holds a number of months, whose length varies, and a number of
+// microseconds, days always being 24 hours long.  Dates and timestamps are
+// stored as 8 bytes, and intervals as 16.
+//
+// Dates and timestamps are written as in 2024-01-31 and 2024-01-31 13:45:00.5,
+// and intervals as in 1 year 2 months 3 days 04:05:06.  Strings compared with
+// or inserted into fields of these types are read as values of their type.
+//
+// Arithmetic follows SQL:
+//   - a date plus or minus an integer is the date that many days later or
+//     earlier, and the difference of two dates is the number of days between
+//     them
+//   - a date or timestamp plus or minus an interval is a timestamp, adding the
+//     months first and clamping the day to the length of the month, so that
+//     2024-01-31 plus a month is 2024-02-29
+//   - the difference of two timestamps is an interval
+//   - intervals add up, and an interval may be multiplied or divided by an
+//     integer
+//
+// Dates compare with timestamps as their midnight, and intervals compare as if
+// months were 30 days long.
+
+// The number of microseconds in a second and in a day
+const (
+	secondMicros = int64(time.Second / time.Microsecond)
+	dayMicros    = 24 * 60 * 60 * secondMicros
+)
+
+// The number of days in a month, when intervals are compared or divided
+const monthDays = 30
+
+// Date field value, the number of days since 1970-01-01
+type DateField struct {
+	Value int64
+}
+
+// Timestamp field value, the number of microseconds since 1970-01-01 00:00:00
+// UTC
+type TimestampField struct {
+	Value int64
+}
+
+// Interval field value, Months months and Micros microseconds
+type IntervalField struct {
+	Months int64
+	Micros int64
+}
+
+// Return whether values of type t are dates, timestamps or intervals
+func (t DBType) isTemporal() bool {
+	switch t {
+	case DateType, TimestampType, IntervalType:
+		return true
+	}
+	return false
+}
+
+// Return whether v is a date, a timestamp or an interval
+func isTemporalValue(v DBValue) bool {
+	switch v.(type) {
+	case DateField, TimestampField, IntervalField:
+		return true
+	}
+	return false
+}
+
+// Return a / b rounded down, for b > 0
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if a%b < 0 {
+		q--
+	}
+	return q
+}
+
+// Return the number of microseconds since 1970-01-01 00:00:00 UTC of d, or of
+// its midnight if it is a date
+func timestampMicros(v DBValue) int64 {
+	switch v := v.(type) {
+	case DateField:
+		return v.Value * dayMicros
+	case TimestampField:
+		return v.Value
+	}
+	return 0
+}
+
+// Return the time of v, a date or a timestamp
+func timeOf(v DBValue) time.Time {
+	return time.UnixMicro(timestampMicros(v)).UTC()
+}
+
+// Return the date of t, ignoring its time of day
+func dateOf(t time.Time) DateField {
+	return DateField{floorDiv(t.Unix(), dayMicros/secondMicros)}
+}
+
+// Return the key by which v, a date, a timestamp or an interval, is compared
+// with other values of these types: a number of microseconds
+func timeKey(v DBValue) int64 {
+	if i, ok := v.(IntervalField); ok {
+		return i.Months*monthDays*dayMicros + i.Micros
+	}
+	return timestampMicros(v)
+}
+
+// Return d written as 2024-01-31
+func (d DateField) String() string {
+	return timeOf(d).Format("2006-01-02")
+}
+
+// Return t written as 2024-01-31 13:45:00, with as many digits of fractions of
+// a second as it needs
+func (t TimestampField) String() string {
+	return timeOf(t).Format("2006-01-02 15:04:05.999999")
+}
+
+// Return i written as 1 year 2 months 3 days 04:05:06, leaving out the parts
+// that are zero
+func (i IntervalField) String() string {
+	var parts []string
+	plural := func(n int64, unit string) {
+		if n == 1 || n == -1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, unit))
+		} else if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit))
+		}
+	}
+	plural(i.Months/12, "year")
+	plural(i.Months%12, "month")
+	plural(i.Micros/dayMicros, "day")
+	if rest := i.Micros % dayMicros; rest != 0 || len(parts) == 0 {
+		sign := ""
+		if rest < 0 {
+			sign, rest = "-", -rest
+		}
+		clock := time.UnixMicro(rest).UTC().Format("15:04:05.999999")
+		parts = append(parts, sign+clock)
+	}
+	return strings.Join(parts, " ")
+}
+
+// The layouts of the dates and timestamps that are read, with or without
+// seconds and fractions of a second
+var timeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+}
+
+// Return the time written as s, a date or a timestamp in one of timeLayouts
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, GoDBError{TypeMismatchError, fmt.Sprintf("%s is not a date or a timestamp", s)}
+}
+
+// The length of each unit of an interval, by its singular name
+var intervalUnits = map[string]IntervalField{
+	"microsecond": {0, 1},
+	"millisecond": {0, secondMicros / 1000},
+	"second":      {0, secondMicros},
+	"minute":      {0, 60 * secondMicros},
+	"hour":        {0, 60 * 60 * secondMicros},
+	"day":         {0, dayMicros},
+	"week":        {0, 7 * dayMicros},
+	"mon":         {1, 0},
+	"month":       {1, 0},
+	"quarter":     {3, 0},
+	"year":        {12, 0},
+}
+
+// Return an interval of n of the specified unit, like day or months
+func intervalOf(n int64, unit string) (IntervalField, error) {
+	u, ok := intervalUnits[strings.TrimSuffix(strings.ToLower(unit), "s")]
+	if !ok {
+		return IntervalField{}, GoDBError{TypeMismatchError, fmt.Sprintf("unknown interval unit %s", unit)}
+	}
+	return IntervalField{n * u.Months, n * u.Micros}, nil
+}
+
+// Return the interval written as s, a list of numbers of units, like 1 year 2
+// months, optionally followed by a time of day, like 04:05:06.5
+func parseInterval(s string) (IntervalField, error) {
+	var i IntervalField
+	bad := GoDBError{TypeMismatchError, fmt.Sprintf("%s is not an interval", s)}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return i, bad
+	}
+	for len(words) > 0 {
+		if strings.Contains(words[0], ":") {
+			micros, ok := parseClock(words[0])
+			if !ok {
+				return i, bad
+			}
+			i.Micros += micros
+			words = words[1:]
+			continue
+		}
+		n, err := strconv.ParseInt(words[0], 10, 64)
+		if err != nil || len(words) < 2 {
+			return i, bad
+		}
+		part, err := intervalOf(n, words[1])
+		if err != nil {
+			return i, bad
+		}
+		i.Months += part.Months
+		i.Micros += part.Micros
+		words = words[2:]
+	}
+	return i, nil
+}
+
+// Return the number of microseconds of clock, a time like 04:05 or -04:05:06.5
+func parseClock(clock string) (int64, bool) {
+	sign := int64(1)
+	if strings.HasPrefix(clock, "-") {
+		sign, clock = -1, clock[1:]
+	}
+	parts := strings.Split(clock, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	var micros int64
+	for i, unit := range []int64{60 * 60 * secondMicros, 60 * secondMicros} {
+		if i >= len(parts) {
+			break
+		}
+		n, err := strconv.ParseInt(parts[i], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		micros += n * unit
+	}
+	if len(parts) == 3 {
+		seconds, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return 0, false
+		}
+		micros += int64(seconds*float64(secondMicros) + 0.5)
+	}
+	return sign * micros, true
+}
+
+// Return v converted to t, a date, timestamp or interval type: a string read as
+// a value of type t, a timestamp truncated to its date, or a date at its
+// midnight.  NULL is left alone.  Returns an error if v cannot be converted.
+func castTemporal(v DBValue, t DBType) (DBValue, error) {
+	if isNull(v) {
+		return v, nil
+	}
+	if s, ok := v.(StringField); ok {
+		if t == IntervalType {
+			return parseInterval(s.Value)
+		}
+		tm, err := parseTime(s.Value)
+		if err != nil {
+			return nil, err
+		}
+		v = TimestampField{tm.UnixMicro()}
+	}
+	switch v.(type) {
+	case DateField, TimestampField:
+		switch t {
+		case DateType:
+			return dateOf(timeOf(v)), nil
+		case TimestampType:
+			return TimestampField{timestampMicros(v)}, nil
+		}
+	case IntervalField:
+		if t == IntervalType {
+			return v, nil
+		}
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("%v cannot be converted to %s", v, typeName(t))}
+}
+
+// Return the type of the result of the arithmetic operator op on values of types
+// t1 and t2, at least one of them a date, a timestamp or an interval, or
+// UnknownType if op does not apply to them (see [temporalArith])
+func temporalArithType(op string, t1 DBType, t2 DBType) DBType {
+	isTime := func(t DBType) bool { return t == DateType || t == TimestampType }
+	switch op {
+	case "+", "-":
+		switch {
+		case t1 == DateType && t2 == DateType && op == "-":
+			return IntType
+		case t1 == DateType && t2 == IntType, t1 == IntType && t2 == DateType && op == "+":
+			return DateType
+		case isTime(t1) && t2 == IntervalType, t1 == IntervalType && isTime(t2) && op == "+":
+			return TimestampType
+		case isTime(t1) && isTime(t2) && op == "-":
+			return IntervalType
+		case t1 == IntervalType && t2 == IntervalType:
+			return IntervalType
+		}
+	case "*":
+		if t1 == IntervalType && t2 == IntType || t1 == IntType && t2 == IntervalType {
+			return IntervalType
+		}
+	case "/":
+		if t1 == IntervalType && t2 == IntType {
+			return IntervalType
+		}
+	}
+	return UnknownType
+}
+
+// Apply the arithmetic operator op to a and b, at least one of them a date, a
+// timestamp or an interval (see the top of this file).  Dividing an interval
+// divides its months and microseconds, the remainder of the months being
+// counted as 30 day months.  Returns an error if op does not apply to a and b.
+func temporalArith(op string, a DBValue, b DBValue) (DBValue, error) {
+	if op == "+" {
+		// addition commutes, so only consider the first argument being the
+		// date, timestamp or larger interval
+		switch a.(type) {
+		case IntField:
+			a, b = b, a
+		case IntervalField:
+			if _, ok := b.(IntervalField); !ok {
+				a, b = b, a
+			}
+		}
+	}
+	if op == "*" {
+		if _, ok := a.(IntField); ok {
+			a, b = b, a
+		}
+	}
+	sign := int64(1)
+	if op == "-" {
+		sign = -1
+	}
+	switch x := a.(type) {
+	case DateField:
+		switch y := b.(type) {
+		case IntField:
+			if op == "+" || op == "-" {
+				return DateField{x.Value + sign*y.Value}, nil
+			}
+		case DateField:
+			if op == "-" {
+				return IntField{x.Value - y.Value}, nil
+			}
+		}
+	case IntervalField:
+		switch y := b.(type) {
+		case IntervalField:
+			if op == "+" || op == "-" {
+				return IntervalField{x.Months + sign*y.Months, x.Micros + sign*y.Micros}, nil
+			}
+		case IntField:
+			switch op {
+			case "*":
+				return IntervalField{x.Months * y.Value, x.Micros * y.Value}, nil
+			case "/":
+				if y.Value == 0 {
+					return nil, GoDBError{IllegalOperationError, "division by zero"}
+				}
+				rest := x.Months % y.Value * monthDays * dayMicros
+				return IntervalField{x.Months / y.Value, (x.Micros + rest) / y.Value}, nil
+			}
+		}
+	}
+	switch y := b.(type) {
+	case IntervalField:
+		if (op == "+" || op == "-") && isTemporalValue(a) {
+			if _, ok := a.(IntervalField); !ok {
+				return addInterval(a, IntervalField{sign * y.Months, sign * y.Micros}), nil
+			}
+		}
+	case DateField, TimestampField:
+		if _, ok := a.(IntervalField); !ok && op == "-" && isTemporalValue(a) {
+			return IntervalField{0, timestampMicros(a) - timestampMicros(y)}, nil
+		}
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot apply %s to %v and %v", op, a, b)}
+}
+
+// Return the timestamp of v, a date or a timestamp, plus the interval i, adding
+// its months first, clamping the day to the length of the resulting month
+func addInterval(v DBValue, i IntervalField) TimestampField {
+	t := timeOf(v)
+	year, month, day := t.Date()
+	months := int64(month) - 1 + i.Months
+	year += int(floorDiv(months, 12))
+	month = time.Month(months-floorDiv(months, 12)*12) + 1
+	// the day after the last day of the month is the 0th of the next one
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > last {
+		day = last
+	}
+	t = time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return TimestampField{t.UnixMicro() + i.Micros}
+}
+
+// Return the field of v, a date, a timestamp or an interval, named by unit, as
+// an integer: one of year, quarter, month, week (of the ISO year), day, dow
+// (the day of the week, from 0 for Sunday), doy (the day of the year), hour,
+// minute, second or epoch (the number of seconds since 1970-01-01 00:00:00,
+// or of the interval).  Only year, month, day, hour, minute, second and epoch
+// apply to intervals.
+func extractField(unit string, v DBValue) (int64, error) {
+	unit = strings.ToLower(unit)
+	if i, ok := v.(IntervalField); ok {
+		switch unit {
+		case "year":
+			return i.Months / 12, nil
+		case "month":
+			return i.Months % 12, nil
+		case "day":
+			return i.Micros / dayMicros, nil
+		case "hour":
+			return i.Micros % dayMicros / (60 * 60 * secondMicros), nil
+		case "minute":
+			return i.Micros % (60 * 60 * secondMicros) / (60 * secondMicros), nil
+		case "second":
+			return i.Micros % (60 * secondMicros) / secondMicros, nil
+		case "epoch":
+			return timeKey(i) / secondMicros, nil
+		}
+		return 0, GoDBError{TypeMismatchError, fmt.Sprintf("cannot extract %s from an interval", unit)}
+	}
+	t := timeOf(v)
+	switch unit {
+	case "year":
+		return int64(t.Year()), nil
+	case "quarter":
+		return int64(t.Month()+2) / 3, nil
+	case "month":
+		return int64(t.Month()), nil
+	case "week":
+		_, week := t.ISOWeek()
+		return int64(week), nil
+	case "day":
+		return int64(t.Day()), nil
+	case "dow":
+		return int64(t.Weekday()), nil
+	case "doy":
+		return int64(t.YearDay()), nil
+	case "hour":
+		return int64(t.Hour()), nil
+	case "minute":
+		return int64(t.Minute()), nil
+	case "second":
+		return int64(t.Second()), nil
+	case "epoch":
+		return floorDiv(timestampMicros(v), secondMicros), nil
+	}
+	return 0, GoDBError{TypeMismatchError, fmt.Sprintf("cannot extract %s from a date", unit)}
+}
+
+// Return v, a date or a timestamp, truncated to the start of the unit, one of
+// year, quarter, month, week (starting on Monday), day, hour, minute or second
+func truncateTime(unit string, v DBValue) (TimestampField, error) {
+	if _, ok := v.(IntervalField); ok {
+		return TimestampField{}, GoDBError{TypeMismatchError, "cannot truncate an interval"}
+	}
+	t := timeOf(v)
+	year, month, day := t.Date()
+	switch strings.ToLower(unit) {
+	case "year":
+		t = time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	case "quarter":
+		t = time.Date(year, (month-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+	case "month":
+		t = time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	case "week":
+		t = time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, time.UTC)
+	case "day":
+		t = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	case "hour":
+		t = t.Truncate(time.Hour)
+	case "minute":
+		t = t.Truncate(time.Minute)
+	case "second":
+		t = t.Truncate(time.Second)
+	default:
+		return TimestampField{}, GoDBError{TypeMismatchError, fmt.Sprintf("cannot truncate a date to a %s", unit)}
+	}
+	return TimestampField{t.UnixMicro()}, nil
+}
@@ -0,0 +1,245 @@
+package godb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Return a catalog with the table o (id int, placed date, shipped timestamp,
+// wait interval), holding three orders
+func makeDateTimeTestCatalog(t *testing.T) *Catalog {
+	return makeQueryTestCatalog(t, "o (id int, placed date, shipped timestamp, wait interval)", `insert into o values
+		(1, '2024-01-31', '2024-02-02 10:30:00', '2 days 10:30'),
+		(2, '2024-02-29', '2024-03-01 08:00:00', '1 day'),
+		(3, '2023-12-15', '2024-01-15 00:00:00.25', '1 month')`)
+}
+
+func TestDateTimeValues(t *testing.T) {
+	d, err := castTemporal(StringField{"2024-02-29"}, DateType)
+	if err != nil || d != (DateField{19782}) || d.(DateField).String() != "2024-02-29" {
+		t.Errorf("unexpected date %v (%v)", d, err)
+	}
+	if d, _ := castTemporal(StringField{"1969-12-31 23:00:00"}, DateType); d != (DateField{-1}) {
+		t.Errorf("expected a timestamp before 1970 to be truncated to its date, got %v", d)
+	}
+	ts, err := castTemporal(StringField{"2024-01-31T13:45:00.5"}, TimestampType)
+	if err != nil || ts.(TimestampField).String() != "2024-01-31 13:45:00.5" {
+		t.Errorf("unexpected timestamp %v (%v)", ts, err)
+	}
+	for s, want := range map[string]IntervalField{
+		"1 year 2 months 3 days 04:05:06": {14, 3*dayMicros + (4*3600+5*60+6)*secondMicros},
+		"-1 day":                          {0, -dayMicros},
+		"1 quarter 90 minutes":            {3, 90 * 60 * secondMicros},
+		"00:00:01.5":                      {0, 3 * secondMicros / 2},
+	} {
+		if got, err := parseInterval(s); err != nil || got != want {
+			t.Errorf("%q: expected %v, got %v (%v)", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"", "1", "1 fortnight", "1:2:3:4"} {
+		if _, err := parseInterval(s); err == nil {
+			t.Errorf("expected %q not to be an interval", s)
+		}
+	}
+	if s := (IntervalField{14, 3*dayMicros + 3600*secondMicros}).String(); s != "1 year 2 months 3 days 01:00:00" {
+		t.Errorf("unexpected interval %s", s)
+	}
+	if _, err := castTemporal(StringField{"yesterday"}, DateType); err == nil {
+		t.Errorf("expected yesterday not to be a date")
+	}
+	if _, err := castTemporal(IntervalField{1, 0}, DateType); err == nil {
+		t.Errorf("expected an interval not to be cast to a date")
+	}
+}
+
+func TestDateTimeArith(t *testing.T) {
+	date := func(s string) DBValue {
+		d, _ := castTemporal(StringField{s}, DateType)
+		return d
+	}
+	timestamp := func(s string) DBValue {
+		ts, _ := castTemporal(StringField{s}, TimestampType)
+		return ts
+	}
+	month, day := IntervalField{1, 0}, IntervalField{0, dayMicros}
+	for _, c := range []struct {
+		op   string
+		a, b DBValue
+		want DBValue
+	}{
+		{"+", date("2024-02-28"), IntField{2}, date("2024-03-01")},
+		{"+", IntField{1}, date("2024-12-31"), date("2025-01-01")},
+		{"-", date("2024-03-01"), date("2024-02-01"), IntField{29}},
+		{"+", date("2024-01-31"), month, timestamp("2024-02-29")},
+		{"-", date("2024-03-31"), IntervalField{13, 0}, timestamp("2023-02-28")},
+		{"+", day, timestamp("2024-01-01 12:00:00"), timestamp("2024-01-02 12:00:00")},
+		{"-", timestamp("2024-01-02 12:00:00"), date("2024-01-01"), IntervalField{0, dayMicros * 3 / 2}},
+		{"+", month, day, IntervalField{1, dayMicros}},
+		{"*", IntField{3}, month, IntervalField{3, 0}},
+		{"/", IntervalField{3, 0}, IntField{2}, IntervalField{1, 15 * dayMicros}},
+	} {
+		got, err := arith(c.op, c.a, c.b)
+		if err != nil || got != c.want {
+			t.Errorf("%v %s %v: expected %v, got %v (%v)", c.a, c.op, c.b, c.want, got, err)
+		}
+	}
+	for _, c := range []struct {
+		op     string
+		a, b   DBValue
+		t1, t2 DBType
+	}{
+		{"+", date("2024-01-01"), date("2024-01-01"), DateType, DateType},
+		{"*", date("2024-01-01"), IntField{2}, DateType, IntType},
+		{"-", IntField{1}, date("2024-01-01"), IntType, DateType},
+	} {
+		if _, err := arith(c.op, c.a, c.b); err == nil {
+			t.Errorf("expected %v %s %v to fail", c.a, c.op, c.b)
+		}
+		if typ := arithType(c.op, c.t1, c.t2); typ != UnknownType {
+			t.Errorf("expected no type for %s %s %s, got %s", typeName(c.t1), c.op, typeName(c.t2), typeName(typ))
+		}
+	}
+	if typ := arithType("-", TimestampType, DateType); typ != IntervalType {
+		t.Errorf("expected the difference of times to be an interval, got %s", typeName(typ))
+	}
+
+	ts := timestamp("2024-05-15 13:45:30")
+	for unit, want := range map[string]int64{"year": 2024, "quarter": 2, "month": 5, "day": 15, "dow": 3, "doy": 136, "hour": 13, "second": 30} {
+		if got, err := extractField(unit, ts); err != nil || got != want {
+			t.Errorf("extract %s: expected %d, got %d (%v)", unit, want, got, err)
+		}
+	}
+	if got, _ := extractField("month", IntervalField{14, 0}); got != 2 {
+		t.Errorf("expected an interval of 14 months to have 2 months, got %d", got)
+	}
+	for unit, want := range map[string]DBValue{"year": timestamp("2024-01-01"), "quarter": timestamp("2024-04-01"), "week": timestamp("2024-05-13"), "hour": timestamp("2024-05-15 13:00:00")} {
+		if got, err := truncateTime(unit, ts); err != nil || got != want {
+			t.Errorf("date_trunc %s: expected %v, got %v (%v)", unit, want, got, err)
+		}
+	}
+}
+
+func TestDateTimeSerialization(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{"d", "", DateType}, {"t", "", TimestampType}, {"i", "", IntervalType}}}
+	t1 := Tuple{Desc: td, Fields: []DBValue{DateField{-400}, TimestampField{1706708700000000}, IntervalField{-3, 12345}}}
+	b := new(bytes.Buffer)
+	if err := t1.writeTo(b); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if b.Len() != 1+8+8+16 {
+		t.Errorf("expected a date, a timestamp and an interval to take 33 bytes, got %d", b.Len())
+	}
+	t2, err := readTupleFrom(b, &td)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !t2.equals(&t1) {
+		t.Errorf("expected %v, got %v", t1.Fields, t2.Fields)
+	}
+}
+
+func TestDateTimeQueries(t *testing.T) {
+	c := makeDateTimeTestCatalog(t)
+	if s := c.CatalogString(); s != "o (id int, placed date, shipped timestamp, wait interval)\n" {
+		t.Errorf("unexpected catalog %q", s)
+	}
+	for query, want := range map[string]int{
+		"select id from o where placed >= '2024-01-31'":                               2,
+		"select id from o where placed < date '2024-02-29' - interval '29' day":       1,
+		"select id from o where shipped > date '2024-03-01'":                          1,
+		"select id from o where shipped - placed > '2 days'":                          2,
+		"select id from o where wait >= '1 day'":                                      3,
+		"select id from o where placed = timestamp '2024-02-29 00:00:00'":             1,
+		"select id from o where placed + wait > '2024-02-01'":                         2,
+		"select id from o where placed < date '2024-03-31' - interval '1' month":      2,
+		"select id from o where extract(month from placed) = 2":                       1,
+		"select id from o where date_trunc('month', shipped) = date('2024-01-01')":    1,
+		"select id from o where placed > date '2024-01-01' and placed < '2024-02-01'": 1,
+	} {
+		if got := runTestQuery(t, c, query); len(got) != want {
+			t.Errorf("%q: expected %d tuples, got %d", query, want, len(got))
+		}
+	}
+
+	got := runTestQuery(t, c, "select id, placed + 1, shipped - placed, extract(year from placed), placed from o order by placed desc")
+	want := []DBValue{IntField{2}, DateField{19783}, IntervalField{0, dayMicros + 8*3600*secondMicros}, IntField{2024}}
+	for i, v := range want {
+		if got[0].Fields[i] != v {
+			t.Errorf("expression %d: expected %v, got %v", i, v, got[0].Fields[i])
+		}
+	}
+	if got[2].Fields[0] != (IntField{3}) {
+		t.Errorf("expected the earliest order last, got %v", got[2].Fields[0])
+	}
+	if s := got[0].PrettyPrintString(false); s != "2,2024-03-01,1 day 08:00:00,2024,2024-02-29" {
+		t.Errorf("unexpected printed tuple %s", s)
+	}
+
+	got = runTestQuery(t, c, "select min(placed), max(shipped), count(wait) from o")
+	want = []DBValue{DateField{19706}, TimestampField{1709280000000000}, IntField{3}}
+	for i, v := range want {
+		if got[0].Fields[i] != v {
+			t.Errorf("aggregate %d: expected %v, got %v", i, v, got[0].Fields[i])
+		}
+	}
+	if _, _, err := Parse(c, "select sum(placed) from o"); err == nil {
+		t.Errorf("expected the sum of dates not to parse")
+	}
+
+	if _, _, err := Parse(c, "create table e (at date, until datetime, lasts interval)"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	desc := c.tables[len(c.tables)-1].desc
+	for i, w := range []DBType{DateType, TimestampType, IntervalType} {
+		if desc.Fields[i].Ftype != w {
+			t.Errorf("column %d: expected %s, got %s", i, typeName(w), typeName(desc.Fields[i].Ftype))
+		}
+	}
+	if _, _, err := Parse(c, "create table f (a int, b interval)"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if desc := c.tables[len(c.tables)-1].desc; desc.Fields[0].Ftype != IntType || desc.Fields[1].Ftype != IntervalType {
+		t.Errorf("expected only the second column to be an interval, got %v", desc.Fields)
+	}
+	csvPath := filepath.Join(t.TempDir(), "e.csv")
+	os.WriteFile(csvPath, []byte("2024-01-01,2024-01-01 10:00,1 hour\n,,\n"), 0644)
+	csv, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer csv.Close()
+	hf, _ := c.GetTable("e")
+	if err := hf.(*HeapFile).LoadFromCSV(csv, false, ",", false); err != nil {
+		t.Fatalf("%s", err)
+	}
+	got = runTestQuery(t, c, "select at, until, lasts from e where at is not null")
+	want = []DBValue{DateField{19723}, TimestampField{1704103200000000}, IntervalField{0, 3600 * secondMicros}}
+	if len(got) != 1 {
+		t.Fatalf("expected one loaded tuple with a date, got %d", len(got))
+	}
+	for i, v := range want {
+		if got[0].Fields[i] != v {
+			t.Errorf("loaded field %d: expected %v, got %v", i, v, got[0].Fields[i])
+		}
+	}
+	if _, plan, err := Parse(c, "insert into e values ('someday', null, null)"); err == nil {
+		tid := NewTID()
+		c.bp.BeginTransaction(tid)
+		if _, err := plan.Iterator(tid); err == nil {
+			t.Errorf("expected a malformed date not to be inserted")
+		}
+		c.bp.AbortTransaction(tid)
+	}
+}
+
+func TestIntervalColumnName(t *testing.T) {
+	// INTERVAL is only read as a type in the column list of CREATE TABLE;
+	// sqlparser needs the column name quoted, as INTERVAL is a keyword
+	c := makeQueryTestCatalog(t, "n (name string, interval int)", "insert into n (name, `interval`) values ('a', 3), ('b', 5)")
+	got := runTestQuery(t, c, "select max(`interval`) from n")
+	if len(got) != 1 || got[0].Fields[0] != (IntField{5}) {
+		t.Errorf("expected the maximum of a column named interval to be 5, got %v", got)
+	}
+}
@@ -3,6 +3,7 @@ package godb
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 )
@@ -165,3 +166,136 @@ func TestUpgradeWriteDeadlock(t *testing.T) {
 		fmt.Println("should not be nil")
 	}
 }
+
+// Set up a buffer pool with the given options over the three page test file,
+// with two transactions holding write locks on pages 0 and 1 respectively.
+// tid1 is older than tid2.
+func policyTestSetUp(t *testing.T, opts ...BufferPoolOption) (*BufferPool, *HeapFile, TransactionID, TransactionID) {
+	td, _, _, _, _, _ := makeTestVars(t)
+	bp := NewBufferPool(3, opts...)
+	os.Remove(TestingFile)
+	hf, err := NewHeapFile(TestingFile, &td, bp)
+	if err != nil {
+		t.Fatalf("failed to create heap file: %s", err)
+	}
+	csvFile, err := os.Open("txn_test_300_3.csv")
+	if err != nil {
+		t.Fatalf("error opening test file")
+	}
+	defer csvFile.Close()
+	hf.LoadFromCSV(padTestCSV(t, csvFile, &td, 100), false, ",", false)
+
+	tid1 := NewTID()
+	bp.BeginTransaction(tid1)
+	tid2 := NewTID()
+	bp.BeginTransaction(tid2)
+	if _, err := bp.GetPage(hf, 0, tid1, WritePerm); err != nil {
+		t.Fatalf("failed to lock page 0: %s", err)
+	}
+	if _, err := bp.GetPage(hf, 1, tid2, WritePerm); err != nil {
+		t.Fatalf("failed to lock page 1: %s", err)
+	}
+	return bp, hf, tid1, tid2
+}
+
+// Check that the grabber of the victim failed, and that the other one got its
+// lock once the victim was aborted
+func checkDeadlockVictim(t *testing.T, victim *LockGrabber, survivor *LockGrabber) {
+	time.Sleep(POLL_INTERVAL)
+	if victim.getError() == nil {
+		t.Errorf("expected victim to be aborted")
+	}
+	if survivor.getError() != nil || !survivor.acquired() {
+		t.Errorf("expected survivor to acquire its lock, got error %v", survivor.getError())
+	}
+}
+
+func TestDetectYoungestAbortsRequester(t *testing.T) {
+	bp, hf, tid1, tid2 := policyTestSetUp(t, WithDeadlockPolicy(DetectYoungest))
+	lg1 := startGrabber(bp, tid1, hf, 1, WritePerm)
+	time.Sleep(POLL_INTERVAL)
+	lg2 := startGrabber(bp, tid2, hf, 0, WritePerm)
+	checkDeadlockVictim(t, lg2, lg1)
+}
+
+func TestDetectYoungestAbortsWaiter(t *testing.T) {
+	bp, hf, tid1, tid2 := policyTestSetUp(t, WithDeadlockPolicy(DetectYoungest))
+	lg2 := startGrabber(bp, tid2, hf, 0, WritePerm)
+	time.Sleep(POLL_INTERVAL)
+	lg1 := startGrabber(bp, tid1, hf, 1, WritePerm)
+	checkDeadlockVictim(t, lg2, lg1)
+}
+
+func TestDetectLeastWork(t *testing.T) {
+	bp, hf, tid1, tid2 := policyTestSetUp(t, WithDeadlockPolicy(DetectLeastWork))
+	// tid2 has done more work than the older tid1, so tid1 is aborted
+	if _, err := bp.GetPage(hf, 2, tid2, WritePerm); err != nil {
+		t.Fatalf("failed to lock page 2: %s", err)
+	}
+	lg2 := startGrabber(bp, tid2, hf, 0, WritePerm)
+	time.Sleep(POLL_INTERVAL)
+	lg1 := startGrabber(bp, tid1, hf, 1, WritePerm)
+	checkDeadlockVictim(t, lg1, lg2)
+}
+
+func TestWaitDie(t *testing.T) {
+	bp, hf, tid1, tid2 := policyTestSetUp(t, WithDeadlockPolicy(WaitDie))
+	// the older transaction waits
+	lg1 := startGrabber(bp, tid1, hf, 1, WritePerm)
+	time.Sleep(POLL_INTERVAL)
+	if lg1.acquired() || lg1.getError() != nil {
+		t.Fatalf("expected older transaction to wait")
+	}
+	// the younger one dies
+	lg2 := startGrabber(bp, tid2, hf, 0, WritePerm)
+	checkDeadlockVictim(t, lg2, lg1)
+}
+
+func TestWaitDieWithoutDeadlock(t *testing.T) {
+	bp, hf, _, tid2 := policyTestSetUp(t, WithDeadlockPolicy(WaitDie))
+	// the younger transaction dies even though there is no deadlock
+	lg2 := startGrabber(bp, tid2, hf, 0, ReadPerm)
+	time.Sleep(POLL_INTERVAL)
+	if lg2.getError() == nil {
+		t.Errorf("expected younger transaction to die")
+	}
+}
+
+func TestWoundWaitWoundsWaiter(t *testing.T) {
+	bp, hf, tid1, tid2 := policyTestSetUp(t, WithDeadlockPolicy(WoundWait))
+	// the younger transaction waits
+	lg2 := startGrabber(bp, tid2, hf, 0, WritePerm)
+	time.Sleep(POLL_INTERVAL)
+	if lg2.acquired() || lg2.getError() != nil {
+		t.Fatalf("expected younger transaction to wait")
+	}
+	// the older one wounds it
+	lg1 := startGrabber(bp, tid1, hf, 1, WritePerm)
+	checkDeadlockVictim(t, lg2, lg1)
+}
+
+func TestWoundWaitWoundsHolder(t *testing.T) {
+	bp, hf, tid1, tid2 := policyTestSetUp(t, WithDeadlockPolicy(WoundWait))
+	// the older transaction wounds the running younger one, and waits for it
+	lg1 := startGrabber(bp, tid1, hf, 1, WritePerm)
+	time.Sleep(POLL_INTERVAL)
+	if lg1.acquired() || lg1.getError() != nil {
+		t.Fatalf("expected older transaction to wait for the wounded one")
+	}
+	// which is aborted at its next lock request
+	lg2 := startGrabber(bp, tid2, hf, 2, ReadPerm)
+	checkDeadlockVictim(t, lg2, lg1)
+}
+
+func TestLockTimeout(t *testing.T) {
+	bp, hf, tid1, tid2 := policyTestSetUp(t, WithLockTimeout(2*POLL_INTERVAL))
+	lg1 := startGrabber(bp, tid1, hf, 1, WritePerm)
+	time.Sleep(POLL_INTERVAL)
+	lg2 := startGrabber(bp, tid2, hf, 0, WritePerm)
+	time.Sleep(POLL_INTERVAL / 2)
+	if lg1.getError() != nil || lg2.getError() != nil {
+		t.Fatalf("expected both transactions to wait until the timeout")
+	}
+	// tid1 started waiting first, so it times out first
+	checkDeadlockVictim(t, lg1, lg2)
+}
@@ -29,17 +29,23 @@ func (i *DeleteOp) Descriptor() *TupleDesc {
 // method.
 func (dop *DeleteOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
-	iter, _ := dop.child.Iterator(tid)
+	iter, err := dop.child.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
 	count := 0
 	for {
-		t, _ := iter()
+		t, err := iter()
+		if err != nil {
+			return nil, err
+		}
 		if t == nil {
 			break
 		}
-		ok := dop.deleteFile.deleteTuple(t, tid)
-		if ok == nil {
-			count++
+		if err := dop.deleteFile.deleteTuple(t, tid); err != nil {
+			return nil, err
 		}
+		count++
 	}
 	return func() (*Tuple, error) {
 		res := new(Tuple)
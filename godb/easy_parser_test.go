@@ -3,44 +3,55 @@ package godb
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
-func MakeTestDatabaseEasy(bp *BufferPool) error {
+// Create the tables t and t2 of catalog.txt, loaded from testdb.txt, in a
+// temporary directory of t, along with a copy of catalog.txt, and return the
+// directory
+func MakeTestDatabaseEasy(t *testing.T, bp *BufferPool) (string, error) {
 	var td = TupleDesc{Fields: []FieldType{
 		{Fname: "name", Ftype: StringType},
 		{Fname: "age", Ftype: IntType},
 	}}
-	os.Remove("t2.dat")
-	os.Remove("t.dat")
+	dir := t.TempDir()
+	catalog, err := os.ReadFile("catalog.txt")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "catalog.txt"), catalog, 0644); err != nil {
+		return "", err
+	}
 
-	hf, err := NewHeapFile("t.dat", &td, bp)
+	hf, err := NewHeapFile(filepath.Join(dir, "t.dat"), &td, bp)
 	if err != nil {
-		return err
+		return "", err
 	}
-	hf2, err := NewHeapFile("t2.dat", &td, bp)
+	hf2, err := NewHeapFile(filepath.Join(dir, "t2.dat"), &td, bp)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	f, err := os.Open("testdb.txt")
 	if err != nil {
-		return err
+		return "", err
 	}
 	err = hf.LoadFromCSV(f, true, ",", false)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	f, err = os.Open("testdb.txt")
 	if err != nil {
-		return err
+		return "", err
 	}
 	err = hf2.LoadFromCSV(f, true, ",", false)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return dir, nil
 }
 
 func TestParseEasy(t *testing.T) {
@@ -58,25 +69,26 @@ func TestParseEasy(t *testing.T) {
 		"select name from (select x.name from (select t.name from t) x)y order by name asc",
 		"select age, count(*) from t group by age",
 	}
+	// the saved results of the first query were computed at the end of 2023
+	now = func() time.Time { return time.Date(2023, time.December, 15, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
 	save := false        //set save to true to save the output of the current test run as the correct answer
 	printOutput := false //print the result set during testing
 
 	bp := NewBufferPool(10)
-	err := MakeTestDatabaseEasy(bp)
+	dir, err := MakeTestDatabaseEasy(t, bp)
 	if err != nil {
 		t.Errorf("failed to create test database, %s", err.Error())
 		return
 	}
 
-	c, err := NewCatalogFromFile("catalog.txt", bp, "./")
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
 	if err != nil {
 		t.Errorf("failed load catalog, %s", err.Error())
 		return
 	}
 	qNo := 0
 	for _, sql := range queries {
-		tid := NewTID()
-		bp.BeginTransaction(tid)
 		qNo++
 		// if qNo == 4 {
 		// 	continue
@@ -109,8 +121,7 @@ func TestParseEasy(t *testing.T) {
 			}
 			//outfile, _ = NewHeapFile(fname, plan.Descriptor(), bp)
 		} else {
-			fname_bin := fmt.Sprintf("savedresults/q%d-easy-result.dat", qNo)
-			os.Remove(fname_bin)
+			fname_bin := filepath.Join(dir, fmt.Sprintf("q%d-easy-result.dat", qNo))
 			desc := plan.Descriptor()
 			if desc == nil {
 				t.Errorf("descriptor was nil")
@@ -132,7 +143,13 @@ func TestParseEasy(t *testing.T) {
 				t.Errorf(err.Error())
 				return
 			}
+		}
 
+		// begin the transaction once the expected results are loaded, so
+		// that its snapshot sees them
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		if !save {
 			resultIter, err := outfile.Iterator(tid)
 			if err != nil {
 				t.Errorf("%s", err.Error())
@@ -13,7 +13,7 @@ import (
 //other values from tuples.
 
 type Expr interface {
-	EvalExpr(t *Tuple) (DBValue, error) //DBValue is either IntField or StringField
+	EvalExpr(t *Tuple) (DBValue, error) //DBValue is an IntField, FloatField, DecimalField, DateField, TimestampField, IntervalField, StringField, BytesField or NullField
 	GetExprType() FieldType             //Return the type of the Expression
 }
 
@@ -36,7 +36,7 @@ func (f *FieldExpr) GetExprType() FieldType {
 }
 
 type ConstExpr struct {
-	val       any //should be an IntField or a StringField
+	val       any //should be an IntField, a FloatField, a DecimalField, a StringField, or a NullField of UnknownType
 	constType DBType
 }
 
@@ -48,6 +48,26 @@ func (c *ConstExpr) EvalExpr(_ *Tuple) (DBValue, error) {
 	return c.val, nil
 }
 
+// Return whether e is of type t, or a NULL constant, which is of every type.
+// Every numeric type is a NumericType, dates, timestamps and intervals are
+// TemporalTypes, both are ArithmeticTypes, and every type is an AnyType.
+func isTyped(e Expr, t DBType) bool {
+	if c, ok := e.(*ConstExpr); ok && isNull(c.val) {
+		return true
+	}
+	switch ft := e.GetExprType().Ftype; t {
+	case AnyType:
+		return true
+	case NumericType:
+		return ft.isNumeric()
+	case TemporalType:
+		return ft.isTemporal()
+	case ArithmeticType:
+		return ft.isNumeric() || ft.isTemporal()
+	}
+	return e.GetExprType().Ftype == t
+}
+
 type FuncExpr struct {
 	op   string
 	args []*Expr
@@ -66,10 +86,26 @@ func (f *FuncExpr) GetExprType() FieldType {
 			ft = fieldExpr.GetExprType()
 		}
 	}
-	return FieldType{ft.Fname, ft.TableQualifier, fType.outType}
+	outType := fType.outType
+	if (outType == NumericType || outType == ArithmeticType) && len(f.args) > 0 {
+		// the type of arithmetic depends on the types of its arguments
+		t1 := (*f.args[0]).GetExprType().Ftype
+		t2 := t1
+		if len(f.args) > 1 {
+			t2 = (*f.args[1]).GetExprType().Ftype
+		}
+		outType = arithType(arithOps[f.op], t1, t2)
+	}
+	return FieldType{ft.Fname, ft.TableQualifier, outType}
 
 }
 
+// The signature and implementation of a function.  Arguments of type
+// NumericType, ArithmeticType, TemporalType or AnyType, or of a date, timestamp
+// or interval type, are passed to f as their DBValue, and f returns a DBValue
+// if the output type is one of those.  Functions may return an error instead of
+// a value.  Those in nullArgFuncs are passed NULL arguments as a NullField, and
+// may return a NullField.
 type FuncType struct {
 	argTypes []DBType
 	outType  DBType
@@ -78,18 +114,40 @@ type FuncType struct {
 
 var funcs = map[string]FuncType{
 	//note should all be lower case
-	"+":                     {[]DBType{IntType, IntType}, IntType, addFunc},
-	"-":                     {[]DBType{IntType, IntType}, IntType, minusFunc},
-	"*":                     {[]DBType{IntType, IntType}, IntType, timesFunc},
-	"/":                     {[]DBType{IntType, IntType}, IntType, divFunc},
-	"mod":                   {[]DBType{IntType, IntType}, IntType, modFunc},
+	"+":                     {[]DBType{ArithmeticType, ArithmeticType}, ArithmeticType, addFunc},
+	"-":                     {[]DBType{ArithmeticType, ArithmeticType}, ArithmeticType, minusFunc},
+	"*":                     {[]DBType{ArithmeticType, ArithmeticType}, ArithmeticType, timesFunc},
+	"/":                     {[]DBType{ArithmeticType, ArithmeticType}, ArithmeticType, divFunc},
+	"mod":                   {[]DBType{NumericType, NumericType}, NumericType, modFunc},
 	"rand":                  {[]DBType{}, IntType, randIntFunc},
-	"sq":                    {[]DBType{IntType}, IntType, sqFunc},
+	"sq":                    {[]DBType{NumericType}, NumericType, sqFunc},
 	"getsubstr":             {[]DBType{StringType, IntType, IntType}, StringType, subStrFunc},
 	"epoch":                 {[]DBType{}, IntType, epoch},
 	"datetimestringtoepoch": {[]DBType{StringType}, IntType, dateTimeToEpoch},
 	"datestringtoepoch":     {[]DBType{StringType}, IntType, dateToEpoch},
 	"epochtodatetimestring": {[]DBType{IntType}, StringType, dateString},
+	"date":                  {[]DBType{StringType}, DateType, dateFunc},
+	"timestamp":             {[]DBType{StringType}, TimestampType, timestampFunc},
+	"interval":              {[]DBType{IntType, StringType}, IntervalType, intervalFunc},
+	"extract":               {[]DBType{StringType, TemporalType}, IntType, extractFunc},
+	"date_trunc":            {[]DBType{StringType, TemporalType}, TimestampType, dateTruncFunc},
+	"=":                     {[]DBType{AnyType, AnyType}, BoolType, comparisonFunc(OpEq)},
+	"<>":                    {[]DBType{AnyType, AnyType}, BoolType, comparisonFunc(OpNeq)},
+	"<":                     {[]DBType{AnyType, AnyType}, BoolType, comparisonFunc(OpLt)},
+	"<=":                    {[]DBType{AnyType, AnyType}, BoolType, comparisonFunc(OpLe)},
+	">":                     {[]DBType{AnyType, AnyType}, BoolType, comparisonFunc(OpGt)},
+	">=":                    {[]DBType{AnyType, AnyType}, BoolType, comparisonFunc(OpGe)},
+	"like":                  {[]DBType{StringType, StringType}, BoolType, likeFunc},
+	"not like":              {[]DBType{StringType, StringType}, BoolType, notLikeFunc},
+	"and":                   {[]DBType{BoolType, BoolType}, BoolType, andFunc},
+	"or":                    {[]DBType{BoolType, BoolType}, BoolType, orFunc},
+	"not":                   {[]DBType{BoolType}, BoolType, notFunc},
+	"is null":               {[]DBType{AnyType}, BoolType, isNullFunc(false)},
+	"is not null":           {[]DBType{AnyType}, BoolType, isNullFunc(true)},
+	"is true":               {[]DBType{BoolType}, BoolType, isBoolFunc(true, false)},
+	"is not true":           {[]DBType{BoolType}, BoolType, isBoolFunc(true, true)},
+	"is false":              {[]DBType{BoolType}, BoolType, isBoolFunc(false, false)},
+	"is not false":          {[]DBType{BoolType}, BoolType, isBoolFunc(false, true)},
 	"imin":                  {[]DBType{IntType, IntType}, IntType, minFunc},
 	"imax":                  {[]DBType{IntType, IntType}, IntType, maxFunc},
 }
@@ -104,12 +162,7 @@ func ListOfFunctions() string {
 			if hasArg {
 				args = args + ","
 			}
-			switch a {
-			case IntType:
-				args = args + "int"
-			case StringType:
-				args = args + "string"
-			}
+			args = args + typeName(a)
 			hasArg = true
 		}
 		args = args + ")"
@@ -160,37 +213,101 @@ func dateString(args []any) any {
 	return strDate
 }
 
+// Return the current time, which epoch() returns; tests replace it to pin the
+// results of queries that use it
+var now = time.Now
+
 func epoch(args []any) any {
-	t := time.Now()
+	t := now()
 	return time.Time.Unix(t)
 }
 
+// Return the date written as a string, or an error
+func dateFunc(args []any) any {
+	v, err := castTemporal(StringField{args[0].(string)}, DateType)
+	if err != nil {
+		return err
+	}
+	return v
+}
+
+// Return the timestamp written as a string, or an error
+func timestampFunc(args []any) any {
+	v, err := castTemporal(StringField{args[0].(string)}, TimestampType)
+	if err != nil {
+		return err
+	}
+	return v
+}
+
+// Return the interval of a number of a unit, like day, or an error, for
+// INTERVAL '90' DAY
+func intervalFunc(args []any) any {
+	v, err := intervalOf(args[0].(int64), args[1].(string))
+	if err != nil {
+		return err
+	}
+	return v
+}
+
+// Return a field of a date, timestamp or interval (see [extractField]), for
+// EXTRACT(unit FROM value)
+func extractFunc(args []any) any {
+	n, err := extractField(args[0].(string), args[1].(DBValue))
+	if err != nil {
+		return err
+	}
+	return n
+}
+
+// Return a date or timestamp truncated to a unit (see [truncateTime])
+func dateTruncFunc(args []any) any {
+	v, err := truncateTime(args[0].(string), args[1].(DBValue))
+	if err != nil {
+		return err
+	}
+	return v
+}
+
 func randIntFunc(args []any) any {
 	return int64(rand.Int())
 }
 
+// The arithmetic operator (see [arith]) that each arithmetic function applies
+var arithOps = map[string]string{"+": "+", "-": "-", "*": "*", "/": "/", "mod": "mod", "sq": "*"}
+
+// Apply the arithmetic operator op to x and y, two numbers, returning the
+// result or an error
+func arithFunc(op string, x any, y any) any {
+	v, err := arith(op, x.(DBValue), y.(DBValue))
+	if err != nil {
+		return err
+	}
+	return v
+}
+
 func modFunc(args []any) any {
-	return args[0].(int64) % args[1].(int64)
+	return arithFunc("mod", args[0], args[1])
 }
 
 func divFunc(args []any) any {
-	return args[0].(int64) / args[1].(int64)
+	return arithFunc("/", args[0], args[1])
 }
 
 func timesFunc(args []any) any {
-	return args[0].(int64) * args[1].(int64)
+	return arithFunc("*", args[0], args[1])
 }
 
 func minusFunc(args []any) any {
-	return args[0].(int64) - args[1].(int64)
+	return arithFunc("-", args[0], args[1])
 }
 
 func addFunc(args []any) any {
-	return args[0].(int64) + args[1].(int64)
+	return arithFunc("+", args[0], args[1])
 }
 
 func sqFunc(args []any) any {
-	return args[0].(int64) * args[0].(int64)
+	return arithFunc("*", args[0], args[0])
 }
 
 func subStrFunc(args []any) any {
@@ -219,33 +336,52 @@ func (f *FuncExpr) EvalExpr(t *Tuple) (DBValue, error) {
 		return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected %d args", f.op, len(fType.argTypes))}
 	}
 	argvals := make([]any, len(fType.argTypes))
+	null := false
 	for i, argType := range fType.argTypes {
 		arg := *f.args[i]
-		if arg.GetExprType().Ftype != argType {
-			typeName := "string"
-			switch argType {
-			case IntType:
-				typeName = "int"
-			}
-			return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected arg of type %s", f.op, typeName)}
+		if !isTyped(arg, argType) {
+			return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected arg of type %s", f.op, typeName(argType))}
 		}
 		val, err := arg.EvalExpr(t)
 		if err != nil {
 			return nil, err
 		}
+		if isNull(val) {
+			null = true
+			argvals[i] = val
+			continue
+		}
 		switch argType {
 		case IntType:
 			argvals[i] = val.(IntField).Value
 		case StringType:
 			argvals[i] = val.(StringField).Value
+		case BoolType:
+			argvals[i] = val.(BoolField).Value
+		default:
+			argvals[i] = val
 		}
 	}
+	// functions of NULL are NULL, except those that handle NULL themselves
+	if null && !nullArgFuncs[f.op] {
+		return NullField{}, nil
+	}
 	result := fType.f(argvals)
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
+	if isNull(result) {
+		return NullField{}, nil
+	}
 	switch fType.outType {
+	case NumericType, ArithmeticType, DateType, TimestampType, IntervalType:
+		return result.(DBValue), nil
 	case IntType:
 		return IntField{result.(int64)}, nil
 	case StringType:
 		return StringField{result.(string)}, nil
+	case BoolType:
+		return BoolField{result.(bool)}, nil
 	}
 	return nil, GoDBError{ParseError, "unknown result type in function"}
 }
@@ -22,9 +22,25 @@ func stringFilterGetter(v DBValue) string {
 	return stringV.Value
 }
 
+// Return a getter that reads an integer or a decimal as an integer, multiplied
+// by 10^scale so that decimals of up to that scale are exact
+func decimalFilterGetter(scale int) func(DBValue) int64 {
+	return func(v DBValue) int64 {
+		return scaledInt64(v, scale)
+	}
+}
+
+func floatFilterGetter(v DBValue) float64 {
+	return numericFloat(v)
+}
+
+func boolFilterGetter(v DBValue) int64 {
+	return boolInt(v.(BoolField).Value)
+}
+
 // Constructor for a filter operator on ints
 func NewIntFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[int64], error) {
-	if constExpr.GetExprType().Ftype != IntType || field.GetExprType().Ftype != IntType {
+	if !isTyped(constExpr, IntType) || !isTyped(field, IntType) {
 		return nil, GoDBError{IncompatibleTypesError, "cannot apply int filter to non int-types"}
 	}
 	f, err := newFilter[int64](constExpr, op, field, child, intFilterGetter)
@@ -33,13 +49,82 @@ func NewIntFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filte
 
 // Constructor for a filter operator on strings
 func NewStringFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[string], error) {
-	if constExpr.GetExprType().Ftype != StringType || field.GetExprType().Ftype != StringType {
+	if !isTyped(constExpr, StringType) || !isTyped(field, StringType) {
 		return nil, GoDBError{IncompatibleTypesError, "cannot apply string filter to non string-types"}
 	}
 	f, err := newFilter[string](constExpr, op, field, child, stringFilterGetter)
 	return f, err
 }
 
+// Constructor for a filter operator on decimals and ints, at least one of them
+// a decimal, which compares them exactly
+func NewDecimalFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[int64], error) {
+	ct, ft := constExpr.GetExprType().Ftype, field.GetExprType().Ftype
+	for _, e := range []Expr{constExpr, field} {
+		if !isTyped(e, NumericType) || e.GetExprType().Ftype.kind() == FloatType {
+			return nil, GoDBError{IncompatibleTypesError, "cannot apply decimal filter to non decimal-types"}
+		}
+	}
+	scale := maxInt(ct.scale(), ft.scale())
+	return newFilter[int64](constExpr, op, field, child, decimalFilterGetter(scale))
+}
+
+// Constructor for a filter operator on numbers of any type, at least one of
+// them a float, which compares them as floats
+func NewFloatFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[float64], error) {
+	if !isTyped(constExpr, NumericType) || !isTyped(field, NumericType) {
+		return nil, GoDBError{IncompatibleTypesError, "cannot apply float filter to non numeric-types"}
+	}
+	return newFilter[float64](constExpr, op, field, child, floatFilterGetter)
+}
+
+// Constructor for a filter operator on booleans, which orders false before true
+func NewBoolFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[int64], error) {
+	if !isTyped(constExpr, BoolType) || !isTyped(field, BoolType) {
+		return nil, GoDBError{IncompatibleTypesError, "cannot apply bool filter to non bool-types"}
+	}
+	return newFilter[int64](constExpr, op, field, child, boolFilterGetter)
+}
+
+// Constructor for a filter operator on dates and timestamps, or on intervals,
+// which compares dates with timestamps as timestamps.  A string constant
+// compared with one of them is read as a value of its type.
+func NewTimeFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[int64], error) {
+	constExpr, err := castConstExpr(constExpr, field.GetExprType().Ftype)
+	if err != nil {
+		return nil, err
+	}
+	field, err = castConstExpr(field, constExpr.GetExprType().Ftype)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range []Expr{constExpr, field} {
+		if !isTyped(e, TemporalType) {
+			return nil, GoDBError{IncompatibleTypesError, "cannot apply time filter to non time-types"}
+		}
+	}
+	// intervals are only compared with intervals
+	ct, ft := constExpr.GetExprType().Ftype, field.GetExprType().Ftype
+	if ct != UnknownType && ft != UnknownType && (ct == IntervalType) != (ft == IntervalType) {
+		return nil, GoDBError{IncompatibleTypesError, "cannot compare an interval with a date or timestamp"}
+	}
+	return newFilter[int64](constExpr, op, field, child, timeKey)
+}
+
+// Return e, or if e is a string constant and t a date, timestamp or interval
+// type, a constant of type t read from the string
+func castConstExpr(e Expr, t DBType) (Expr, error) {
+	c, ok := e.(*ConstExpr)
+	if !ok || c.constType != StringType || !t.isTemporal() {
+		return e, nil
+	}
+	v, err := castTemporal(c.val, t)
+	if err != nil {
+		return nil, err
+	}
+	return &ConstExpr{v, t}, nil
+}
+
 // Getter is a function that reads a value of the desired type
 // from a field of a tuple
 // This allows us to have a generic interface for filters that work
@@ -73,9 +158,17 @@ func (f *Filter[T]) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 			if err != nil || t == nil {
 				return nil, err
 			}
-			leftval, _ := f.left.EvalExpr(t)
-			rightval, _ := f.right.EvalExpr(t)
-			if evalPred[T](f.getter(leftval), f.getter(rightval), f.op) {
+			leftval, err := f.left.EvalExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			rightval, err := f.right.EvalExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			// tuples for which the predicate is unknown are filtered out,
+			// like those for which it is false
+			if evalPred(leftval, rightval, f.op, f.getter) == TruthTrue {
 				return t, nil
 			}
 		}
@@ -6,7 +6,7 @@ import (
 )
 
 func TestIntFilter(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	var f FieldType = FieldType{"age", "", IntType}
@@ -37,7 +37,7 @@ func TestIntFilter(t *testing.T) {
 }
 
 func TestStringFilter(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	var f FieldType = FieldType{"name", "", StringType}
@@ -0,0 +1,716 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// HashFile is a hash index over one column of a table, which maps the values
+// of the column (its keys) to the Rids of the tuples holding them like a
+// BTreeFile does, but only looks up single keys: equality predicates, and the
+// probes of an [IndexJoin].  It is a DBFile whose tuples are its entries, and
+// whose pages are read and written through the BufferPool.
+//
+// Entries are kept in buckets by linear hashing: an index with n buckets, where
+// 2^level <= n < 2^(level+1), keeps the entries whose key hashes to h in
+// bucket h mod 2^(level+1), or h mod 2^level if there is no such bucket yet
+// (see bucketOf).  Whenever the entries would fill more than hashFillFactor of
+// the bucket pages, one more bucket is added by splitting bucket n - 2^level,
+// the next one in turn, between itself and the new bucket, so that the index
+// grows one bucket at a time without ever being rebuilt.
+//
+// Page 0 of the file is a meta page holding the number of buckets, the number
+// of entries, the head of the list of free pages, and the spares of each group
+// of buckets.  Every bucket has a bucket page, followed by a chain of overflow
+// pages when its entries do not fit on one.  Bucket pages are allocated a group
+// at a time: bucket 0 is group 0, and buckets 2^(g-1) to 2^g - 1 are group g,
+// whose pages follow each other, so that the page of a bucket is found from
+// the number of overflow pages allocated before its group, its spares.
+// Overflow pages are appended to the file, or reused from the free list once
+// a split or delete empties them.  Pages begin with the same header as those
+// of a BTreeFile, whose link is the next page of the chain of a bucket, or
+// the next free page of a free page, and hold entries serialized like tuples
+// (see [Tuple.writeTo]).
+//
+// Like a BTreeFile, keys are indexed by indexKey, NULLs are not indexed, and
+// pages are pinned and latched rather than locked.  Operations latch the meta
+// page, then the bucket page of the bucket they use, which protects the chain
+// of the bucket: lookups latch both for reading, inserts and deletes latch the
+// bucket for updating, and splits latch the meta page for updating as well.
+// Splits, and the inserts and deletes that link or unlink an overflow page,
+// change several pages, and run as structural changes (see updateIndex).
+type HashFile struct {
+	bufPool  *BufferPool
+	Filename string
+	keyType  DBType
+	// serializes the allocation and freeing of pages
+	allocLock sync.Mutex
+	// see [indexFile.updates]
+	updateLock sync.RWMutex
+}
+
+type hashPageKind uint16
+
+const (
+	hashMetaPage     hashPageKind = iota
+	hashBucketPage   hashPageKind = iota
+	hashOverflowPage hashPageKind = iota
+	hashFreePage     hashPageKind = iota
+)
+
+const (
+	hashMagic         = 0x4748
+	hashFormatVersion = 1
+	// the meta page, and the bucket page of bucket 0
+	hashMetaPageNo    = 0
+	hashFirstBucketNo = 1
+	// the number of groups of buckets, which bounds the number of buckets
+	hashGroups     = 32
+	maxHashBuckets = 1 << (hashGroups - 1)
+	// a bucket is added when the entries would fill more than this share of
+	// the bucket pages
+	hashFillFactor = 0.75
+)
+
+// A page of a HashFile, whose entries are those of a bucket or overflow page,
+// in no particular order, and whose link is the next page of the chain of a
+// bucket, or the next free page of a free page
+type hashPage struct {
+	indexPage
+	file *HashFile
+	kind hashPageKind
+
+	// meta page only: the number of buckets and the spares of their groups,
+	// guarded by the latch, and the number of entries of the index
+	buckets int
+	spares  [hashGroups]int
+	count   atomic.Int64
+}
+
+// Create a HashFile for keys of type keyType, stored in fromFile, which is
+// created with a single empty bucket if it does not exist
+func NewHashFile(fromFile string, keyType DBType, bp *BufferPool) (*HashFile, error) {
+	file, err := os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	f := &HashFile{bufPool: bp, Filename: fromFile, keyType: keyType}
+	if info.Size() == 0 {
+		var meta, bucket Page = f.newPage(hashMetaPageNo, hashMetaPage), f.newPage(hashFirstBucketNo, hashBucketPage)
+		meta.(*hashPage).buckets = 1
+		for _, p := range []*Page{&meta, &bucket} {
+			if err := f.flushPage(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *HashFile) newPage(pageNo int, kind hashPageKind) *hashPage {
+	return &hashPage{indexPage: indexPage{pageNo: pageNo, link: -1, free: -1}, file: f, kind: kind}
+}
+
+// Return the number of pages in the file
+func (f *HashFile) NumPages() int {
+	return indexNumPages(f.Filename)
+}
+
+// Return the largest number of entries a page holds, which is the number of
+// entries with the longest keys that fit on it
+func (f *HashFile) maxEntries() int {
+	return maxIndexEntries(f.keyType, 2, PageSize-indexHeaderSize)
+}
+
+// Return an error unless v may be compared with the keys of f
+func (f *HashFile) checkKey(v DBValue) error {
+	return checkIndexKey(f.keyType, v)
+}
+
+// Return the hash of key, a key as it is indexed.  Keys that compare equal
+// hash alike: numbers are hashed by their value as a float64, whatever their
+// type, and dates and timestamps by the timestamp they stand for.
+func hashKey(key DBValue) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	switch v := key.(type) {
+	case IntField, FloatField, DecimalField:
+		x := numericFloat(v)
+		if x == 0 {
+			// so that -0 hashes like 0
+			x = 0
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(x))
+		h.Write(buf[:])
+	case DateField, TimestampField, IntervalField:
+		binary.LittleEndian.PutUint64(buf[:], uint64(timeKey(v)))
+		h.Write(buf[:])
+	case StringField:
+		h.Write([]byte(v.Value))
+	case BytesField:
+		h.Write(v.Value)
+	case BoolField:
+		if v.Value {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+		}
+	}
+	return h.Sum64()
+}
+
+// Return the bucket holding the entries whose keys hash to h, in an index with
+// n buckets
+func bucketOf(h uint64, n int) int {
+	level := bits.Len(uint(n)) - 1
+	b := h & (1<<(level+1) - 1)
+	if b >= uint64(n) {
+		b = h & (1<<level - 1)
+	}
+	return int(b)
+}
+
+// Return the group of bucket b (see HashFile)
+func bucketGroup(b int) int {
+	return bits.Len(uint(b))
+}
+
+// Return the bucket page of bucket b, given the meta page
+func (meta *hashPage) bucketPage(b int) int {
+	return hashFirstBucketNo + b + meta.spares[bucketGroup(b)]
+}
+
+// Return whether the entries of the index fill more than hashFillFactor of the
+// bucket pages, given the meta page
+func (f *HashFile) overFull(meta *hashPage) bool {
+	return float64(meta.count.Load()) > hashFillFactor*float64(meta.buckets*f.maxEntries())
+}
+
+// Fetch a page through the buffer pool, pinned, and latch it for reading
+// (ReadPerm) or updating (WritePerm)
+func (f *HashFile) fetch(pageNo int, perm RWPerm) (*hashPage, error) {
+	return fetchIndexPage[*hashPage](f.bufPool, f, pageNo, perm)
+}
+
+func (f *HashFile) updates() *sync.RWMutex {
+	return &f.updateLock
+}
+
+// Return the pages of the chain of bucket b, from its bucket page on, latched
+// with perm.  The caller holds the meta page latched.
+func (f *HashFile) fetchChain(meta *hashPage, b int, perm RWPerm) ([]*hashPage, error) {
+	var chain []*hashPage
+	for pageNo := meta.bucketPage(b); pageNo >= 0; {
+		page, err := f.fetch(pageNo, perm)
+		if err != nil {
+			releaseAll(chain, perm)
+			return nil, err
+		}
+		chain = append(chain, page)
+		pageNo = page.link
+	}
+	return chain, nil
+}
+
+// Return a function that iterates through the entries whose key is lo, which
+// must be the same as hi, or through every entry if both are nil.  Like for a
+// BTreeFile, strings that only share their first maxIndexKeyLength bytes with
+// lo are returned as well.  Entries are not returned in any particular order,
+// and a scan of every entry may miss or repeat those moved by a concurrent
+// split.
+func (f *HashFile) scan(lo DBValue, hi DBValue) (func() (*indexEntry, error), error) {
+	if lo == nil && hi == nil {
+		return f.scanAll(), nil
+	}
+	if lo == nil || hi == nil {
+		return nil, GoDBError{IllegalOperationError, "a hash index only looks up single keys"}
+	}
+	for _, bound := range []DBValue{lo, hi} {
+		if err := f.checkKey(bound); err != nil {
+			return nil, err
+		}
+	}
+	key := indexKey(lo)
+	if cmp, err := compareDBValues(key, indexKey(hi)); err != nil || cmp != 0 {
+		return nil, GoDBError{IllegalOperationError, "a hash index only looks up single keys"}
+	}
+
+	meta, err := f.fetch(hashMetaPageNo, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := f.fetchChain(meta, bucketOf(hashKey(key), meta.buckets), ReadPerm)
+	meta.release(ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	var entries []indexEntry
+	for _, p := range chain {
+		for _, e := range p.entries {
+			if cmp, _ := compareDBValues(e.key, key); cmp == 0 {
+				entries = append(entries, e)
+			}
+		}
+	}
+	releaseAll(chain, ReadPerm)
+	return func() (*indexEntry, error) {
+		if len(entries) == 0 {
+			return nil, nil
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return &e, nil
+	}, nil
+}
+
+// Return a function that iterates through every entry, a bucket at a time
+func (f *HashFile) scanAll() func() (*indexEntry, error) {
+	var entries []indexEntry
+	bucket := 0
+	return func() (*indexEntry, error) {
+		for len(entries) == 0 {
+			meta, err := f.fetch(hashMetaPageNo, ReadPerm)
+			if err != nil {
+				return nil, err
+			}
+			if bucket >= meta.buckets {
+				meta.release(ReadPerm)
+				return nil, nil
+			}
+			chain, err := f.fetchChain(meta, bucket, ReadPerm)
+			meta.release(ReadPerm)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range chain {
+				entries = append(entries, p.entries...)
+			}
+			releaseAll(chain, ReadPerm)
+			bucket++
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return &e, nil
+	}
+}
+
+// Return the position of e in the chain of a bucket, as the page holding it
+// and its place on the page, or -1 if it is not there
+func findInChain(chain []*hashPage, e *indexEntry) (int, int) {
+	for i, p := range chain {
+		for j := range p.entries {
+			if compareEntries(&p.entries[j], e) == 0 {
+				return i, j
+			}
+		}
+	}
+	return -1, -1
+}
+
+// Add an entry to the index, unless it is already there, then split a bucket
+// if the index is over full.  Adding an overflow page to a chain, and splitting
+// a bucket, are structural changes (see updateIndex).
+func (f *HashFile) insertEntry(e indexEntry) error {
+	split := false
+	err := updateIndex(f.bufPool, f, func(restructure bool) (bool, error) {
+		var done bool
+		var err error
+		done, split, err = f.addEntry(e, restructure)
+		return done, err
+	})
+	if err != nil || !split {
+		return err
+	}
+	return restructureIndex(f.bufPool, f, f.split)
+}
+
+// Add an entry to its bucket, unless it is already there, and return true,
+// along with whether a bucket should be split, unless it needs an overflow
+// page and restructure is false (see updateIndex)
+func (f *HashFile) addEntry(e indexEntry, restructure bool) (bool, bool, error) {
+	meta, err := f.fetch(hashMetaPageNo, ReadPerm)
+	if err != nil {
+		return false, false, err
+	}
+	defer meta.release(ReadPerm)
+	chain, err := f.fetchChain(meta, bucketOf(hashKey(e.key), meta.buckets), WritePerm)
+	if err != nil {
+		return false, false, err
+	}
+	defer func() { releaseAll(chain, WritePerm) }()
+	if i, _ := findInChain(chain, &e); i >= 0 {
+		return true, false, nil
+	}
+	var page *hashPage
+	for _, p := range chain {
+		if len(p.entries) < f.maxEntries() {
+			page = p
+			break
+		}
+	}
+	if page == nil {
+		if !restructure {
+			return false, false, nil
+		}
+		if page, err = f.allocPage(); err != nil {
+			return false, false, err
+		}
+		last := chain[len(chain)-1]
+		last.link = page.pageNo
+		last.setDirty(true)
+		chain = append(chain, page)
+	}
+	page.entries = append(page.entries, e)
+	page.setDirty(true)
+	meta.count.Add(1)
+	meta.setDirty(true)
+	return true, f.overFull(meta), nil
+}
+
+// Add a bucket to the index by splitting the next bucket in turn, if the index
+// is still over full
+func (f *HashFile) split() error {
+	freed, err := f.splitBucket()
+	if err != nil {
+		return err
+	}
+	for _, pageNo := range freed {
+		if err := f.freePage(pageNo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Split the next bucket in turn between itself and a new bucket, if the index
+// is still over full.  Returns the overflow pages of the bucket that are no
+// longer part of its chain, to be freed once they are released.
+func (f *HashFile) splitBucket() ([]int, error) {
+	meta, err := f.fetch(hashMetaPageNo, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer meta.release(WritePerm)
+	n := meta.buckets
+	if !f.overFull(meta) || n >= maxHashBuckets {
+		return nil, nil
+	}
+	if n&(n-1) == 0 {
+		if err := f.allocGroup(meta, n); err != nil {
+			return nil, err
+		}
+	}
+	old, err := f.fetchChain(meta, n-1<<(bits.Len(uint(n))-1), WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAll(old, WritePerm)
+	bucket, err := f.fetch(meta.bucketPage(n), WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	moved := []*hashPage{bucket}
+	defer func() { releaseAll(moved, WritePerm) }()
+
+	var keep, move []indexEntry
+	for _, p := range old {
+		for _, e := range p.entries {
+			if bucketOf(hashKey(e.key), n+1) == n {
+				move = append(move, e)
+			} else {
+				keep = append(keep, e)
+			}
+		}
+	}
+	if moved, err = f.fill(moved, move); err != nil {
+		return nil, err
+	}
+	// the bucket keeps fewer entries than it had, so no page is added to it
+	f.fill(old, keep)
+	meta.buckets = n + 1
+	meta.setDirty(true)
+	var freed []int
+	for _, p := range old {
+		if p.kind == hashFreePage {
+			freed = append(freed, p.pageNo)
+		}
+	}
+	return freed, nil
+}
+
+// Append the bucket pages of the group of bucket n, its first bucket, to the
+// file.  The caller holds the meta page latched for updating.
+func (f *HashFile) allocGroup(meta *hashPage, n int) error {
+	f.allocLock.Lock()
+	defer f.allocLock.Unlock()
+	first := f.NumPages()
+	for pageNo := first; pageNo < first+n; pageNo++ {
+		var empty Page = f.newPage(pageNo, hashBucketPage)
+		if err := f.flushPage(&empty); err != nil {
+			return err
+		}
+	}
+	meta.spares[bucketGroup(n)] = first - hashFirstBucketNo - n
+	meta.setDirty(true)
+	return nil
+}
+
+// Store entries on the pages of the chain of a bucket, which the caller holds
+// latched for updating, adding overflow pages to the chain or unlinking the
+// ones it no longer needs, which are marked as free pages and are to be freed
+// once they are released (see [HashFile.freePage]).  Returns the pages of the
+// chain, including those that were unlinked, which stay latched.
+func (f *HashFile) fill(chain []*hashPage, entries []indexEntry) ([]*hashPage, error) {
+	max := f.maxEntries()
+	used := 1
+	if len(entries) > max {
+		used = (len(entries) + max - 1) / max
+	}
+	for len(chain) < used {
+		page, err := f.allocPage()
+		if err != nil {
+			return chain, err
+		}
+		last := chain[len(chain)-1]
+		last.link = page.pageNo
+		last.setDirty(true)
+		chain = append(chain, page)
+	}
+	for i, p := range chain[:used] {
+		p.entries = append([]indexEntry(nil), entries[i*max:minInt((i+1)*max, len(entries))]...)
+		p.setDirty(true)
+	}
+	chain[used-1].link = -1
+	for _, p := range chain[used:] {
+		p.kind, p.entries = hashFreePage, nil
+		p.setDirty(true)
+	}
+	return chain, nil
+}
+
+// Remove an entry from the index, if it is there and keep, which is called
+// with the bucket holding the entry latched, returns false.  An overflow page
+// that is left empty is removed from its chain and freed, as a structural
+// change (see updateIndex).
+func (f *HashFile) deleteEntry(e indexEntry, keep func() bool) error {
+	return updateIndex(f.bufPool, f, func(restructure bool) (bool, error) {
+		freed, done, err := f.removeEntry(e, keep, restructure)
+		if err != nil || freed < 0 {
+			return done, err
+		}
+		return true, f.freePage(freed)
+	})
+}
+
+// Remove an entry from its bucket, as for deleteEntry, and return true, unless
+// it would leave an overflow page empty and restructure is false (see
+// updateIndex).  Also returns the overflow page that was unlinked from the
+// chain of the bucket, to be freed once it is released, or -1.
+func (f *HashFile) removeEntry(e indexEntry, keep func() bool, restructure bool) (int, bool, error) {
+	meta, err := f.fetch(hashMetaPageNo, ReadPerm)
+	if err != nil {
+		return -1, false, err
+	}
+	defer meta.release(ReadPerm)
+	chain, err := f.fetchChain(meta, bucketOf(hashKey(e.key), meta.buckets), WritePerm)
+	if err != nil {
+		return -1, false, err
+	}
+	defer releaseAll(chain, WritePerm)
+	i, j := findInChain(chain, &e)
+	if i < 0 {
+		return -1, true, nil
+	}
+	if i > 0 && len(chain[i].entries) == 1 && !restructure {
+		return -1, false, nil
+	}
+	if keep != nil && keep() {
+		return -1, true, nil
+	}
+	page := chain[i]
+	page.entries = append(page.entries[:j], page.entries[j+1:]...)
+	page.setDirty(true)
+	meta.count.Add(-1)
+	meta.setDirty(true)
+	if i > 0 && len(page.entries) == 0 {
+		chain[i-1].link = page.link
+		chain[i-1].setDirty(true)
+		return page.pageNo, true, nil
+	}
+	return -1, true, nil
+}
+
+// Return an overflow page, taken from the free list or appended to the file,
+// pinned and latched for updating
+func (f *HashFile) allocPage() (*hashPage, error) {
+	f.allocLock.Lock()
+	defer f.allocLock.Unlock()
+	p, err := f.bufPool.fetchPage(f, hashMetaPageNo)
+	if err != nil {
+		return nil, err
+	}
+	meta := (*p).(*hashPage)
+	defer meta.unpin()
+
+	pageNo := meta.free
+	if pageNo < 0 {
+		pageNo = f.NumPages()
+		var empty Page = f.newPage(pageNo, hashFreePage)
+		if err := f.flushPage(&empty); err != nil {
+			return nil, err
+		}
+	}
+	page, err := f.fetch(pageNo, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	if meta.free >= 0 {
+		meta.free = page.link
+		meta.setDirty(true)
+	}
+	page.kind, page.entries, page.link = hashOverflowPage, nil, -1
+	page.setDirty(true)
+	return page, nil
+}
+
+// Add an overflow page that is no longer part of a chain to the free list.
+// Pages are only latched while the allocLock is held once they are unlinked,
+// so that allocating them again never waits for an operation that holds
+// latches on the pages of a bucket.
+func (f *HashFile) freePage(pageNo int) error {
+	f.allocLock.Lock()
+	defer f.allocLock.Unlock()
+	p, err := f.bufPool.fetchPage(f, hashMetaPageNo)
+	if err != nil {
+		return err
+	}
+	meta := (*p).(*hashPage)
+	defer meta.unpin()
+	page, err := f.fetch(pageNo, WritePerm)
+	if err != nil {
+		return err
+	}
+	defer page.release(WritePerm)
+	page.kind, page.entries, page.link = hashFreePage, nil, meta.free
+	page.setDirty(true)
+	meta.free = page.pageNo
+	meta.setDirty(true)
+	return nil
+}
+
+// Add the entry t, a tuple of the descriptor of f, to the index.  Entries are
+// not locked on behalf of tid (see HashFile).
+func (f *HashFile) insertTuple(t *Tuple, tid TransactionID) error {
+	return insertIndexTuple(f, t)
+}
+
+// Remove the entry t, a tuple of the descriptor of f, from the index
+func (f *HashFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	return deleteIndexTuple(f, t)
+}
+
+// [Operator] descriptor method: entries are a key and the page and slot of the
+// Rid of a tuple holding it
+func (f *HashFile) Descriptor() *TupleDesc {
+	return indexEntryDesc(f.keyType)
+}
+
+// [Operator] iterator method: return a function that iterates through the
+// entries of the index, a bucket at a time
+func (f *HashFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return entryTuples(f.Descriptor(), f.scanAll()), nil
+}
+
+// Read the specified page of the file from disk
+func (f *HashFile) readPage(pageNo int) (*Page, error) {
+	return readIndexPage(f.Filename, f.newPage(pageNo, hashFreePage))
+}
+
+// Write a page back to its place in the file
+func (f *HashFile) flushPage(p *Page) error {
+	return flushIndexPage(f.Filename, p)
+}
+
+func (f *HashFile) pageKey(pgNo int) any {
+	return heapHash{f.Filename, pgNo}
+}
+
+func (f *HashFile) fileName() string {
+	return f.Filename
+}
+
+func (p *hashPage) getFile() *DBFile {
+	var f DBFile = p.file
+	return &f
+}
+
+// Return the on-disk image of the page as of before its unflushed changes, or
+// the image of a free page if it has never been on disk
+func (p *hashPage) getBeforeImage() []byte {
+	return indexBeforeImage(p, func() indexFilePage { return p.file.newPage(p.pageNo, hashFreePage) })
+}
+
+func (p *hashPage) setBeforeImage() error {
+	return setIndexBeforeImage(p)
+}
+
+// Write the page to a new buffer of PageSize bytes, as described for HashFile.
+// The meta page follows the header with the number of buckets, the number of
+// entries, the first free page and the spares of every group.
+func (p *hashPage) toBuffer() (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	if err := p.writeHeader(b, hashMagic, hashFormatVersion, uint16(p.kind)); err != nil {
+		return nil, err
+	}
+	if p.kind == hashMetaPage {
+		var spares [hashGroups]int32
+		for g, s := range p.spares {
+			spares[g] = int32(s)
+		}
+		for _, v := range []any{int32(p.buckets), p.count.Load(), int32(p.free), spares} {
+			if err := binary.Write(b, binary.LittleEndian, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.writeEntries(b, p.file.Descriptor(), false); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Read the contents of the page from a buffer written by toBuffer
+func (p *hashPage) initFromBuffer(buf *bytes.Buffer) error {
+	kind, n, err := p.readHeader(buf, p.file.Filename, hashMagic, hashFormatVersion)
+	if err != nil {
+		return err
+	}
+	p.kind = hashPageKind(kind)
+	if p.kind == hashMetaPage {
+		var buckets, free int32
+		var count int64
+		var spares [hashGroups]int32
+		for _, v := range []any{&buckets, &count, &free, &spares} {
+			if err := binary.Read(buf, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+		p.buckets, p.free = int(buckets), int(free)
+		p.count.Store(count)
+		for g, s := range spares {
+			p.spares[g] = int(s)
+		}
+	}
+	return p.readEntries(buf, p.file.Descriptor(), n, false)
+}
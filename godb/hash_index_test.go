@@ -0,0 +1,405 @@
+package godb
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Return an empty HashFile of keys of type keyType, in a buffer pool of
+// numPages pages
+func makeHashTestFile(t *testing.T, keyType DBType, numPages int) *HashFile {
+	f, err := NewHashFile(filepath.Join(t.TempDir(), "test.idx"), keyType, NewBufferPool(numPages))
+	if err != nil {
+		t.Fatalf("failed to create index: %s", err)
+	}
+	return f
+}
+
+// Return the entries of f whose key is key, or every entry if key is nil
+func scanHash(t *testing.T, f *HashFile, key DBValue) []indexEntry {
+	next, err := f.scan(key, key)
+	if err != nil {
+		t.Fatalf("scan failed: %s", err)
+	}
+	var entries []indexEntry
+	for {
+		e, err := next()
+		if err != nil {
+			t.Fatalf("scan failed: %s", err)
+		}
+		if e == nil {
+			return entries
+		}
+		entries = append(entries, *e)
+	}
+}
+
+// Check that every entry of f is in the bucket its key hashes to, and that the
+// meta page counts them.  Returns the number of buckets.
+func checkHash(t *testing.T, f *HashFile) int {
+	meta, err := f.fetch(hashMetaPageNo, ReadPerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer meta.release(ReadPerm)
+	count := 0
+	for b := 0; b < meta.buckets; b++ {
+		chain, err := f.fetchChain(meta, b, ReadPerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		for i, p := range chain {
+			if want := map[bool]hashPageKind{true: hashBucketPage, false: hashOverflowPage}[i == 0]; p.kind != want {
+				t.Errorf("page %d of the chain of bucket %d is of kind %d", p.pageNo, b, p.kind)
+			}
+			for _, e := range p.entries {
+				if got := bucketOf(hashKey(e.key), meta.buckets); got != b {
+					t.Errorf("entry %v is in bucket %d rather than %d", e, b, got)
+				}
+			}
+			count += len(p.entries)
+		}
+		releaseAll(chain, ReadPerm)
+	}
+	if int64(count) != meta.count.Load() {
+		t.Errorf("expected the meta page to count %d entries, got %d", count, meta.count.Load())
+	}
+	return meta.buckets
+}
+
+func TestHashInsertAndLookup(t *testing.T) {
+	f := makeHashTestFile(t, IntType, 50)
+	n := f.maxEntries() * 20
+	for _, i := range rand.Perm(n) {
+		// every key is held by two tuples
+		for slot := 0; slot < 2; slot++ {
+			if err := f.insertEntry(indexEntry{key: IntField{int64(i / 2)}, rid: Rid{i, slot}}); err != nil {
+				t.Fatalf("insert failed: %s", err)
+			}
+		}
+	}
+	if buckets := checkHash(t, f); buckets < 40 {
+		t.Errorf("expected the index to have split into at least 40 buckets, got %d", buckets)
+	}
+	// inserting an entry twice does nothing
+	f.insertEntry(indexEntry{key: IntField{0}, rid: Rid{0, 0}})
+	if got := scanHash(t, f, nil); len(got) != 2*n {
+		t.Fatalf("expected %d entries, got %d", 2*n, len(got))
+	}
+	for _, k := range []int64{0, 7, int64(n/2 - 1)} {
+		got := scanHash(t, f, IntField{k})
+		if len(got) != 4 {
+			t.Errorf("expected 4 entries for key %d, got %d", k, len(got))
+		}
+		for _, e := range got {
+			if e.key.(IntField).Value != k {
+				t.Errorf("expected key %d, got %v", k, e.key)
+			}
+		}
+	}
+	if got := scanHash(t, f, IntField{int64(n)}); len(got) != 0 {
+		t.Errorf("expected no entries for a missing key, got %d", len(got))
+	}
+	// numbers of other types are looked up by their value
+	if got := scanHash(t, f, FloatField{7}); len(got) != 4 {
+		t.Errorf("expected 4 entries for 7.0, got %d", len(got))
+	}
+	if got := scanHash(t, f, DecimalField{700, 2}); len(got) != 4 {
+		t.Errorf("expected 4 entries for 7.00, got %d", len(got))
+	}
+	if got := scanHash(t, f, FloatField{7.5}); len(got) != 0 {
+		t.Errorf("expected no entries for 7.5, got %d", len(got))
+	}
+	if _, err := f.scan(IntField{1}, IntField{5}); err == nil {
+		t.Errorf("expected a range not to be looked up in a hash index")
+	}
+	if _, err := f.scan(StringField{"a"}, StringField{"a"}); err == nil {
+		t.Errorf("expected a string not to be looked up in an index of integers")
+	}
+}
+
+func TestHashOverflowAndDelete(t *testing.T) {
+	f := makeHashTestFile(t, IntType, 50)
+	// more entries with one key than fit on a page, which splits cannot spread
+	n := f.maxEntries() * 3
+	for i := 0; i < n; i++ {
+		f.insertEntry(indexEntry{key: IntField{42}, rid: Rid{i, 0}})
+		f.insertEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, 1}})
+	}
+	checkHash(t, f)
+	if got := scanHash(t, f, IntField{42}); len(got) != n+1 {
+		t.Fatalf("expected %d entries for key 42, got %d", n+1, len(got))
+	}
+	pages := f.NumPages()
+
+	for i := 0; i < n; i++ {
+		if err := f.deleteEntry(indexEntry{key: IntField{42}, rid: Rid{i, 0}}, nil); err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+	}
+	checkHash(t, f)
+	if got := scanHash(t, f, IntField{42}); len(got) != 1 || got[0].rid != (Rid{42, 1}) {
+		t.Errorf("expected a single entry for key 42, got %v", got)
+	}
+	// deleting an entry that is not there, or that keep retains, does nothing
+	f.deleteEntry(indexEntry{key: IntField{42}, rid: Rid{0, 0}}, nil)
+	f.deleteEntry(indexEntry{key: IntField{42}, rid: Rid{42, 1}}, func() bool { return true })
+	if got := scanHash(t, f, IntField{42}); len(got) != 1 {
+		t.Errorf("expected the entry to be kept")
+	}
+
+	// emptied overflow pages are reused before the file grows
+	for i := 0; i < n; i++ {
+		f.insertEntry(indexEntry{key: IntField{42}, rid: Rid{i, 0}})
+	}
+	if f.NumPages() != pages {
+		t.Errorf("expected freed pages to be reused, file grew from %d to %d pages", pages, f.NumPages())
+	}
+	checkHash(t, f)
+}
+
+func TestHashStringKeys(t *testing.T) {
+	f := makeHashTestFile(t, StringType, 50)
+	long := strings.Repeat("x", 2*maxIndexKeyLength)
+	for i := 0; i < 2000; i++ {
+		key := StringField{string(rune('a'+i%26)) + long[:i%len(long)]}
+		if err := f.insertEntry(indexEntry{key: indexKey(key), rid: Rid{i, 0}}); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	checkHash(t, f)
+	if got := scanHash(t, f, StringField{"a"}); len(got) != 2 {
+		t.Errorf("expected 2 entries for key a, got %d", len(got))
+	}
+	for _, e := range scanHash(t, f, StringField{"a" + long}) {
+		if v := e.key.(StringField).Value; len(v) != maxIndexKeyLength || v[0] != 'a' {
+			t.Errorf("expected only keys cut to %d bytes from the string, got %s", maxIndexKeyLength, v)
+		}
+	}
+}
+
+func TestHashPersistence(t *testing.T) {
+	f := makeHashTestFile(t, IntType, 50)
+	n := f.maxEntries() * 5
+	for i := 0; i < n; i++ {
+		f.insertEntry(indexEntry{key: IntField{int64(i)}, rid: Rid{i, i}})
+	}
+	f.bufPool.FlushAllPages()
+
+	reopened, err := NewHashFile(f.Filename, IntType, NewBufferPool(10))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	checkHash(t, reopened)
+	iter, err := reopened.Iterator(NewTID())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	count := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if tup == nil {
+			break
+		}
+		if tup.Fields[0].(IntField).Value != tup.Fields[2].(IntField).Value {
+			t.Errorf("expected the slot of the entry to be its key, got %v", tup.Fields)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("expected %d entries after reopening the index, got %d", n, count)
+	}
+	if got := scanHash(t, reopened, IntField{int64(n - 1)}); len(got) != 1 {
+		t.Errorf("expected to look up the last key after reopening the index, got %v", got)
+	}
+}
+
+// Return the number of buckets of f
+func hashBuckets(t *testing.T, f *HashFile) int {
+	meta, err := f.fetch(hashMetaPageNo, ReadPerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer meta.release(ReadPerm)
+	return meta.buckets
+}
+
+func TestHashSplitRecoveredTogether(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	f := makeHashTestFile(t, IntType, 50)
+	if err := f.bufPool.OpenLog(logFile); err != nil {
+		t.Fatalf("failed to open log: %s", err)
+	}
+	buckets := hashBuckets(t, f)
+	n := 0
+	for ; hashBuckets(t, f) == buckets; n++ {
+		if err := f.insertEntry(indexEntry{key: IntField{int64(n)}, rid: Rid{n, n}}); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	// write back the buckets that were there before the split, as eviction
+	// would, but not the new bucket or the meta page, then crash
+	meta, err := f.fetch(hashMetaPageNo, ReadPerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var pages []int
+	for b := 0; b < buckets; b++ {
+		pages = append(pages, meta.bucketPage(b))
+	}
+	meta.release(ReadPerm)
+	for _, pageNo := range pages {
+		if err := f.bufPool.writeBackPage(heapHash{f.Filename, pageNo}); err != nil {
+			t.Fatalf("write back failed: %s", err)
+		}
+	}
+	f.bufPool.logFile.Close()
+
+	bp := NewBufferPool(50)
+	if err := bp.OpenLog(logFile); err != nil {
+		t.Fatalf("recovery failed: %s", err)
+	}
+	defer bp.logFile.Close()
+	reopened, err := NewHashFile(f.Filename, IntType, bp)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if entries := scanHash(t, reopened, nil); len(entries) != n {
+		t.Errorf("expected %d entries after recovery, got %d", n, len(entries))
+	}
+	checkHash(t, reopened)
+}
+
+func TestHashConcurrent(t *testing.T) {
+	f := makeHashTestFile(t, IntType, 100)
+	const workers, perWorker = 8, 1000
+	// keys of even workers are deleted again as they are inserted, while odd
+	// workers insert theirs and look them up
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				e := indexEntry{key: IntField{int64(i*workers + w)}, rid: Rid{w, i}}
+				if err := f.insertEntry(e); err != nil {
+					errs <- err
+					return
+				}
+				if w%2 == 0 && i > 0 {
+					prev := indexEntry{key: IntField{int64((i-1)*workers + w)}, rid: Rid{w, i - 1}}
+					if err := f.deleteEntry(prev, nil); err != nil {
+						errs <- err
+						return
+					}
+				}
+				if w%2 == 1 {
+					next, err := f.scan(e.key, e.key)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if got, _ := next(); got == nil {
+						t.Errorf("expected key %v to be found as soon as it is inserted", e.key)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("%s", err)
+	}
+	checkHash(t, f)
+	want := workers/2*perWorker + workers/2
+	if got := scanHash(t, f, nil); len(got) != want {
+		t.Errorf("expected %d entries, got %d", want, len(got))
+	}
+}
+
+func TestHashIndexStatements(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte("f (name string, age int)\ng (age int, label string)\n"), 0644)
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(20), dir)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %s", err)
+	}
+	defer c.bp.logFile.Close()
+	runTestQuery(t, c, "insert into f values ('sam', 25), ('joe', 30), ('bob', 30), ('ann', 41)")
+	runTestQuery(t, c, "insert into g values (30, 'thirty'), (41, 'forty one'), (50, 'fifty')")
+
+	if _, _, err := Parse(c, "create index f_age on f using hash (age)"); err != nil {
+		t.Fatalf("failed to create index: %s", err)
+	}
+	if _, _, err := Parse(c, "create index g_age on g (age) using hash"); err != nil {
+		t.Fatalf("failed to create index: %s", err)
+	}
+	if _, _, err := Parse(c, "create index g_label on g (label) using bitmap"); err == nil {
+		t.Errorf("expected an unknown index method to fail")
+	}
+	runTestQuery(t, c, "insert into f values ('tim', 30)")
+
+	_, plan, err := Parse(c, "select name from f where age = 30")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !planUses[*IndexScan](plan) {
+		t.Errorf("expected the plan to look up the hash index")
+	}
+	if got := runTestQuery(t, c, "select name from f where age = 30"); len(got) != 3 {
+		t.Errorf("expected 3 tuples of age 30, got %d", len(got))
+	}
+	_, plan, _ = Parse(c, "select name from f where age > 30")
+	if planUses[*IndexScan](plan) {
+		t.Errorf("expected a range not to be looked up in the hash index")
+	}
+	if got := runTestQuery(t, c, "select name from f where age > 30"); len(got) != 1 {
+		t.Errorf("expected 1 tuple older than 30, got %d", len(got))
+	}
+
+	_, plan, err = Parse(c, "select f.name, g.label from f, g where f.age = g.age")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !planUses[*IndexJoin](plan) {
+		t.Errorf("expected the join to probe the hash index")
+	}
+	got := runTestQuery(t, c, "select f.name, g.label from f, g where f.age = g.age")
+	if len(got) != 4 {
+		t.Fatalf("expected 4 joined tuples, got %d", len(got))
+	}
+	for _, tup := range got {
+		name, label := tup.Fields[0].(StringField).Value, tup.Fields[1].(StringField).Value
+		if (name == "ann") != (label == "forty one") || label == "fifty" {
+			t.Errorf("unexpected joined tuple %s, %s", name, label)
+		}
+	}
+
+	if err := c.SaveToFile("catalog.txt", dir); err != nil {
+		t.Fatalf("%s", err)
+	}
+	saved, _ := os.ReadFile(filepath.Join(dir, "catalog.txt"))
+	if !strings.Contains(string(saved), "index f_age on f (age) using hash") {
+		t.Errorf("expected the method of the index to be saved in the catalog, got %s", saved)
+	}
+	c.bp.logFile.Close()
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPool(20), dir)
+	if err != nil {
+		t.Fatalf("failed to reload catalog: %s", err)
+	}
+	if _, plan, _ := Parse(c, "select name from f where age = 30"); !planUses[*IndexScan](plan) {
+		t.Errorf("expected the hash index to be looked up after reloading")
+	}
+	if got := runTestQuery(t, c, "select name from f where age = 30"); len(got) != 3 {
+		t.Errorf("expected 3 tuples of age 30 after reloading, got %d", len(got))
+	}
+}
@@ -23,6 +23,11 @@ type HeapFile struct {
 	sync.Mutex
 	desc     *TupleDesc
 	Filename string
+	// holds the values too large to be stored in their tuples, see
+	// overflow.go; nil until needed
+	overflow *HeapFile
+	// the indexes maintained along with the file, see index.go
+	indexes []*Index
 }
 
 // Create a HeapFile.
@@ -38,6 +43,10 @@ func NewHeapFile(fromFile string, td *TupleDesc, bp *BufferPool) (*HeapFile, err
 		return nil, err
 	}
 	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() == 0 && bp != nil {
+		// any header left by a file of the same name is out of date
+		bp.resetRows(fromFile)
+	}
 
 	hf := new(HeapFile)
 	hf.bufPool = bp
@@ -86,47 +95,66 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 		}
 		var newFields []DBValue
 		for fno, field := range fields {
-			switch f.Descriptor().Fields[fno].Ftype {
-			case IntType:
+			ftype := f.Descriptor().Fields[fno].Ftype
+			if k := ftype.kind(); k != StringType && k != BytesType {
+				// strings are taken as they are, but an empty field of any
+				// other type is NULL
 				field = strings.TrimSpace(field)
+				if field == "" {
+					newFields = append(newFields, NullField{})
+					continue
+				}
+			}
+			switch ftype.kind() {
+			case IntType:
 				floatVal, err := strconv.ParseFloat(field, 64)
 				if err != nil {
 					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: couldn't convert value %s to int, tuple %d", field, cnt)}
 				}
 				intValue := int(floatVal)
 				newFields = append(newFields, IntField{int64(intValue)})
-			case StringType:
-				if len(field) > StringLength {
-					field = field[0:StringLength]
+			case FloatType:
+				floatVal, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: couldn't convert value %s to float, tuple %d", field, cnt)}
+				}
+				newFields = append(newFields, FloatField{floatVal})
+			case DecimalType:
+				decimalVal, err := parseDecimal(field, ftype)
+				if err != nil {
+					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: couldn't convert value %s to %s, tuple %d", field, typeName(ftype), cnt)}
 				}
+				newFields = append(newFields, decimalVal)
+			case DateType, TimestampType, IntervalType:
+				timeVal, err := castTemporal(StringField{field}, ftype)
+				if err != nil {
+					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: couldn't convert value %s to %s, tuple %d", field, typeName(ftype), cnt)}
+				}
+				newFields = append(newFields, timeVal)
+			case BoolType:
+				boolVal, err := castBool(StringField{field})
+				if err != nil {
+					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: couldn't convert value %s to bool, tuple %d", field, cnt)}
+				}
+				newFields = append(newFields, boolVal)
+			case StringType:
 				newFields = append(newFields, StringField{field})
+			case BytesType:
+				newFields = append(newFields, BytesField{[]byte(field)})
 			}
 		}
 		newT := Tuple{*f.Descriptor(), newFields, nil}
 		tid := NewTID()
 		bp := f.bufPool
 		bp.BeginTransaction(tid)
-		f.insertTuple(&newT, tid)
-
-		// hack to force dirty pages to disk
-		// because CommitTransaction may not be implemented
-		// yet if this is called in lab 1 or 2
-		for j := 0; j < f.NumPages(); j++ {
-			pg, err := bp.GetPage(f, j, tid, ReadPerm)
-			if pg == nil || err != nil {
-				fmt.Println("page nil or error", err)
-				break
-			}
-			if (*pg).isDirty() {
-				(*f).flushPage(pg)
-				(*pg).setDirty(false)
-			}
-
+		if err := f.insertTuple(&newT, tid); err != nil {
+			return bp.abortOnError(tid, err)
 		}
 
 		//commit frequently, to avoid all pages in BP being full
-		//todo fix
-		bp.CommitTransaction(tid)
+		if err := bp.CommitTransaction(tid); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -152,7 +180,10 @@ func (f *HeapFile) readPage(pageNo int) (*Page, error) {
 		return nil, err1
 	}
 	hg := newHeapPage(f.desc, pageNo, f)
-	hg.initFromBuffer(bytes.NewBuffer(buf))
+	if err := hg.initFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		return nil, err
+	}
+	hg.beforeImage = buf
 
 	var ans Page = hg
 	return &ans, nil
@@ -170,33 +201,96 @@ func (f *HeapFile) readPage(pageNo int) (*Page, error) {
 // rather than directly reading pages itself. For lab 1, you do not need to
 // worry about concurrent transactions modifying the Page or HeapFile.  We will
 // add support for concurrent modifications in lab 3.
+//
+// The tuple is locked by its Rid rather than locking the page it is inserted
+// into, so that concurrent transactions can insert into the same page.
+//
+// Values that make the tuple too large are stored out of line, in the overflow
+// file of f (see overflow.go).  The entries of the tuple are added to the
+// indexes of f (see index.go), and it is counted in the row count of f once
+// tid commits (see row_count.go).
 func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 	// TODO: some code goes here
+	cast, err := castTuple(t, f.Descriptor())
+	if err != nil {
+		return err
+	}
+	if err := f.bufPool.trackRows(f); err != nil {
+		return err
+	}
+	stored, err := f.storeOverflow(cast, tid)
+	if err != nil {
+		return err
+	}
+	if err := f.insertStored(stored, tid); err != nil {
+		return err
+	}
+	t.Rid = stored.Rid
+	cast.Rid = stored.Rid
+	return f.insertIndexEntries(cast, tid)
+}
+
+// Insert the tuple as it is to be stored on a page, with any values stored out
+// of line replaced by references to them, and set its Rid
+func (f *HeapFile) insertStored(t *Tuple, tid TransactionID) error {
+	tup, err := encodeTuple(t)
+	if err != nil {
+		return err
+	}
 	for i := 0; i < f.NumPages(); i++ {
-		hp, err := f.bufPool.GetPage(f, i, tid, 1)
+		ok, err := f.insertIntoPage(t, tup, tid, i)
+		if err != nil || ok {
+			return err
+		}
+	}
+	//All pages full: append an empty page to the file, then insert into it
+	//through the buffer pool so that the new tuple is locked and logged like
+	//any other page update
+	for {
+		f.Lock()
+		pageNo := f.NumPages()
+		var empty Page = newHeapPage(f.desc, pageNo, f)
+		err := f.flushPage(&empty)
+		f.Unlock()
 		if err != nil {
 			return err
 		}
-		page := (*hp).(*heapPage)
-		_, ok := page.insertTuple(t)
-		if ok == nil {
-			page.setDirty(true)
-			return nil
+		ok, err := f.insertIntoPage(t, tup, tid, pageNo)
+		if err != nil || ok {
+			return err
 		}
 	}
-	//All pages full
-	new_page := newHeapPage(f.desc, f.NumPages(), f)
-	_, ok := new_page.insertTuple(t)
-	if ok != nil {
-		return ok
-	}
-	//Flush to diskfile
-	var pg Page = new_page
-	f.flushPage(&pg)
-	new_page.setDirty(false)
-
-	return nil
+}
 
+// Insert the tuple, serialized as tup, into a free slot of the specified page
+// that no other transaction holds a lock on, and return true, or return false
+// if there is no such slot or not enough free space on the page.
+func (f *HeapFile) insertIntoPage(t *Tuple, tup []byte, tid TransactionID, pageNo int) (bool, error) {
+	hp, err := f.bufPool.getPageForRows(f, pageNo, tid, WritePerm)
+	if err != nil {
+		return false, err
+	}
+	page := (*hp).(*heapPage)
+	// a free slot may still be locked by a transaction that deleted its tuple
+	// and has not committed yet
+	for slot := page.nextFreeSlot(0, len(tup)); slot >= 0; slot = page.nextFreeSlot(slot+1, len(tup)) {
+		ok, err := f.bufPool.tryLockRow(f, Rid{pageNo, slot}, tid, Exclusive)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		err = f.bufPool.updateTuple(tid, InsertRecord, page, slot, tup, func() error {
+			_, err := page.insertTupleAt(t, slot)
+			return err
+		})
+		if gerr, ok := err.(GoDBError); ok && gerr.code == PageFullError {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, nil
 }
 
 // Remove the provided tuple from the HeapFile.  This method should use the
@@ -212,20 +306,48 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
   对于通过 [Iterator] 读取的元组。 请注意，Rid 是一个空接口，因此您可以提供任何您想要的对象。
 	您可能需要识别元组来自的页面中的堆页面和槽。
 */
+//
+// Only the tuple is locked, so that concurrent transactions can delete other
+// tuples of the same page.  The values of the tuple stored out of line are
+// deleted along with it, and its index entries are removed once no snapshot
+// can see it (see index.go).
 func (f *HeapFile) deleteTuple(t *Tuple, tid TransactionID) error {
 	// TODO: some code goes here
-	pageid := t.Rid.(Rid).pageid
-	hp, err := f.bufPool.GetPage(f, pageid, tid, 1)
+	if err := f.bufPool.trackRows(f); err != nil {
+		return err
+	}
+	stored, err := f.deleteStored(t.Rid.(Rid), tid)
 	if err != nil {
 		return err
 	}
+	if err := f.deleteIndexEntries(stored, t.Rid.(Rid), tid); err != nil {
+		return err
+	}
+	return f.freeOverflow(stored, tid)
+}
+
+// Delete the tuple with the specified Rid, and return it as it was stored on
+// its page
+func (f *HeapFile) deleteStored(rid Rid, tid TransactionID) (*Tuple, error) {
+	hp, err := f.bufPool.getPageForRows(f, rid.pageid, tid, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.bufPool.lockRow(f, rid, tid, Exclusive); err != nil {
+		return nil, err
+	}
 	page := (*hp).(*heapPage)
-	ok := page.deleteTuple(t.Rid)
-	if ok != nil {
-		return ok
+	var stored *Tuple
+	err = f.bufPool.updateTuple(tid, DeleteRecord, page, rid.slotid, nil, func() error {
+		if stored, err = page.tupleAt(rid.slotid); err != nil {
+			return err
+		}
+		return page.deleteTuple(rid)
+	})
+	if gerr, ok := err.(GoDBError); ok && gerr.code == SerializationError {
+		err = f.bufPool.abortOnError(tid, err)
 	}
-	page.setDirty(true)
-	return nil
+	return stored, err
 }
 
 // Method to force the specified page back to the backing file at the appropriate
@@ -273,35 +395,37 @@ func (f *HeapFile) Descriptor() *TupleDesc {
 // 由于 BufferPool 缓存页面并管理事务的页面级锁定状态
 // 您应该确保此方法返回的元组的 Rid 对象设置适当，以便 [deleteTuple] 可以工作（请参阅那里的其他注释）。
 */
+//
+// Tuples are read from the snapshot of tid (see mvcc.go), without locking the
+// pages they are on, along with their values stored out of line.
 func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 
 	// TODO: some code goes here
 	i := 0
-	var iter func() (*Tuple, error)
-	var page *heapPage = new(heapPage)
-	page.pageNo = -1
-	fmt.Println(f.NumPages())
+	var tuples []*Tuple
+	f.bufPool.beginScan(tid)
 
 	return func() (*Tuple, error) {
-		for i < f.NumPages() {
-			if page.pageNo != i {
-				hp, err := f.bufPool.GetPage(f, i, tid, 1)
-				if err != nil {
-					return nil, err
-				}
-				page = (*hp).(*heapPage)
-				iter = page.tupleIter()
+		for len(tuples) == 0 {
+			if i >= f.NumPages() {
+				return nil, nil
 			}
-			for {
-				tup, _ := iter()
-				if tup != nil {
-					return tup, nil
-				}
-				i++
-				break
+			hp, err := f.bufPool.getPageForScan(f, i, tid)
+			if err != nil {
+				return nil, err
 			}
+			tuples, err = f.bufPool.visibleTuples(tid, (*hp).(*heapPage))
+			if err != nil {
+				return nil, err
+			}
+			i++
+		}
+		tup := tuples[0]
+		tuples = tuples[1:]
+		if err := f.fetchOverflow(tup, tid); err != nil {
+			return nil, err
 		}
-		return nil, nil
+		return tup, nil
 	}, nil
 
 }
@@ -2,13 +2,25 @@ package godb
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
-const TestingFile string = "test.dat"
-const TestingFile2 string = "test2.dat"
+// The heap files of the current test, in a temporary directory (see
+// useTestFiles)
+var TestingFile = "test.dat"
+var TestingFile2 = "test2.dat"
+
+// Point TestingFile and TestingFile2 at files in a new temporary directory for
+// the rest of the test
+func useTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	TestingFile, TestingFile2 = filepath.Join(dir, "test.dat"), filepath.Join(dir, "test2.dat")
+	t.Cleanup(func() { TestingFile, TestingFile2 = "test.dat", "test2.dat" })
+}
 
-func makeTestVars() (TupleDesc, Tuple, Tuple, *HeapFile, *BufferPool, TransactionID) {
+func makeTestVars(t *testing.T) (TupleDesc, Tuple, Tuple, *HeapFile, *BufferPool, TransactionID) {
 	var td = TupleDesc{Fields: []FieldType{
 		{Fname: "name", Ftype: StringType},
 		{Fname: "age", Ftype: IntType},
@@ -29,7 +41,7 @@ func makeTestVars() (TupleDesc, Tuple, Tuple, *HeapFile, *BufferPool, Transactio
 		}}
 
 	bp := NewBufferPool(3)
-	os.Remove(TestingFile)
+	useTestFiles(t)
 	hf, err := NewHeapFile(TestingFile, &td, bp)
 	if err != nil {
 		print("ERROR MAKING TEST VARS, BLARGH")
@@ -44,7 +56,7 @@ func makeTestVars() (TupleDesc, Tuple, Tuple, *HeapFile, *BufferPool, Transactio
 }
 
 func TestCreateAndInsertHeapFile(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	iter, _ := hf.Iterator(tid)
@@ -62,7 +74,7 @@ func TestCreateAndInsertHeapFile(t *testing.T) {
 }
 
 func TestDeleteHeapFile(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 
@@ -81,7 +93,7 @@ func TestDeleteHeapFile(t *testing.T) {
 }
 
 func testSerializeN(t *testing.T, n int) {
-	td, t1, t2, hf, bp, _ := makeTestVars()
+	td, t1, t2, hf, bp, _ := makeTestVars(t)
 	for i := 0; i < n; i++ {
 		tid := NewTID()
 		bp.BeginTransaction(tid)
@@ -149,7 +161,7 @@ func TestSerializeVeryLargeHeapFile(t *testing.T) {
 }
 
 func TestLoadCSV(t *testing.T) {
-	_, _, _, hf, _, tid := makeTestVars()
+	_, _, _, hf, bp, _ := makeTestVars(t)
 	f, err := os.Open("test_heap_file.csv")
 	if err != nil {
 		t.Errorf("Couldn't open test_heap_file.csv")
@@ -159,7 +171,10 @@ func TestLoadCSV(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Load failed, %s", err)
 	}
-	//should have 384 records
+	//should have 384 records, visible to transactions that begin after the
+	//load has committed
+	tid := NewTID()
+	bp.BeginTransaction(tid)
 	iter, _ := hf.Iterator(tid)
 	i := 0
 	for {
@@ -175,7 +190,7 @@ func TestLoadCSV(t *testing.T) {
 }
 
 func TestHeapFilePageKey(t *testing.T) {
-	td, t1, _, hf, bp, tid := makeTestVars()
+	td, t1, _, hf, bp, tid := makeTestVars(t)
 
 	os.Remove(TestingFile2)
 	hf2, _ := NewHeapFile(TestingFile2, &td, bp)
@@ -204,3 +219,22 @@ func TestHeapFilePageKey(t *testing.T) {
 		}
 	}
 }
+
+func TestHeapFileLongStrings(t *testing.T) {
+	td, _, _, hf, bp, tid := makeTestVars(t)
+	long := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("a", 2*StringLength)}, IntField{1}}}
+	if err := hf.insertTuple(&long, tid); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	bp.CommitTransaction(tid)
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := hf.Iterator(tid)
+	tup, err := iter()
+	if err != nil || tup == nil || !tup.equals(&long) {
+		t.Errorf("expected long string to be read back untruncated, got %v", tup)
+	}
+	if tup, _ := iter(); tup != nil {
+		t.Errorf("expected one tuple, got another: %v", tup)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sync"
 	"unsafe"
 )
 
@@ -16,55 +17,80 @@ interface requires.  You will want to use an interface like what we provide to
 implement the methods of [HeapFile] that insert, delete, and iterate through
 tuples.
 
-In GoDB all tuples are fixed length, which means that given a TupleDesc it is
-possible to figure out how many tuple "slots" fit on a given page.
+In GoDB tuples are variable length: strings are stored with their length
+rather than padded to a fixed size (see [Tuple.writeTo]), so the number of
+tuples that fit on a page depends on their contents.  Pages are slotted pages.
 
-In addition, all pages are PageSize bytes.  They begin with a header with a 32
-bit integer with the number of slots (tuples), and a second 32 bit integer with
-the number of used slots.
+All pages are PageSize bytes.  They begin with a header with a 16 bit magic
+number and a 16 bit format version (see pageMagic), a 32 bit integer with the
+number of slots in the slot directory, and a second 32 bit integer with the
+number of used slots, followed by the slot directory.  Each slot takes
+two 16 bit integers, the offset of its tuple on the page and the length of the
+tuple, with an offset of 0 for a slot that is not used.  The tuples themselves
+are stored at the end of the page, growing towards the slot directory:
 
-Each tuple occupies the same number of bytes.  You can use the go function
-unsafe.Sizeof() to determine the size in bytes of an object.  So, a GoDB integer
-(represented as an int64) requires unsafe.Sizeof(int64(0)) bytes.  For strings,
-we encode them as byte arrays of StringLength, so they are size
-((int)(unsafe.Sizeof(byte('a')))) * StringLength bytes.  The size in bytes  of a
-tuple is just the sum of the size in bytes of its fields.
+header | slot 0 | slot 1 | ... free space ... | tuple 1 | tuple 0
 
-Once you have figured out how big a record is, you can determine the number of
-slots on on the page as:
+A tuple keeps its slot number (and Rid) for as long as it exists, even when the
+page is written back to disk and read again, or when the tuples are moved to
+make room for others.  The buffer pool relies on this to lock individual tuples
+by their Rid.  So the slot of a deleted tuple is left in the directory, to be
+reused by a later insert, and the slot directory never shrinks.
 
-remPageSize = PageSize - 8 // bytes after header
-numSlots = remPageSize / bytesPerTuple //integer division will round down
+A page in memory holds the decoded tuples, and only keeps track of how many
+bytes are free, so that deleting tuples never leaves holes in it.  Tuples are
+packed at the end of the page as it is written out.  Raw page images that are
+updated in place during recovery are compacted whenever a tuple does not fit
+in the space between the slot directory and the tuples (see [setSlotInImage]).
 
-To serialize a page to a buffer, you can then:
-
-write the number of slots as an int32
-write the number of used slots as an int32
-write the tuples themselves to the buffer
-
-You will follow the inverse process to read pages from a buffer.
-
-Note that to process deletions you will likely delete tuples at a specific
-position (slot) in the heap page.  This means that after a page is read from
-disk, tuples should retain the same slot number. Because GoDB will never evict a
-dirty page, it's OK if tuples are renumbered when they are written back to disk.
+Pages written before the format was versioned have no magic number, and the
+tuples on pages of version 1 have no bitmap of NULL fields.  Neither can be
+read, and the files they belong to must be rewritten with [MigrateHeapFile]
+first.
 */
 
 type Header struct {
+	// number of slots in the slot directory
 	slots int32
 	useds int32
 }
 
+const (
+	// the first 16 bits of every page, followed by the format version: 1 for
+	// tuples with no bitmap of NULL fields, 2 for the current format
+	pageMagic         = 0x4744
+	pageFormatVersion = 2
+	pageHeaderSize    = 12
+	slotEntrySize     = 4
+	// the largest serialized tuple that fits on an empty page
+	maxTupleSize = PageSize - pageHeaderSize - slotEntrySize
+)
+
 type heapPage struct {
 	// TODO: some code goes here
 	hdr    Header
 	tuples []*Tuple
 	desc   *TupleDesc
 	used   []bool
+	// serialized length of the tuple in each used slot
+	lengths []int
+	// bytes not taken by the header, the slot directory or the tuples
+	free int
+	// serialized length of a tuple of desc whose strings are StringLength
+	// bytes long, used to report how many tuples fit on the page
+	nominalSize int
 
 	file   *HeapFile
 	pageNo int
 	Dirty  bool
+
+	// contents of the page as of the last time it was logged, or read from or
+	// written to disk
+	beforeImage []byte
+
+	// protects the slots of a page that several transactions update at once,
+	// each holding locks on the rows they touch
+	latch sync.Mutex
 }
 
 type Rid struct {
@@ -79,62 +105,225 @@ func newHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) *heapPage {
 	hpage.desc = desc
 	hpage.pageNo = pageNo
 	hpage.file = f
-	bytesPerTuple := 0
+	hpage.nominalSize = nullBitmapSize(len(desc.Fields))
 	for i := 0; i < len(desc.Fields); i++ {
-		if desc.Fields[i].Ftype == IntType {
-			bytesPerTuple += int(unsafe.Sizeof(int64(0)))
+		if k := desc.Fields[i].Ftype.kind(); k == IntType || k == FloatType || k == DecimalType || k == DateType || k == TimestampType {
+			hpage.nominalSize += int(unsafe.Sizeof(int64(0)))
+		} else if k == IntervalType {
+			hpage.nominalSize += 2 * int(unsafe.Sizeof(int64(0)))
+		} else if k == BoolType {
+			hpage.nominalSize++
 		} else if desc.Fields[i].Ftype == StringType {
-			bytesPerTuple += ((int)(unsafe.Sizeof(byte('a')))) * StringLength
+			hpage.nominalSize += int(unsafe.Sizeof(uint16(0))) + StringLength
 		}
 	}
-	remPageSize := PageSize - 8             // bytes after header
-	numSlots := remPageSize / bytesPerTuple //integer division will round down
-
-	hpage.hdr.slots = int32(numSlots)
-	hpage.hdr.useds = 0
-	hpage.used = make([]bool, hpage.hdr.slots)
-	hpage.tuples = make([]*Tuple, hpage.hdr.slots)
+	hpage.free = PageSize - pageHeaderSize
 	return hpage //replace me
 }
 
+// Return how many more tuples whose strings are StringLength bytes long fit on
+// the page.  Tuples with shorter strings take less space, so more of them may
+// fit.
 func (h *heapPage) getNumSlots() int {
 	// TODO: some code goes here
-	return int(h.hdr.slots - h.hdr.useds)
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	size := h.nominalSize
+	if size == 0 {
+		size = 1
+	}
+	unused := int(h.hdr.slots - h.hdr.useds)
+	n := h.free / size
+	if n <= unused {
+		return n
+	}
+	return unused + (h.free-unused*size)/(size+slotEntrySize)
+}
+
+// Return the number of slots in the slot directory
+func (h *heapPage) numSlots() int {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	return int(h.hdr.slots)
+}
+
+// Return how many bytes storing a tuple of the specified size in slot takes,
+// including the slots that have to be added to the slot directory
+func (h *heapPage) spaceFor(slot int, size int) int {
+	if slot < len(h.used) {
+		return size
+	}
+	return size + (slot+1-len(h.used))*slotEntrySize
 }
 
-// Insert the tuple into a free slot on the page, or return an error if there are
-// no free slots.  Set the tuples rid and return it.
+// Insert the tuple into a free slot on the page, or return an error if there is
+// not enough free space.  Set the tuples rid and return it.
 func (h *heapPage) insertTuple(t *Tuple) (recordID, error) {
 	// TODO: some code goes here
-	if h.hdr.useds == h.hdr.slots {
-		return Rid{-1, -1}, fmt.Errorf("full insert fail")
+	tup, err := encodeTuple(t)
+	if err != nil {
+		return Rid{-1, -1}, err
 	}
-	for i := 0; i < len(h.tuples); i++ {
-		if !h.used[i] {
-			h.tuples[i] = t
-			t.Rid = Rid{h.pageNo, i}
-			h.used[i] = true
-			h.hdr.useds++
-			return t.Rid, nil
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	slot := h.freeSlot(0, len(tup))
+	if slot < 0 {
+		return Rid{-1, -1}, GoDBError{PageFullError, "page is full"}
+	}
+	h.fillSlot(t, slot, len(tup))
+	return t.Rid, nil
+}
+
+func (h *heapPage) fillSlot(t *Tuple, slot int, size int) {
+	h.free -= h.spaceFor(slot, size)
+	for len(h.used) <= slot {
+		h.used = append(h.used, false)
+		h.tuples = append(h.tuples, nil)
+		h.lengths = append(h.lengths, 0)
+	}
+	h.hdr.slots = int32(len(h.used))
+	h.tuples[slot] = t
+	h.lengths[slot] = size
+	t.Rid = Rid{h.pageNo, slot}
+	h.used[slot] = true
+	h.hdr.useds++
+}
+
+// Return the first free slot at or after from that a tuple of the specified
+// size fits in, adding a slot to the slot directory if needed, or -1 if there
+// is no room for the tuple.  The caller must hold the latch.
+func (h *heapPage) freeSlot(from int, size int) int {
+	for i := from; i < len(h.used); i++ {
+		if !h.used[i] && size <= h.free {
+			return i
 		}
 	}
-	return Rid{-1, -1}, fmt.Errorf("insert fail")
+	slot := from
+	if slot < len(h.used) {
+		slot = len(h.used)
+	}
+	if h.spaceFor(slot, size) <= h.free {
+		return slot
+	}
+	return -1
+}
+
+// Return the first free slot at or after from that a tuple of the specified
+// size fits in, or -1 if there is none
+func (h *heapPage) nextFreeSlot(from int, size int) int {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	return h.freeSlot(from, size)
+}
+
+// Return whether a tuple of the specified size fits in slot, which must be free
+func (h *heapPage) fits(slot int, size int) bool {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	return slot >= 0 && (slot >= len(h.used) || !h.used[slot]) && h.spaceFor(slot, size) <= h.free
+}
+
+// Insert the tuple into the specified slot, which must be free.  Set the tuples
+// rid and return it.
+func (h *heapPage) insertTupleAt(t *Tuple, slot int) (recordID, error) {
+	tup, err := encodeTuple(t)
+	if err != nil {
+		return Rid{-1, -1}, err
+	}
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	if slot < 0 || (slot < len(h.used) && h.used[slot]) {
+		return Rid{-1, -1}, GoDBError{PageFullError, "slot is not free"}
+	}
+	if h.spaceFor(slot, len(tup)) > h.free {
+		return Rid{-1, -1}, GoDBError{PageFullError, "tuple does not fit on the page"}
+	}
+	h.fillSlot(t, slot, len(tup))
+	return t.Rid, nil
+}
+
+// Replace the tuple in the specified slot by t, which keeps the rid of the
+// tuple it replaces.  Return an error if the slot is not used, or if t does not
+// fit on the page.
+func (h *heapPage) updateTuple(rid recordID, t *Tuple) error {
+	tup, err := encodeTuple(t)
+	if err != nil {
+		return err
+	}
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	idx := rid.(Rid).slotid
+	if idx < 0 || idx >= len(h.used) || !h.used[idx] {
+		return GoDBError{TupleNotFoundError, "slot is not used"}
+	}
+	if len(tup)-h.lengths[idx] > h.free {
+		return GoDBError{PageFullError, "tuple does not fit on the page"}
+	}
+	h.free -= len(tup) - h.lengths[idx]
+	h.lengths[idx] = len(tup)
+	h.tuples[idx] = t
+	t.Rid = Rid{h.pageNo, idx}
+	return nil
 }
 
 // Delete the tuple in the specified slot number, or return an error if
 // the slot is invalid
 func (h *heapPage) deleteTuple(rid recordID) error {
+	h.latch.Lock()
+	defer h.latch.Unlock()
 	r := rid.(Rid)
 	idx := r.slotid
-	if !h.used[idx] {
+	if idx < 0 || idx >= len(h.used) || !h.used[idx] {
 		return fmt.Errorf("delete not exisit")
 	}
 	h.used[idx] = false
+	h.tuples[idx] = nil
+	h.free += h.lengths[idx]
+	h.lengths[idx] = 0
 	h.hdr.useds--
 	return nil //replace me
 
 }
 
+// Return the tuple in the specified slot, or an error if the slot is not used
+func (h *heapPage) tupleAt(slot int) (*Tuple, error) {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	if slot < 0 || slot >= len(h.used) || !h.used[slot] {
+		return nil, GoDBError{TupleNotFoundError, "slot is not used"}
+	}
+	return h.tuples[slot], nil
+}
+
+// Serialize the tuple as it is stored in a slot, or return an error if it is
+// too large to fit on a page
+func encodeTuple(t *Tuple) ([]byte, error) {
+	b := new(bytes.Buffer)
+	if err := t.writeTo(b); err != nil {
+		return nil, err
+	}
+	if b.Len() > maxTupleSize {
+		return nil, GoDBError{MalformedDataError, "tuple is too large to fit on a page"}
+	}
+	return b.Bytes(), nil
+}
+
+// Return the serialized tuple in the specified slot, or an error if the slot
+// is not used
+func (h *heapPage) slotBytes(slot int) ([]byte, error) {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	if slot < 0 || slot >= len(h.used) || !h.used[slot] {
+		return nil, GoDBError{TupleNotFoundError, "slot is not used"}
+	}
+	return encodeTuple(h.tuples[slot])
+}
+
+// Decode a tuple serialized by encodeTuple
+func (h *heapPage) decodeTuple(b []byte) (*Tuple, error) {
+	return readTupleFrom(bytes.NewBuffer(b), h.desc)
+}
+
 // Page method - return whether or not the page is dirty
 func (h *heapPage) isDirty() bool {
 	return h.Dirty //replace me
@@ -145,6 +334,32 @@ func (h *heapPage) setDirty(dirty bool) {
 	h.Dirty = dirty
 }
 
+// Page method - return the on-disk image of the page that was current before
+// any of the unflushed changes were made.  A page that has never been on disk
+// has an empty page as its before image.
+func (h *heapPage) getBeforeImage() []byte {
+	if h.beforeImage == nil {
+		empty := newHeapPage(h.desc, h.pageNo, h.file)
+		buf, err := empty.toBuffer()
+		if err != nil {
+			return nil
+		}
+		return buf.Bytes()
+	}
+	return h.beforeImage
+}
+
+// Page method - record the current contents of the page as its before image,
+// called whenever the page is known to match what is on disk
+func (h *heapPage) setBeforeImage() error {
+	buf, err := h.toBuffer()
+	if err != nil {
+		return err
+	}
+	h.beforeImage = buf.Bytes()
+	return nil
+}
+
 // Page method - return the corresponding HeapFile
 // for this page.
 func (p *heapPage) getFile() *DBFile {
@@ -155,41 +370,209 @@ func (p *heapPage) getFile() *DBFile {
 // Allocate a new bytes.Buffer and write the heap page to it. Returns an error
 // if the write to the the buffer fails. You will likely want to call this from
 // your [HeapFile.flushPage] method.  You should write the page header, using
-// the binary.Write method in LittleEndian order, followed by the tuples of the
-// page, written using the Tuple.writeTo method.
+// the binary.Write method in LittleEndian order, followed by the slot
+// directory, with the tuples, written using the Tuple.writeTo method, packed
+// at the end of the page.
 func (h *heapPage) toBuffer() (*bytes.Buffer, error) {
 	// TODO: some code goes here
-	b := new(bytes.Buffer)
-	binary.Write(b, binary.LittleEndian, h.hdr)
+	h.latch.Lock()
+	defer h.latch.Unlock()
+	img := make([]byte, PageSize)
+	putImageHeader(img, int(h.hdr.slots), h.hdr.useds)
+	end := PageSize
 	for i := 0; i < len(h.tuples); i++ {
-		if h.used[i] {
-			err := h.tuples[i].writeTo(b)
-			if err != nil {
-				return b, err
-			}
+		if !h.used[i] {
+			continue
 		}
+		tup, err := encodeTuple(h.tuples[i])
+		if err != nil {
+			return nil, err
+		}
+		end -= len(tup)
+		if end < pageHeaderSize+len(h.used)*slotEntrySize {
+			return nil, GoDBError{PageFullError, "tuples do not fit on the page"}
+		}
+		copy(img[end:], tup)
+		setImageSlot(img, i, end, len(tup))
 	}
-	pading := make([]byte, PageSize-b.Len())
-	_, err1 := b.Write(pading)
-	if err1 != nil {
-		return nil, err1
-	}
-	return b, nil //replace me
+	return bytes.NewBuffer(img), nil //replace me
 }
 
 // Read the contents of the HeapPage from the supplied buffer.
 func (h *heapPage) initFromBuffer(buf *bytes.Buffer) error {
 	// TODO: some code goes here
-	binary.Read(buf, binary.LittleEndian, &h.hdr.slots)
-	binary.Read(buf, binary.LittleEndian, &h.hdr.useds)
-	for i := 0; i < int(h.hdr.useds); i++ {
-		h.tuples[i], _ = readTupleFrom(buf, h.desc)
-		h.tuples[i].Rid = Rid{h.pageNo, i}
+	img := buf.Next(PageSize)
+	if len(img) < PageSize {
+		return GoDBError{MalformedDataError, "page is cut short"}
+	}
+	if isZeroImage(img) {
+		// a page that was never written, left as a hole in the file
+		return nil
+	}
+	if err := checkImageFormat(img); err != nil {
+		return err
+	}
+	slots, _ := imageHeader(img)
+	dirEnd := pageHeaderSize + slots*slotEntrySize
+	if slots < 0 || dirEnd > PageSize {
+		return GoDBError{MalformedDataError, "slot directory does not fit on the page"}
+	}
+	h.used = make([]bool, slots)
+	h.tuples = make([]*Tuple, slots)
+	h.lengths = make([]int, slots)
+	h.hdr = Header{int32(slots), 0}
+	h.free = PageSize - dirEnd
+	for i := 0; i < slots; i++ {
+		off, length := imageSlot(img, i)
+		if off == 0 {
+			continue
+		}
+		if off < dirEnd || off+length > PageSize {
+			return GoDBError{MalformedDataError, "tuple is not on the page"}
+		}
+		tup, err := h.decodeTuple(img[off : off+length])
+		if err != nil {
+			return err
+		}
+		tup.Rid = Rid{h.pageNo, i}
+		h.tuples[i] = tup
 		h.used[i] = true
+		h.lengths[i] = length
+		h.free -= length
+		h.hdr.useds++
 	}
 	return nil //replace me
 }
 
+// Return an error unless a raw page image is in the current format
+func checkImageFormat(img []byte) error {
+	magic := binary.LittleEndian.Uint16(img[0:2])
+	version := binary.LittleEndian.Uint16(img[2:4])
+	if magic != pageMagic {
+		return GoDBError{MalformedDataError, "page is in an older format, rewrite the file with MigrateHeapFile"}
+	}
+	if version != pageFormatVersion {
+		return GoDBError{MalformedDataError, fmt.Sprintf("unsupported page format version %d", version)}
+	}
+	return nil
+}
+
+func isZeroImage(img []byte) bool {
+	for _, b := range img {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the number of slots and of used slots in the header of a raw page image
+func imageHeader(img []byte) (int, int32) {
+	return int(int32(binary.LittleEndian.Uint32(img[4:8]))), int32(binary.LittleEndian.Uint32(img[8:12]))
+}
+
+func putImageHeader(img []byte, slots int, useds int32) {
+	binary.LittleEndian.PutUint16(img[0:2], pageMagic)
+	binary.LittleEndian.PutUint16(img[2:4], pageFormatVersion)
+	binary.LittleEndian.PutUint32(img[4:8], uint32(slots))
+	binary.LittleEndian.PutUint32(img[8:12], uint32(useds))
+}
+
+// Return the offset and length of the tuple in the specified slot of a raw page
+// image, with an offset of 0 if the slot is not used
+func imageSlot(img []byte, slot int) (int, int) {
+	entry := img[pageHeaderSize+slot*slotEntrySize:]
+	return int(binary.LittleEndian.Uint16(entry[0:2])), int(binary.LittleEndian.Uint16(entry[2:4]))
+}
+
+func setImageSlot(img []byte, slot int, off int, length int) {
+	entry := img[pageHeaderSize+slot*slotEntrySize:]
+	binary.LittleEndian.PutUint16(entry[0:2], uint16(off))
+	binary.LittleEndian.PutUint16(entry[2:4], uint16(length))
+}
+
+// Return the offset of the first tuple of a raw page image with the specified
+// number of slots, or PageSize if the page is empty
+func imageDataStart(img []byte, slots int) int {
+	start := PageSize
+	for i := 0; i < slots; i++ {
+		if off, _ := imageSlot(img, i); off != 0 && off < start {
+			start = off
+		}
+	}
+	return start
+}
+
+// Move the tuples of a raw page image with the specified number of slots to the
+// end of the page, so that all of its free space is between the slot directory
+// and the tuples.  Slots keep their numbers.
+func compactImage(img []byte, slots int) {
+	data := make([]byte, PageSize)
+	end := PageSize
+	for i := 0; i < slots; i++ {
+		off, length := imageSlot(img, i)
+		if off == 0 {
+			continue
+		}
+		end -= length
+		copy(data[end:], img[off:off+length])
+		setImageSlot(img, i, end, length)
+	}
+	copy(img[end:], data[end:])
+}
+
+// Mark the specified slot of a raw page image, as written by
+// [heapPage.toBuffer], as used and store tup in it, or mark it as free if tup
+// is nil.  The slot directory grows if needed, and the page is compacted if tup
+// does not fit in the free space between the slot directory and the tuples.
+// Used to undo changes to pages that are not in the buffer pool.
+func setSlotInImage(img []byte, slot int, tup []byte) error {
+	if !isZeroImage(img) {
+		if err := checkImageFormat(img); err != nil {
+			return err
+		}
+	}
+	slots, useds := imageHeader(img)
+	if slot < 0 || pageHeaderSize+(slot+1)*slotEntrySize > PageSize {
+		return GoDBError{MalformedDataError, "slot is not on the page"}
+	}
+	if slot < slots {
+		if off, _ := imageSlot(img, slot); off != 0 {
+			setImageSlot(img, slot, 0, 0)
+			useds--
+		}
+	}
+	if tup != nil {
+		newSlots := slots
+		if slot >= newSlots {
+			newSlots = slot + 1
+		}
+		dirEnd := pageHeaderSize + newSlots*slotEntrySize
+		used := 0
+		for i := 0; i < slots; i++ {
+			if off, length := imageSlot(img, i); off != 0 {
+				used += length
+			}
+		}
+		if dirEnd+used+len(tup) > PageSize {
+			return GoDBError{PageFullError, "tuple does not fit on the page"}
+		}
+		if imageDataStart(img, slots)-dirEnd < len(tup) {
+			compactImage(img, slots)
+		}
+		off := imageDataStart(img, slots) - len(tup)
+		for i := slots; i < newSlots; i++ {
+			setImageSlot(img, i, 0, 0)
+		}
+		copy(img[off:], tup)
+		setImageSlot(img, slot, off, len(tup))
+		slots = newSlots
+		useds++
+	}
+	putImageHeader(img, slots, useds)
+	return nil
+}
+
 // Return a function that iterates through the tuples of the heap page.  Be sure
 // to set the rid of the tuple to the rid struct of your choosing beforing
 // return it. Return nil, nil when the last tuple is reached.
@@ -197,12 +580,14 @@ func (p *heapPage) tupleIter() func() (*Tuple, error) {
 	// TODO: some code goes here
 	i := 0
 	return func() (*Tuple, error) {
+		p.latch.Lock()
+		defer p.latch.Unlock()
 		for i < len(p.tuples) {
 			if p.used[i] {
 				res := new(Tuple)
 				res.Desc = p.tuples[i].Desc
 				res.Fields = append(res.Fields, p.tuples[i].Fields...)
-				res.Rid = p.tuples[i].Rid
+				res.Rid = Rid{p.pageNo, i}
 				i++
 				return res, nil
 			} else {
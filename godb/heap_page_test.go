@@ -1,14 +1,31 @@
 package godb
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 	"unsafe"
 )
 
+// Return a copy of t with its strings padded to StringLength bytes, the size
+// that getNumSlots counts tuples in
+func wideTuple(t Tuple) Tuple {
+	fields := make([]DBValue, len(t.Fields))
+	for i, f := range t.Fields {
+		if s, ok := f.(StringField); ok && len(s.Value) < StringLength {
+			f = StringField{s.Value + strings.Repeat(" ", StringLength-len(s.Value))}
+		}
+		fields[i] = f
+	}
+	return Tuple{Desc: t.Desc, Fields: fields}
+}
+
 func TestInsertHeapPage(t *testing.T) {
-	td, t1, t2, hf, _, _ := makeTestVars()
+	td, t1, t2, hf, _, _ := makeTestVars(t)
 	pg := newHeapPage(&td, 0, hf)
-	var expectedSlots = (PageSize - 8) / (StringLength + int(unsafe.Sizeof(int64(0))))
+	// every tuple takes a slot directory entry and a byte of bitmap of NULL
+	// fields, and its string a 16 bit length
+	var expectedSlots = (PageSize - pageHeaderSize) / (1 + StringLength + 2 + int(unsafe.Sizeof(int64(0))) + 4)
 	if pg.getNumSlots() != expectedSlots {
 		t.Fatalf("Incorrect number of slots, expected %d, got %d", expectedSlots, pg.getNumSlots())
 	}
@@ -34,7 +51,7 @@ func TestInsertHeapPage(t *testing.T) {
 }
 
 func TestDeleteHeapPage(t *testing.T) {
-	td, t1, t2, hf, _, _ := makeTestVars()
+	td, t1, t2, hf, _, _ := makeTestVars(t)
 	pg := newHeapPage(&td, 0, hf)
 
 	pg.insertTuple(&t1)
@@ -62,15 +79,18 @@ func TestDeleteHeapPage(t *testing.T) {
 
 // Unit test for insertTuple
 func TestHeapPageInsertTuple(t *testing.T) {
-	td, t1, _, hf, _, _ := makeTestVars()
+	td, t1, _, hf, _, _ := makeTestVars(t)
 	page := newHeapPage(&td, 0, hf)
 	free := page.getNumSlots()
+	// getNumSlots counts tuples whose strings are StringLength bytes long
+	t1 = wideTuple(t1)
+	name := t1.Fields[0].(StringField).Value
 
 	for i := 0; i < free; i++ {
 		var addition = Tuple{
 			Desc: td,
 			Fields: []DBValue{
-				StringField{"sam"},
+				StringField{name},
 				IntField{int64(i)},
 			},
 		}
@@ -108,7 +128,7 @@ func TestHeapPageInsertTuple(t *testing.T) {
 
 // Unit test for deleteTuple
 func TestHeapPageDeleteTuple(t *testing.T) {
-	td, _, _, hf, _, _ := makeTestVars()
+	td, _, _, hf, _, _ := makeTestVars(t)
 	page := newHeapPage(&td, 0, hf)
 	free := page.getNumSlots()
 
@@ -149,7 +169,7 @@ func TestHeapPageDeleteTuple(t *testing.T) {
 
 // Unit test for isDirty, setDirty
 func TestHeapPageDirty(t *testing.T) {
-	td, _, _, hf, _, _ := makeTestVars()
+	td, _, _, hf, _, _ := makeTestVars(t)
 	page := newHeapPage(&td, 0, hf)
 
 	page.setDirty(true)
@@ -169,7 +189,7 @@ func TestHeapPageDirty(t *testing.T) {
 // Unit test for toBuffer and initFromBuffer
 func TestHeapPageSerialization(t *testing.T) {
 
-	td, _, _, hf, _, _ := makeTestVars()
+	td, _, _, hf, _, _ := makeTestVars(t)
 	page := newHeapPage(&td, 0, hf)
 	free := page.getNumSlots()
 
@@ -219,3 +239,121 @@ func TestHeapPageSerialization(t *testing.T) {
 		}
 	}
 }
+
+// Short strings take less space than long ones, and strings much longer than
+// StringLength are stored without being truncated
+func TestHeapPageVariableLength(t *testing.T) {
+	td, t1, _, hf, _, _ := makeTestVars(t)
+	page := newHeapPage(&td, 0, hf)
+	free := page.getNumSlots()
+	for i := 0; i < free; i++ {
+		if _, err := page.insertTuple(&t1); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	if _, err := page.insertTuple(&t1); err != nil {
+		t.Errorf("expected short tuples to fit beyond getNumSlots, got %s", err)
+	}
+
+	page = newHeapPage(&td, 0, hf)
+	long := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("x0", 1500)}, IntField{7}}}
+	if _, err := page.insertTuple(&long); err != nil {
+		t.Fatalf("insert of long string failed: %s", err)
+	}
+	if _, err := page.insertTuple(&long); err == nil {
+		t.Errorf("expected second long string not to fit on the page")
+	}
+	buf, _ := page.toBuffer()
+	page2 := newHeapPage(&td, 0, hf)
+	if err := page2.initFromBuffer(buf); err != nil {
+		t.Fatalf("failed to read page: %s", err)
+	}
+	if tup, _ := page2.tupleIter()(); tup == nil || !tup.equals(&long) {
+		t.Errorf("long string did not round trip")
+	}
+}
+
+// Rids survive deletes of other tuples, updates that change the size of a
+// tuple, and writing the page out and reading it back; the slot of a deleted
+// tuple is reused
+func TestHeapPageStableRids(t *testing.T) {
+	td, t1, t2, hf, _, _ := makeTestVars(t)
+	page := newHeapPage(&td, 0, hf)
+	rids := make([]recordID, 3)
+	for i := range rids {
+		tup := Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{int64(i)}}}
+		rids[i], _ = page.insertTuple(&tup)
+	}
+	page.deleteTuple(rids[1])
+	longer := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("y", 500)}, IntField{0}}}
+	if err := page.updateTuple(rids[0], &longer); err != nil {
+		t.Fatalf("update failed: %s", err)
+	}
+	buf, _ := page.toBuffer()
+	page2 := newHeapPage(&td, 0, hf)
+	if err := page2.initFromBuffer(buf); err != nil {
+		t.Fatalf("failed to read page: %s", err)
+	}
+	found := map[Rid]int64{}
+	iter := page2.tupleIter()
+	for tup, _ := iter(); tup != nil; tup, _ = iter() {
+		found[tup.Rid.(Rid)] = tup.Fields[1].(IntField).Value
+	}
+	if len(found) != 2 || found[rids[0].(Rid)] != 0 || found[rids[2].(Rid)] != 2 {
+		t.Errorf("expected tuples to keep their rids, got %v", found)
+	}
+	if rid, _ := page2.insertTuple(&t2); rid != rids[1] {
+		t.Errorf("expected the free slot %v to be reused, got %v", rids[1], rid)
+	}
+	if _, err := page2.insertTupleAt(&t1, 10); err != nil {
+		t.Fatalf("insert past the end of the slot directory failed: %s", err)
+	}
+	if page2.numSlots() != 11 {
+		t.Errorf("expected slot directory to grow to 11 slots, got %d", page2.numSlots())
+	}
+}
+
+// Undoing changes on raw page images compacts the page when a tuple only fits
+// once the free space left by deleted tuples is reclaimed
+func TestSetSlotInImageCompacts(t *testing.T) {
+	td, _, _, hf, _, _ := makeTestVars(t)
+	page := newHeapPage(&td, 0, hf)
+	big := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("z", 1300)}, IntField{1}}}
+	for i := 0; i < 3; i++ {
+		page.insertTuple(&big)
+	}
+	buf, _ := page.toBuffer()
+	img := buf.Bytes()
+	tup, _ := encodeTuple(&big)
+	if err := setSlotInImage(img, 0, nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := setSlotInImage(img, 2, nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+	// the first tuple fits in the hole left by slot 2, the second only once the
+	// hole left by slot 0 has been moved next to it
+	for _, slot := range []int{0, 5} {
+		if err := setSlotInImage(img, slot, tup); err != nil {
+			t.Fatalf("failed to store tuple in slot %d: %s", slot, err)
+		}
+	}
+	if err := setSlotInImage(img, 2, tup); err == nil {
+		t.Errorf("expected a fourth tuple not to fit on the page")
+	}
+	page2 := newHeapPage(&td, 0, hf)
+	if err := page2.initFromBuffer(bytes.NewBuffer(img)); err != nil {
+		t.Fatalf("failed to read page: %s", err)
+	}
+	slots := []int{}
+	iter := page2.tupleIter()
+	for tup, _ := iter(); tup != nil; tup, _ = iter() {
+		if !tup.equals(&big) {
+			t.Errorf("tuple was corrupted by compaction")
+		}
+		slots = append(slots, tup.Rid.(Rid).slotid)
+	}
+	if len(slots) != 3 || slots[0] != 0 || slots[1] != 1 || slots[2] != 5 {
+		t.Errorf("expected tuples in slots 0, 1 and 5, got %v", slots)
+	}
+}
@@ -0,0 +1,642 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Secondary indexes of heap tables.  An Index maps the values of one column of
+// a table to the Rids of the tuples holding them, in a BTreeFile, or in a
+// HashFile for indexes that only look up single keys (see IndexMethod), and is
+// maintained by [HeapFile.insertTuple] and [HeapFile.deleteTuple] on behalf of
+// the transactions that change the table.
+//
+// Index entries are not versioned like the tuples they describe (see mvcc.go):
+// an entry is added as soon as its tuple is inserted, and only removed once no
+// snapshot can see the tuple any more.  So an index holds an entry for every
+// version of a row that some snapshot may see, along with entries whose tuple
+// was rolled back or is not visible to the reader, which [IndexScan] skips by
+// reading each tuple through the snapshot of its transaction and checking that
+// it still holds the key of the entry.
+//
+// The changes a transaction makes to indexes are tracked by the BufferPool:
+//   - when it commits, the index pages it has changed are logged (or written
+//     back, without a log), so that the entries of its tuples survive a crash,
+//     and the entries of the tuples it deleted become dead once every running
+//     snapshot sees the delete;
+//   - when it aborts, or rolls back to a savepoint, the entries of the tuples
+//     it inserted are dead right away, and the entries of the tuples it deleted
+//     are kept.
+//
+// Dead entries are removed by [BufferPool.Vacuum], unless the slot of their
+// tuple has since been reused by a tuple with the same key.  Entries that were
+// dead when the process stopped are never removed, which is harmless since
+// readers skip them.
+
+// The structure an index keeps its entries in: a B+ tree, which looks up
+// ranges of keys, or a hash table, which only looks up single keys
+type IndexMethod int
+
+const (
+	BTreeIndex IndexMethod = iota
+	HashIndex  IndexMethod = iota
+)
+
+var indexMethodNames = map[IndexMethod]string{
+	BTreeIndex: "btree",
+	HashIndex:  "hash",
+}
+
+func (m IndexMethod) String() string {
+	return indexMethodNames[m]
+}
+
+// Return the index method named name (e.g. "hash"), which is lower case
+func indexMethodNamed(name string) (IndexMethod, bool) {
+	for m, n := range indexMethodNames {
+		if n == name {
+			return m, true
+		}
+	}
+	return BTreeIndex, false
+}
+
+// The file the entries of an index are kept in (see IndexMethod)
+type indexFile interface {
+	DBFile
+	fileName() string
+	// Return an error unless v may be compared with the keys of the file
+	checkKey(v DBValue) error
+	insertEntry(e indexEntry) error
+	deleteEntry(e indexEntry, keep func() bool) error
+	// Return a function that iterates through the entries whose keys are at
+	// least lo and at most hi, where a nil bound is unbounded
+	scan(lo DBValue, hi DBValue) (func() (*indexEntry, error), error)
+	// Return the lock that updates of the file hold, for writing while they
+	// restructure it (see updateIndex), and that is held for writing as well
+	// while its pages are logged together
+	updates() *sync.RWMutex
+}
+
+// Index is a secondary index over one column of a heap table
+type Index struct {
+	Name   string
+	Column string
+	Method IndexMethod
+	file   indexFile
+	// position of Column in the descriptor of the table
+	field int
+}
+
+// An entry added to or removed from an index on behalf of a transaction
+// (rtype InsertRecord or DeleteRecord), for a tuple of heap
+type indexChange struct {
+	index *Index
+	heap  *HeapFile
+	entry indexEntry
+	rtype LogRecordType
+	// once dead, the value of commitSeq from which on every new snapshot sees
+	// that the tuple of the entry is gone
+	seq int64
+}
+
+// Create an Index called name over the column of a table with descriptor desc,
+// stored in fromFile with the specified method, which is created empty if it
+// does not exist.  Returns a TypeMismatchError if there is no such column.
+func NewIndex(name string, column string, method IndexMethod, desc *TupleDesc, fromFile string, bp *BufferPool) (*Index, error) {
+	field := -1
+	for i, f := range desc.Fields {
+		if f.Fname == column {
+			field = i
+		}
+	}
+	if field < 0 {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("no column %s to index", column)}
+	}
+	var file indexFile
+	var err error
+	switch method {
+	case BTreeIndex:
+		file, err = NewBTreeFile(fromFile, desc.Fields[field].Ftype, bp)
+	case HashIndex:
+		file, err = NewHashFile(fromFile, desc.Fields[field].Ftype, bp)
+	default:
+		err = GoDBError{IllegalOperationError, fmt.Sprintf("unknown index method %d", method)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Index{name, column, method, file, field}, nil
+}
+
+// Return the file the entries of the index are stored in, a *BTreeFile or a
+// *HashFile
+func (idx *Index) File() DBFile {
+	return idx.file
+}
+
+// Add idx to the indexes f maintains
+func (f *HeapFile) addIndex(idx *Index) {
+	f.indexes = append(f.indexes, idx)
+}
+
+// Return the index of f over column, or nil if there is none
+func (f *HeapFile) indexOn(column string) *Index {
+	for _, idx := range f.indexes {
+		if idx.Column == column {
+			return idx
+		}
+	}
+	return nil
+}
+
+// Add the entries of every tuple of f visible to tid to idx, on behalf of tid
+func (idx *Index) build(f *HeapFile, tid TransactionID) error {
+	iter, err := f.Iterator(tid)
+	if err != nil {
+		return err
+	}
+	for {
+		t, err := iter()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		if err := f.insertIndexEntry(idx, t.Fields[idx.field], t.Rid.(Rid), tid); err != nil {
+			return err
+		}
+	}
+}
+
+// Add the entries of t, a tuple just inserted into f by tid, with the values
+// of its fields, to the indexes of f
+func (f *HeapFile) insertIndexEntries(t *Tuple, tid TransactionID) error {
+	for _, idx := range f.indexes {
+		if err := f.insertIndexEntry(idx, t.Fields[idx.field], t.Rid.(Rid), tid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *HeapFile) insertIndexEntry(idx *Index, v DBValue, rid Rid, tid TransactionID) error {
+	if isNull(v) {
+		return nil
+	}
+	e := indexEntry{key: indexKey(v), rid: rid}
+	f.bufPool.addIndexChange(tid, &indexChange{index: idx, heap: f, entry: e, rtype: InsertRecord})
+	return idx.file.insertEntry(e)
+}
+
+// Record that the entries of stored, the tuple with the specified Rid just
+// deleted from f by tid, as it was stored on its page, are to be removed from
+// the indexes of f once the delete is visible to every snapshot.  Its values
+// stored out of line are read from the snapshot of tid, so this must be done
+// before they are deleted.
+func (f *HeapFile) deleteIndexEntries(stored *Tuple, rid Rid, tid TransactionID) error {
+	for _, idx := range f.indexes {
+		v := stored.Fields[idx.field]
+		if ref, ok := v.(overflowRef); ok {
+			value, err := f.readOverflow(ref, tid)
+			if err != nil {
+				return err
+			}
+			v = StringField{string(value)}
+			if f.desc.Fields[idx.field].Ftype == BytesType {
+				v = BytesField{value}
+			}
+		}
+		if isNull(v) {
+			continue
+		}
+		e := indexEntry{key: indexKey(v), rid: rid}
+		f.bufPool.addIndexChange(tid, &indexChange{index: idx, heap: f, entry: e, rtype: DeleteRecord})
+	}
+	return nil
+}
+
+func (bp *BufferPool) addIndexChange(tid TransactionID, c *indexChange) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	bp.indexChanges[tid] = append(bp.indexChanges[tid], c)
+}
+
+// Log the images of the dirty pages of the indexes tid has changed (see
+// logIndexPages), or write them back without a log, so that they are durable
+// once tid commits.  Each file is flushed between updates (see updateIndex),
+// so that it is not caught halfway through one.
+func (bp *BufferPool) flushIndexes(tid TransactionID) error {
+	bp.poolLock.Lock()
+	files := make(map[string]indexFile)
+	for _, c := range bp.indexChanges[tid] {
+		files[c.index.file.fileName()] = c.index.file
+	}
+	bp.poolLock.Unlock()
+
+	for _, f := range files {
+		f.updates().Lock()
+		bp.poolLock.Lock()
+		var err error
+		if bp.logFile != nil {
+			err = bp.logIndexPages(f)
+		} else {
+			for _, p := range bp.indexPages(f) {
+				if err = bp.writePage(p); err != nil {
+					break
+				}
+			}
+		}
+		bp.poolLock.Unlock()
+		f.updates().Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the dirty pages of the index file f in the buffer pool.  The caller
+// must hold the pool lock.
+func (bp *BufferPool) indexPages(f indexFile) []pair {
+	var pages []pair
+	for key, e := range bp.pool {
+		if key.FileName == f.fileName() && (*e.Value.(pair).value).isDirty() {
+			pages = append(pages, e.Value.(pair))
+		}
+	}
+	return pages
+}
+
+// Log the images of the dirty pages of the index file f that have changed since
+// they were last logged, in one record, so that recovery redoes them all or
+// none.  The caller holds the update lock of f for writing, so that no update
+// is halfway done, as well as the pool lock.
+func (bp *BufferPool) logIndexPages(f indexFile) error {
+	var logged []pair
+	var keys []heapHash
+	var images [][]byte
+	for _, p := range bp.indexPages(f) {
+		after, err := (*p.value).toBuffer()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal((*p.value).getBeforeImage(), after.Bytes()) {
+			continue
+		}
+		logged = append(logged, p)
+		keys = append(keys, p.key)
+		images = append(images, after.Bytes())
+	}
+	if len(logged) == 0 {
+		return nil
+	}
+	off, err := bp.logFile.logIndexImages(keys, images)
+	if err != nil {
+		return err
+	}
+	for _, p := range logged {
+		if _, ok := bp.pageRecLSN[p.key]; !ok {
+			bp.pageRecLSN[p.key] = off
+		}
+		if err := (*p.value).setBeforeImage(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write back p, a dirty page of an index file, unless the file is being
+// restructured, in which case false is returned: p may be one of the pages of
+// a split or merge that are only logged, together, once it is done (see
+// updateIndex).  Changes to index pages are never rolled back, so they may be
+// written back without a log.  The caller must hold the pool lock.
+func (bp *BufferPool) writeIndexPage(p pair) (bool, error) {
+	f := (*(*p.value).getFile()).(indexFile)
+	if !f.updates().TryRLock() {
+		return false, nil
+	}
+	defer f.updates().RUnlock()
+	return true, bp.writePage(p)
+}
+
+// Once tid has committed, the entries of the tuples it deleted are dead as of
+// its commit.  The caller must hold the pool lock.
+func (bp *BufferPool) commitIndexChanges(tid TransactionID) {
+	for _, c := range bp.indexChanges[tid] {
+		if c.rtype == DeleteRecord {
+			c.seq = bp.commitSeq
+			bp.deadIndexEntries = append(bp.deadIndexEntries, c)
+		}
+	}
+	delete(bp.indexChanges, tid)
+}
+
+// Roll back the changes of tid to indexes from its from-th one on: the entries
+// of the tuples it inserted are dead right away, and those of the tuples it
+// deleted are kept.  The caller must hold the pool lock.
+func (bp *BufferPool) rollbackIndexChanges(tid TransactionID, from int) {
+	changes := bp.indexChanges[tid]
+	for _, c := range changes[from:] {
+		if c.rtype == InsertRecord {
+			c.seq = 0
+			bp.deadIndexEntries = append(bp.deadIndexEntries, c)
+		}
+	}
+	bp.indexChanges[tid] = changes[:from]
+}
+
+// Take the dead index entries whose tuples no snapshot taken at or after oldest
+// can see.  The caller must hold the pool lock.
+func (bp *BufferPool) takeDeadIndexEntries(oldest int64) []*indexChange {
+	var dead, kept []*indexChange
+	for _, c := range bp.deadIndexEntries {
+		if c.seq <= oldest {
+			dead = append(dead, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	bp.deadIndexEntries = kept
+	return dead
+}
+
+// Remove dead entries from their indexes, unless their slot holds a tuple with
+// the same key again.  Entries that cannot be removed are kept for the next
+// Vacuum.
+func (bp *BufferPool) removeIndexEntries(dead []*indexChange) {
+	for _, c := range dead {
+		c := c
+		err := c.index.file.deleteEntry(c.entry, func() bool { return bp.rowHasKey(c) })
+		if err != nil {
+			bp.poolLock.Lock()
+			bp.deadIndexEntries = append(bp.deadIndexEntries, c)
+			bp.poolLock.Unlock()
+		}
+	}
+}
+
+// Return true if some version of the row of the entry of c, on its page or in
+// the version store, holds the key of the entry, or if that cannot be told
+func (bp *BufferPool) rowHasKey(c *indexChange) bool {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	rid := c.entry.rid
+	key := c.heap.pageKey(rid.pageid).(heapHash)
+	var p *Page
+	if e, ok := bp.pool[key]; ok {
+		p = e.Value.(pair).value
+	} else {
+		var err error
+		if p, err = c.heap.readPage(rid.pageid); err != nil {
+			return true
+		}
+	}
+	page := (*p).(*heapPage)
+	var tuples [][]byte
+	for _, v := range bp.versions[rowLockKey{key, rid.slotid}] {
+		tuples = append(tuples, v.tuple)
+	}
+	if b, err := page.slotBytes(rid.slotid); err == nil {
+		tuples = append(tuples, b)
+	}
+	for _, b := range tuples {
+		t, err := page.decodeTuple(b)
+		if err != nil {
+			return true
+		}
+		v := t.Fields[c.index.field]
+		if _, ok := v.(overflowRef); ok {
+			return true
+		}
+		if isNull(v) {
+			continue
+		}
+		if cmp, err := compareDBValues(indexKey(v), c.entry.key); err != nil || cmp == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Forget the cached pages and pending changes of an index that is dropped
+func (bp *BufferPool) dropIndex(idx *Index) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	for key, e := range bp.pool {
+		if key.FileName == idx.file.fileName() {
+			bp.lst.Remove(e)
+			delete(bp.pool, key)
+			delete(bp.pageRecLSN, key)
+		}
+	}
+	keep := func(changes []*indexChange) []*indexChange {
+		kept := changes[:0]
+		for _, c := range changes {
+			if c.index != idx {
+				kept = append(kept, c)
+			}
+		}
+		return kept
+	}
+	for tid, changes := range bp.indexChanges {
+		bp.indexChanges[tid] = keep(changes)
+	}
+	bp.deadIndexEntries = keep(bp.deadIndexEntries)
+}
+
+// Remove the file of an index that is dropped, along with its pages and
+// changes in bp
+func (idx *Index) remove(bp *BufferPool) error {
+	bp.dropIndex(idx)
+	return os.Remove(idx.file.fileName())
+}
+
+// IndexScan is an operator that reads the tuples of a heap table whose values
+// of an indexed column are within a range, in the order of the index
+type IndexScan struct {
+	table *HeapFile
+	index *Index
+	// bounds of the range, where nil is unbounded
+	lo DBValue
+	hi DBValue
+}
+
+// Construct an IndexScan of the tuples of table whose values of the column of
+// index are at least lo and at most hi, where a nil bound is unbounded.  Pass
+// the same value as lo and hi to look up a single key, which is the only range
+// a hash index looks up besides every key.  Returns a TypeMismatchError if a
+// bound cannot be compared with the column.
+func NewIndexScan(table *HeapFile, index *Index, lo DBValue, hi DBValue) (*IndexScan, error) {
+	for _, bound := range []DBValue{lo, hi} {
+		if bound != nil {
+			if err := index.file.checkKey(bound); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if index.Method == HashIndex && (lo != nil || hi != nil) {
+		if lo == nil || hi == nil {
+			return nil, GoDBError{IllegalOperationError, "a hash index only looks up single keys"}
+		}
+		if cmp, err := compareDBValues(lo, hi); err != nil || cmp != 0 {
+			return nil, GoDBError{IllegalOperationError, "a hash index only looks up single keys"}
+		}
+	}
+	return &IndexScan{table, index, lo, hi}, nil
+}
+
+// [Operator] descriptor method: that of the table
+func (s *IndexScan) Descriptor() *TupleDesc {
+	return s.table.Descriptor()
+}
+
+// Return true if v, which is not NULL, is within the range of the scan
+func (s *IndexScan) inRange(v DBValue) bool {
+	if s.lo != nil {
+		if cmp, err := compareDBValues(v, s.lo); err != nil || cmp < 0 {
+			return false
+		}
+	}
+	if s.hi != nil {
+		if cmp, err := compareDBValues(v, s.hi); err != nil || cmp > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// [Operator] iterator method: return a function that iterates through the
+// tuples in range that are visible to tid, read like [HeapFile.Iterator] does,
+// in the order of the index.  Entries whose tuple is not visible to tid, or
+// no longer holds their key, are skipped.
+func (s *IndexScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	s.table.bufPool.beginScan(tid)
+	return s.tuples(tid)
+}
+
+// Return a function that iterates through the tuples of the scan, as of the
+// snapshot tid already reads from
+func (s *IndexScan) tuples(tid TransactionID) (func() (*Tuple, error), error) {
+	bp := s.table.bufPool
+	next, err := s.index.file.scan(s.lo, s.hi)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		for {
+			e, err := next()
+			if e == nil || err != nil {
+				return nil, err
+			}
+			hp, err := bp.getPageForScan(s.table, e.rid.pageid, tid)
+			if err != nil {
+				return nil, err
+			}
+			t, err := bp.visibleTuple(tid, (*hp).(*heapPage), e.rid.slotid)
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				continue
+			}
+			if err := s.table.fetchOverflow(t, tid); err != nil {
+				return nil, err
+			}
+			v := t.Fields[s.index.field]
+			if isNull(v) || !s.inRange(v) {
+				continue
+			}
+			if cmp, err := compareDBValues(indexKey(v), e.key); err != nil || cmp != 0 {
+				continue
+			}
+			return t, nil
+		}
+	}, nil
+}
+
+// IndexJoin is an equality join that looks up the tuples of a heap table
+// matching each tuple of its other input, the outer one, in an index of the
+// table (an index nested loops join), rather than reading the whole table
+type IndexJoin struct {
+	outer      Operator
+	outerField Expr
+	table      *HeapFile
+	index      *Index
+	// whether the fields of the tuples of the table come before those of the
+	// outer tuples in joined tuples
+	tableLeft bool
+}
+
+// Construct an IndexJoin of outer and table on outerField, an expression over
+// the tuples of outer, being equal to the column of index, an index of table.
+// Joined tuples are the tuples of table followed by those of outer if
+// tableLeft, as an [EqualityJoin] of table and outer returns them, or the
+// other way round.  Returns a TypeMismatchError if the expression and the
+// column are of different types.
+func NewIndexJoin(outer Operator, outerField Expr, table *HeapFile, index *Index, tableLeft bool) (*IndexJoin, error) {
+	if outerField.GetExprType().Ftype.kind() != table.Descriptor().Fields[index.field].Ftype.kind() {
+		return nil, GoDBError{TypeMismatchError, "can't join fields of different types"}
+	}
+	return &IndexJoin{outer, outerField, table, index, tableLeft}, nil
+}
+
+// [Operator] descriptor method: the fields of both inputs, in the order of
+// joined tuples
+func (j *IndexJoin) Descriptor() *TupleDesc {
+	if j.tableLeft {
+		return j.table.Descriptor().merge(j.outer.Descriptor())
+	}
+	return j.outer.Descriptor().merge(j.table.Descriptor())
+}
+
+// [Operator] iterator method: return a function that iterates through the
+// outer tuples, each joined with the tuples of the table an [IndexScan] of the
+// value of the join expression returns.  Outer tuples whose value is NULL
+// match no tuple.
+func (j *IndexJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	outer, err := j.outer.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	j.table.bufPool.beginScan(tid)
+	var t *Tuple
+	var matches func() (*Tuple, error)
+	return func() (*Tuple, error) {
+		for {
+			if matches != nil {
+				m, err := matches()
+				if err != nil {
+					return nil, err
+				}
+				if m != nil {
+					if j.tableLeft {
+						return joinTuples(m, t), nil
+					}
+					return joinTuples(t, m), nil
+				}
+				matches = nil
+			}
+			if t, err = outer(); t == nil || err != nil {
+				return nil, err
+			}
+			v, err := j.outerField.EvalExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			if isNull(v) {
+				continue
+			}
+			scan, err := NewIndexScan(j.table, j.index, v, v)
+			if err != nil {
+				return nil, err
+			}
+			if matches, err = scan.tuples(tid); err != nil {
+				return nil, err
+			}
+		}
+	}, nil
+}
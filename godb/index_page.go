@@ -0,0 +1,412 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// The files of indexes, BTreeFile and HashFile, share the layout of their
+// pages and the way they are read, written and used.  Every page begins with
+// a header of indexHeaderSize bytes: a 16 bit magic number and a 16 bit format
+// version, which tell the kind of index the page belongs to, the 16 bit kind
+// of page, the 16 bit number of entries, and a 32 bit link to another page,
+// whose meaning depends on the kind of page.  The header is followed by the
+// fields of the kind of page, if any, and by its entries, serialized like
+// tuples (see [Tuple.writeTo]).
+//
+// Index pages are read and written through the BufferPool, but are not locked
+// on behalf of transactions: they are pinned while they are used, so that they
+// are not evicted, and latched while they are read or updated (see
+// fetchIndexPage).  Their before images are the images they were last read,
+// written or logged with, as for heap pages.
+//
+// A split or merge changes several pages, which must reach the disk together,
+// so updates that restructure a file exclude every other update of it, and
+// then log its dirty pages in one record, before any of them can be written
+// back (see updateIndex).
+
+const indexHeaderSize = 12
+
+// The state common to the pages of index files, which btreePage and hashPage
+// embed
+type indexPage struct {
+	pageNo int
+	// entries of the page, in an order that depends on the kind of page
+	entries []indexEntry
+	// the link of the header (-1 for none)
+	link int
+	// meta page only: first free page, or -1; guarded by the allocLock of the
+	// file rather than by the latch
+	free int
+
+	dirty atomic.Bool
+	// contents of the page as of the last time it was logged, or read from or
+	// written to disk
+	beforeImage []byte
+
+	// held while the page is read or updated
+	latch sync.RWMutex
+	// number of operations using the page
+	pins atomic.Int32
+}
+
+// The pages of index files
+type indexFilePage interface {
+	Page
+	pinnedPage
+	base() *indexPage
+	// Read the contents of the page from a buffer written by toBuffer
+	initFromBuffer(buf *bytes.Buffer) error
+}
+
+func (p *indexPage) base() *indexPage {
+	return p
+}
+
+func (p *indexPage) isDirty() bool {
+	return p.dirty.Load()
+}
+
+func (p *indexPage) setDirty(dirty bool) {
+	p.dirty.Store(dirty)
+}
+
+func (p *indexPage) pin() {
+	p.pins.Add(1)
+}
+
+func (p *indexPage) unpin() {
+	p.pins.Add(-1)
+}
+
+func (p *indexPage) pinned() bool {
+	return p.pins.Load() > 0
+}
+
+// Latch the page for reading (ReadPerm) or updating (WritePerm)
+func (p *indexPage) lock(perm RWPerm) {
+	if perm == WritePerm {
+		p.latch.Lock()
+	} else {
+		p.latch.RLock()
+	}
+}
+
+// Release the latch on a page fetched with perm, and unpin it
+func (p *indexPage) release(perm RWPerm) {
+	if perm == WritePerm {
+		p.latch.Unlock()
+	} else {
+		p.latch.RUnlock()
+	}
+	p.unpin()
+}
+
+// Release the latches on pages fetched with perm, and unpin them
+func releaseAll[P indexFilePage](pages []P, perm RWPerm) {
+	for _, p := range pages {
+		p.base().release(perm)
+	}
+}
+
+// Fetch a page of file through the buffer pool, pinned, and latch it for
+// reading (ReadPerm) or updating (WritePerm)
+func fetchIndexPage[P indexFilePage](bp *BufferPool, file DBFile, pageNo int, perm RWPerm) (P, error) {
+	var page P
+	p, err := bp.fetchPage(file, pageNo)
+	if err != nil {
+		return page, err
+	}
+	page = (*p).(P)
+	page.base().lock(perm)
+	return page, nil
+}
+
+// Apply an update to the index file f.  Most updates change a single page, and
+// run alongside each other with the update lock of f held for reading, until
+// apply(false) returns false because the update may restructure the file, by
+// splitting or merging pages.  It is then run again by apply(true), as a
+// structural change: with the lock held for writing, so that no other update
+// runs, after which the dirty pages of f are logged in one record, which
+// recovery redoes all or none (see [BufferPool.logIndexPages]).  Until then,
+// the pages of f are not written back (see [BufferPool.writeIndexPage]).
+func updateIndex(bp *BufferPool, f indexFile, apply func(restructure bool) (bool, error)) error {
+	f.updates().RLock()
+	done, err := apply(false)
+	f.updates().RUnlock()
+	if err != nil || done {
+		return err
+	}
+	return restructureIndex(bp, f, func() error {
+		_, err := apply(true)
+		return err
+	})
+}
+
+// Apply change, a structural change of the index file f, as updateIndex does
+func restructureIndex(bp *BufferPool, f indexFile, change func() error) error {
+	f.updates().Lock()
+	defer f.updates().Unlock()
+	if err := change(); err != nil {
+		return err
+	}
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	if bp.logFile == nil {
+		return nil
+	}
+	return bp.logIndexPages(f)
+}
+
+// Read page, an empty page of the index file stored in fileName, from its place
+// in the file
+func readIndexPage(fileName string, page indexFilePage) (*Page, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, PageSize)
+	if _, err := file.ReadAt(buf, int64(page.base().pageNo*PageSize)); err != nil {
+		return nil, err
+	}
+	if err := page.initFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		return nil, err
+	}
+	page.base().beforeImage = buf
+	var p Page = page
+	return &p, nil
+}
+
+// Write a page back to its place in the index file stored in fileName
+func flushIndexPage(fileName string, p *Page) error {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	page := (*p).(indexFilePage)
+	buf, err := page.toBuffer()
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(buf.Bytes(), int64(page.base().pageNo*PageSize))
+	return err
+}
+
+// Return the on-disk image of p as of before its unflushed changes, or the
+// image of the free page free returns if it has never been on disk
+func indexBeforeImage(p indexFilePage, free func() indexFilePage) []byte {
+	if p.base().beforeImage == nil {
+		buf, err := free().toBuffer()
+		if err != nil {
+			return nil
+		}
+		return buf.Bytes()
+	}
+	return p.base().beforeImage
+}
+
+// Set the before image of p to its current contents
+func setIndexBeforeImage(p indexFilePage) error {
+	buf, err := p.toBuffer()
+	if err != nil {
+		return err
+	}
+	p.base().beforeImage = buf.Bytes()
+	return nil
+}
+
+// Write the header of the page, of the specified kind, to b, for an index file
+// with the specified magic number and format version
+func (p *indexPage) writeHeader(b *bytes.Buffer, magic uint16, version uint16, kind uint16) error {
+	for _, v := range []any{magic, version, kind, uint16(len(p.entries)), int32(p.link)} {
+		if err := binary.Write(b, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read the header of the page from buf, and return the kind of page and its
+// number of entries.  Returns a MalformedDataError if the page does not belong
+// to an index file with the specified magic number and format version, which
+// is stored in fileName.
+func (p *indexPage) readHeader(buf *bytes.Buffer, fileName string, magic uint16, version uint16) (uint16, int, error) {
+	var m, v, kind, n uint16
+	var link int32
+	for _, f := range []any{&m, &v, &kind, &n, &link} {
+		if err := binary.Read(buf, binary.LittleEndian, f); err != nil {
+			return 0, 0, err
+		}
+	}
+	if m != magic || v != version {
+		return 0, 0, GoDBError{MalformedDataError, fmt.Sprintf("page %d of %s is not a page of this kind of index", p.pageNo, fileName)}
+	}
+	p.link = int(link)
+	return kind, int(n), nil
+}
+
+// Write the entries of the page to b as tuples of desc: a key, the page and
+// slot of a Rid and, if withChild is true, the child page of a separator.
+// Then pad b to PageSize bytes, or return a PageFullError if it is larger.
+func (p *indexPage) writeEntries(b *bytes.Buffer, desc *TupleDesc, withChild bool) error {
+	for _, e := range p.entries {
+		fields := []DBValue{e.key, IntField{int64(e.rid.pageid)}, IntField{int64(e.rid.slotid)}}
+		if withChild {
+			fields = append(fields, IntField{int64(e.child)})
+		}
+		t := Tuple{*desc, fields, nil}
+		if err := t.writeTo(b); err != nil {
+			return err
+		}
+	}
+	if b.Len() > PageSize {
+		return GoDBError{PageFullError, "index page overflows"}
+	}
+	b.Write(make([]byte, PageSize-b.Len()))
+	return nil
+}
+
+// Read the n entries of the page from buf, written by writeEntries
+func (p *indexPage) readEntries(buf *bytes.Buffer, desc *TupleDesc, n int, withChild bool) error {
+	p.entries = make([]indexEntry, 0, n)
+	for i := 0; i < n; i++ {
+		t, err := readTupleFrom(buf, desc)
+		if err != nil {
+			return err
+		}
+		e := indexEntry{key: t.Fields[0], rid: Rid{int(t.Fields[1].(IntField).Value), int(t.Fields[2].(IntField).Value)}}
+		if withChild {
+			e.child = int(t.Fields[3].(IntField).Value)
+		}
+		p.entries = append(p.entries, e)
+	}
+	return nil
+}
+
+// Return the number of pages of the index file stored in fileName
+func indexNumPages(fileName string) int {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0
+	}
+	return int(info.Size() / int64(PageSize))
+}
+
+// Return the largest number of entries with keys of keyType, followed by
+// intFields integers, that fit in space bytes of a page, which is the number of
+// such entries with the longest keys
+func maxIndexEntries(keyType DBType, intFields int, space int) int {
+	size := 1 + intFields*8
+	switch k := keyType.kind(); {
+	case k == StringType || k == BytesType:
+		size += 2 + maxIndexKeyLength
+	case k == IntervalType:
+		size += 16
+	case k == BoolType:
+		size++
+	default:
+		size += 8
+	}
+	return space / size
+}
+
+// Return an error unless v may be compared with the keys of an index of
+// keyType
+func checkIndexKey(keyType DBType, v DBValue) error {
+	_, isInterval := v.(IntervalField)
+	switch k := keyType.kind(); {
+	case isNull(v):
+		return GoDBError{TypeMismatchError, "NULL is not indexed"}
+	case keyType.isNumeric() && isNumber(v):
+	case keyType.isTemporal() && isTemporalValue(v) && (k == IntervalType) == isInterval:
+	case k == StringType && isString(v), k == BytesType && isBytes(v), k == BoolType && isBool(v):
+	default:
+		return GoDBError{TypeMismatchError, fmt.Sprintf("%v cannot be compared with the keys of an index of type %s", v, typeName(keyType))}
+	}
+	return nil
+}
+
+func isString(v DBValue) bool {
+	_, ok := v.(StringField)
+	return ok
+}
+
+func isBytes(v DBValue) bool {
+	_, ok := v.(BytesField)
+	return ok
+}
+
+func isBool(v DBValue) bool {
+	_, ok := v.(BoolField)
+	return ok
+}
+
+// Return the descriptor of the entries of an index of keyType: a key and the
+// page and slot of the Rid of a tuple holding it
+func indexEntryDesc(keyType DBType) *TupleDesc {
+	return &TupleDesc{Fields: []FieldType{
+		{Fname: "key", Ftype: keyType},
+		{Fname: "page", Ftype: IntType},
+		{Fname: "slot", Ftype: IntType},
+	}}
+}
+
+// Return the entry of the tuple t, whose fields are a key and the page and
+// slot of a Rid (see indexEntryDesc)
+func entryOf(t *Tuple) (indexEntry, error) {
+	if len(t.Fields) != 3 {
+		return indexEntry{}, GoDBError{MalformedDataError, "an index entry has a key, a page and a slot"}
+	}
+	page, pok := t.Fields[1].(IntField)
+	slot, sok := t.Fields[2].(IntField)
+	if !pok || !sok {
+		return indexEntry{}, GoDBError{MalformedDataError, "the Rid of an index entry must be integers"}
+	}
+	return indexEntry{key: indexKey(t.Fields[0]), rid: Rid{int(page.Value), int(slot.Value)}}, nil
+}
+
+// Add the entry t, a tuple of the descriptor of f, to the index file f
+func insertIndexTuple(f indexFile, t *Tuple) error {
+	cast, err := castTuple(t, f.Descriptor())
+	if err != nil {
+		return err
+	}
+	e, err := entryOf(cast)
+	if err != nil {
+		return err
+	}
+	if err := f.checkKey(e.key); err != nil {
+		return err
+	}
+	return f.insertEntry(e)
+}
+
+// Remove the entry t, a tuple of the descriptor of f, from the index file f
+func deleteIndexTuple(f indexFile, t *Tuple) error {
+	e, err := entryOf(t)
+	if err != nil {
+		return err
+	}
+	if err := f.checkKey(e.key); err != nil {
+		return err
+	}
+	return f.deleteEntry(e, nil)
+}
+
+// Return a function that iterates through the entries next returns, as tuples
+// of desc
+func entryTuples(desc *TupleDesc, next func() (*indexEntry, error)) func() (*Tuple, error) {
+	return func() (*Tuple, error) {
+		e, err := next()
+		if e == nil || err != nil {
+			return nil, err
+		}
+		return &Tuple{*desc, []DBValue{e.key, IntField{int64(e.rid.pageid)}, IntField{int64(e.rid.slotid)}}, nil}, nil
+	}
+}
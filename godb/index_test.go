@@ -0,0 +1,300 @@
+package godb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A heap file of (name string, age int) tuples with an index on age
+func makeIndexTestVars(t *testing.T) (TupleDesc, *HeapFile, *Index, *BufferPool) {
+	td := TupleDesc{Fields: []FieldType{
+		{Fname: "name", Ftype: StringType},
+		{Fname: "age", Ftype: IntType},
+	}}
+	dir := t.TempDir()
+	bp := NewBufferPool(50)
+	hf, err := NewHeapFile(filepath.Join(dir, "t.dat"), &td, bp)
+	if err != nil {
+		t.Fatalf("failed to create heap file: %s", err)
+	}
+	idx, err := NewIndex("t_age", "age", BTreeIndex, &td, filepath.Join(dir, "t_age.idx"), bp)
+	if err != nil {
+		t.Fatalf("failed to create index: %s", err)
+	}
+	hf.addIndex(idx)
+	return td, hf, idx, bp
+}
+
+// Insert a tuple for each age into hf in a transaction of its own
+func insertAges(t *testing.T, hf *HeapFile, ages ...int64) {
+	tid := NewTID()
+	hf.bufPool.BeginTransaction(tid)
+	for _, age := range ages {
+		tup := Tuple{Desc: *hf.Descriptor(), Fields: []DBValue{StringField{"sam"}, IntField{age}}}
+		if err := hf.insertTuple(&tup, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	if err := hf.bufPool.CommitTransaction(tid); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+}
+
+// Return the ages of the tuples an IndexScan of hf from lo to hi returns to tid
+func scanAges(t *testing.T, hf *HeapFile, idx *Index, lo DBValue, hi DBValue, tid TransactionID) []int64 {
+	scan, err := NewIndexScan(hf, idx, lo, hi)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	iter, err := scan.Iterator(tid)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var ages []int64
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("index scan failed: %s", err)
+		}
+		if tup == nil {
+			return ages
+		}
+		ages = append(ages, tup.Fields[1].(IntField).Value)
+	}
+}
+
+// Delete the tuples of hf visible to tid with the specified age
+func deleteAge(t *testing.T, hf *HeapFile, age int64, tid TransactionID) {
+	iter, _ := hf.Iterator(tid)
+	for tup, _ := iter(); tup != nil; tup, _ = iter() {
+		if tup.Fields[1].(IntField).Value == age {
+			if err := hf.deleteTuple(tup, tid); err != nil {
+				t.Fatalf("delete failed: %s", err)
+			}
+		}
+	}
+}
+
+func numEntries(t *testing.T, idx *Index) int {
+	return len(scanBTree(t, idx.file.(*BTreeFile), nil, nil))
+}
+
+func TestIndexScan(t *testing.T) {
+	_, hf, idx, bp := makeIndexTestVars(t)
+	var ages []int64
+	for i := 0; i < 500; i++ {
+		ages = append(ages, int64((i*37)%100))
+	}
+	insertAges(t, hf, ages...)
+	// NULLs are not indexed
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	tup := Tuple{Desc: *hf.Descriptor(), Fields: []DBValue{StringField{"bob"}, NullField{}}}
+	hf.insertTuple(&tup, tid)
+	bp.CommitTransaction(tid)
+	if n := numEntries(t, idx); n != 500 {
+		t.Errorf("expected 500 index entries, got %d", n)
+	}
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if got := scanAges(t, hf, idx, IntField{7}, IntField{7}, tid); len(got) != 5 {
+		t.Errorf("expected 5 tuples of age 7, got %v", got)
+	}
+	got := scanAges(t, hf, idx, IntField{90}, nil, tid)
+	if len(got) != 50 {
+		t.Errorf("expected 50 tuples of age 90 or more, got %d", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i-1] > got[i] {
+			t.Errorf("expected tuples in the order of the index, got %v", got)
+			break
+		}
+	}
+	if _, err := NewIndexScan(hf, idx, StringField{"seven"}, nil); err == nil {
+		t.Errorf("expected a string not to be looked up in an index of integers")
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestIndexDeleteAndVacuum(t *testing.T) {
+	_, hf, idx, bp := makeIndexTestVars(t)
+	insertAges(t, hf, 1, 2, 2, 3)
+
+	old := NewTID()
+	bp.BeginTransaction(old)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	deleteAge(t, hf, 2, tid)
+	bp.CommitTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if got := scanAges(t, hf, idx, nil, nil, tid); len(got) != 2 {
+		t.Errorf("expected deleted tuples not to be scanned, got %v", got)
+	}
+	bp.CommitTransaction(tid)
+	// the entries of the deleted tuples are kept while a snapshot sees them
+	if got := scanAges(t, hf, idx, nil, nil, old); len(got) != 4 {
+		t.Errorf("expected a snapshot from before the delete to scan 4 tuples, got %v", got)
+	}
+	bp.Vacuum()
+	if n := numEntries(t, idx); n != 4 {
+		t.Errorf("expected the entries of tuples visible to a snapshot to be kept, got %d", n)
+	}
+	bp.CommitTransaction(old)
+	bp.Vacuum()
+	if n := numEntries(t, idx); n != 2 {
+		t.Errorf("expected the entries of deleted tuples to be removed, got %d entries", n)
+	}
+}
+
+func TestIndexAbortAndSavepoint(t *testing.T) {
+	_, hf, idx, bp := makeIndexTestVars(t)
+	insertAges(t, hf, 1)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for _, age := range []int64{2, 3} {
+		tup := Tuple{Desc: *hf.Descriptor(), Fields: []DBValue{StringField{"joe"}, IntField{age}}}
+		hf.insertTuple(&tup, tid)
+		if age == 2 {
+			bp.Savepoint(tid, "sp")
+		}
+	}
+	deleteAge(t, hf, 1, tid)
+	if err := bp.RollbackToSavepoint(tid, "sp"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got := scanAges(t, hf, idx, nil, nil, tid); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("expected ages 1 and 2 after rolling back to the savepoint, got %v", got)
+	}
+	bp.CommitTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	tup := Tuple{Desc: *hf.Descriptor(), Fields: []DBValue{StringField{"bob"}, IntField{4}}}
+	hf.insertTuple(&tup, tid)
+	bp.AbortTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if got := scanAges(t, hf, idx, nil, nil, tid); len(got) != 2 {
+		t.Errorf("expected rolled back tuples not to be scanned, got %v", got)
+	}
+	bp.CommitTransaction(tid)
+	bp.Vacuum()
+	if n := numEntries(t, idx); n != 2 {
+		t.Errorf("expected the entries of rolled back tuples to be removed, got %d entries", n)
+	}
+}
+
+func TestIndexSlotReused(t *testing.T) {
+	_, hf, idx, bp := makeIndexTestVars(t)
+	insertAges(t, hf, 5)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	deleteAge(t, hf, 5, tid)
+	bp.CommitTransaction(tid)
+	// the new tuple takes the slot of the deleted one, with the same key
+	insertAges(t, hf, 5)
+	bp.Vacuum()
+
+	if n := numEntries(t, idx); n != 1 {
+		t.Errorf("expected the entry of the new tuple to be kept, got %d entries", n)
+	}
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if got := scanAges(t, hf, idx, IntField{5}, IntField{5}, tid); len(got) != 1 {
+		t.Errorf("expected the new tuple to be found, got %v", got)
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestCreateIndexStatement(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte("f (name string, age int)\n"), 0644)
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(20), dir)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %s", err)
+	}
+	defer c.bp.logFile.Close()
+	runTestQuery(t, c, "insert into f values ('sam', 25), ('joe', 30), ('bob', 30), ('ann', 41)")
+
+	if qtype, _, err := Parse(c, "create index f_age on f (age)"); err != nil || qtype != CreateIndexQueryType {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if _, _, err := Parse(c, "create index f_age on f (name)"); err == nil {
+		t.Errorf("expected an index name to be used only once")
+	}
+	if _, _, err := Parse(c, "create index g_age on g (age)"); err == nil {
+		t.Errorf("expected an index on a table that does not exist to fail")
+	}
+	runTestQuery(t, c, "insert into f values ('tim', 30)")
+
+	_, plan, err := Parse(c, "select name from f where age = 30")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !planUses[*IndexScan](plan) {
+		t.Errorf("expected the plan to scan the index")
+	}
+	if got := runTestQuery(t, c, "select name from f where age = 30"); len(got) != 3 {
+		t.Errorf("expected 3 tuples of age 30, got %d", len(got))
+	}
+	if got := runTestQuery(t, c, "select name from f where age > 30"); len(got) != 1 || got[0].Fields[0].(StringField).Value != "ann" {
+		t.Errorf("expected ann to be older than 30, got %v", got)
+	}
+
+	if err := c.SaveToFile("catalog.txt", dir); err != nil {
+		t.Fatalf("%s", err)
+	}
+	saved, _ := os.ReadFile(filepath.Join(dir, "catalog.txt"))
+	if !strings.Contains(string(saved), "index f_age on f (age)") {
+		t.Errorf("expected the index to be saved in the catalog, got %s", saved)
+	}
+	c.bp.logFile.Close()
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPool(20), dir)
+	if err != nil {
+		t.Fatalf("failed to reload catalog: %s", err)
+	}
+	if got := runTestQuery(t, c, "select name from f where age >= 30"); len(got) != 4 {
+		t.Errorf("expected 4 tuples of age 30 or more after reloading, got %d", len(got))
+	}
+
+	if qtype, _, err := Parse(c, "drop index f_age on f"); err != nil || qtype != DropIndexQueryType {
+		t.Fatalf("failed to drop index: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "f_age.idx")); !os.IsNotExist(err) {
+		t.Errorf("expected the file of the index to be removed")
+	}
+	if strings.Contains(c.CatalogString(), "f_age") {
+		t.Errorf("expected the index to be dropped from the catalog")
+	}
+	_, plan, _ = Parse(c, "select name from f where age = 30")
+	if planUses[*IndexScan](plan) {
+		t.Errorf("expected a dropped index not to be scanned")
+	}
+	if _, _, err := Parse(c, "drop index f_age"); err == nil {
+		t.Errorf("expected dropping an index twice to fail")
+	}
+}
+
+// Return true if plan has an operator of type T
+func planUses[T Operator](plan Operator) bool {
+	switch op := plan.(type) {
+	case T:
+		return true
+	case *Project:
+		return planUses[T](op.child)
+	case *OrderBy:
+		return planUses[T](op.child)
+	case *Filter[int64]:
+		return planUses[T](op.child)
+	case *Filter[string]:
+		return planUses[T](op.child)
+	}
+	return false
+}
@@ -30,14 +30,22 @@ func (i *InsertOp) Descriptor() *TupleDesc {
 // method.
 func (iop *InsertOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
-	iter, _ := iop.child.Iterator(tid)
+	iter, err := iop.child.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
 	count := 0
 	for {
-		t, _ := iter()
+		t, err := iter()
+		if err != nil {
+			return nil, err
+		}
 		if t == nil {
 			break
 		}
-		iop.InsertFile.insertTuple(t, tid)
+		if err := iop.InsertFile.insertTuple(t, tid); err != nil {
+			return nil, err
+		}
 		count++
 	}
 	return func() (*Tuple, error) {
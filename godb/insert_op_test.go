@@ -1,19 +1,18 @@
 package godb
 
 import (
-	"os"
+	"path/filepath"
 	"testing"
 )
 
 const InsertTestFile string = "InsertTestFile.dat"
 
 func TestInsert(t *testing.T) {
-	td, t1, _, hf, bp, tid := makeTestVars()
+	td, t1, _, hf, bp, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t1, tid)
 	bp.CommitTransaction(tid)
-	os.Remove(InsertTestFile)
-	hf2, _ := NewHeapFile(InsertTestFile, &td, bp)
+	hf2, _ := NewHeapFile(filepath.Join(t.TempDir(), InsertTestFile), &td, bp)
 	if hf2 == nil {
 		t.Fatalf("hf was nil")
 	}
@@ -0,0 +1,100 @@
+package godb
+
+import "strings"
+
+// The isolation level of a transaction, chosen when it begins (see
+// [WithIsolationLevel]).  The levels differ in what scans of heap tables see,
+// and in how long read locks are held:
+//
+//   - ReadUncommitted scans see the newest version of every row, including
+//     changes of transactions that have not committed yet.
+//   - ReadCommitted scans read from a snapshot taken when the scan starts, so
+//     they only see committed changes, but two scans of the same table may
+//     disagree.
+//   - RepeatableRead scans read from the snapshot taken when the transaction
+//     began (see mvcc.go), and a transaction that deletes a row changed by a
+//     concurrent transaction that committed first is aborted.
+//   - Serializable scans lock the whole table in shared mode until the
+//     transaction ends (strict two-phase locking), and see the newest
+//     committed version of every row.
+//
+// Pages read through [BufferPool.GetPage] with ReadPerm are only locked until
+// the lock is granted under ReadUncommitted and ReadCommitted, and until the
+// transaction ends under the other levels.
+type IsolationLevel int
+
+const (
+	ReadUncommitted IsolationLevel = iota
+	ReadCommitted   IsolationLevel = iota
+	RepeatableRead  IsolationLevel = iota
+	Serializable    IsolationLevel = iota
+)
+
+// The isolation level of transactions that do not ask for one
+const DefaultIsolationLevel = RepeatableRead
+
+var isolationLevelNames = map[IsolationLevel]string{
+	ReadUncommitted: "READ UNCOMMITTED",
+	ReadCommitted:   "READ COMMITTED",
+	RepeatableRead:  "REPEATABLE READ",
+	Serializable:    "SERIALIZABLE",
+}
+
+func (level IsolationLevel) String() string {
+	return isolationLevelNames[level]
+}
+
+// Return the isolation level named by words (e.g. ["read", "committed"]),
+// which are lower case
+func isolationLevelNamed(words []string) (IsolationLevel, bool) {
+	name := strings.ToUpper(strings.Join(words, " "))
+	for level, n := range isolationLevelNames {
+		if n == name {
+			return level, true
+		}
+	}
+	return DefaultIsolationLevel, false
+}
+
+// Scans see the newest version of rows, rather than those in a snapshot
+func (level IsolationLevel) readsNewest() bool {
+	return level == ReadUncommitted || level == Serializable
+}
+
+// Read locks on pages are released as soon as they are granted
+func (level IsolationLevel) shortReadLocks() bool {
+	return level == ReadUncommitted || level == ReadCommitted
+}
+
+// Options of a transaction, passed to [BufferPool.BeginTransaction]
+type TransactionOption func(*transactionOptions)
+
+type transactionOptions struct {
+	level IsolationLevel
+}
+
+// Run the transaction at the given isolation level, instead of
+// DefaultIsolationLevel
+func WithIsolationLevel(level IsolationLevel) TransactionOption {
+	return func(opts *transactionOptions) {
+		opts.level = level
+	}
+}
+
+// Return the isolation level of tid.  The caller must hold poolLock.
+func (bp *BufferPool) isolationLevel(tid TransactionID) IsolationLevel {
+	if level, ok := bp.isolation[tid]; ok {
+		return level
+	}
+	return DefaultIsolationLevel
+}
+
+// Prepare for a scan by tid of a heap table: under ReadCommitted, the scan
+// reads from a new snapshot
+func (bp *BufferPool) beginScan(tid TransactionID) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	if _, ok := bp.aliveTransactions[tid]; ok && bp.isolationLevel(tid) == ReadCommitted {
+		bp.takeSnapshot(tid)
+	}
+}
@@ -0,0 +1,121 @@
+package godb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadUncommittedSeesUncommittedRows(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	writer, dirty, snapshot := NewTID(), NewTID(), NewTID()
+	bp.BeginTransaction(writer)
+	bp.BeginTransaction(dirty, WithIsolationLevel(ReadUncommitted))
+	bp.BeginTransaction(snapshot, WithIsolationLevel(RepeatableRead))
+	finishesSoon(t, insertN(hf, t1, writer, 5))
+	if cnt := countVisible(t, hf, dirty); cnt != 15 {
+		t.Errorf("expected READ UNCOMMITTED to see 15 tuples, got %d", cnt)
+	}
+	if cnt := countVisible(t, hf, snapshot); cnt != 10 {
+		t.Errorf("expected REPEATABLE READ to see 10 tuples, got %d", cnt)
+	}
+}
+
+func TestReadCommittedSeesCommitsBetweenScans(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	committed, repeatable := NewTID(), NewTID()
+	bp.BeginTransaction(committed, WithIsolationLevel(ReadCommitted))
+	bp.BeginTransaction(repeatable)
+	if cnt := countVisible(t, hf, committed); cnt != 10 {
+		t.Fatalf("expected 10 tuples, got %d", cnt)
+	}
+	insertAndCommit(t, hf, bp, t1, 5)
+	if cnt := countVisible(t, hf, committed); cnt != 15 {
+		t.Errorf("expected READ COMMITTED to see the new commit, got %d tuples", cnt)
+	}
+	if cnt := countVisible(t, hf, repeatable); cnt != 10 {
+		t.Errorf("expected REPEATABLE READ not to see the new commit, got %d tuples", cnt)
+	}
+}
+
+func TestSerializableScanBlocksWriters(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	reader, writer := NewTID(), NewTID()
+	bp.BeginTransaction(reader, WithIsolationLevel(Serializable))
+	bp.BeginTransaction(writer)
+	if cnt := countVisible(t, hf, reader); cnt != 10 {
+		t.Fatalf("expected 10 tuples, got %d", cnt)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- insertN(hf, t1, writer, 1)()
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected insert to wait for the serializable reader, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := bp.CommitTransaction(reader); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected commit to release the table lock")
+	}
+}
+
+func TestReadCommittedReleasesReadLocks(t *testing.T) {
+	bp, hf, tid1, tid2 := lockingTestSetUp(t)
+	bp.AbortTransaction(tid1)
+	bp.AbortTransaction(tid2)
+	reader, writer := NewTID(), NewTID()
+	bp.BeginTransaction(reader, WithIsolationLevel(ReadCommitted))
+	bp.BeginTransaction(writer)
+	if _, err := bp.GetPage(hf, 0, reader, ReadPerm); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, ok := bp.lockManager.Holds(reader, hf.pageKey(0)); ok {
+		t.Errorf("expected READ COMMITTED read lock to be released")
+	}
+	finishesSoon(t, func() error {
+		_, err := bp.GetPage(hf, 0, writer, WritePerm)
+		return err
+	})
+}
+
+func TestParseIsolationLevel(t *testing.T) {
+	cases := []struct {
+		query string
+		qtype QueryType
+		level IsolationLevel
+	}{
+		{"begin isolation level read uncommitted", BeginXactionType, ReadUncommitted},
+		{"BEGIN TRANSACTION ISOLATION LEVEL SERIALIZABLE;", BeginXactionType, Serializable},
+		{"set transaction isolation level read committed", SetIsolationType, ReadCommitted},
+		{"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ", SetIsolationType, RepeatableRead},
+	}
+	for _, c := range cases {
+		qtype, _, err := Parse(nil, c.query)
+		if err != nil || qtype != c.qtype {
+			t.Errorf("%s: expected query type %d, got %d (%v)", c.query, c.qtype, qtype, err)
+		}
+		if level, ok := ParseIsolationLevel(c.query); !ok || level != c.level {
+			t.Errorf("%s: expected %s, got %s", c.query, c.level, level)
+		}
+	}
+	if _, _, err := Parse(nil, "set transaction isolation level snapshot"); err == nil {
+		t.Errorf("expected unknown isolation level to fail to parse")
+	}
+	if _, ok := ParseIsolationLevel("begin"); ok {
+		t.Errorf("expected plain begin not to name an isolation level")
+	}
+}
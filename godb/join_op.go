@@ -13,7 +13,8 @@ type EqualityJoin[T comparable] struct {
 	getter func(DBValue) T
 
 	// The maximum number of records of intermediate state that the join should use
-	// (only required for optional exercise)
+	// (only required for optional exercise); inputs that do not fit are
+	// partitioned into spill files (see spill.go)
 	maxBufferSize int
 }
 
@@ -70,29 +71,342 @@ func (hj *EqualityJoin[T]) Descriptor() *TupleDesc {
 // maxBufferSize records, and should pass the testBigJoin test without timing
 // out.  To pass this test, you will need to use something other than a nested
 // loops join.
+//
+// The join is a hash join: the tuples of one input, the build side, are held
+// in a hash table by the values of their join expression, which the tuples of
+// the other input, the probe side, look up.  The inputs are read in turn until
+// one of them ends, which makes it the build side, as the smaller input.  If
+// maxBufferSize tuples are read before either ends, neither fits, and the join
+// is a Grace hash join instead: both inputs are partitioned by the hash of the
+// values of their join expressions into spill files, so that each partition of
+// one input only joins with the same partition of the other, and the pairs of
+// partitions are joined one at a time, building on the smaller of the two (see
+// joinPartitions).  Tuples whose join value is NULL join with nothing.  The
+// spill files are removed once the join ends or fails, or, if it is not read to
+// its end, once tid commits or aborts.
 func (joinOp *EqualityJoin[T]) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
-	iter1, _ := (*joinOp.left).Iterator(tid)
-	iter2, _ := (*joinOp.right).Iterator(tid)
-	t1, _ := iter1()
+	leftIter, err := (*joinOp.left).Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	rightIter, err := (*joinOp.right).Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var leftBuf, rightBuf []*Tuple
+	leftDone, rightDone := false, false
+	for !leftDone && !rightDone && len(leftBuf)+len(rightBuf) < joinBufferSize(joinOp.maxBufferSize) {
+		if leftBuf, leftDone, err = readInto(leftBuf, leftIter); err != nil {
+			return nil, err
+		}
+		if leftDone {
+			break
+		}
+		if rightBuf, rightDone, err = readInto(rightBuf, rightIter); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case leftDone:
+		return joinOp.hashJoin(leftBuf, true, concatIterators(rightBuf, rightIter))
+	case rightDone:
+		return joinOp.hashJoin(rightBuf, false, concatIterators(leftBuf, leftIter))
+	}
+
+	left, err := joinOp.partition(concatIterators(leftBuf, leftIter), true, 0, tid)
+	if err != nil {
+		return nil, err
+	}
+	right, err := joinOp.partition(concatIterators(rightBuf, rightIter), false, 0, tid)
+	if err != nil {
+		removeSpillFiles(left)
+		return nil, err
+	}
+	var pending []joinPartition
+	for i := range left {
+		pending = append(pending, joinPartition{left[i], right[i], 1})
+	}
+	return joinOp.joinPartitions(pending, tid), nil
+}
+
+// Return the number of tuples a join with the specified maxBufferSize may hold
+// in memory, at least one of each input
+func joinBufferSize(maxBufferSize int) int {
+	if maxBufferSize < 2 {
+		return 2
+	}
+	return maxBufferSize
+}
+
+// Append the next tuple iter returns to buf, and return whether iter has ended
+// instead
+func readInto(buf []*Tuple, iter func() (*Tuple, error)) ([]*Tuple, bool, error) {
+	t, err := iter()
+	if err != nil || t == nil {
+		return buf, err == nil, err
+	}
+	return append(buf, t), false, nil
+}
+
+// Return a function that iterates through the tuples of buf, then those iter
+// returns
+func concatIterators(buf []*Tuple, iter func() (*Tuple, error)) func() (*Tuple, error) {
+	return func() (*Tuple, error) {
+		if len(buf) > 0 {
+			t := buf[0]
+			buf = buf[1:]
+			return t, nil
+		}
+		return iter()
+	}
+}
+
+// Return the expressions that return the join values of the tuples of the
+// build side of the join, and of the probe side, where buildLeft tells
+// whether the build side is the left input
+func (joinOp *EqualityJoin[T]) fields(buildLeft bool) (Expr, Expr) {
+	if buildLeft {
+		return joinOp.leftField, joinOp.rightField
+	}
+	return joinOp.rightField, joinOp.leftField
+}
+
+// Return a hash table of tuples, tuples of the build side, by their join value
+func (joinOp *EqualityJoin[T]) buildTable(tuples []*Tuple, buildLeft bool) (map[T][]*Tuple, error) {
+	field, _ := joinOp.fields(buildLeft)
+	table := make(map[T][]*Tuple)
+	for _, t := range tuples {
+		v, err := field.EvalExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		if isNull(v) {
+			continue
+		}
+		key := joinOp.getter(v)
+		table[key] = append(table[key], t)
+	}
+	return table, nil
+}
+
+// Return a function that iterates through the tuples of build, the tuples of
+// the build side, joined with the tuples probe returns that have the same join
+// value
+func (joinOp *EqualityJoin[T]) hashJoin(build []*Tuple, buildLeft bool, probe func() (*Tuple, error)) (func() (*Tuple, error), error) {
+	table, err := joinOp.buildTable(build, buildLeft)
+	if err != nil {
+		return nil, err
+	}
+	return joinOp.probeTable(table, buildLeft, probe), nil
+}
+
+// Return a function that iterates through the tuples probe returns joined with
+// those of table, a hash table of the build side, that have the same join value
+func (joinOp *EqualityJoin[T]) probeTable(table map[T][]*Tuple, buildLeft bool, probe func() (*Tuple, error)) func() (*Tuple, error) {
+	_, field := joinOp.fields(buildLeft)
+	var t *Tuple
+	var matches []*Tuple
+	return func() (*Tuple, error) {
+		for len(matches) == 0 {
+			var err error
+			if t, err = probe(); t == nil || err != nil {
+				return nil, err
+			}
+			v, err := field.EvalExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			if !isNull(v) {
+				matches = table[joinOp.getter(v)]
+			}
+		}
+		m := matches[0]
+		matches = matches[1:]
+		if buildLeft {
+			return joinTuples(m, t), nil
+		}
+		return joinTuples(t, m), nil
+	}
+}
+
+const (
+	// the number of partitions each input of a Grace hash join is split into
+	joinFanout = 16
+	// partitions are split again by different bits of the hash of join
+	// values, up to this many times
+	maxJoinLevels = 64 / 4
+)
+
+// The partitions of the left and right inputs of a join whose tuples are
+// joined with each other, at the level of partitioning they were split at
+type joinPartition struct {
+	left, right *spillFile
+	level       int
+}
+
+// Split the tuples iter returns, tuples of the left input if left is true and
+// of the right one otherwise, among joinFanout spill files by the hash of their
+// join value at the specified level of partitioning, on behalf of tid.  Tuples
+// whose join value is NULL are left out.
+func (joinOp *EqualityJoin[T]) partition(iter func() (*Tuple, error), left bool, level int, tid TransactionID) ([]*spillFile, error) {
+	field, desc := joinOp.leftField, (*joinOp.left).Descriptor()
+	if !left {
+		field, desc = joinOp.rightField, (*joinOp.right).Descriptor()
+	}
+	files := make([]*spillFile, joinFanout)
+	for {
+		t, err := iter()
+		if err != nil {
+			removeSpillFiles(files)
+			return nil, err
+		}
+		if t == nil {
+			break
+		}
+		v, err := field.EvalExpr(t)
+		if err != nil {
+			removeSpillFiles(files)
+			return nil, err
+		}
+		if isNull(v) {
+			continue
+		}
+		i := (hashKey(v) >> (4 * level)) % joinFanout
+		if files[i] == nil {
+			if files[i], err = newSpillFile(desc, tid); err != nil {
+				removeSpillFiles(files)
+				return nil, err
+			}
+		}
+		if err := files[i].append(t); err != nil {
+			removeSpillFiles(files)
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Return a function that iterates through the joined tuples of each of the
+// pending pairs of partitions in turn, removing their files once they are
+// joined.  The smaller partition of a pair is the build side of its join.  A
+// build side with more than maxBufferSize tuples is split again, on behalf of
+// tid, at the next level of partitioning, or, once every level is used, as
+// when its tuples all have the same join value, joined maxBufferSize tuples at
+// a time, reading the probe side once for each.
+func (joinOp *EqualityJoin[T]) joinPartitions(pending []joinPartition, tid TransactionID) func() (*Tuple, error) {
+	var current *joinPartition
+	var next func() (*Tuple, error)
+	done := func() {
+		if current != nil {
+			removeSpillFiles([]*spillFile{current.left, current.right})
+			current = nil
+		}
+		for _, p := range pending {
+			removeSpillFiles([]*spillFile{p.left, p.right})
+		}
+		pending = nil
+	}
+	return func() (*Tuple, error) {
+		for {
+			if next != nil {
+				t, err := next()
+				if err != nil {
+					done()
+					return nil, err
+				}
+				if t != nil {
+					return t, nil
+				}
+				removeSpillFiles([]*spillFile{current.left, current.right})
+				current, next = nil, nil
+			}
+			if len(pending) == 0 {
+				return nil, nil
+			}
+			p := pending[0]
+			pending = pending[1:]
+			if p.left == nil || p.right == nil {
+				// no tuple of the other input has a join value of this partition
+				removeSpillFiles([]*spillFile{p.left, p.right})
+				continue
+			}
+			build, probe, buildLeft := p.left, p.right, true
+			if p.right.count < p.left.count {
+				build, probe, buildLeft = p.right, p.left, false
+			}
+			if build.count > joinBufferSize(joinOp.maxBufferSize) && p.level < maxJoinLevels {
+				split, err := joinOp.split(p, tid)
+				removeSpillFiles([]*spillFile{p.left, p.right})
+				if err != nil {
+					done()
+					return nil, err
+				}
+				pending = append(split, pending...)
+				continue
+			}
+			current = &p
+			next = joinOp.blockJoin(build, probe, buildLeft)
+		}
+	}
+}
+
+// Split both partitions of p at the next level of partitioning, on behalf of tid
+func (joinOp *EqualityJoin[T]) split(p joinPartition, tid TransactionID) ([]joinPartition, error) {
+	var files [2][]*spillFile
+	for side, s := range []*spillFile{p.left, p.right} {
+		iter, err := s.iterator()
+		if err == nil {
+			files[side], err = joinOp.partition(iter, side == 0, p.level, tid)
+		}
+		if err != nil {
+			removeSpillFiles(files[0])
+			return nil, err
+		}
+	}
+	split := make([]joinPartition, joinFanout)
+	for i := range split {
+		split[i] = joinPartition{files[0][i], files[1][i], p.level + 1}
+	}
+	return split, nil
+}
+
+// Return a function that iterates through the tuples of the spill file build
+// joined with those of probe, holding at most maxBufferSize tuples of build in
+// a hash table at a time, and reading probe once for each
+func (joinOp *EqualityJoin[T]) blockJoin(build *spillFile, probe *spillFile, buildLeft bool) func() (*Tuple, error) {
+	var buildIter, next func() (*Tuple, error)
 	return func() (*Tuple, error) {
-		selectt1, _ := joinOp.leftField.EvalExpr(t1)
 		for {
-			t2, _ := iter2()
-			if t2 == nil {
-				t1, _ = iter1()
-				if t1 == nil {
-					return nil, nil
-				} else {
-					selectt1, _ = joinOp.leftField.EvalExpr(t1)
-					iter2, _ = (*joinOp.right).Iterator(tid)
-					t2, _ = iter2()
+			if next != nil {
+				t, err := next()
+				if t != nil || err != nil {
+					return t, err
 				}
 			}
-			selectt2, _ := joinOp.rightField.EvalExpr(t2)
-			if selectt1 == selectt2 {
-				return joinTuples(t1, t2), nil
+			var err error
+			if buildIter == nil {
+				if buildIter, err = build.iterator(); err != nil {
+					return nil, err
+				}
 			}
+			var block []*Tuple
+			for done := false; !done && len(block) < joinBufferSize(joinOp.maxBufferSize); {
+				if block, done, err = readInto(block, buildIter); err != nil {
+					return nil, err
+				}
+			}
+			if len(block) == 0 {
+				return nil, nil
+			}
+			table, err := joinOp.buildTable(block, buildLeft)
+			if err != nil {
+				return nil, err
+			}
+			probeIter, err := probe.iterator()
+			if err != nil {
+				return nil, err
+			}
+			next = joinOp.probeTable(table, buildLeft, probeIter)
 		}
-		return nil, nil
-	}, nil
+	}
 }
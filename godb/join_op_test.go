@@ -2,6 +2,8 @@ package godb
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -9,13 +11,12 @@ import (
 const JoinTestFile string = "JoinTestFile.dat"
 
 func TestJoin(t *testing.T) {
-	td, t1, t2, hf, bp, tid := makeTestVars()
+	td, t1, t2, hf, bp, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	hf.insertTuple(&t2, tid)
 
-	os.Remove(JoinTestFile)
-	hf2, _ := NewHeapFile(JoinTestFile, &td, bp)
+	hf2, _ := NewHeapFile(filepath.Join(t.TempDir(), JoinTestFile), &td, bp)
 	hf2.insertTuple(&t1, tid)
 	hf2.insertTuple(&t2, tid)
 	hf2.insertTuple(&t2, tid)
@@ -77,6 +78,7 @@ const BigJoinFile2 string = "jointest2.dat"
 func TestBigJoinOptional(t *testing.T) {
 
 	timeout := time.After(20 * time.Second)
+	dir := t.TempDir()
 
 	done := make(chan bool)
 
@@ -85,15 +87,13 @@ func TestBigJoinOptional(t *testing.T) {
 		ntups := 3141
 		bp := NewBufferPool(100)
 		td := TupleDesc{[]FieldType{{"name", "", IntType}}}
-		os.Remove(BigJoinFile1)
-		os.Remove(BigJoinFile2)
-		hf1, err := NewHeapFile(BigJoinFile1, &td, bp)
+		hf1, err := NewHeapFile(filepath.Join(dir, BigJoinFile1), &td, bp)
 		if err != nil {
 			t.Errorf("unexpected error heap file")
 			done <- true
 			return
 		}
-		hf2, err := NewHeapFile(BigJoinFile2, &td, bp)
+		hf2, err := NewHeapFile(filepath.Join(dir, BigJoinFile2), &td, bp)
 		if err != nil {
 			t.Errorf("unexpected error heap file")
 			done <- true
@@ -103,7 +103,7 @@ func TestBigJoinOptional(t *testing.T) {
 
 		for i := 0; i < ntups; i++ {
 			if i%5000 == 0 {
-				if tid != nil {
+				if tid != 0 {
 					// hack to force dirty pages to disk
 					// because CommitTransaction may not be implemented
 					// yet if this is called in lab 1 or 2
@@ -197,3 +197,249 @@ func TestBigJoinOptional(t *testing.T) {
 	}
 
 }
+
+// Operator over a list of tuples of (key int, side string), which fails
+// with err once it has returned failAt tuples, if err is not nil
+type tupleListOp struct {
+	desc   TupleDesc
+	tuples []*Tuple
+	failAt int
+	err    error
+}
+
+// Return a tupleListOp of a tuple for each key, where a negative key is NULL
+func newTupleListOp(side string, keys ...int64) *tupleListOp {
+	desc := TupleDesc{Fields: []FieldType{{Fname: "key", TableQualifier: side, Ftype: IntType}, {Fname: "side", TableQualifier: side, Ftype: StringType}}}
+	op := &tupleListOp{desc: desc}
+	for _, k := range keys {
+		var v DBValue = IntField{k}
+		if k < 0 {
+			v = NullField{}
+		}
+		op.tuples = append(op.tuples, &Tuple{desc, []DBValue{v, StringField{side}}, nil})
+	}
+	return op
+}
+
+func (op *tupleListOp) Descriptor() *TupleDesc {
+	return &op.desc
+}
+
+func (op *tupleListOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	i := 0
+	return func() (*Tuple, error) {
+		if op.err != nil && i == op.failAt {
+			return nil, op.err
+		}
+		if i >= len(op.tuples) {
+			return nil, nil
+		}
+		i++
+		return op.tuples[i-1], nil
+	}, nil
+}
+
+// Join left and right on their keys, holding at most maxBufferSize tuples in
+// memory, and return the number of joined tuples of each key.  Checks that the
+// fields of the left input come first.
+func runListJoin(t *testing.T, left *tupleListOp, right *tupleListOp, maxBufferSize int) (map[int64]int, error) {
+	join, err := NewIntJoin(left, &FieldExpr{left.desc.Fields[0]}, right, &FieldExpr{right.desc.Fields[0]}, maxBufferSize)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	iter, err := join.Iterator(NewTID())
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int64]int)
+	for {
+		tup, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if tup == nil {
+			return counts, nil
+		}
+		if tup.Fields[1].(StringField).Value != "l" || tup.Fields[3].(StringField).Value != "r" {
+			t.Fatalf("expected the left tuple to come first, got %v", tup.Fields)
+		}
+		if tup.Fields[0] != tup.Fields[2] {
+			t.Fatalf("joined tuples of different keys %v", tup.Fields)
+		}
+		counts[tup.Fields[0].(IntField).Value]++
+	}
+}
+
+func TestJoinSpillsPartitions(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	var leftKeys, rightKeys []int64
+	for i := int64(0); i < 600; i++ {
+		leftKeys = append(leftKeys, i%50)
+		rightKeys = append(rightKeys, i%100)
+	}
+	// NULL joins with nothing, not even NULL
+	leftKeys = append(leftKeys, -1)
+	rightKeys = append(rightKeys, -1)
+	left, right := newTupleListOp("l", leftKeys...), newTupleListOp("r", rightKeys...)
+
+	for _, size := range []int{20, 100000} {
+		counts, err := runListJoin(t, left, right, size)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(counts) != 50 {
+			t.Errorf("expected 50 keys to join with a buffer of %d tuples, got %d", size, len(counts))
+		}
+		for k, n := range counts {
+			if n != 12*6 {
+				t.Errorf("expected %d tuples of key %d with a buffer of %d tuples, got %d", 12*6, k, size, n)
+			}
+		}
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+
+	// partitions of tuples that all have the same key cannot be split, and
+	// are joined a block at a time
+	same := make([]int64, 300)
+	counts, err := runListJoin(t, newTupleListOp("l", same...), newTupleListOp("r", same[:200]...), 25)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if counts[0] != 300*200 {
+		t.Errorf("expected %d joined tuples, got %d", 300*200, counts[0])
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+}
+
+func TestJoinBuildsOnSmallerInput(t *testing.T) {
+	var keys []int64
+	for i := int64(0); i < 1000; i++ {
+		keys = append(keys, i)
+	}
+	// the smaller input fits in the buffer, while the larger one does not
+	for _, sides := range [][2][]int64{{keys, {1, 2, 3}}, {{1, 2, 3}, keys}} {
+		counts, err := runListJoin(t, newTupleListOp("l", sides[0]...), newTupleListOp("r", sides[1]...), 10)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(counts) != 3 || counts[1] != 1 || counts[2] != 1 || counts[3] != 1 {
+			t.Errorf("expected keys 1, 2 and 3 to join once, got %v", counts)
+		}
+	}
+}
+
+func TestJoinChildErrors(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	var keys []int64
+	for i := int64(0); i < 100; i++ {
+		keys = append(keys, i)
+	}
+	for _, failAt := range []int{0, 5, 60} {
+		for _, size := range []int{10, 1000} {
+			left, right := newTupleListOp("l", keys...), newTupleListOp("r", keys...)
+			right.err, right.failAt = GoDBError{MalformedDataError, "bad page"}, failAt
+			if _, err := runListJoin(t, left, right, size); err == nil {
+				t.Errorf("expected an error of the right input after %d tuples to be returned with a buffer of %d tuples", failAt, size)
+			}
+			left.err, left.failAt, right.err = GoDBError{MalformedDataError, "bad page"}, failAt, nil
+			if _, err := runListJoin(t, left, right, size); err == nil {
+				t.Errorf("expected an error of the left input after %d tuples to be returned with a buffer of %d tuples", failAt, size)
+			}
+		}
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+}
+
+func TestJoinSpillsRemovedWithTransaction(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	var keys []int64
+	for i := int64(0); i < 100; i++ {
+		keys = append(keys, i)
+	}
+	bp := NewBufferPool(10)
+	for _, commit := range []bool{true, false} {
+		left, right := newTupleListOp("l", keys...), newTupleListOp("r", keys...)
+		join, err := NewIntJoin(left, &FieldExpr{left.desc.Fields[0]}, right, &FieldExpr{right.desc.Fields[0]}, 10)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		iter, err := join.Iterator(tid)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		// stop after the first tuple, as a LIMIT would
+		if tup, err := iter(); tup == nil || err != nil {
+			t.Fatalf("expected a joined tuple, got %v (%v)", tup, err)
+		}
+		if files, _ := os.ReadDir(spillDir); len(files) == 0 {
+			t.Fatalf("expected the join to spill its partitions")
+		}
+		if commit {
+			err = bp.CommitTransaction(tid)
+		} else {
+			bp.AbortTransaction(tid)
+		}
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+			t.Errorf("expected the spill files to be removed once the transaction ends, found %d", len(files))
+		}
+	}
+}
+
+func TestSpillLargeTuples(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	desc := TupleDesc{Fields: []FieldType{{Fname: "id", Ftype: IntType}, {Fname: "s", Ftype: StringType}, {Fname: "b", Ftype: BytesType}}}
+	s, err := newSpillFile(&desc, NewTID())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer s.remove()
+	// tuples larger than a page are stored with their largest values out of
+	// line, between tuples that fit
+	var want []*Tuple
+	for i := 0; i < 6; i++ {
+		n := 10
+		if i%2 == 1 {
+			n = PageSize * i
+		}
+		tup := &Tuple{Desc: desc, Fields: []DBValue{IntField{int64(i)}, StringField{strings.Repeat("s", n)}, BytesField{[]byte(strings.Repeat("b", n))}}}
+		if err := s.append(tup); err != nil {
+			t.Fatalf("tuple %d: %s", i, err)
+		}
+		want = append(want, tup)
+	}
+	iter, err := s.iterator()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for i, w := range want {
+		got, err := iter()
+		if err != nil || got == nil {
+			t.Fatalf("tuple %d: expected a tuple, got %v (%v)", i, got, err)
+		}
+		if !got.equals(w) {
+			t.Errorf("tuple %d: expected a tuple of %d bytes, got one of %d", i, len(w.Fields[1].(StringField).Value), len(got.Fields[1].(StringField).Value))
+		}
+	}
+	if got, err := iter(); got != nil || err != nil {
+		t.Errorf("expected the file to end, got %v (%v)", got, err)
+	}
+	s.remove()
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill file and its overflow file to be removed, found %d", len(files))
+	}
+}
@@ -43,9 +43,14 @@ func computeFieldSum(fileName string, td TupleDesc, sumField string) (int, error
 		return 0, nil
 	}
 	tid := NewTID()
+	bp.BeginTransaction(tid)
+	defer bp.CommitTransaction(tid)
 	iter, _ := hp.Iterator(tid)
 	for {
-		t, _ := iter()
+		t, err := iter()
+		if err != nil {
+			return 0, err
+		}
 		if t == nil {
 			break
 		}
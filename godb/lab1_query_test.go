@@ -2,6 +2,8 @@ package godb
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -9,7 +11,12 @@ func TestLab1Query(t *testing.T) {
 	f1 := FieldType{"name", "", StringType}
 	f2 := FieldType{"age", "", IntType}
 	td := TupleDesc{[]FieldType{f1, f2}}
-	sum, err := computeFieldSum("lab1_test.csv", td, "age")
+	// computeFieldSum creates its heap file in the working directory
+	csvFile, _ := filepath.Abs("lab1_test.csv")
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	sum, err := computeFieldSum(csvFile, td, "age")
 	if err != nil {
 		fmt.Println(err)
 	}
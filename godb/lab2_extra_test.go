@@ -10,7 +10,7 @@ import (
 // have tried to take into account the suggestions from Piazza — thank you
 // all for your constructive input!
 
-func makeJoinOrderingVars() (*HeapFile, *HeapFile, Tuple, Tuple, *BufferPool) {
+func makeJoinOrderingVars(t *testing.T) (*HeapFile, *HeapFile, Tuple, Tuple, *BufferPool) {
 	var td1 = TupleDesc{Fields: []FieldType{
 		{Fname: "a", Ftype: StringType},
 		{Fname: "b", Ftype: IntType},
@@ -35,14 +35,13 @@ func makeJoinOrderingVars() (*HeapFile, *HeapFile, Tuple, Tuple, *BufferPool) {
 		}}
 
 	bp := NewBufferPool(3)
-	os.Remove(TestingFile)
+	useTestFiles(t)
 	hf1, err := NewHeapFile(TestingFile, &td1, bp)
 	if err != nil {
 		print("ERROR MAKING TEST VARS, BLARGH")
 		panic(err)
 	}
 
-	os.Remove(TestingFile2)
 	hf2, err := NewHeapFile(TestingFile2, &td2, bp)
 	if err != nil {
 		print("ERROR MAKING TEST VARS, BLARGH")
@@ -52,14 +51,14 @@ func makeJoinOrderingVars() (*HeapFile, *HeapFile, Tuple, Tuple, *BufferPool) {
 	return hf1, hf2, t1, t2, bp
 }
 
-func makeOrderByOrderingVars() (*HeapFile, Tuple, TupleDesc, *BufferPool) {
+func makeOrderByOrderingVars(t *testing.T) (*HeapFile, Tuple, TupleDesc, *BufferPool) {
 	var td = TupleDesc{Fields: []FieldType{
 		{Fname: "a", Ftype: StringType},
 		{Fname: "b", Ftype: IntType},
 		{Fname: "c", Ftype: IntType},
 	}}
 
-	var t = Tuple{
+	var tup = Tuple{
 		Desc: td,
 		Fields: []DBValue{
 			StringField{"sam"},
@@ -68,23 +67,27 @@ func makeOrderByOrderingVars() (*HeapFile, Tuple, TupleDesc, *BufferPool) {
 		}}
 
 	bp := NewBufferPool(3)
-	os.Remove(TestingFile)
+	useTestFiles(t)
 	hf, err := NewHeapFile(TestingFile, &td, bp)
 	if err != nil {
 		print("ERROR MAKING TEST VARS, BLARGH")
 		panic(err)
 	}
 
-	return hf, t, td, bp
+	return hf, tup, td, bp
 }
 
 func TestSetDirty(t *testing.T) {
-	_, t1, _, hf, bp, _ := makeTestVars()
+	_, t1, _, hf, bp, _ := makeTestVars(t)
 	tid := NewTID()
 	bp.BeginTransaction(tid)
-	for i := 0; i < 308; i++ {
+	// the pool holds three pages, which getNumSlots counts in tuples whose
+	// strings are StringLength bytes long
+	t1 = wideTuple(t1)
+	full := 3 * newHeapPage(&t1.Desc, 0, hf).getNumSlots()
+	for i := 0; i < full+2; i++ {
 		err := hf.insertTuple(&t1, tid)
-		if err != nil && (i == 306 || i == 307) {
+		if err != nil && (i == full || i == full+1) {
 			return
 		} else if err != nil {
 			t.Fatalf("%v", err)
@@ -95,7 +98,7 @@ func TestSetDirty(t *testing.T) {
 }
 
 func TestDirtyBit(t *testing.T) {
-	_, t1, _, hf, bp, _ := makeTestVars()
+	_, t1, _, hf, bp, _ := makeTestVars(t)
 
 	tid := NewTID()
 	bp.BeginTransaction(tid)
@@ -108,7 +111,7 @@ func TestDirtyBit(t *testing.T) {
 }
 
 func TestJoinFieldOrder(t *testing.T) {
-	hf1, hf2, t1, t2, bp := makeJoinOrderingVars()
+	hf1, hf2, t1, t2, bp := makeJoinOrderingVars(t)
 
 	tid := NewTID()
 	bp.BeginTransaction(tid)
@@ -150,7 +153,7 @@ func TestJoinFieldOrder(t *testing.T) {
 }
 
 func TestOrderByFieldsOrder(t *testing.T) {
-	hf, tup, td, bp := makeOrderByOrderingVars()
+	hf, tup, td, bp := makeOrderByOrderingVars(t)
 
 	tid := NewTID()
 	bp.BeginTransaction(tid)
@@ -190,7 +193,7 @@ func TestOrderByFieldsOrder(t *testing.T) {
 }
 
 func TestProjectOrdering(t *testing.T) {
-	hf, tup, td, bp := makeOrderByOrderingVars()
+	hf, tup, td, bp := makeOrderByOrderingVars(t)
 
 	tid := NewTID()
 	bp.BeginTransaction(tid)
@@ -225,7 +228,7 @@ func TestProjectOrdering(t *testing.T) {
 }
 
 func TestJoinTupleNil(t *testing.T) {
-	_, t1, t2, _, _, _ := makeTestVars()
+	_, t1, t2, _, _, _ := makeTestVars(t)
 	tNew := joinTuples(&t1, nil)
 	if !tNew.equals(&t1) {
 		t.Fatalf("Unexpected output of joinTuple with nil")
@@ -237,7 +240,7 @@ func TestJoinTupleNil(t *testing.T) {
 }
 
 func TestJoinTuplesDesc(t *testing.T) {
-	_, t1, t2, _, _, _ := makeTestVars()
+	_, t1, t2, _, _, _ := makeTestVars(t)
 	tNew := joinTuples(&t1, &t2)
 	if len(tNew.Desc.Fields) != 4 {
 		t.Fatalf("Expected 4 fields in desc after join")
@@ -251,7 +254,7 @@ func TestJoinTuplesDesc(t *testing.T) {
 }
 
 func TestHeapFileSize(t *testing.T) {
-	_, t1, _, hf, bp, _ := makeTestVars()
+	_, t1, _, hf, bp, _ := makeTestVars(t)
 
 	tid := NewTID()
 	bp.BeginTransaction(tid)
@@ -272,7 +275,7 @@ func TestHeapFileSize(t *testing.T) {
 }
 
 func TestProjectExtra(t *testing.T) {
-	_, _, t1, _, _ := makeJoinOrderingVars()
+	_, _, t1, _, _ := makeJoinOrderingVars(t)
 	ft1 := FieldType{"a", "", StringType}
 	ft2 := FieldType{"b", "", IntType}
 	outTup, _ := t1.project([]FieldType{ft1})
@@ -328,7 +331,7 @@ func TestProjectExtra(t *testing.T) {
 
 func TestBufferLen(t *testing.T) {
 
-	td, _, _, hf, _, _ := makeTestVars()
+	td, _, _, hf, _, _ := makeTestVars(t)
 	page := newHeapPage(&td, 0, hf)
 	free := page.getNumSlots()
 
@@ -352,7 +355,7 @@ func TestBufferLen(t *testing.T) {
 }
 
 func TestHeapFileIteratorExtra(t *testing.T) {
-	_, t1, _, hf, bp, _ := makeTestVars()
+	_, t1, _, hf, bp, _ := makeTestVars(t)
 	tid := NewTID()
 	bp.BeginTransaction(tid)
 
@@ -380,7 +383,7 @@ func TestHeapFileIteratorExtra(t *testing.T) {
 }
 
 func TestBufferPoolHoldsMultipleHeapFiles(t *testing.T) {
-	td, t1, t2, hf, bp, tid := makeTestVars()
+	td, t1, t2, hf, bp, tid := makeTestVars(t)
 	os.Remove(TestingFile2)
 	hf2, err := NewHeapFile(TestingFile2, &td, bp)
 	if err != nil {
@@ -5,7 +5,7 @@ import (
 )
 
 func testLimitCount(t *testing.T, n int) {
-	_, t1, t2, hf, bp, _ := makeTestVars()
+	_, t1, t2, hf, bp, _ := makeTestVars(t)
 
 	for i := 0; i < n; i++ {
 		tid := NewTID()
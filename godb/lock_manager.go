@@ -0,0 +1,546 @@
+package godb
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// How a LockManager keeps transactions from waiting for each other forever
+type DeadlockPolicy int
+
+const (
+	// Detect cycles in the wait-for graph, and abort the youngest transaction
+	// in the cycle
+	DetectYoungest DeadlockPolicy = iota
+	// Detect cycles in the wait-for graph, and abort the transaction in the
+	// cycle holding the fewest locks
+	DetectLeastWork DeadlockPolicy = iota
+	// A transaction may only wait for younger transactions; a younger one
+	// requesting a lock held by an older one is aborted
+	WaitDie DeadlockPolicy = iota
+	// A transaction may only wait for older transactions; an older one
+	// requesting a lock held by a younger one aborts it
+	WoundWait DeadlockPolicy = iota
+	// A transaction waiting for a lock for longer than the lock timeout is
+	// aborted
+	LockTimeout DeadlockPolicy = iota
+)
+
+// Lock timeout used with the LockTimeout policy, by default
+const DefaultLockTimeout = 100 * time.Millisecond
+
+// Number of row locks a transaction may hold on one page, by default, before
+// they are replaced by a lock on the whole page (see [LockManager.LockRow])
+const DefaultMaxRowLocksPerPage = 32
+
+// Mode of a lock.  Tables and pages are locked with the intention modes by
+// transactions that lock some of the pages or rows below them; rows, and pages
+// or tables locked as a whole, are locked in Shared or Exclusive mode.
+type LockMode int
+
+const (
+	IntentionShared          LockMode = iota
+	IntentionExclusive       LockMode = iota
+	Shared                   LockMode = iota
+	SharedIntentionExclusive LockMode = iota
+	Exclusive                LockMode = iota
+)
+
+// Whether locks held in the given modes by different transactions are
+// compatible, indexed by mode
+var lockCompatible = [5][5]bool{
+	IntentionShared:          {true, true, true, true, false},
+	IntentionExclusive:       {true, true, false, false, false},
+	Shared:                   {true, false, true, false, false},
+	SharedIntentionExclusive: {true, false, false, false, false},
+	Exclusive:                {false, false, false, false, false},
+}
+
+// The weakest mode that grants everything both a and b grant, indexed by mode
+var lockCombined = [5][5]LockMode{
+	IntentionShared:          {IntentionShared, IntentionExclusive, Shared, SharedIntentionExclusive, Exclusive},
+	IntentionExclusive:       {IntentionExclusive, IntentionExclusive, SharedIntentionExclusive, SharedIntentionExclusive, Exclusive},
+	Shared:                   {Shared, SharedIntentionExclusive, Shared, SharedIntentionExclusive, Exclusive},
+	SharedIntentionExclusive: {SharedIntentionExclusive, SharedIntentionExclusive, SharedIntentionExclusive, SharedIntentionExclusive, Exclusive},
+	Exclusive:                {Exclusive, Exclusive, Exclusive, Exclusive, Exclusive},
+}
+
+// Return true if a lock held in mode held grants everything one in mode mode
+// does
+func covers(held LockMode, mode LockMode) bool {
+	return lockCombined[held][mode] == held
+}
+
+// Return the mode to lock the parent of an object locked in mode with
+func intention(mode LockMode) LockMode {
+	if mode == IntentionShared || mode == Shared {
+		return IntentionShared
+	}
+	return IntentionExclusive
+}
+
+// Return the lock mode that grants perm on a page
+func permMode(perm RWPerm) LockMode {
+	if perm == WritePerm {
+		return Exclusive
+	}
+	return Shared
+}
+
+// Key of the lock on a table, the parent of the locks on its pages (heapHash)
+type tableLockKey struct {
+	FileName string
+}
+
+// Key of the lock on a row, the child of the lock on its page
+type rowLockKey struct {
+	page heapHash
+	slot int
+}
+
+// A request for a lock that has not been granted yet
+type lockRequest struct {
+	tid  TransactionID
+	mode LockMode
+}
+
+// The lock on one object: the transactions holding it, and the requests
+// waiting for it in the order they will be granted.  Waiters sleep on cond,
+// which is broadcast whenever the holders or the queue change.
+type pageLock struct {
+	holders map[TransactionID]LockMode
+	waiters *list.List // of *lockRequest
+	cond    *sync.Cond
+}
+
+// LockManager grants locks on tables, pages and rows to transactions, blocking
+// requests that conflict with locks held by other transactions until those are
+// released.
+//
+// Locks are hierarchical: before locking a page or a row, a transaction locks
+// its table (and the page of a row) in an intention mode, so that a lock on a
+// whole table or page conflicts with the locks on the objects below it.  A
+// transaction holding many row locks on a page has them replaced by a single
+// page lock (escalation).
+//
+// Requests are granted in FIFO order, except that a transaction upgrading a
+// lock it already holds to a stronger mode goes to the front of the queue; the
+// upgrade is granted once no other holder conflicts with it.
+//
+// Deadlocks are handled according to the DeadlockPolicy.  A transaction chosen
+// to be aborted gets a DeadlockError from Acquire, either right away if it is
+// the one requesting a lock, or else when it is next woken up or requests a
+// lock; it must then be aborted, releasing its locks.  Transactions are
+// ordered by age by their ids.
+type LockManager struct {
+	sync.Mutex
+	policy  DeadlockPolicy
+	timeout time.Duration
+	// number of row locks on a page a transaction may hold before they are
+	// escalated
+	maxRowLocks int
+	locks       map[any]*pageLock
+	// the locks held by each transaction
+	held map[TransactionID]map[any]LockMode
+	// the number of row locks each transaction holds on each page
+	rowLocks map[TransactionID]map[heapHash]int
+	// the object each blocked transaction is waiting for
+	waiting map[TransactionID]any
+	// transactions chosen to be aborted
+	doomed map[TransactionID]struct{}
+}
+
+func NewLockManager(policy DeadlockPolicy, timeout time.Duration) *LockManager {
+	return &LockManager{
+		policy:      policy,
+		timeout:     timeout,
+		maxRowLocks: DefaultMaxRowLocksPerPage,
+		locks:       make(map[any]*pageLock),
+		held:        make(map[TransactionID]map[any]LockMode),
+		rowLocks:    make(map[TransactionID]map[heapHash]int),
+		waiting:     make(map[TransactionID]any),
+		doomed:      make(map[TransactionID]struct{}),
+	}
+}
+
+// Return true if a started before b
+func older(a TransactionID, b TransactionID) bool {
+	return a < b
+}
+
+func (lm *LockManager) pageLock(key any) *pageLock {
+	pl, ok := lm.locks[key]
+	if !ok {
+		pl = &pageLock{holders: make(map[TransactionID]LockMode), waiters: list.New(), cond: sync.NewCond(&lm.Mutex)}
+		lm.locks[key] = pl
+	}
+	return pl
+}
+
+// Return the transactions that a request for key by tid in mode must wait
+// for: the other holders of conflicting locks, and the conflicting requests
+// queued ahead of it.  If tid is not queued, all waiters count as ahead.
+func (lm *LockManager) blockers(pl *pageLock, tid TransactionID, mode LockMode) []TransactionID {
+	var blockers []TransactionID
+	for holder, held := range pl.holders {
+		if holder != tid && !lockCompatible[held][mode] {
+			blockers = append(blockers, holder)
+		}
+	}
+	for e := pl.waiters.Front(); e != nil; e = e.Next() {
+		req := e.Value.(*lockRequest)
+		if req.tid == tid {
+			break
+		}
+		if !lockCompatible[req.mode][mode] {
+			blockers = append(blockers, req.tid)
+		}
+	}
+	return blockers
+}
+
+// Return the transactions that tid, which must be blocked, is waiting for
+func (lm *LockManager) waitsFor(tid TransactionID) []TransactionID {
+	key, ok := lm.waiting[tid]
+	if !ok {
+		return nil
+	}
+	pl := lm.locks[key]
+	for e := pl.waiters.Front(); e != nil; e = e.Next() {
+		if req := e.Value.(*lockRequest); req.tid == tid {
+			return lm.blockers(pl, tid, req.mode)
+		}
+	}
+	return nil
+}
+
+// Return the transactions in a cycle of the wait-for graph that tid would
+// close by waiting for blockers, or nil if there is none.  Doomed transactions
+// are about to release their locks, so they cannot be part of a deadlock.
+func (lm *LockManager) findCycle(tid TransactionID, blockers []TransactionID) []TransactionID {
+	visited := make(map[TransactionID]bool)
+	var path []TransactionID
+	var visit func(t TransactionID) bool
+	visit = func(t TransactionID) bool {
+		if t == tid {
+			return true
+		}
+		if _, ok := lm.doomed[t]; ok || visited[t] {
+			return false
+		}
+		visited[t] = true
+		path = append(path, t)
+		for _, next := range lm.waitsFor(t) {
+			if visit(next) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	for _, t := range blockers {
+		if visit(t) {
+			return append(path, tid)
+		}
+	}
+	return nil
+}
+
+// Pick the transaction to abort to break a cycle
+func (lm *LockManager) chooseVictim(cycle []TransactionID) TransactionID {
+	victim := cycle[0]
+	for _, t := range cycle[1:] {
+		if lm.policy == DetectLeastWork && len(lm.held[t]) != len(lm.held[victim]) {
+			if len(lm.held[t]) < len(lm.held[victim]) {
+				victim = t
+			}
+		} else if older(victim, t) {
+			victim = t
+		}
+	}
+	return victim
+}
+
+// Mark tid to be aborted, waking it up if it is blocked
+func (lm *LockManager) doom(tid TransactionID) {
+	lm.doomed[tid] = struct{}{}
+	if key, ok := lm.waiting[tid]; ok {
+		lm.locks[key].cond.Broadcast()
+	}
+}
+
+// Decide whether tid may wait for blockers, according to the deadlock policy,
+// dooming other transactions if the policy requires it.  Returns a
+// DeadlockError if tid must be aborted instead.
+func (lm *LockManager) mayWait(tid TransactionID, blockers []TransactionID, deadline time.Time) error {
+	switch lm.policy {
+	case DetectYoungest, DetectLeastWork:
+		if cycle := lm.findCycle(tid, blockers); cycle != nil {
+			victim := lm.chooseVictim(cycle)
+			if victim == tid {
+				return GoDBError{DeadlockError, "deadlock detected while waiting for lock"}
+			}
+			lm.doom(victim)
+		}
+	case WaitDie:
+		for _, t := range blockers {
+			if !older(tid, t) {
+				return GoDBError{DeadlockError, "lock is held by an older transaction"}
+			}
+		}
+	case WoundWait:
+		for _, t := range blockers {
+			if older(tid, t) {
+				lm.doom(t)
+			}
+		}
+	case LockTimeout:
+		if !time.Now().Before(deadline) {
+			return GoDBError{DeadlockError, "timed out waiting for lock"}
+		}
+	}
+	return nil
+}
+
+func (lm *LockManager) grant(pl *pageLock, key any, tid TransactionID, mode LockMode) {
+	if lm.held[tid] == nil {
+		lm.held[tid] = make(map[any]LockMode)
+	}
+	if _, ok := lm.held[tid][key]; !ok {
+		if row, ok := key.(rowLockKey); ok {
+			if lm.rowLocks[tid] == nil {
+				lm.rowLocks[tid] = make(map[heapHash]int)
+			}
+			lm.rowLocks[tid][row.page]++
+		}
+	}
+	pl.holders[tid] = mode
+	lm.held[tid][key] = mode
+}
+
+// Acquire a lock on key in the given mode on behalf of tid, blocking until it
+// is granted.  A transaction already holding a lock on key has it upgraded to
+// a mode granting both the held and the requested mode.  Returns a
+// DeadlockError, without acquiring the lock, if tid would wait for itself, or
+// if the deadlock policy aborts it.
+//
+// Acquire does not lock the parents of key; use LockPage and LockRow to lock
+// pages and rows along with their parents.
+func (lm *LockManager) Acquire(tid TransactionID, key any, mode LockMode) error {
+	lm.Lock()
+	defer lm.Unlock()
+	_, err := lm.acquire(tid, key, mode, true)
+	return err
+}
+
+// Acquire a lock like Acquire, but without blocking: returns false, without
+// acquiring the lock, if tid would have to wait for it.
+func (lm *LockManager) TryAcquire(tid TransactionID, key any, mode LockMode) (bool, error) {
+	lm.Lock()
+	defer lm.Unlock()
+	return lm.acquire(tid, key, mode, false)
+}
+
+func (lm *LockManager) acquire(tid TransactionID, key any, mode LockMode, wait bool) (bool, error) {
+	if _, ok := lm.doomed[tid]; ok {
+		return false, GoDBError{DeadlockError, "transaction was aborted to resolve a deadlock"}
+	}
+	held, ok := lm.held[tid][key]
+	if ok && covers(held, mode) {
+		return true, nil
+	}
+	if ok {
+		mode = lockCombined[held][mode]
+	}
+	pl := lm.pageLock(key)
+	if !wait {
+		if len(lm.blockers(pl, tid, mode)) > 0 {
+			return false, nil
+		}
+		lm.grant(pl, key, tid, mode)
+		return true, nil
+	}
+	req := &lockRequest{tid, mode}
+	var e *list.Element
+	if ok {
+		e = pl.waiters.PushFront(req)
+	} else {
+		e = pl.waiters.PushBack(req)
+	}
+	var deadline time.Time
+	if lm.policy == LockTimeout {
+		deadline = time.Now().Add(lm.timeout)
+		timer := time.AfterFunc(lm.timeout, func() {
+			lm.Lock()
+			defer lm.Unlock()
+			pl.cond.Broadcast()
+		})
+		defer timer.Stop()
+	}
+	for blockers := lm.blockers(pl, tid, mode); len(blockers) > 0; blockers = lm.blockers(pl, tid, mode) {
+		err := lm.mayWait(tid, blockers, deadline)
+		if _, ok := lm.doomed[tid]; ok && err == nil {
+			err = GoDBError{DeadlockError, "transaction was aborted to resolve a deadlock"}
+		}
+		if err != nil {
+			pl.waiters.Remove(e)
+			lm.dropIfUnused(key, pl)
+			pl.cond.Broadcast()
+			return false, err
+		}
+		lm.waiting[tid] = key
+		pl.cond.Wait()
+		delete(lm.waiting, tid)
+	}
+	pl.waiters.Remove(e)
+	lm.grant(pl, key, tid, mode)
+	// requests queued behind this one may be compatible with it
+	pl.cond.Broadcast()
+	return true, nil
+}
+
+// Lock a page in mode on behalf of tid, after locking its table in the
+// matching intention mode (see Acquire).
+func (lm *LockManager) LockPage(tid TransactionID, page heapHash, mode LockMode) error {
+	lm.Lock()
+	defer lm.Unlock()
+	if _, err := lm.acquire(tid, tableLockKey{page.FileName}, intention(mode), true); err != nil {
+		return err
+	}
+	_, err := lm.acquire(tid, page, mode, true)
+	return err
+}
+
+// Lock a row in mode (Shared or Exclusive) on behalf of tid, after locking its
+// table and page in the matching intention mode (see Acquire).  Nothing is
+// locked if tid already holds a lock on the page that covers the row.  Once tid
+// holds more than the maximum number of row locks on the page, they are
+// escalated: the page is locked in Shared mode, or in Exclusive mode if any of
+// the rows is locked in Exclusive mode, and the row locks are released.
+func (lm *LockManager) LockRow(tid TransactionID, row rowLockKey, mode LockMode) error {
+	lm.Lock()
+	defer lm.Unlock()
+	_, err := lm.lockRow(tid, row, mode, true)
+	return err
+}
+
+// Lock a row like LockRow, but without waiting for the row lock: returns false,
+// without locking the row, if it is locked by another transaction.  The table
+// and page may still be locked, and escalation may still block.
+func (lm *LockManager) TryLockRow(tid TransactionID, row rowLockKey, mode LockMode) (bool, error) {
+	lm.Lock()
+	defer lm.Unlock()
+	return lm.lockRow(tid, row, mode, false)
+}
+
+func (lm *LockManager) lockRow(tid TransactionID, row rowLockKey, mode LockMode, wait bool) (bool, error) {
+	if held, ok := lm.held[tid][row.page]; ok && covers(held, mode) {
+		return true, nil
+	}
+	if _, err := lm.acquire(tid, tableLockKey{row.page.FileName}, intention(mode), true); err != nil {
+		return false, err
+	}
+	if _, err := lm.acquire(tid, row.page, intention(mode), true); err != nil {
+		return false, err
+	}
+	if ok, err := lm.acquire(tid, row, mode, wait); !ok || err != nil {
+		return ok, err
+	}
+	if lm.rowLocks[tid][row.page] > lm.maxRowLocks {
+		return true, lm.escalate(tid, row.page)
+	}
+	return true, nil
+}
+
+// Replace the row locks tid holds on page by a lock on the page
+func (lm *LockManager) escalate(tid TransactionID, page heapHash) error {
+	mode := Shared
+	var rows []rowLockKey
+	for key, held := range lm.held[tid] {
+		if row, ok := key.(rowLockKey); ok && row.page == page {
+			rows = append(rows, row)
+			if held == Exclusive {
+				mode = Exclusive
+			}
+		}
+	}
+	if _, err := lm.acquire(tid, tableLockKey{page.FileName}, intention(mode), true); err != nil {
+		return err
+	}
+	if _, err := lm.acquire(tid, page, mode, true); err != nil {
+		return err
+	}
+	held := lm.held[tid][page]
+	for _, row := range rows {
+		if covers(held, lm.held[tid][row]) {
+			lm.release(tid, row)
+		}
+	}
+	return nil
+}
+
+// Release the lock tid holds on key, if any
+func (lm *LockManager) Release(tid TransactionID, key any) {
+	lm.Lock()
+	defer lm.Unlock()
+	lm.release(tid, key)
+}
+
+func (lm *LockManager) release(tid TransactionID, key any) {
+	if _, ok := lm.held[tid][key]; !ok {
+		return
+	}
+	delete(lm.held[tid], key)
+	if row, ok := key.(rowLockKey); ok {
+		lm.rowLocks[tid][row.page]--
+		if lm.rowLocks[tid][row.page] == 0 {
+			delete(lm.rowLocks[tid], row.page)
+		}
+	}
+	pl := lm.locks[key]
+	delete(pl.holders, tid)
+	lm.dropIfUnused(key, pl)
+	pl.cond.Broadcast()
+}
+
+// Forget the lock on key if nobody holds or waits for it
+func (lm *LockManager) dropIfUnused(key any, pl *pageLock) {
+	if len(pl.holders) == 0 && pl.waiters.Len() == 0 {
+		delete(lm.locks, key)
+	}
+}
+
+// Release all locks held by tid
+func (lm *LockManager) ReleaseAll(tid TransactionID) {
+	lm.Lock()
+	defer lm.Unlock()
+	for key := range lm.held[tid] {
+		lm.release(tid, key)
+	}
+	delete(lm.held, tid)
+	delete(lm.rowLocks, tid)
+	delete(lm.doomed, tid)
+}
+
+// Return the mode of the lock tid holds on key, and whether it holds one
+func (lm *LockManager) Holds(tid TransactionID, key any) (LockMode, bool) {
+	lm.Lock()
+	defer lm.Unlock()
+	mode, ok := lm.held[tid][key]
+	return mode, ok
+}
+
+// Return the pages tid holds locks on that allow it to update them, or some of
+// their rows (IntentionExclusive or stronger)
+func (lm *LockManager) WritePages(tid TransactionID) []heapHash {
+	lm.Lock()
+	defer lm.Unlock()
+	var pages []heapHash
+	for key, mode := range lm.held[tid] {
+		if page, ok := key.(heapHash); ok && mode != IntentionShared && mode != Shared {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
@@ -0,0 +1,205 @@
+package godb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Acquire a lock in the background; the returned channel receives the result
+// once the lock is granted or fails
+func acquireAsync(lm *LockManager, tid TransactionID, key any, mode LockMode) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- lm.Acquire(tid, key, mode)
+	}()
+	return done
+}
+
+func expectBlocked(t *testing.T, done chan error) {
+	select {
+	case err := <-done:
+		t.Fatalf("expected lock request to block, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func expectGranted(t *testing.T, done chan error) {
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected lock to be granted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected lock to be granted")
+	}
+}
+
+func TestLockManagerWakesWaiter(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	tid1, tid2 := NewTID(), NewTID()
+	if err := lm.Acquire(tid1, 0, Exclusive); err != nil {
+		t.Fatalf("%v", err)
+	}
+	done := acquireAsync(lm, tid2, 0, Shared)
+	expectBlocked(t, done)
+	lm.ReleaseAll(tid1)
+	expectGranted(t, done)
+	if mode, ok := lm.Holds(tid2, 0); !ok || mode != Shared {
+		t.Errorf("expected tid2 to hold a read lock")
+	}
+}
+
+func TestLockManagerFIFO(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	holder, writer, reader := NewTID(), NewTID(), NewTID()
+	lm.Acquire(holder, 0, Shared)
+	writeDone := acquireAsync(lm, writer, 0, Exclusive)
+	expectBlocked(t, writeDone)
+	// a read lock is compatible with the holder, but must not overtake the
+	// queued writer
+	readDone := acquireAsync(lm, reader, 0, Shared)
+	expectBlocked(t, readDone)
+
+	lm.Release(holder, 0)
+	expectGranted(t, writeDone)
+	expectBlocked(t, readDone)
+	lm.Release(writer, 0)
+	expectGranted(t, readDone)
+}
+
+func TestLockManagerUpgrade(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	tid1, tid2, tid3 := NewTID(), NewTID(), NewTID()
+	lm.Acquire(tid1, 0, Shared)
+	lm.Acquire(tid2, 0, Shared)
+	writeDone := acquireAsync(lm, tid3, 0, Exclusive)
+	expectBlocked(t, writeDone)
+
+	// the upgrade goes ahead of the queued writer once tid1 is the only reader
+	upgradeDone := acquireAsync(lm, tid1, 0, Exclusive)
+	expectBlocked(t, upgradeDone)
+	lm.ReleaseAll(tid2)
+	expectGranted(t, upgradeDone)
+	if mode, _ := lm.Holds(tid1, 0); mode != Exclusive {
+		t.Errorf("expected tid1 to hold the write lock")
+	}
+	expectBlocked(t, writeDone)
+	lm.ReleaseAll(tid1)
+	expectGranted(t, writeDone)
+}
+
+func TestLockManagerDeadlock(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	tid1, tid2 := NewTID(), NewTID()
+	lm.Acquire(tid1, 0, Exclusive)
+	lm.Acquire(tid2, 1, Exclusive)
+	done := acquireAsync(lm, tid1, 1, Exclusive)
+	expectBlocked(t, done)
+	if err := lm.Acquire(tid2, 0, Exclusive); err == nil {
+		t.Fatalf("expected deadlock error")
+	}
+	lm.ReleaseAll(tid2)
+	expectGranted(t, done)
+}
+
+func TestLockManagerManyReaders(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	writer := NewTID()
+	lm.Acquire(writer, 0, Exclusive)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		tid := NewTID()
+		go func() {
+			defer wg.Done()
+			lm.Acquire(tid, 0, Shared)
+			lm.ReleaseAll(tid)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	lm.ReleaseAll(writer)
+	wg.Wait()
+}
+
+func TestLockManagerIntentionModes(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	tid1, tid2, tid3, tid4 := NewTID(), NewTID(), NewTID(), NewTID()
+	if err := lm.Acquire(tid1, 0, IntentionExclusive); err != nil {
+		t.Fatalf("%v", err)
+	}
+	expectGranted(t, acquireAsync(lm, tid2, 0, IntentionExclusive))
+	expectGranted(t, acquireAsync(lm, tid3, 0, IntentionShared))
+	sharedDone := acquireAsync(lm, tid4, 0, Shared)
+	expectBlocked(t, sharedDone)
+	lm.ReleaseAll(tid1)
+	expectBlocked(t, sharedDone)
+	lm.ReleaseAll(tid2)
+	expectGranted(t, sharedDone)
+
+	// a transaction holding S that asks for IX holds SIX, which is compatible
+	// with the remaining IS holder
+	expectGranted(t, acquireAsync(lm, tid4, 0, IntentionExclusive))
+	if mode, _ := lm.Holds(tid4, 0); mode != SharedIntentionExclusive {
+		t.Errorf("expected S and IX to combine to SIX, got %d", mode)
+	}
+}
+
+func TestLockManagerRowLocks(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	tid1, tid2 := NewTID(), NewTID()
+	page := heapHash{"t.dat", 0}
+	if err := lm.LockRow(tid1, rowLockKey{page, 0}, Exclusive); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if mode, _ := lm.Holds(tid1, page); mode != IntentionExclusive {
+		t.Errorf("expected row lock to take an intention lock on the page")
+	}
+	if mode, _ := lm.Holds(tid1, tableLockKey{page.FileName}); mode != IntentionExclusive {
+		t.Errorf("expected row lock to take an intention lock on the table")
+	}
+	// other rows of the page are free, the locked one is not
+	if ok, err := lm.TryLockRow(tid2, rowLockKey{page, 1}, Exclusive); !ok || err != nil {
+		t.Errorf("expected lock on another row of the page to be granted")
+	}
+	if ok, _ := lm.TryLockRow(tid2, rowLockKey{page, 0}, Exclusive); ok {
+		t.Errorf("expected lock on a locked row not to be granted")
+	}
+	// neither transaction can lock the whole page
+	pageDone := make(chan error, 1)
+	go func() {
+		pageDone <- lm.LockPage(tid2, page, Shared)
+	}()
+	expectBlocked(t, pageDone)
+	lm.ReleaseAll(tid1)
+	expectGranted(t, pageDone)
+}
+
+func TestLockManagerEscalation(t *testing.T) {
+	lm := NewLockManager(DetectYoungest, DefaultLockTimeout)
+	lm.maxRowLocks = 2
+	tid := NewTID()
+	page := heapHash{"t.dat", 0}
+	for slot := 0; slot < 3; slot++ {
+		mode := Shared
+		if slot == 1 {
+			mode = Exclusive
+		}
+		if err := lm.LockRow(tid, rowLockKey{page, slot}, mode); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if mode, _ := lm.Holds(tid, page); mode != Exclusive {
+		t.Errorf("expected row locks to be escalated to an exclusive page lock")
+	}
+	for slot := 0; slot < 3; slot++ {
+		if _, ok := lm.Holds(tid, rowLockKey{page, slot}); ok {
+			t.Errorf("expected escalated row lock %d to be released", slot)
+		}
+	}
+	// rows of a locked page are not locked again
+	lm.LockRow(tid, rowLockKey{page, 5}, Exclusive)
+	if _, ok := lm.Holds(tid, rowLockKey{page, 5}); ok {
+		t.Errorf("expected row of an exclusively locked page not to be locked")
+	}
+}
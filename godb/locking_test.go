@@ -153,3 +153,115 @@ func TestAcquireWriteAndReadLocks(t *testing.T) {
 		tid1, hf, 0, ReadPerm,
 		true)
 }
+
+// Run f in the background, failing the test if it does not return in time
+func finishesSoon(t *testing.T, f func() error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected operation not to block")
+	}
+}
+
+func insertN(hf *HeapFile, tup Tuple, tid TransactionID, n int) func() error {
+	return func() error {
+		for i := 0; i < n; i++ {
+			if err := hf.insertTuple(&tup, tid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestRowLocksAllowInsertsIntoSamePage(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	tid1, tid2 := NewTID(), NewTID()
+	bp.BeginTransaction(tid1)
+	bp.BeginTransaction(tid2)
+	finishesSoon(t, insertN(hf, t1, tid1, 10))
+	finishesSoon(t, insertN(hf, t1, tid2, 10))
+	if hf.NumPages() != 1 {
+		t.Fatalf("expected both transactions to insert into one page, got %d pages", hf.NumPages())
+	}
+
+	// the abort only removes the rows of tid1
+	bp.AbortTransaction(tid1)
+	if err := bp.CommitTransaction(tid2); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	bp.FlushAllPages()
+	if cnt := countOnDisk(t, &td); cnt != 10 {
+		t.Errorf("expected 10 tuples, got %d", cnt)
+	}
+}
+
+func TestRowLocksOnDelete(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	row := func(slot int) *Tuple {
+		tup := t1
+		tup.Rid = Rid{0, slot}
+		return &tup
+	}
+	tid1, tid2, tid3 := NewTID(), NewTID(), NewTID()
+	bp.BeginTransaction(tid1)
+	bp.BeginTransaction(tid2)
+	bp.BeginTransaction(tid3)
+	finishesSoon(t, func() error { return hf.deleteTuple(row(0), tid1) })
+	finishesSoon(t, func() error { return hf.deleteTuple(row(1), tid2) })
+
+	// the free slot stays locked by tid1, so an insert goes elsewhere
+	finishesSoon(t, insertN(hf, t1, tid3, 1))
+	if _, ok := bp.lockManager.Holds(tid3, rowLockKey{hf.pageKey(0).(heapHash), 0}); ok {
+		t.Errorf("expected insert not to reuse a slot locked by another transaction")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- hf.deleteTuple(row(0), tid3)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected delete of a locked row to block, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	bp.AbortTransaction(tid1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected delete to succeed once the row is restored, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected abort to release the row lock")
+	}
+}
+
+func TestRecoveryUndoesRowsOfLoser(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	tid1, tid2 := NewTID(), NewTID()
+	bp.BeginTransaction(tid1)
+	bp.BeginTransaction(tid2)
+	finishesSoon(t, insertN(hf, t1, tid1, 10))
+	finishesSoon(t, insertN(hf, t1, tid2, 10))
+	// the committed image of the page includes the rows of tid1
+	if err := bp.CommitTransaction(tid2); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	bp.FlushAllPages()
+
+	// crash before tid1 commits
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 10 {
+		t.Errorf("expected recovery to keep only the 10 committed tuples, got %d", cnt)
+	}
+}
@@ -0,0 +1,638 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LogFile is the write-ahead log used by the BufferPool.  It is an append-only
+// sequence of records, each of which is framed as
+//
+//	uint32 body length | uint32 crc32 of body | body
+//
+// so that a record that was only partially written when the process died can be
+// recognized (and discarded) during recovery.  The body of every record starts
+// with the record type and the id of the transaction that wrote it, or zero for
+// records that belong to no transaction.
+//
+// Changes to pages are logged in two ways:
+//   - the changes a transaction makes to tuples are logged logically, as
+//     insert and delete records holding the slot and the bytes of the tuple, as
+//     they are made.  They are used to undo the changes of a transaction that
+//     aborts, or that was running at the time of a crash.  Since the tuple is
+//     locked by the transaction until it ends, undoing a change only touches
+//     that one slot, and never the changes other transactions made to the page;
+//   - pages are logged physically, as full images, whenever they are written
+//     back or a transaction that changed them commits.  Images are used to
+//     redo changes, and are not tied to any transaction.  The pages of an
+//     index file are logged together, in one record, so that the pages of a
+//     split or merge are redone all or none (see [BufferPool.logIndexPages]).
+//
+// Both are idempotent: writing an image or setting a slot any number of times
+// always produces the same page, so recovery can itself be interrupted and
+// restarted safely.
+//
+// The protocol followed by the BufferPool is the usual WAL rule: the records
+// for a page must be forced to the log before the page is written to its
+// DBFile, and a transaction is committed once its commit record is on disk.
+type LogFile struct {
+	sync.Mutex
+	Filename string
+	file     *os.File
+	size     int64 // offset of the end of the log, where the next record goes
+	maxTid   int64 // largest transaction id found in the log by Recover
+}
+
+// Name of the log file that a Catalog opens in its root path
+const LogFileName string = "godb.log"
+
+type LogRecordType uint8
+
+const (
+	// full image of a page
+	UpdateRecord LogRecordType = iota
+	CommitRecord LogRecordType = iota
+	AbortRecord  LogRecordType = iota
+	// written by [BufferPool.Checkpoint]
+	CheckpointRecord LogRecordType = iota
+	// a tuple was inserted into, or deleted from, a slot
+	InsertRecord LogRecordType = iota
+	DeleteRecord LogRecordType = iota
+	// full images of several pages of an index file
+	IndexUpdateRecord LogRecordType = iota
+)
+
+const logFrameHeaderSize = 8
+
+type logRecord struct {
+	rtype LogRecordType
+	tid   int64
+	lsn   int64 // offset of the record in the log, set when it is read
+
+	// only set for UpdateRecord, InsertRecord and DeleteRecord
+	page heapHash
+	// only set for UpdateRecord
+	image []byte
+	// only set for InsertRecord and DeleteRecord
+	slot  int
+	tuple []byte
+	// only set for IndexUpdateRecord: the pages and their images, in the same
+	// order
+	pages  []heapHash
+	images [][]byte
+
+	// only set for CheckpointRecord
+	checkpoint *checkpointTables
+}
+
+// Contents of a checkpoint record.  Positions in the log are stored as
+// distances back from the checkpoint record itself, so that they stay valid
+// when the head of the log is truncated.
+type checkpointTables struct {
+	redoBack     int64              // redo starts this many bytes before the record
+	transactions map[int64]int64    // active transaction -> its first update record, or -1
+	dirtyPages   map[heapHash]int64 // dirty page -> first update record since it was written
+}
+
+// Open (or create) the log file at fileName.  The log is not recovered; callers
+// that open an existing log should call [LogFile.Recover] before appending to it.
+func NewLogFile(fileName string) (*LogFile, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &LogFile{Filename: fileName, file: file, size: info.Size()}, nil
+}
+
+func (lf *LogFile) Close() error {
+	lf.Lock()
+	defer lf.Unlock()
+	return lf.file.Close()
+}
+
+// Size of the log in bytes
+func (lf *LogFile) Size() int64 {
+	lf.Lock()
+	defer lf.Unlock()
+	return lf.size
+}
+
+func logTid(tid TransactionID) int64 {
+	return int64(tid)
+}
+
+func writePageKey(b *bytes.Buffer, page heapHash) {
+	binary.Write(b, binary.LittleEndian, uint16(len(page.FileName)))
+	b.WriteString(page.FileName)
+	binary.Write(b, binary.LittleEndian, int64(page.PageNo))
+}
+
+func readPageKey(b *bytes.Buffer) (heapHash, error) {
+	var nameLen uint16
+	if err := binary.Read(b, binary.LittleEndian, &nameLen); err != nil {
+		return heapHash{}, err
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(b, name); err != nil {
+		return heapHash{}, err
+	}
+	var pageNo int64
+	if err := binary.Read(b, binary.LittleEndian, &pageNo); err != nil {
+		return heapHash{}, err
+	}
+	return heapHash{string(name), int(pageNo)}, nil
+}
+
+func (r *logRecord) toBuffer() *bytes.Buffer {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, r.rtype)
+	binary.Write(b, binary.LittleEndian, r.tid)
+	if r.rtype == UpdateRecord {
+		writePageKey(b, r.page)
+		b.Write(r.image)
+	}
+	if r.rtype == InsertRecord || r.rtype == DeleteRecord {
+		writePageKey(b, r.page)
+		binary.Write(b, binary.LittleEndian, int32(r.slot))
+		binary.Write(b, binary.LittleEndian, uint32(len(r.tuple)))
+		b.Write(r.tuple)
+	}
+	if r.rtype == IndexUpdateRecord {
+		binary.Write(b, binary.LittleEndian, uint32(len(r.pages)))
+		for i, page := range r.pages {
+			writePageKey(b, page)
+			b.Write(r.images[i])
+		}
+	}
+	if r.rtype == CheckpointRecord {
+		cp := r.checkpoint
+		binary.Write(b, binary.LittleEndian, cp.redoBack)
+		binary.Write(b, binary.LittleEndian, uint32(len(cp.transactions)))
+		for tid, back := range cp.transactions {
+			binary.Write(b, binary.LittleEndian, tid)
+			binary.Write(b, binary.LittleEndian, back)
+		}
+		binary.Write(b, binary.LittleEndian, uint32(len(cp.dirtyPages)))
+		for page, back := range cp.dirtyPages {
+			writePageKey(b, page)
+			binary.Write(b, binary.LittleEndian, back)
+		}
+	}
+	return b
+}
+
+func readCheckpointTables(b *bytes.Buffer) (*checkpointTables, error) {
+	cp := &checkpointTables{transactions: make(map[int64]int64), dirtyPages: make(map[heapHash]int64)}
+	if err := binary.Read(b, binary.LittleEndian, &cp.redoBack); err != nil {
+		return nil, err
+	}
+	var n uint32
+	if err := binary.Read(b, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < n; i++ {
+		var entry [2]int64
+		if err := binary.Read(b, binary.LittleEndian, &entry); err != nil {
+			return nil, err
+		}
+		cp.transactions[entry[0]] = entry[1]
+	}
+	if err := binary.Read(b, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < n; i++ {
+		page, err := readPageKey(b)
+		if err != nil {
+			return nil, err
+		}
+		var back int64
+		if err := binary.Read(b, binary.LittleEndian, &back); err != nil {
+			return nil, err
+		}
+		cp.dirtyPages[page] = back
+	}
+	return cp, nil
+}
+
+func readLogRecord(body []byte) (*logRecord, error) {
+	b := bytes.NewBuffer(body)
+	r := new(logRecord)
+	if err := binary.Read(b, binary.LittleEndian, &r.rtype); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(b, binary.LittleEndian, &r.tid); err != nil {
+		return nil, err
+	}
+	switch r.rtype {
+	case CommitRecord, AbortRecord:
+		return r, nil
+	case CheckpointRecord:
+		cp, err := readCheckpointTables(b)
+		if err != nil {
+			return nil, err
+		}
+		r.checkpoint = cp
+		return r, nil
+	case UpdateRecord:
+		page, err := readPageKey(b)
+		if err != nil {
+			return nil, err
+		}
+		r.page = page
+		r.image = make([]byte, PageSize)
+		if _, err := io.ReadFull(b, r.image); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case InsertRecord, DeleteRecord:
+		page, err := readPageKey(b)
+		if err != nil {
+			return nil, err
+		}
+		r.page = page
+		var slot int32
+		if err := binary.Read(b, binary.LittleEndian, &slot); err != nil {
+			return nil, err
+		}
+		r.slot = int(slot)
+		var tupleLen uint32
+		if err := binary.Read(b, binary.LittleEndian, &tupleLen); err != nil {
+			return nil, err
+		}
+		r.tuple = make([]byte, tupleLen)
+		if _, err := io.ReadFull(b, r.tuple); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case IndexUpdateRecord:
+		var n uint32
+		if err := binary.Read(b, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < n; i++ {
+			page, err := readPageKey(b)
+			if err != nil {
+				return nil, err
+			}
+			img := make([]byte, PageSize)
+			if _, err := io.ReadFull(b, img); err != nil {
+				return nil, err
+			}
+			r.pages = append(r.pages, page)
+			r.images = append(r.images, img)
+		}
+		return r, nil
+	}
+	return nil, GoDBError{MalformedDataError, "unknown log record type"}
+}
+
+// Append a record to the end of the log, returning the offset it was written
+// at.  The record is not guaranteed to be on disk until [LogFile.force] is
+// called.
+func (lf *LogFile) append(r *logRecord) (int64, error) {
+	lf.Lock()
+	defer lf.Unlock()
+	body := r.toBuffer().Bytes()
+	frame := make([]byte, logFrameHeaderSize, logFrameHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(body))
+	frame = append(frame, body...)
+	off := lf.size
+	if _, err := lf.file.WriteAt(frame, off); err != nil {
+		return 0, err
+	}
+	lf.size += int64(len(frame))
+	return off, nil
+}
+
+// Log the full image of a page
+func (lf *LogFile) logPageImage(page heapHash, img []byte) (int64, error) {
+	return lf.append(&logRecord{rtype: UpdateRecord, page: page, image: img})
+}
+
+// Log the full images of several pages of an index file, which are redone
+// together
+func (lf *LogFile) logIndexImages(pages []heapHash, images [][]byte) (int64, error) {
+	return lf.append(&logRecord{rtype: IndexUpdateRecord, pages: pages, images: images})
+}
+
+// Log that tid inserted tuple into (InsertRecord) or deleted it from
+// (DeleteRecord) the given slot of page
+func (lf *LogFile) logTupleUpdate(rtype LogRecordType, tid TransactionID, page heapHash, slot int, tuple []byte) (int64, error) {
+	return lf.append(&logRecord{rtype: rtype, tid: logTid(tid), page: page, slot: slot, tuple: tuple})
+}
+
+func (lf *LogFile) logCommit(tid TransactionID) error {
+	_, err := lf.append(&logRecord{rtype: CommitRecord, tid: logTid(tid)})
+	return err
+}
+
+func (lf *LogFile) logAbort(tid TransactionID) error {
+	_, err := lf.append(&logRecord{rtype: AbortRecord, tid: logTid(tid)})
+	return err
+}
+
+// Read the record that was appended at offset off
+func (lf *LogFile) readRecordAt(off int64) (*logRecord, error) {
+	lf.Lock()
+	defer lf.Unlock()
+	hdr := make([]byte, logFrameHeaderSize)
+	if _, err := lf.file.ReadAt(hdr, off); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(hdr[0:4]))
+	if _, err := lf.file.ReadAt(body, off+logFrameHeaderSize); err != nil {
+		return nil, err
+	}
+	r, err := readLogRecord(body)
+	if err != nil {
+		return nil, err
+	}
+	r.lsn = off
+	return r, nil
+}
+
+func (lf *LogFile) logCheckpoint(cp *checkpointTables) (int64, error) {
+	return lf.append(&logRecord{rtype: CheckpointRecord, checkpoint: cp})
+}
+
+// Discard the log before offset cut, which must be the start of a record.  The
+// rest of the log is copied to a new file that atomically replaces the old
+// one, so a crash during truncation leaves either the old or the new log.
+// Offsets of the remaining records decrease by cut.
+func (lf *LogFile) truncateBefore(cut int64) error {
+	lf.Lock()
+	defer lf.Unlock()
+	if cut <= 0 {
+		return nil
+	}
+	tail := make([]byte, lf.size-cut)
+	if _, err := lf.file.ReadAt(tail, cut); err != nil && err != io.EOF {
+		return err
+	}
+	tmpName := lf.Filename + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(tail); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpName, lf.Filename); err != nil {
+		tmp.Close()
+		return err
+	}
+	lf.file.Close()
+	lf.file = tmp
+	lf.size -= cut
+	if dir, err := os.Open(filepath.Dir(lf.Filename)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Force all appended records to disk
+func (lf *LogFile) force() error {
+	lf.Lock()
+	defer lf.Unlock()
+	return lf.file.Sync()
+}
+
+// Read every complete record in the log.  Also returns the offset just past the
+// last complete record; anything after it is a torn write from a crash.
+func (lf *LogFile) readRecords() ([]*logRecord, int64, error) {
+	records := make([]*logRecord, 0)
+	var off int64 = 0
+	hdr := make([]byte, logFrameHeaderSize)
+	for {
+		if _, err := lf.file.ReadAt(hdr, off); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return records, off, nil
+			}
+			return nil, 0, err
+		}
+		bodyLen := binary.LittleEndian.Uint32(hdr[0:4])
+		sum := binary.LittleEndian.Uint32(hdr[4:8])
+		if int64(bodyLen) > lf.size-off-logFrameHeaderSize {
+			return records, off, nil
+		}
+		body := make([]byte, bodyLen)
+		if _, err := lf.file.ReadAt(body, off+logFrameHeaderSize); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return records, off, nil
+			}
+			return nil, 0, err
+		}
+		if crc32.ChecksumIEEE(body) != sum {
+			return records, off, nil
+		}
+		r, err := readLogRecord(body)
+		if err != nil {
+			return records, off, nil
+		}
+		r.lsn = off
+		records = append(records, r)
+		off += logFrameHeaderSize + int64(bodyLen)
+	}
+}
+
+// Write a raw page image to the data file the page belongs to
+func writePageImage(page heapHash, img []byte) error {
+	file, err := os.OpenFile(page.FileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteAt(img, int64(page.PageNo*PageSize))
+	return err
+}
+
+// Read the raw image of a page from the data file it belongs to, or return nil
+// if the file ends before it
+func readPageImage(page heapHash) ([]byte, error) {
+	file, err := os.OpenFile(page.FileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readImage(file, page)
+}
+
+func readImage(file *os.File, page heapHash) ([]byte, error) {
+	img := make([]byte, PageSize)
+	if _, err := file.ReadAt(img, int64(page.PageNo*PageSize)); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// Undo an insert or delete record on the raw image of its page
+func undoTupleUpdate(img []byte, r *logRecord) error {
+	if r.rtype == InsertRecord {
+		return setSlotInImage(img, r.slot, nil)
+	}
+	return setSlotInImage(img, r.slot, r.tuple)
+}
+
+// pageWriter writes raw page images to data files during recovery, keeping
+// each file open so that it can be synced once recovery is done.
+type pageWriter struct {
+	files map[string]*os.File
+}
+
+func (w *pageWriter) open(page heapHash) (*os.File, error) {
+	file, ok := w.files[page.FileName]
+	if !ok {
+		var err error
+		file, err = os.OpenFile(page.FileName, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+		w.files[page.FileName] = file
+	}
+	return file, nil
+}
+
+func (w *pageWriter) write(page heapHash, img []byte) error {
+	file, err := w.open(page)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(img, int64(page.PageNo*PageSize))
+	return err
+}
+
+// Read the raw image of a page, or return nil if the data file ends before it
+func (w *pageWriter) read(page heapHash) ([]byte, error) {
+	file, err := w.open(page)
+	if err != nil {
+		return nil, err
+	}
+	return readImage(file, page)
+}
+
+func (w *pageWriter) syncAndClose() error {
+	var firstErr error
+	for _, file := range w.files {
+		if err := file.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Bring the data files back to a transaction consistent state after a crash.
+//
+// Recovery runs in two passes over the log:
+//   - redo repeats history, writing every page image in log order, starting
+//     from the redo point of the last checkpoint (see [BufferPool.Checkpoint]);
+//   - undo walks the log backwards and reverts every insert and delete made by
+//     a transaction that has neither a commit nor an abort record, on the raw
+//     images of the pages.  (A transaction that aborted has logged the images
+//     of the pages it rolled back before its abort record.)
+//
+// Once the data files have been synced, every logged change is reflected on
+// disk, so the log is truncated.  The headers of the files named in the log are
+// removed, to be rebuilt from their pages (see row_count.go).
+func (lf *LogFile) Recover() error {
+	lf.Lock()
+	defer lf.Unlock()
+	records, _, err := lf.readRecords()
+	if err != nil {
+		return err
+	}
+
+	finished := make(map[int64]bool)
+	var redoStart int64 = 0
+	for _, r := range records {
+		if r.tid > lf.maxTid {
+			lf.maxTid = r.tid
+		}
+		if r.rtype == CommitRecord || r.rtype == AbortRecord {
+			finished[r.tid] = true
+		}
+		if r.rtype == CheckpointRecord {
+			redoStart = r.lsn - r.checkpoint.redoBack
+		}
+	}
+
+	w := &pageWriter{make(map[string]*os.File)}
+	for _, r := range records {
+		if r.lsn < redoStart {
+			continue
+		}
+		var err error
+		switch r.rtype {
+		case UpdateRecord:
+			err = w.write(r.page, r.image)
+		case IndexUpdateRecord:
+			for i := 0; i < len(r.pages) && err == nil; i++ {
+				err = w.write(r.pages[i], r.images[i])
+			}
+		}
+		if err != nil {
+			w.syncAndClose()
+			return err
+		}
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if (r.rtype != InsertRecord && r.rtype != DeleteRecord) || finished[r.tid] {
+			continue
+		}
+		img, err := w.read(r.page)
+		if err == nil && img != nil {
+			if err = undoTupleUpdate(img, r); err == nil {
+				err = w.write(r.page, img)
+			}
+		}
+		if err != nil {
+			w.syncAndClose()
+			return err
+		}
+	}
+	if err := w.syncAndClose(); err != nil {
+		return err
+	}
+	// the row counts of the files may not match their recovered pages
+	logged := make(map[string]bool)
+	for _, r := range records {
+		if r.rtype == UpdateRecord || r.rtype == InsertRecord || r.rtype == DeleteRecord {
+			logged[r.page.FileName] = true
+		}
+	}
+	for fileName := range logged {
+		removeHeaderFile(fileName)
+	}
+
+	if lf.size > 0 {
+		if err := lf.file.Truncate(0); err != nil {
+			return err
+		}
+		lf.size = 0
+		return lf.file.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package godb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The log of the current test, in a temporary directory (see useTestLog)
+var TestingLogFile = "test.log"
+
+// Point TestingLogFile at a new log in a temporary directory for the rest of
+// the test
+func useTestLog(t *testing.T) {
+	TestingLogFile = filepath.Join(t.TempDir(), "test.log")
+	t.Cleanup(func() { TestingLogFile = "test.log" })
+}
+
+func makeLoggedTestVars(t *testing.T) (TupleDesc, Tuple, *HeapFile, *BufferPool) {
+	td, t1, _, _, _, _ := makeTestVars(t)
+	os.Remove(TestingFile)
+	useTestLog(t)
+	bp := NewBufferPool(20)
+	if err := bp.OpenLog(TestingLogFile); err != nil {
+		t.Fatalf("failed to open log: %s", err)
+	}
+	hf, err := NewHeapFile(TestingFile, &td, bp)
+	if err != nil {
+		t.Fatalf("failed to create heap file: %s", err)
+	}
+	return td, t1, hf, bp
+}
+
+func insertAndCommit(t *testing.T, hf *HeapFile, bp *BufferPool, tup Tuple, n int) {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < n; i++ {
+		if err := hf.insertTuple(&tup, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+}
+
+// Reopen the heap file in a fresh buffer pool, as after a restart, and return
+// how many tuples it contains once the log has been recovered.
+func countAfterRecovery(t *testing.T, td *TupleDesc) int {
+	bp := NewBufferPool(20)
+	if err := bp.OpenLog(TestingLogFile); err != nil {
+		t.Fatalf("recovery failed: %s", err)
+	}
+	defer bp.logFile.Close()
+	hf, err := NewHeapFile(TestingFile, td, bp)
+	if err != nil {
+		t.Fatalf("failed to reopen heap file: %s", err)
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf("iterator failed: %s", err)
+	}
+	cnt := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("iterator failed: %s", err)
+		}
+		if tup == nil {
+			break
+		}
+		cnt++
+	}
+	return cnt
+}
+
+func TestLogRecordRoundTrip(t *testing.T) {
+	_, _, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	tid := NewTID()
+	img := make([]byte, PageSize)
+	img[0] = 7
+	bp.logFile.logPageImage(hf.pageKey(3).(heapHash), img)
+	bp.logFile.logTupleUpdate(DeleteRecord, tid, hf.pageKey(2).(heapHash), 5, []byte{1, 2, 3})
+	bp.logFile.logCommit(tid)
+	bp.logFile.logAbort(tid)
+
+	records, end, err := bp.logFile.readRecords()
+	if err != nil {
+		t.Fatalf("failed to read log: %s", err)
+	}
+	if end != bp.logFile.Size() {
+		t.Errorf("expected log to end at %d, got %d", bp.logFile.Size(), end)
+	}
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(records))
+	}
+	r := records[0]
+	if r.rtype != UpdateRecord || r.page != hf.pageKey(3) || r.image[0] != 7 {
+		t.Errorf("update record did not round trip")
+	}
+	r = records[1]
+	if r.rtype != DeleteRecord || r.tid != int64(tid) || r.page != hf.pageKey(2) || r.slot != 5 || !bytes.Equal(r.tuple, []byte{1, 2, 3}) {
+		t.Errorf("delete record did not round trip")
+	}
+	if records[2].rtype != CommitRecord || records[3].rtype != AbortRecord {
+		t.Errorf("commit and abort records did not round trip")
+	}
+}
+
+func TestLogTornTailIgnored(t *testing.T) {
+	_, _, _, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	tid := NewTID()
+	bp.logFile.logCommit(tid)
+	size := bp.logFile.Size()
+	bp.logFile.logCommit(tid)
+	for cut := size; cut < bp.logFile.Size(); cut++ {
+		os.Truncate(TestingLogFile, cut)
+		bp.logFile.size = cut
+		records, end, _ := bp.logFile.readRecords()
+		if len(records) != 1 || end != size {
+			t.Fatalf("torn record at %d should be ignored", cut)
+		}
+	}
+}
+
+func TestLogCommitWritesLog(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, 10)
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 10 {
+		t.Errorf("expected 10 tuples after recovery, got %d", cnt)
+	}
+	info, _ := os.Stat(TestingLogFile)
+	if info.Size() != 0 {
+		t.Errorf("expected log to be truncated after recovery")
+	}
+}
+
+// Simulate crashes while the second of two transactions is committing, or
+// while its pages are written back, by truncating the log at many offsets,
+// combined with data files that were written partially (torn) or cut short.
+// Recovery must always produce either the state before or after the second
+// transaction.
+func TestLogRecoveryAfterCrash(t *testing.T) {
+	const n1, n2 = 150, 300
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	insertAndCommit(t, hf, bp, t1, n1)
+	bp.logFile.Close()
+	committed, _ := os.ReadFile(TestingFile)
+
+	// the second transaction runs after a restart, which recovers (and so
+	// truncates) the log; it spans several pages, some of them new
+	bp = NewBufferPool(20)
+	if err := bp.OpenLog(TestingLogFile); err != nil {
+		t.Fatalf("failed to open log: %s", err)
+	}
+	hf, _ = NewHeapFile(TestingFile, &td, bp)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < n2; i++ {
+		if err := hf.insertTuple(&t1, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+	}
+	beforeCommit, _ := os.ReadFile(TestingFile)
+	logStart := bp.logFile.Size()
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	bp.FlushAllPages()
+	bp.logFile.Close()
+	afterCommit, _ := os.ReadFile(TestingFile)
+	log, _ := os.ReadFile(TestingLogFile)
+	// the commit record is the last one in the log
+	commitRecord := (&logRecord{rtype: CommitRecord, tid: logTid(tid)}).toBuffer()
+	commitStart := int64(len(log)) - logFrameHeaderSize - int64(commitRecord.Len())
+
+	offsets := []int64{logStart, logStart + 1, commitStart - 1, commitStart, commitStart + 5, int64(len(log)) - 1, int64(len(log))}
+	for off := logStart; off < commitStart; off += 1531 {
+		offsets = append(offsets, off)
+	}
+	for _, cut := range offsets {
+		var datas [][]byte
+		if cut < commitStart {
+			// WAL: no page is written before all of its update records are forced
+			datas = [][]byte{beforeCommit}
+		} else {
+			for j := 0; j <= len(afterCommit); j += PageSize + PageSize/3 {
+				torn := append([]byte{}, afterCommit[:j]...)
+				torn = append(torn, beforeCommit[j:]...)
+				datas = append(datas, torn)
+				// pages of the first transaction are no longer in the log, so
+				// only the part of the file written by the second one may be
+				// cut short
+				if j >= len(committed) {
+					datas = append(datas, afterCommit[:j])
+				}
+			}
+		}
+		expected := n1
+		if cut == int64(len(log)) {
+			expected = n1 + n2
+		}
+		for _, data := range datas {
+			os.WriteFile(TestingLogFile, log[:cut], 0666)
+			os.WriteFile(TestingFile, data, 0666)
+			if cnt := countAfterRecovery(t, &td); cnt != expected {
+				t.Fatalf("log cut at %d, data file of %d bytes: expected %d tuples, got %d", cut, len(data), expected, cnt)
+			}
+		}
+	}
+}
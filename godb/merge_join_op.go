@@ -0,0 +1,317 @@
+package godb
+
+// SortMergeJoin is an equality join of two inputs that returns its tuples in
+// ascending order of the join value, merging the inputs in that order.  An
+// input that is not already sorted on its join expression (see sortedOn) is
+// sorted first, with an external sort that spills to disk.  Unlike an
+// [EqualityJoin], it joins values of any type that may be ordered (see
+// [compareDBValues]).
+type SortMergeJoin struct {
+	left, right Operator
+	// Expressions that when applied to tuples from the left or right operators,
+	// respectively, return the value of the left or right side of the join
+	leftField, rightField Expr
+	// whether the left or right input already returns its tuples in
+	// ascending order of the value of its join expression
+	leftSorted, rightSorted bool
+
+	// The maximum number of tuples the join holds in memory, in sorting its
+	// inputs and in runs of right tuples of the same join value
+	maxBufferSize int
+}
+
+// Construct a SortMergeJoin of left and right on leftField = rightField.
+// Returns a TypeMismatchError if values of the expressions cannot be compared
+// with each other.
+func NewSortMergeJoin(left Operator, leftField Expr, right Operator, rightField Expr, maxBufferSize int) (*SortMergeJoin, error) {
+	l, r := leftField.GetExprType().Ftype, rightField.GetExprType().Ftype
+	if !(l.isNumeric() && r.isNumeric()) && l.kind() != r.kind() {
+		return nil, GoDBError{TypeMismatchError, "can't join fields of different types"}
+	}
+	return &SortMergeJoin{left, right, leftField, rightField, sortedOn(left, leftField), sortedOn(right, rightField), maxBufferSize}, nil
+}
+
+// Return true if op is known to return its tuples in ascending order of the
+// values of field, a column of its tuples, other than those whose value is
+// NULL: it is an [IndexScan] of a B+ tree index on the column, a
+// [SortMergeJoin] on the column, an [OrderBy] whose first key is the column in
+// ascending order, or a [Filter] of one of these.  B+ tree indexes order
+// strings and byte strings by their first maxIndexKeyLength bytes only, so
+// scans of them are not known to be sorted.
+func sortedOn(op Operator, field Expr) bool {
+	fe, ok := field.(*FieldExpr)
+	if !ok {
+		return false
+	}
+	switch op := op.(type) {
+	case *IndexScan:
+		column := op.table.Descriptor().Fields[op.index.field]
+		k := column.Ftype.kind()
+		return op.index.Method == BTreeIndex && k != StringType && k != BytesType && sameField(fe, column)
+	case *SortMergeJoin:
+		for _, e := range []Expr{op.leftField, op.rightField} {
+			if joined, ok := e.(*FieldExpr); ok && sameField(fe, joined.selectField) {
+				return true
+			}
+		}
+	case *OrderBy:
+		if len(op.orderBy) == 0 {
+			return false
+		}
+		if first, ok := op.orderBy[0].(*FieldExpr); ok && op.asc[0] {
+			return sameField(fe, first.selectField)
+		}
+	case *Filter[int64]:
+		return sortedOn(op.child, field)
+	case *Filter[string]:
+		return sortedOn(op.child, field)
+	case *Filter[float64]:
+		return sortedOn(op.child, field)
+	}
+	return false
+}
+
+// Return true if fe is the field f, where a field with no table qualifier
+// matches a field of any table
+func sameField(fe *FieldExpr, f FieldType) bool {
+	q := fe.selectField.TableQualifier
+	return fe.selectField.Fname == f.Fname && (q == "" || f.TableQualifier == "" || q == f.TableQualifier)
+}
+
+// [Operator] descriptor method: the fields of the left input followed by those
+// of the right one
+func (j *SortMergeJoin) Descriptor() *TupleDesc {
+	return j.left.Descriptor().merge(j.right.Descriptor())
+}
+
+// Return the input of a join of the tuples op returns whose value of field is
+// not NULL, in ascending order of the value, sorting them with at most
+// maxTuples tuples in memory on behalf of tid unless sorted is true
+func sortedInput(op Operator, field Expr, sorted bool, maxTuples int, tid TransactionID) (*mergeInput, error) {
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	notNull := func() (*Tuple, error) {
+		for {
+			t, err := iter()
+			if t == nil || err != nil {
+				return nil, err
+			}
+			v, err := field.EvalExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			if !isNull(v) {
+				return t, nil
+			}
+		}
+	}
+	if sorted {
+		return &mergeInput{iter: notNull, close: func() {}, field: field}, nil
+	}
+	s := &tupleSorter{
+		desc: op.Descriptor(),
+		tid:  tid,
+		keys: func(t *Tuple) ([]DBValue, error) {
+			v, err := field.EvalExpr(t)
+			return []DBValue{v}, err
+		},
+		compare: func(a []DBValue, b []DBValue) (int, error) {
+			return compareDBValues(a[0], b[0])
+		},
+		maxTuples: maxTuples,
+	}
+	next, close, err := s.sort(notNull)
+	if err != nil {
+		return nil, err
+	}
+	return &mergeInput{iter: next, close: close, field: field}, nil
+}
+
+// [Operator] iterator method: return a function that iterates through the
+// joined tuples.  Both inputs are read in ascending order of their join
+// values, sorting each with at most half of maxBufferSize tuples in memory
+// unless it is already sorted, and the tuples of the one with the smaller
+// value are skipped until the values are equal.  Then the run of right tuples
+// of that value is read, spilling those beyond the first half of
+// maxBufferSize to disk, and each left tuple of the value is joined with every
+// tuple of the run.  Tuples whose join value is NULL join with nothing.
+func (j *SortMergeJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	half := joinBufferSize(j.maxBufferSize) / 2
+	left, err := sortedInput(j.left, j.leftField, j.leftSorted, half, tid)
+	if err != nil {
+		return nil, err
+	}
+	right, err := sortedInput(j.right, j.rightField, j.rightSorted, half, tid)
+	if err != nil {
+		left.close()
+		return nil, err
+	}
+	run := &tupleRun{desc: j.right.Descriptor(), tid: tid, maxTuples: half}
+	// remove the spill files of the join, once it ends or fails, or else once
+	// tid commits or aborts
+	done := func() {
+		left.close()
+		right.close()
+		run.clear()
+	}
+	if err := left.advance(); err != nil {
+		done()
+		return nil, err
+	}
+	if err := right.advance(); err != nil {
+		done()
+		return nil, err
+	}
+	var matches func() (*Tuple, error)
+	return func() (*Tuple, error) {
+		for {
+			if matches != nil {
+				m, err := matches()
+				if err != nil {
+					done()
+					return nil, err
+				}
+				if m != nil {
+					return joinTuples(left.t, m), nil
+				}
+				matches = nil
+				if err := left.advance(); err != nil {
+					done()
+					return nil, err
+				}
+				if left.t != nil {
+					cmp, err := compareDBValues(left.v, run.v)
+					if err == nil && cmp == 0 {
+						matches, err = run.iterator()
+					}
+					if err != nil {
+						done()
+						return nil, err
+					}
+					if matches != nil {
+						continue
+					}
+				}
+				run.clear()
+			}
+			if left.t == nil || right.t == nil {
+				done()
+				return nil, nil
+			}
+			cmp, err := compareDBValues(left.v, right.v)
+			switch {
+			case err != nil:
+			case cmp < 0:
+				err = left.advance()
+			case cmp > 0:
+				err = right.advance()
+			default:
+				matches, err = readRun(right, run)
+			}
+			if err != nil {
+				done()
+				return nil, err
+			}
+		}
+	}, nil
+}
+
+// Read the tuples of right whose join value is that of its current tuple into
+// run, and return a function that iterates through them
+func readRun(right *mergeInput, run *tupleRun) (func() (*Tuple, error), error) {
+	run.v = right.v
+	for right.t != nil {
+		cmp, err := compareDBValues(right.v, run.v)
+		if err != nil {
+			return nil, err
+		}
+		if cmp != 0 {
+			break
+		}
+		if err := run.add(right.t); err != nil {
+			return nil, err
+		}
+		if err := right.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return run.iterator()
+}
+
+// An input of a SortMergeJoin, and its current tuple and the join value of it
+type mergeInput struct {
+	iter func() (*Tuple, error)
+	// Remove the spill files of the input, if it was sorted
+	close func()
+	field Expr
+	// current tuple, or nil once the input has ended
+	t *Tuple
+	v DBValue
+}
+
+// Read the next tuple of the input
+func (in *mergeInput) advance() error {
+	t, err := in.iter()
+	if t == nil || err != nil {
+		in.t, in.v = nil, nil
+		return err
+	}
+	v, err := in.field.EvalExpr(t)
+	if err != nil {
+		return err
+	}
+	in.t, in.v = t, v
+	return nil
+}
+
+// The tuples of one side of a join that have the same join value v, of which
+// the first maxTuples are held in memory and the rest in a spill file, written
+// on behalf of tid
+type tupleRun struct {
+	desc      *TupleDesc
+	tid       TransactionID
+	maxTuples int
+	v         DBValue
+	tuples    []*Tuple
+	spill     *spillFile
+}
+
+// Add t to the run
+func (r *tupleRun) add(t *Tuple) error {
+	if len(r.tuples) < r.maxTuples {
+		r.tuples = append(r.tuples, t)
+		return nil
+	}
+	if r.spill == nil {
+		var err error
+		if r.spill, err = newSpillFile(r.desc, r.tid); err != nil {
+			return err
+		}
+	}
+	return r.spill.append(t)
+}
+
+// Return a function that iterates through the tuples of the run, in the order
+// they were added
+func (r *tupleRun) iterator() (func() (*Tuple, error), error) {
+	next := concatIterators(r.tuples, func() (*Tuple, error) { return nil, nil })
+	if r.spill != nil {
+		spilled, err := r.spill.iterator()
+		if err != nil {
+			return nil, err
+		}
+		next = concatIterators(r.tuples, spilled)
+	}
+	return next, nil
+}
+
+// Empty the run, removing its spill file
+func (r *tupleRun) clear() {
+	r.tuples = nil
+	if r.spill != nil {
+		r.spill.remove()
+		r.spill = nil
+	}
+}
@@ -0,0 +1,220 @@
+package godb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Join left and right on their keys with a SortMergeJoin, holding at most
+// maxBufferSize tuples in memory, and return the keys of the joined tuples in
+// the order they were returned.  Checks that the fields of the left input come
+// first.
+func runMergeJoin(t *testing.T, left *tupleListOp, right *tupleListOp, maxBufferSize int) ([]int64, error) {
+	join, err := NewSortMergeJoin(left, &FieldExpr{left.desc.Fields[0]}, right, &FieldExpr{right.desc.Fields[0]}, maxBufferSize)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	iter, err := join.Iterator(NewTID())
+	if err != nil {
+		return nil, err
+	}
+	var keys []int64
+	for {
+		tup, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if tup == nil {
+			return keys, nil
+		}
+		if tup.Fields[1].(StringField).Value != "l" || tup.Fields[3].(StringField).Value != "r" {
+			t.Fatalf("expected the left tuple to come first, got %v", tup.Fields)
+		}
+		if tup.Fields[0] != tup.Fields[2] {
+			t.Fatalf("joined tuples of different keys %v", tup.Fields)
+		}
+		keys = append(keys, tup.Fields[0].(IntField).Value)
+	}
+}
+
+func TestSortMergeJoin(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	var leftKeys, rightKeys []int64
+	for i := int64(0); i < 600; i++ {
+		leftKeys = append(leftKeys, (i*37)%50)
+		rightKeys = append(rightKeys, (i*53)%100)
+	}
+	// NULL joins with nothing, not even NULL
+	leftKeys = append(leftKeys, -1)
+	rightKeys = append(rightKeys, -1)
+	left, right := newTupleListOp("l", leftKeys...), newTupleListOp("r", rightKeys...)
+
+	// a buffer of 20 tuples sorts the inputs in more runs than are merged at
+	// once, and spills runs of duplicates
+	for _, size := range []int{20, 100000} {
+		keys, err := runMergeJoin(t, left, right, size)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(keys) != 50*12*6 {
+			t.Errorf("expected %d joined tuples with a buffer of %d tuples, got %d", 50*12*6, size, len(keys))
+		}
+		for i := 1; i < len(keys); i++ {
+			if keys[i] < keys[i-1] {
+				t.Fatalf("expected joined tuples in order of their keys with a buffer of %d tuples", size)
+			}
+		}
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+
+	// runs of duplicates on both sides
+	same := make([]int64, 300)
+	keys, err := runMergeJoin(t, newTupleListOp("l", same...), newTupleListOp("r", same[:200]...), 25)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(keys) != 300*200 {
+		t.Errorf("expected %d joined tuples, got %d", 300*200, len(keys))
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+}
+
+func TestSortMergeJoinChildErrors(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	var keys []int64
+	for i := int64(0); i < 100; i++ {
+		keys = append(keys, i)
+	}
+	for _, failAt := range []int{0, 60} {
+		for _, size := range []int{10, 1000} {
+			left, right := newTupleListOp("l", keys...), newTupleListOp("r", keys...)
+			right.err, right.failAt = GoDBError{MalformedDataError, "bad page"}, failAt
+			if _, err := runMergeJoin(t, left, right, size); err == nil {
+				t.Errorf("expected an error of the right input after %d tuples to be returned with a buffer of %d tuples", failAt, size)
+			}
+			left.err, left.failAt, right.err = GoDBError{MalformedDataError, "bad page"}, failAt, nil
+			if _, err := runMergeJoin(t, left, right, size); err == nil {
+				t.Errorf("expected an error of the left input after %d tuples to be returned with a buffer of %d tuples", failAt, size)
+			}
+		}
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+}
+
+func TestSortMergeJoinSortedInputs(t *testing.T) {
+	_, hf, idx, _ := makeIndexTestVars(t)
+	insertAges(t, hf, 5, 3, 3, 9, 1)
+	scan, err := NewIndexScan(hf, idx, nil, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	age := &FieldExpr{hf.Descriptor().Fields[1]}
+	if !sortedOn(scan, age) || sortedOn(hf, age) {
+		t.Errorf("expected only the index scan to be sorted on age")
+	}
+	right := newTupleListOp("r", 9, 3, 7, 1, 3)
+	join, err := NewSortMergeJoin(scan, age, right, &FieldExpr{right.desc.Fields[0]}, 10)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !join.leftSorted || join.rightSorted {
+		t.Errorf("expected only the left input not to be sorted by the join")
+	}
+	if !sortedOn(join, age) {
+		t.Errorf("expected the join to be sorted on its key")
+	}
+	tid := NewTID()
+	hf.bufPool.BeginTransaction(tid)
+	defer hf.bufPool.CommitTransaction(tid)
+	iter, err := join.Iterator(tid)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var got []int64
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if tup == nil {
+			break
+		}
+		got = append(got, tup.Fields[1].(IntField).Value)
+	}
+	want := []int64{1, 3, 3, 3, 3, 9}
+	if len(got) != len(want) {
+		t.Fatalf("expected keys %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected keys %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSortMergeJoinPlans(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte("f (name string, age int, score float)\ng (age int, label string, score float)\n"), 0644)
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(20), dir)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %s", err)
+	}
+	defer c.bp.logFile.Close()
+	runTestQuery(t, c, "insert into f values ('sam', 25, 1.5), ('joe', 30, 2.5), ('bob', 30, 1.5), ('ann', 41, 4.0)")
+	runTestQuery(t, c, "insert into g values (30, 'thirty', 1.5), (41, 'forty one', 3.0), (50, 'fifty', 2.5)")
+
+	query := "select f.name, g.label from f, g where f.age = g.age"
+	if _, plan, err := Parse(c, query); err != nil || planUses[*SortMergeJoin](plan) {
+		t.Errorf("expected a hash join of unsorted inputs")
+	}
+	query = "select f.name, f.age, g.label from f, g where f.age = g.age order by f.age"
+	_, plan, err := Parse(c, query)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !planUses[*SortMergeJoin](plan) {
+		t.Errorf("expected a merge join ahead of an order by on the join key")
+	}
+	if got := runTestQuery(t, c, query); len(got) != 3 {
+		t.Errorf("expected 3 joined tuples, got %d", len(got))
+	}
+
+	// inputs sorted by B+ tree indexes
+	if _, _, err := Parse(c, "create index f_age on f (age)"); err != nil {
+		t.Fatalf("failed to create index: %s", err)
+	}
+	if _, _, err := Parse(c, "create index g_age on g (age)"); err != nil {
+		t.Fatalf("failed to create index: %s", err)
+	}
+	query = "select f.name, g.label from f, g where f.age = g.age and f.age > 0 and g.age > 0"
+	if _, plan, err = Parse(c, query); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !planUses[*SortMergeJoin](plan) {
+		t.Errorf("expected a merge join of inputs sorted by their indexes")
+	}
+	if got := runTestQuery(t, c, query); len(got) != 3 {
+		t.Errorf("expected 3 joined tuples, got %d", len(got))
+	}
+
+	// only a merge join joins numbers other than integers
+	query = "select f.name, g.label from f, g where f.score = g.score"
+	if _, plan, err = Parse(c, query); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !planUses[*SortMergeJoin](plan) {
+		t.Errorf("expected a merge join of floats")
+	}
+	if got := runTestQuery(t, c, query); len(got) != 3 {
+		t.Errorf("expected 3 joined tuples, got %d", len(got))
+	}
+}
@@ -0,0 +1,204 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Heap files written in an older page format (see [heapPage]) are rewritten in
+// the current format by MigrateHeapFile.  Pages written before pages carried a
+// magic number and format version are in one of two formats:
+//
+//   - Fixed size tuples: a header with the number of slots and the number of
+//     used slots, followed by the used tuples, one after the other.  Integers
+//     take 8 bytes, and strings StringLength bytes, padded with the character
+//     '0'.  Trailing '0's were stripped from strings as they were read, so
+//     strings that ended in '0' were already corrupted when they were written,
+//     and are migrated without them.
+//   - Slotted pages like the current ones, with no magic number or version, so
+//     with an 8 byte header, and tuples with no bitmap of NULL fields.
+//
+// Pages of version 1 are slotted pages like the current ones, but with tuples
+// with no bitmap of NULL fields.
+
+// size of the header of pages with no magic number
+const unversionedHeaderSize = 8
+
+// Rewrite the heap file stored in fileName, whose tuples are described by desc,
+// in the current page format, if any of its pages are in an older format.
+// Returns whether the file was rewritten.
+//
+// Tuples keep their order, but may move to other pages, since tuples that fit
+// on a page in the fixed size format do not always fit on a slotted page.  So
+// the file must not be in use, through a buffer pool or a log, while it is
+// migrated.  Values stored out of line are left in the overflow file.
+func MigrateHeapFile(fileName string, desc *TupleDesc) (bool, error) {
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(data)%PageSize != 0 {
+		return false, GoDBError{MalformedDataError, fmt.Sprintf("%s is not a whole number of pages", fileName)}
+	}
+	outdated := false
+	for off := 0; off < len(data); off += PageSize {
+		img := data[off : off+PageSize]
+		if !isZeroImage(img) && checkImageFormat(img) != nil {
+			outdated = true
+			break
+		}
+	}
+	if !outdated {
+		return false, nil
+	}
+
+	tmpName := fileName + ".migrate"
+	out, err := os.Create(tmpName)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmpName)
+	defer out.Close()
+	page := newHeapPage(desc, 0, nil)
+	flush := func() error {
+		buf, err := page.toBuffer()
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(buf.Bytes())
+		page = newHeapPage(desc, page.pageNo+1, nil)
+		return err
+	}
+	for off := 0; off < len(data); off += PageSize {
+		tuples, err := imageTuples(data[off:off+PageSize], desc)
+		if err != nil {
+			return false, err
+		}
+		for _, t := range tuples {
+			_, err := page.insertTuple(t)
+			if err != nil && page.hdr.useds > 0 {
+				// start a new page, where the tuple fits unless it is too large
+				if err := flush(); err != nil {
+					return false, err
+				}
+				_, err = page.insertTuple(t)
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	if page.hdr.useds > 0 {
+		if err := flush(); err != nil {
+			return false, err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmpName, fileName)
+}
+
+// Return the tuples stored on a raw page image in any format, in slot order,
+// with values stored out of line left as references
+func imageTuples(img []byte, desc *TupleDesc) ([]*Tuple, error) {
+	if isZeroImage(img) {
+		return nil, nil
+	}
+	if binary.LittleEndian.Uint16(img[0:2]) == pageMagic {
+		if version := binary.LittleEndian.Uint16(img[2:4]); version == 1 {
+			if tuples, ok := oldSlottedTuples(img, pageHeaderSize, desc); ok {
+				return tuples, nil
+			}
+			return nil, GoDBError{MalformedDataError, "slot directory is not consistent"}
+		}
+		page := newHeapPage(desc, 0, nil)
+		if err := page.initFromBuffer(bytes.NewBuffer(img)); err != nil {
+			return nil, err
+		}
+		var tuples []*Tuple
+		for i, t := range page.tuples {
+			if page.used[i] {
+				tuples = append(tuples, t)
+			}
+		}
+		return tuples, nil
+	}
+	if tuples, ok := oldSlottedTuples(img, unversionedHeaderSize, desc); ok {
+		return tuples, nil
+	}
+	return fixedSizeTuples(img, desc)
+}
+
+// Return the tuples of a slotted page image whose tuples have no bitmap of NULL
+// fields, and whose header, of the specified size, ends with the number of
+// slots and of used slots, or false if the image is not a consistent slotted
+// page
+func oldSlottedTuples(img []byte, headerSize int, desc *TupleDesc) ([]*Tuple, bool) {
+	slots := int(int32(binary.LittleEndian.Uint32(img[headerSize-8:])))
+	useds := int(int32(binary.LittleEndian.Uint32(img[headerSize-4:])))
+	dirEnd := headerSize + slots*slotEntrySize
+	if slots < 0 || dirEnd > PageSize {
+		return nil, false
+	}
+	var tuples []*Tuple
+	for i := 0; i < slots; i++ {
+		entry := img[headerSize+i*slotEntrySize:]
+		off := int(binary.LittleEndian.Uint16(entry[0:2]))
+		length := int(binary.LittleEndian.Uint16(entry[2:4]))
+		if off == 0 {
+			continue
+		}
+		if off < dirEnd || off+length > PageSize {
+			return nil, false
+		}
+		b := bytes.NewBuffer(img[off : off+length])
+		t, err := readFieldsFrom(b, desc, nil)
+		if err != nil || b.Len() != 0 {
+			return nil, false
+		}
+		tuples = append(tuples, t)
+	}
+	return tuples, len(tuples) == useds
+}
+
+// Return the tuples of a page image in the fixed size format
+func fixedSizeTuples(img []byte, desc *TupleDesc) ([]*Tuple, error) {
+	size := 0
+	for _, f := range desc.Fields {
+		switch f.Ftype {
+		case IntType:
+			size += 8
+		case StringType:
+			size += StringLength
+		default:
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("no fixed size format for field %s", f.Fname)}
+		}
+	}
+	useds := int(int32(binary.LittleEndian.Uint32(img[4:8])))
+	if useds < 0 || unversionedHeaderSize+useds*size > PageSize {
+		return nil, GoDBError{MalformedDataError, "page is in no known format"}
+	}
+	b := bytes.NewBuffer(img[unversionedHeaderSize:])
+	tuples := make([]*Tuple, 0, useds)
+	for i := 0; i < useds; i++ {
+		t := &Tuple{Desc: *desc.copy()}
+		for _, f := range desc.Fields {
+			if f.Ftype == IntType {
+				t.Fields = append(t.Fields, IntField{int64(binary.LittleEndian.Uint64(b.Next(8)))})
+				continue
+			}
+			t.Fields = append(t.Fields, StringField{string(bytes.TrimRight(b.Next(StringLength), "0"))})
+		}
+		tuples = append(tuples, t)
+	}
+	return tuples, nil
+}
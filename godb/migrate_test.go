@@ -0,0 +1,161 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Return a page image in the fixed size format with the specified tuples of
+// (name string, age int)
+func fixedSizeImage(tuples []Tuple) []byte {
+	size := StringLength + 8
+	img := make([]byte, PageSize)
+	binary.LittleEndian.PutUint32(img[0:4], uint32((PageSize-unversionedHeaderSize)/size))
+	binary.LittleEndian.PutUint32(img[4:8], uint32(len(tuples)))
+	off := unversionedHeaderSize
+	for _, t := range tuples {
+		name := t.Fields[0].(StringField).Value
+		copy(img[off:], name+strings.Repeat("0", StringLength-len(name)))
+		binary.LittleEndian.PutUint64(img[off+StringLength:], uint64(t.Fields[1].(IntField).Value))
+		off += size
+	}
+	return img
+}
+
+// Return the tuples of hf, failing the test if they cannot be read
+func readAll(t *testing.T, hf *HeapFile) []*Tuple {
+	tid := NewTID()
+	hf.bufPool.BeginTransaction(tid)
+	defer hf.bufPool.CommitTransaction(tid)
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var tuples []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if tup == nil {
+			return tuples
+		}
+		tuples = append(tuples, tup)
+	}
+}
+
+func TestMigrateFixedSizeFile(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars(t)
+	// full length strings take more space on slotted pages, so the tuples of a
+	// full page spill onto the next one
+	var tuples []Tuple
+	for i := 0; i < (PageSize-unversionedHeaderSize)/(StringLength+8); i++ {
+		name := strings.Repeat("x", StringLength-1) + "y"
+		tuples = append(tuples, Tuple{Desc: td, Fields: []DBValue{StringField{name}, IntField{int64(i)}}})
+	}
+	tuples = append(tuples, Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{25}}})
+	img := append(fixedSizeImage(tuples[:len(tuples)-1]), fixedSizeImage(tuples[len(tuples)-1:])...)
+	os.Remove(TestingFile)
+	if err := os.WriteFile(TestingFile, img, 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	hf, _ := NewHeapFile(TestingFile, &td, NewBufferPool(3))
+	tid := NewTID()
+	hf.bufPool.BeginTransaction(tid)
+	if _, err := hf.bufPool.GetPage(hf, 0, tid, ReadPerm); err == nil {
+		t.Errorf("expected a page in the fixed size format not to be read")
+	}
+	hf.bufPool.CommitTransaction(tid)
+
+	migrated, err := MigrateHeapFile(TestingFile, &td)
+	if err != nil || !migrated {
+		t.Fatalf("expected the file to be migrated, got %v", err)
+	}
+	hf, _ = NewHeapFile(TestingFile, &td, NewBufferPool(3))
+	got := readAll(t, hf)
+	if len(got) != len(tuples) {
+		t.Fatalf("expected %d tuples, got %d", len(tuples), len(got))
+	}
+	if got[len(got)-2].Rid.(Rid).pageid != 1 {
+		t.Errorf("expected tuples of the first page to spill onto the second")
+	}
+	for i := range tuples {
+		if !got[i].equals(&tuples[i]) {
+			t.Errorf("tuple %d was not migrated intact", i)
+		}
+	}
+	if migrated, err := MigrateHeapFile(TestingFile, &td); err != nil || migrated {
+		t.Errorf("expected a migrated file to be left alone, got %v", err)
+	}
+}
+
+// Return a slotted page image with the specified header size and tuples, with
+// no bitmap of NULL fields, and a magic number and version 1 if the header has
+// room for them
+func oldSlottedImage(tuples []Tuple, headerSize int) []byte {
+	img := make([]byte, PageSize)
+	if headerSize > unversionedHeaderSize {
+		binary.LittleEndian.PutUint16(img[0:2], pageMagic)
+		binary.LittleEndian.PutUint16(img[2:4], 1)
+	}
+	binary.LittleEndian.PutUint32(img[headerSize-8:], uint32(len(tuples)))
+	binary.LittleEndian.PutUint32(img[headerSize-4:], uint32(len(tuples)))
+	end := PageSize
+	for i, t := range tuples {
+		b := new(bytes.Buffer)
+		t.writeTo(b)
+		tup := b.Bytes()[nullBitmapSize(len(t.Fields)):]
+		end -= len(tup)
+		copy(img[end:], tup)
+		entry := img[headerSize+i*slotEntrySize:]
+		binary.LittleEndian.PutUint16(entry[0:2], uint16(end))
+		binary.LittleEndian.PutUint16(entry[2:4], uint16(len(tup)))
+	}
+	return img
+}
+
+func TestMigrateOldSlottedFiles(t *testing.T) {
+	td, t1, t2, _, _, _ := makeTestVars(t)
+	t1.Fields[0] = StringField{"1000"}
+	for _, headerSize := range []int{unversionedHeaderSize, pageHeaderSize} {
+		os.Remove(TestingFile)
+		os.WriteFile(TestingFile, oldSlottedImage([]Tuple{t1, t2}, headerSize), 0644)
+
+		migrated, err := MigrateHeapFile(TestingFile, &td)
+		if err != nil || !migrated {
+			t.Fatalf("expected the file to be migrated, got %v", err)
+		}
+		hf, _ := NewHeapFile(TestingFile, &td, NewBufferPool(3))
+		got := readAll(t, hf)
+		if len(got) != 2 || !got[0].equals(&t1) || !got[1].equals(&t2) {
+			t.Errorf("tuples were not migrated intact from a %d byte header", headerSize)
+		}
+	}
+}
+
+// Loading a catalog migrates the files of its tables
+func TestCatalogMigratesTables(t *testing.T) {
+	_, t1, t2, _, _, _ := makeTestVars(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte("t (name string, age int)\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "t.dat"), fixedSizeImage([]Tuple{t1, t2}), 0644)
+
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(3), dir)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %s", err)
+	}
+	defer c.bp.logFile.Close()
+	hf, err := c.GetTable("t")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	got := readAll(t, hf.(*HeapFile))
+	if len(got) != 2 || !got[0].equals(&t1) || !got[1].equals(&t2) {
+		t.Errorf("expected the table to be migrated")
+	}
+}
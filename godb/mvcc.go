@@ -0,0 +1,250 @@
+package godb
+
+import "time"
+
+// Heap tables are read with multi-version concurrency control: a transaction
+// reads from a snapshot of the database taken when it began (or when its scan
+// started, depending on its isolation level, see isolation.go), so readers take
+// no page or row locks, and never wait for writers or make them wait.  Writers
+// still lock the rows they update (see [LockManager.LockRow]), and a
+// transaction that deletes a row deleted or replaced by a transaction that
+// committed after its snapshot was taken is aborted (first-committer-wins).
+//
+// Pages only hold the newest version of each row.  Older versions that may
+// still be visible to a snapshot are kept in the version store of the
+// BufferPool, along with the transactions that created and deleted them.
+// Snapshots never outlive the process, so the version store is not persisted.
+// Versions that no snapshot can see any more are dead, and are reclaimed by
+// Vacuum.
+
+// How often the background vacuum started by [BufferPool.StartVacuum] runs, by
+// default.
+const DefaultVacuumInterval = time.Second
+
+// A version of a row: the serialized tuple, the transaction that created it,
+// and the transaction that deleted it, if any.  Transaction ids are those
+// written to the log (see logTid); zero stands for a transaction that
+// committed before any running snapshot was taken.
+type tupleVersion struct {
+	tuple   []byte
+	creator int64
+	deleter int64
+}
+
+// Take a snapshot for tid, which sees the transactions that have committed so
+// far
+func (bp *BufferPool) takeSnapshot(tid TransactionID) {
+	bp.snapshots[tid] = bp.commitSeq
+}
+
+// Return true if the changes of transaction id are visible to tid
+func (bp *BufferPool) sees(tid TransactionID, id int64) bool {
+	if id == 0 || id == logTid(tid) {
+		return true
+	}
+	seq, ok := bp.committedAt[id]
+	return ok && seq <= bp.snapshots[tid]
+}
+
+func (bp *BufferPool) versionVisible(tid TransactionID, v *tupleVersion) bool {
+	return bp.sees(tid, v.creator) && (v.deleter == 0 || !bp.sees(tid, v.deleter))
+}
+
+// Return the tuples of the page that are visible to the snapshot of tid, or
+// the newest version of each row if the isolation level of tid does not read
+// from a snapshot (see isolation.go)
+func (bp *BufferPool) visibleTuples(tid TransactionID, page *heapPage) ([]*Tuple, error) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	tuples := make([]*Tuple, 0)
+	for slot := 0; slot < page.numSlots(); slot++ {
+		t, err := bp.visibleSlot(tid, page, slot)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			tuples = append(tuples, t)
+		}
+	}
+	return tuples, nil
+}
+
+// Return the tuple in the specified slot of the page as visibleTuples does, or
+// nil if there is none
+func (bp *BufferPool) visibleTuple(tid TransactionID, page *heapPage, slot int) (*Tuple, error) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	return bp.visibleSlot(tid, page, slot)
+}
+
+func (bp *BufferPool) visibleSlot(tid TransactionID, page *heapPage, slot int) (*Tuple, error) {
+	key := page.file.pageKey(page.pageNo).(heapHash)
+	var tup []byte
+	if versions, ok := bp.versions[rowLockKey{key, slot}]; ok && !bp.isolationLevel(tid).readsNewest() {
+		for _, v := range versions {
+			if bp.versionVisible(tid, v) {
+				tup = v.tuple
+			}
+		}
+	} else if b, err := page.slotBytes(slot); err == nil {
+		tup = b
+	}
+	if tup == nil {
+		return nil, nil
+	}
+	t, err := page.decodeTuple(tup)
+	if err != nil {
+		return nil, err
+	}
+	t.Rid = Rid{page.pageNo, slot}
+	return t, nil
+}
+
+// Return a SerializationError if the newest version of a row was not seen by
+// tid, because it was created or deleted by a transaction that committed after
+// the snapshot of tid was taken (or is still running, if tid does not hold the
+// lock on the row).
+func (bp *BufferPool) checkNewestVisible(tid TransactionID, row rowLockKey) error {
+	versions, ok := bp.versions[row]
+	if !ok {
+		return nil
+	}
+	newest := versions[len(versions)-1]
+	if !bp.sees(tid, newest.creator) || (newest.deleter != 0 && !bp.sees(tid, newest.deleter)) {
+		return GoDBError{SerializationError, "row was updated by a concurrent transaction"}
+	}
+	return nil
+}
+
+// Record the new version of a row created by an insert or delete made by tid,
+// which holds the lock on the row.  tuple is the serialized tuple.  The change
+// is also added to the changes of tid, so that it can be rolled back.
+func (bp *BufferPool) addVersion(tid TransactionID, rtype LogRecordType, row rowLockKey, tuple []byte) {
+	versions, ok := bp.versions[row]
+	if rtype == InsertRecord {
+		versions = append(versions, &tupleVersion{tuple, logTid(tid), 0})
+	} else if !ok {
+		versions = []*tupleVersion{{tuple, 0, logTid(tid)}}
+	} else {
+		versions[len(versions)-1].deleter = logTid(tid)
+	}
+	bp.versions[row] = versions
+	change := &logRecord{rtype: rtype, tid: logTid(tid), page: row.page, slot: row.slot, tuple: tuple}
+	bp.transactionChanges[tid] = append(bp.transactionChanges[tid], change)
+}
+
+// Undo the changes of tid to the version store from its from-th change on,
+// newest first: the versions it created are dropped, and its deletes are
+// undone.  Since tid holds the locks on the rows it changed, its versions are
+// the newest ones.
+func (bp *BufferPool) rollbackVersions(tid TransactionID, from int) {
+	changes := bp.transactionChanges[tid]
+	for i := len(changes) - 1; i >= from; i-- {
+		row := rowLockKey{changes[i].page, changes[i].slot}
+		versions := bp.versions[row]
+		if len(versions) == 0 {
+			continue
+		}
+		if changes[i].rtype == InsertRecord {
+			versions = versions[:len(versions)-1]
+		} else {
+			versions[len(versions)-1].deleter = 0
+		}
+		bp.setVersions(row, versions)
+	}
+	bp.transactionChanges[tid] = changes[:from]
+}
+
+// Store the versions of a row, dropping them if the page alone describes the
+// row to every snapshot
+func (bp *BufferPool) setVersions(row rowLockKey, versions []*tupleVersion) {
+	if len(versions) == 0 || (len(versions) == 1 && versions[0].creator == 0 && versions[0].deleter == 0) {
+		delete(bp.versions, row)
+	} else {
+		bp.versions[row] = versions
+	}
+}
+
+// Record that tid has committed, so that snapshots taken from now on see it,
+// and forget its snapshot
+func (bp *BufferPool) commitVersions(tid TransactionID) {
+	bp.commitSeq++
+	bp.committedAt[logTid(tid)] = bp.commitSeq
+	delete(bp.transactionChanges, tid)
+	bp.endSnapshot(tid)
+}
+
+// Forget the snapshot of tid, which has ended.  Once no transaction is running
+// any more, every version but the newest one is dead, so the version store is
+// emptied right away.
+func (bp *BufferPool) endSnapshot(tid TransactionID) {
+	delete(bp.snapshots, tid)
+	if len(bp.snapshots) == 0 {
+		bp.versions = make(map[rowLockKey][]*tupleVersion)
+		bp.committedAt = make(map[int64]int64)
+	}
+}
+
+// Reclaim dead versions: versions deleted by a transaction that committed
+// before the oldest running snapshot was taken are dropped, and the transactions
+// that committed before it are forgotten, since every snapshot sees them.  The
+// index entries of the tuples no snapshot can see any more are removed as well
+// (see index.go).
+func (bp *BufferPool) Vacuum() {
+	bp.poolLock.Lock()
+	oldest := bp.commitSeq
+	for _, seq := range bp.snapshots {
+		if seq < oldest {
+			oldest = seq
+		}
+	}
+	old := func(id int64) bool {
+		seq, ok := bp.committedAt[id]
+		return ok && seq <= oldest
+	}
+	for row, versions := range bp.versions {
+		kept := make([]*tupleVersion, 0, len(versions))
+		for _, v := range versions {
+			if v.deleter != 0 && old(v.deleter) {
+				continue
+			}
+			if old(v.creator) {
+				v.creator = 0
+			}
+			kept = append(kept, v)
+		}
+		bp.setVersions(row, kept)
+	}
+	for id, seq := range bp.committedAt {
+		if seq <= oldest {
+			delete(bp.committedAt, id)
+		}
+	}
+	dead := bp.takeDeadIndexEntries(oldest)
+	bp.poolLock.Unlock()
+	bp.removeIndexEntries(dead)
+}
+
+// Start a goroutine that runs Vacuum every interval.  The returned function
+// stops it.
+func (bp *BufferPool) StartVacuum(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				bp.Vacuum()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,119 @@
+package godb
+
+import (
+	"testing"
+)
+
+// Count the tuples of hf visible to tid
+func countVisible(t *testing.T, hf *HeapFile, tid TransactionID) int {
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	cnt := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if tup == nil {
+			return cnt
+		}
+		cnt++
+	}
+}
+
+func rowAt(tup Tuple, slot int) *Tuple {
+	tup.Rid = Rid{0, slot}
+	return &tup
+}
+
+func TestSnapshotReaderDoesNotBlockWriter(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	reader, writer := NewTID(), NewTID()
+	bp.BeginTransaction(reader)
+	bp.BeginTransaction(writer)
+	if cnt := countVisible(t, hf, reader); cnt != 10 {
+		t.Fatalf("expected 10 tuples, got %d", cnt)
+	}
+	finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 0), writer) })
+	finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 1), writer) })
+	finishesSoon(t, insertN(hf, t1, writer, 1))
+
+	// the writer sees its own changes, the reader does not
+	if cnt := countVisible(t, hf, writer); cnt != 9 {
+		t.Errorf("expected writer to see 9 tuples, got %d", cnt)
+	}
+	if cnt := countVisible(t, hf, reader); cnt != 10 {
+		t.Errorf("expected reader to see 10 tuples before the commit, got %d", cnt)
+	}
+	if err := bp.CommitTransaction(writer); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	if cnt := countVisible(t, hf, reader); cnt != 10 {
+		t.Errorf("expected reader to see 10 tuples after the commit, got %d", cnt)
+	}
+
+	later := NewTID()
+	bp.BeginTransaction(later)
+	if cnt := countVisible(t, hf, later); cnt != 9 {
+		t.Errorf("expected a new snapshot to see 9 tuples, got %d", cnt)
+	}
+}
+
+func TestFirstCommitterWins(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	tid1, tid2 := NewTID(), NewTID()
+	bp.BeginTransaction(tid1)
+	bp.BeginTransaction(tid2)
+	finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 0), tid1) })
+	if err := bp.CommitTransaction(tid1); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+
+	err := hf.deleteTuple(rowAt(t1, 0), tid2)
+	if gerr, ok := err.(GoDBError); !ok || gerr.code != SerializationError {
+		t.Fatalf("expected a serialization error, got %v", err)
+	}
+	// deleting a row nobody else changed is fine for a new transaction
+	tid3 := NewTID()
+	bp.BeginTransaction(tid3)
+	finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 1), tid3) })
+}
+
+func TestVacuumReclaimsVersions(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	reader, writer := NewTID(), NewTID()
+	bp.BeginTransaction(reader)
+	bp.BeginTransaction(writer)
+	finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 0), writer) })
+	if err := bp.CommitTransaction(writer); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+
+	// the deleted version is still visible to reader
+	later := NewTID()
+	bp.BeginTransaction(later)
+	bp.Vacuum()
+	if len(bp.versions) != 1 {
+		t.Fatalf("expected the deleted version to be kept, got %d rows", len(bp.versions))
+	}
+	if cnt := countVisible(t, hf, reader); cnt != 10 {
+		t.Errorf("expected reader to see 10 tuples, got %d", cnt)
+	}
+
+	bp.CommitTransaction(reader)
+	bp.Vacuum()
+	if len(bp.versions) != 0 {
+		t.Errorf("expected vacuum to reclaim the dead version, got %d rows", len(bp.versions))
+	}
+	if cnt := countVisible(t, hf, later); cnt != 9 {
+		t.Errorf("expected 9 tuples, got %d", cnt)
+	}
+}
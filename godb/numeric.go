@@ -0,0 +1,462 @@
+package godb
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Besides integers, fields may hold floating point numbers (FloatType) and
+// exact fixed point numbers (DECIMAL(p,s), with p digits, s of them after the
+// decimal point).
+//
+// A FloatField holds a 64 bit IEEE floating point number.  A DecimalField holds
+// its value multiplied by 10^Scale, so 1.50 is DecimalField{150, 2} in a
+// DECIMAL(p,2) field.  So that the values fit in 64 bits, the precision of a
+// decimal is at most maxDecimalPrecision.  Both are stored as 8 bytes, the
+// scale of a decimal being that of its field.
+//
+// When numbers of different types are compared or combined, they are first
+// coerced to the more general of the two types: an integer is a decimal with
+// a scale of 0, and integers and decimals are converted to floats.  So
+// comparing an integer with a decimal is exact, while comparing a decimal
+// with a float is only as exact as the float.  Values inserted into a field
+// are coerced to the type of the field, rounding them half away from zero to
+// its scale, and failing if they have too many digits for its precision.
+
+// The largest precision of a decimal
+const maxDecimalPrecision = 18
+
+// The precision and scale of a DECIMAL without any
+const (
+	defaultDecimalPrecision = 10
+	defaultDecimalScale     = 0
+)
+
+// The number of digits that the division of a decimal adds to its scale
+const divisionScaleIncrement = 4
+
+// Return the type of DECIMAL(precision, scale) fields, which is DecimalType
+// with the precision and scale in its higher bits
+func decimalType(precision int, scale int) DBType {
+	return DecimalType | DBType(precision)<<8 | DBType(scale)<<16
+}
+
+// Return the type t without the precision and scale of decimals, e.g.,
+// DecimalType for any decimal type
+func (t DBType) kind() DBType {
+	return t & 0xff
+}
+
+// Return the number of digits of values of type t, a decimal or an integer
+func (t DBType) precision() int {
+	if t.kind() != DecimalType {
+		return maxDecimalPrecision
+	}
+	return int(t>>8) & 0xff
+}
+
+// Return the number of digits after the decimal point of values of type t, a
+// decimal or an integer
+func (t DBType) scale() int {
+	if t.kind() != DecimalType {
+		return 0
+	}
+	return int(t>>16) & 0xff
+}
+
+// Return whether values of type t are numbers
+func (t DBType) isNumeric() bool {
+	switch t.kind() {
+	case IntType, FloatType, DecimalType:
+		return true
+	}
+	return false
+}
+
+// Return the name of type t, as in a catalog file
+func typeName(t DBType) string {
+	if t.kind() == DecimalType {
+		return fmt.Sprintf("decimal(%d,%d)", t.precision(), t.scale())
+	}
+	return typeNames[t]
+}
+
+// Return the type to which numbers of types t1 and t2 are coerced when they are
+// compared, ignoring the precision and scale of decimals: FloatType,
+// DecimalType or IntType
+func commonNumericKind(t1 DBType, t2 DBType) DBType {
+	switch {
+	case t1.kind() == FloatType || t2.kind() == FloatType:
+		return FloatType
+	case t1.kind() == DecimalType || t2.kind() == DecimalType:
+		return DecimalType
+	}
+	return IntType
+}
+
+// Return the type of the result of the arithmetic operator op (see [arith]) on
+// numbers of types t1 and t2.  Types that are not numeric, like that of a NULL
+// constant, are taken to be integers.  Dates, timestamps and intervals follow
+// their own rules (see [temporalArithType]).
+func arithType(op string, t1 DBType, t2 DBType) DBType {
+	if t1.isTemporal() || t2.isTemporal() {
+		return temporalArithType(op, t1, t2)
+	}
+	switch commonNumericKind(t1, t2) {
+	case FloatType:
+		return FloatType
+	case IntType:
+		return IntType
+	}
+	p1, s1 := t1.precision(), t1.scale()
+	p2, s2 := t2.precision(), t2.scale()
+	s := arithScale(op, s1, s2)
+	var p int
+	switch op {
+	case "*":
+		p = p1 + p2
+	case "/":
+		p = p1 - s1 + s2 + s
+	default:
+		p = maxInt(p1-s1, p2-s2) + s + 1
+	}
+	return decimalType(minInt(maxInt(p, maxInt(s, 1)), maxDecimalPrecision), s)
+}
+
+// Return the scale of the result of the arithmetic operator op on decimals of
+// scales s1 and s2
+func arithScale(op string, s1 int, s2 int) int {
+	switch op {
+	case "*":
+		return minInt(s1+s2, maxDecimalPrecision)
+	case "/":
+		return minInt(s1+divisionScaleIncrement, maxDecimalPrecision)
+	}
+	return maxInt(s1, s2)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Return the value of v, a decimal or an integer, as a fraction
+func numericRat(v DBValue) *big.Rat {
+	switch v := v.(type) {
+	case DecimalField:
+		return new(big.Rat).SetFrac(big.NewInt(v.Value), pow10(v.Scale))
+	case IntField:
+		return new(big.Rat).SetInt64(v.Value)
+	}
+	return new(big.Rat)
+}
+
+// Return the value of v, a number, as a float
+func numericFloat(v DBValue) float64 {
+	switch v := v.(type) {
+	case FloatField:
+		return v.Value
+	case DecimalField:
+		f, _ := numericRat(v).Float64()
+		return f
+	case IntField:
+		return float64(v.Value)
+	}
+	return 0
+}
+
+// Return 10^n
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Return r multiplied by 10^scale and rounded half away from zero, or false if
+// the result does not fit in a decimal of the specified precision
+func roundRat(r *big.Rat, scale int, precision int) (int64, bool) {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow10(scale)))
+	q, m := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if m.Sign() != 0 && new(big.Int).Mul(m.Abs(m), big.NewInt(2)).Cmp(scaled.Denom()) >= 0 {
+		q.Add(q, big.NewInt(int64(scaled.Sign())))
+	}
+	if new(big.Int).Abs(q).Cmp(pow10(precision)) >= 0 {
+		return 0, false
+	}
+	return q.Int64(), true
+}
+
+// Return v, an integer or a decimal, multiplied by 10^scale, which is at least
+// the scale of v, saturating at the smallest and largest int64.  Since decimals
+// have at most maxDecimalPrecision digits, only values much larger than any
+// decimal saturate, so values compare in the same way as the numbers they
+// stand for, except when both saturate.
+func scaledInt64(v DBValue, scale int) int64 {
+	n := numericRat(v)
+	n.Mul(n, new(big.Rat).SetInt(pow10(scale)))
+	i := new(big.Int).Quo(n.Num(), n.Denom())
+	switch {
+	case i.IsInt64():
+		return i.Int64()
+	case i.Sign() > 0:
+		return math.MaxInt64
+	}
+	return math.MinInt64
+}
+
+// Return whether v is a number, of any numeric type
+func isNumber(v DBValue) bool {
+	switch v.(type) {
+	case IntField, FloatField, DecimalField:
+		return true
+	}
+	return false
+}
+
+// Compare a and b, two numbers of any numeric type, returning -1, 0 or 1 as a
+// is less than, equal to or greater than b
+func compareNumeric(a DBValue, b DBValue) int {
+	_, aFloat := a.(FloatField)
+	_, bFloat := b.(FloatField)
+	if aFloat || bFloat {
+		fa, fb := numericFloat(a), numericFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return numericRat(a).Cmp(numericRat(b))
+}
+
+// Return d written out with Scale digits after the decimal point, e.g., 1.50
+func (d DecimalField) String() string {
+	return numericRat(d).FloatString(d.Scale)
+}
+
+// a decimal number with a decimal point and no exponent, like 1.5 or .25
+var decimalLiteralRegexp = regexp.MustCompile(`^[+-]?([0-9]+\.[0-9]*|\.[0-9]+)$`)
+
+// Return the decimal written as s, a literal with a decimal point, like 1.50,
+// whose precision and scale are those of the literal, or false if s is not such
+// a literal or has too many digits
+func decimalLiteral(s string) (DecimalField, DBType, bool) {
+	if !decimalLiteralRegexp.MatchString(s) {
+		return DecimalField{}, UnknownType, false
+	}
+	digits := strings.TrimLeft(s, "+-")
+	point := strings.Index(digits, ".")
+	scale := len(digits) - point - 1
+	precision := maxInt(len(strings.TrimLeft(digits[:point], "0"))+scale, 1)
+	if precision > maxDecimalPrecision {
+		return DecimalField{}, UnknownType, false
+	}
+	r, _ := new(big.Rat).SetString(s)
+	v, _ := roundRat(r, scale, precision)
+	return DecimalField{v, scale}, decimalType(precision, scale), true
+}
+
+// a number with an exponent, like 1.5e3
+var floatLiteralRegexp = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)[eE][+-]?[0-9]+$`)
+
+// Return the float written as s, a literal with an exponent, like 1.5e3, or a
+// decimal literal with too many digits to be a decimal, or false if s is not
+// such a literal
+func floatLiteral(s string) (FloatField, bool) {
+	if !floatLiteralRegexp.MatchString(s) && !decimalLiteralRegexp.MatchString(s) {
+		return FloatField{}, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return FloatField{f}, err == nil
+}
+
+// Return the decimal of type t, a decimal type, written as s, rounded to the
+// scale of t
+func parseDecimal(s string, t DBType) (DecimalField, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return DecimalField{}, GoDBError{TypeMismatchError, fmt.Sprintf("%s is not a number", s)}
+	}
+	v, ok := roundRat(r, t.scale(), t.precision())
+	if !ok {
+		return DecimalField{}, GoDBError{NumericRangeError, fmt.Sprintf("%s is out of range for %s", s, typeName(t))}
+	}
+	return DecimalField{v, t.scale()}, nil
+}
+
+// Return v coerced to type t: a number converted to the numeric type t, a value
+// converted to the date, timestamp or interval type t (see [castTemporal]) or
+// to a boolean (see [castBool]), or v itself if t is none of them or v is NULL.
+// Returns an error if v is not a number but t is, or if v does not fit in t.
+func castValue(v DBValue, t DBType) (DBValue, error) {
+	if t.isTemporal() {
+		return castTemporal(v, t)
+	}
+	if t == BoolType {
+		return castBool(v)
+	}
+	if !t.isNumeric() || isNull(v) {
+		return v, nil
+	}
+	var r *big.Rat
+	switch v := v.(type) {
+	case IntField, DecimalField:
+		r = numericRat(v)
+	case FloatField:
+		if t.kind() == FloatType {
+			return v, nil
+		}
+		if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+			return nil, GoDBError{NumericRangeError, fmt.Sprintf("%v is out of range for %s", v.Value, typeName(t))}
+		}
+		r = new(big.Rat).SetFloat64(v.Value)
+	default:
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("%v is not a number", v)}
+	}
+	switch t.kind() {
+	case FloatType:
+		return FloatField{numericFloat(v)}, nil
+	case IntType:
+		if i, ok := v.(IntField); ok {
+			return i, nil
+		}
+		// round to an integer; int64 holds 18 digits, and then some
+		if i, ok := roundRat(r, 0, maxDecimalPrecision+1); ok {
+			return IntField{i}, nil
+		}
+	case DecimalType:
+		if d, ok := v.(DecimalField); ok && d.Scale == t.scale() && new(big.Int).Abs(big.NewInt(d.Value)).Cmp(pow10(t.precision())) < 0 {
+			return d, nil
+		}
+		if d, ok := roundRat(r, t.scale(), t.precision()); ok {
+			return DecimalField{d, t.scale()}, nil
+		}
+	}
+	return nil, GoDBError{NumericRangeError, fmt.Sprintf("%v is out of range for %s", v, typeName(t))}
+}
+
+// Return t, or a copy of it whose values are coerced to the types of the fields
+// of desc (see [castValue]), except for strings and byte strings
+func castTuple(t *Tuple, desc *TupleDesc) (*Tuple, error) {
+	var cast *Tuple
+	for i, f := range desc.Fields {
+		if i >= len(t.Fields) || f.Ftype == StringType || f.Ftype == BytesType {
+			continue
+		}
+		v, err := castValue(t.Fields[i], f.Ftype)
+		if err != nil {
+			return nil, err
+		}
+		if v == t.Fields[i] {
+			continue
+		}
+		if cast == nil {
+			cast = &Tuple{Desc: t.Desc, Fields: append([]DBValue{}, t.Fields...), Rid: t.Rid}
+		}
+		cast.Fields[i] = v
+	}
+	if cast == nil {
+		return t, nil
+	}
+	return cast, nil
+}
+
+// Apply the arithmetic operator op, one of +, -, *, / and mod, to a and b, two
+// numbers, after coercing them to the more general of their types.  Integer
+// division truncates, while the quotient of decimals has
+// divisionScaleIncrement more digits after the decimal point than a.  Returns
+// an error for a division by zero, except of floats, or for a decimal that
+// does not fit in maxDecimalPrecision digits.  Dates, timestamps and intervals
+// follow their own rules (see [temporalArith]).
+func arith(op string, a DBValue, b DBValue) (DBValue, error) {
+	if isTemporalValue(a) || isTemporalValue(b) {
+		return temporalArith(op, a, b)
+	}
+	switch commonNumericKind(numericType(a), numericType(b)) {
+	case FloatType:
+		x, y := numericFloat(a), numericFloat(b)
+		switch op {
+		case "+":
+			return FloatField{x + y}, nil
+		case "-":
+			return FloatField{x - y}, nil
+		case "*":
+			return FloatField{x * y}, nil
+		case "/":
+			return FloatField{x / y}, nil
+		case "mod":
+			return FloatField{math.Mod(x, y)}, nil
+		}
+	case IntType:
+		x, y := a.(IntField).Value, b.(IntField).Value
+		switch op {
+		case "+":
+			return IntField{x + y}, nil
+		case "-":
+			return IntField{x - y}, nil
+		case "*":
+			return IntField{x * y}, nil
+		}
+		if y == 0 {
+			return nil, GoDBError{IllegalOperationError, "division by zero"}
+		}
+		switch op {
+		case "/":
+			return IntField{x / y}, nil
+		case "mod":
+			return IntField{x % y}, nil
+		}
+	case DecimalType:
+		x, y := numericRat(a), numericRat(b)
+		r := new(big.Rat)
+		switch op {
+		case "+":
+			r.Add(x, y)
+		case "-":
+			r.Sub(x, y)
+		case "*":
+			r.Mul(x, y)
+		case "/", "mod":
+			if y.Sign() == 0 {
+				return nil, GoDBError{IllegalOperationError, "division by zero"}
+			}
+			r.Quo(x, y)
+			if op == "mod" {
+				// x - y * trunc(x / y), which has the sign of x
+				q := new(big.Int).Quo(r.Num(), r.Denom())
+				r.Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(q)))
+			}
+		}
+		scale := arithScale(op, numericType(a).scale(), numericType(b).scale())
+		if v, ok := roundRat(r, scale, maxDecimalPrecision); ok {
+			return DecimalField{v, scale}, nil
+		}
+		return nil, GoDBError{NumericRangeError, fmt.Sprintf("result of %s is out of range for a decimal", op)}
+	}
+	return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown arithmetic operator %s", op)}
+}
+
+// Return the type of v, a number, with the scale of a decimal but not its
+// precision, which only the type of the field it came from has
+func numericType(v DBValue) DBType {
+	switch v := v.(type) {
+	case FloatField:
+		return FloatType
+	case DecimalField:
+		return decimalType(maxDecimalPrecision, v.Scale)
+	}
+	return IntType
+}
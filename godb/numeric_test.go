@@ -0,0 +1,198 @@
+package godb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Return a catalog with the table p (name string, price decimal(10,2), ratio
+// float), holding three products
+func makeNumericTestCatalog(t *testing.T) *Catalog {
+	return makeQueryTestCatalog(t, "p (name string, price decimal(10, 2), ratio float)",
+		"insert into p values ('pen', 1.5, 0.25), ('ink', 10, 1.5e0), ('pad', 2.125, 0.75)")
+}
+
+func TestDecimalTypes(t *testing.T) {
+	d := decimalType(10, 2)
+	if d.kind() != DecimalType || d.precision() != 10 || d.scale() != 2 || !d.isNumeric() {
+		t.Errorf("decimal(10,2) is %d, with precision %d and scale %d", d.kind(), d.precision(), d.scale())
+	}
+	if typeName(d) != "decimal(10,2)" || typeName(FloatType) != "float" {
+		t.Errorf("unexpected type names %s and %s", typeName(d), typeName(FloatType))
+	}
+	for _, c := range []struct {
+		op     string
+		t1, t2 DBType
+		want   DBType
+	}{
+		{"+", IntType, IntType, IntType},
+		{"+", IntType, FloatType, FloatType},
+		{"+", decimalType(10, 2), decimalType(5, 3), decimalType(12, 3)},
+		{"*", decimalType(4, 2), decimalType(3, 1), decimalType(7, 3)},
+		{"/", decimalType(4, 2), IntType, decimalType(8, 6)},
+		{"-", decimalType(4, 2), FloatType, FloatType},
+	} {
+		if got := arithType(c.op, c.t1, c.t2); got != c.want {
+			t.Errorf("%s %s %s: expected %s, got %s", typeName(c.t1), c.op, typeName(c.t2), typeName(c.want), typeName(got))
+		}
+	}
+}
+
+func TestNumericArith(t *testing.T) {
+	for _, c := range []struct {
+		op   string
+		a, b DBValue
+		want DBValue
+	}{
+		{"+", IntField{2}, IntField{3}, IntField{5}},
+		{"/", IntField{7}, IntField{2}, IntField{3}},
+		{"+", DecimalField{150, 2}, IntField{1}, DecimalField{250, 2}},
+		{"-", DecimalField{150, 2}, DecimalField{5, 1}, DecimalField{100, 2}},
+		{"*", DecimalField{15, 1}, DecimalField{15, 1}, DecimalField{225, 2}},
+		{"/", DecimalField{1, 0}, IntField{3}, DecimalField{3333, 4}},
+		{"/", DecimalField{2, 0}, IntField{3}, DecimalField{6667, 4}},
+		{"mod", DecimalField{-75, 1}, IntField{2}, DecimalField{-15, 1}},
+		{"*", DecimalField{15, 1}, FloatField{2}, FloatField{3}},
+	} {
+		got, err := arith(c.op, c.a, c.b)
+		if err != nil || got != c.want {
+			t.Errorf("%v %s %v: expected %v, got %v (%v)", c.a, c.op, c.b, c.want, got, err)
+		}
+	}
+	if _, err := arith("/", IntField{1}, IntField{0}); err == nil {
+		t.Errorf("expected an error dividing an integer by zero")
+	}
+	if _, err := arith("/", DecimalField{1, 0}, DecimalField{0, 2}); err == nil {
+		t.Errorf("expected an error dividing a decimal by zero")
+	}
+	if _, err := arith("*", DecimalField{1e17, 0}, IntField{100}); err == nil {
+		t.Errorf("expected an error for a decimal with too many digits")
+	}
+}
+
+func TestNumericCoercion(t *testing.T) {
+	if compareNumeric(IntField{2}, DecimalField{200, 2}) != 0 ||
+		compareNumeric(DecimalField{199, 2}, IntField{2}) != -1 ||
+		compareNumeric(FloatField{0.5}, DecimalField{4, 1}) != 1 {
+		t.Errorf("numbers of different types compare incorrectly")
+	}
+	for _, c := range []struct {
+		v    DBValue
+		t    DBType
+		want DBValue
+	}{
+		{IntField{3}, decimalType(5, 2), DecimalField{300, 2}},
+		{DecimalField{12345, 3}, decimalType(5, 2), DecimalField{1235, 2}},
+		{DecimalField{-12345, 3}, decimalType(5, 2), DecimalField{-1235, 2}},
+		{FloatField{0.125}, decimalType(5, 2), DecimalField{13, 2}},
+		{DecimalField{25, 1}, IntType, IntField{3}},
+		{DecimalField{25, 1}, FloatType, FloatField{2.5}},
+		{NullField{}, FloatType, NullField{}},
+		{StringField{"x"}, StringType, StringField{"x"}},
+	} {
+		got, err := castValue(c.v, c.t)
+		if err != nil || got != c.want {
+			t.Errorf("%v as %s: expected %v, got %v (%v)", c.v, typeName(c.t), c.want, got, err)
+		}
+	}
+	if _, err := castValue(IntField{1000}, decimalType(5, 2)); err == nil {
+		t.Errorf("expected 1000 not to fit in decimal(5,2)")
+	}
+	if _, err := castValue(StringField{"1"}, IntType); err == nil {
+		t.Errorf("expected a string not to be cast to an int")
+	}
+	if d, typ, ok := decimalLiteral("-012.50"); !ok || d != (DecimalField{-1250, 2}) || typ != decimalType(4, 2) {
+		t.Errorf("unexpected decimal literal %v of type %s", d, typeName(typ))
+	}
+	if _, _, ok := decimalLiteral("1e5"); ok {
+		t.Errorf("expected 1e5 not to be a decimal literal")
+	}
+}
+
+func TestNumericSerialization(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{"price", "", decimalType(10, 2)}, {"ratio", "", FloatType}}}
+	t1 := Tuple{Desc: td, Fields: []DBValue{DecimalField{-1999, 2}, FloatField{0.1}}}
+	b := new(bytes.Buffer)
+	if err := t1.writeTo(b); err != nil {
+		t.Fatalf("%s", err)
+	}
+	t2, err := readTupleFrom(b, &td)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !t2.equals(&t1) {
+		t.Errorf("expected %v, got %v", t1.Fields, t2.Fields)
+	}
+	if s := t2.PrettyPrintString(false); s != "-19.99,0.1" {
+		t.Errorf("unexpected printed tuple %s", s)
+	}
+}
+
+func TestNumericCatalog(t *testing.T) {
+	c := makeNumericTestCatalog(t)
+	if s := c.CatalogString(); s != "p (name string, price decimal(10,2), ratio float)\n" {
+		t.Errorf("unexpected catalog %q", s)
+	}
+	if _, _, err := Parse(c, "create table q (a decimal(6,2), b decimal, c double, d float)"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	desc := c.tables[len(c.tables)-1].desc
+	want := []DBType{decimalType(6, 2), decimalType(defaultDecimalPrecision, defaultDecimalScale), FloatType, FloatType}
+	for i, w := range want {
+		if desc.Fields[i].Ftype != w {
+			t.Errorf("column %d: expected %s, got %s", i, typeName(w), typeName(desc.Fields[i].Ftype))
+		}
+	}
+	if _, _, err := Parse(c, "create table r (a decimal(20,2))"); err == nil {
+		t.Errorf("expected a decimal with too many digits to be rejected")
+	}
+}
+
+func TestNumericQueries(t *testing.T) {
+	c := makeNumericTestCatalog(t)
+	for query, want := range map[string]int{
+		"select name from p where price > 2":       2,
+		"select name from p where price = 2.13":    1,
+		"select name from p where price < 1.505":   1,
+		"select name from p where ratio >= 0.75":   2,
+		"select name from p where ratio < 1":       2,
+		"select name from p where price > 1.5e0":   2,
+		"select name from p where price <> 10.000": 2,
+	} {
+		if got := runTestQuery(t, c, query); len(got) != want {
+			t.Errorf("%q: expected %d tuples, got %d", query, want, len(got))
+		}
+	}
+
+	got := runTestQuery(t, c, "select sum(price), avg(price), min(price), max(ratio), sum(ratio) from p")
+	want := []DBValue{DecimalField{1363, 2}, DecimalField{4543333, 6}, DecimalField{150, 2}, FloatField{1.5}, FloatField{2.5}}
+	for i, v := range want {
+		if got[0].Fields[i] != v {
+			t.Errorf("aggregate %d: expected %v, got %v", i, v, got[0].Fields[i])
+		}
+	}
+	if got[0].Desc.Fields[0].Ftype != decimalType(maxDecimalPrecision, 2) {
+		t.Errorf("expected the sum of decimal(10,2) to be a decimal with a scale of 2")
+	}
+
+	got = runTestQuery(t, c, "select name, price * 2 + 1, ratio * price, price from p order by price desc")
+	want = []DBValue{StringField{"ink"}, DecimalField{2100, 2}, FloatField{15}}
+	for i, v := range want {
+		if got[0].Fields[i] != v {
+			t.Errorf("expression %d: expected %v, got %v", i, v, got[0].Fields[i])
+		}
+	}
+	if got[2].Fields[0] != (StringField{"pen"}) {
+		t.Errorf("expected the cheapest product last, got %v", got[2].Fields[0])
+	}
+
+	runTestQuery(t, c, "insert into p values ('big', 99999999.99, 0)")
+	if _, plan, err := Parse(c, "insert into p values ('huge', 100000000, 0)"); err == nil {
+		tid := NewTID()
+		c.bp.BeginTransaction(tid)
+		if _, err := plan.Iterator(tid); err == nil {
+			t.Errorf("expected a price with too many digits not to be inserted")
+		}
+		c.bp.AbortTransaction(tid)
+	}
+}
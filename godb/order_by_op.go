@@ -1,26 +1,50 @@
 package godb
 
-import (
-	"sort"
-)
-
 // TODO: some code goes here
 type OrderBy struct {
 	orderBy []Expr // OrderBy should include these two fields (used by parser)
 	child   Operator
 	//add additional fields here
 	asc []bool
+	// whether NULL orders before (true) or after (false) other values of each
+	// field
+	nullsFirst []bool
+	// the number of bytes of tuples the sort may hold in memory
+	maxMemory int
 }
 
 // Order by constructor -- should save the list of field, child, and ascending
 // values for use in the Iterator() method. Here, orderByFields is a list of
 // expressions that can be extacted from the child operator's tuples, and the
 // ascending bitmap indicates whether the ith field in the orderByFields
-// list should be in ascending (true) or descending (false) order.
+// list should be in ascending (true) or descending (false) order.  NULL orders
+// after other values in ascending order, and before them in descending order.
 func NewOrderBy(orderByFields []Expr, child Operator, ascending []bool) (*OrderBy, error) {
 	// TODO: some code goes here
-	return &OrderBy{orderByFields, child, ascending}, nil
+	nullsFirst := make([]bool, len(ascending))
+	for i, asc := range ascending {
+		nullsFirst[i] = !asc
+	}
+	return NewOrderByNulls(orderByFields, child, ascending, nullsFirst)
+
+}
 
+// Order by constructor that also takes, for each field, whether NULL should
+// order before (true) or after (false) other values, as with NULLS FIRST and
+// NULLS LAST.  The sort holds at most DefaultSortMemory bytes of tuples in
+// memory.
+func NewOrderByNulls(orderByFields []Expr, child Operator, ascending []bool, nullsFirst []bool) (*OrderBy, error) {
+	return NewOrderByBuffered(orderByFields, child, ascending, nullsFirst, DefaultSortMemory)
+}
+
+// Order by constructor that also takes the maximum number of bytes of tuples
+// the sort holds in memory, as they are stored on a page.  Larger inputs are
+// sorted in runs that are written to disk and merged.
+func NewOrderByBuffered(orderByFields []Expr, child Operator, ascending []bool, nullsFirst []bool, maxMemory int) (*OrderBy, error) {
+	if len(ascending) != len(orderByFields) || len(nullsFirst) != len(orderByFields) {
+		return nil, GoDBError{IllegalOperationError, "expected an order for every field"}
+	}
+	return &OrderBy{orderByFields, child, ascending, nullsFirst, maxMemory}, nil
 }
 
 func (o *OrderBy) Descriptor() *TupleDesc {
@@ -30,69 +54,74 @@ func (o *OrderBy) Descriptor() *TupleDesc {
 
 // Return a function that iterators through the results of the child iterator in
 // ascending/descending order, as specified in the construtor.  This sort is
-// "blocking" -- it should first construct an in-memory sorted list of results
-// to return, and then iterate through them one by one on each subsequent
-// invocation of the iterator function.
+// "blocking" -- it reads the whole child before returning the first tuple.
 //
-// Although you are free to implement your own sorting logic, you may wish to
-// leverage the go sort pacakge and the [sort.Sort] method for this purpose.  To
-// use this you will need to implement three methods:  Len, Swap, and Less that
-// the sort algorithm will invoke to preduce a sorted list. See the first
-// example, example of SortMultiKeys, and documentation at: https://pkg.go.dev/sort
+// The sort is an external merge sort (see sort.go): runs of at most
+// maxMemory bytes of tuples are sorted in memory and, unless the child fits in
+// a single run, written to spill files and merged.  The runs are removed once
+// the iterator ends or fails.  Operators are not told when they stop being
+// read, so the runs of an iterator that is not read to its end, as under a
+// LIMIT, stay on disk until tid commits or aborts.  Tuples that order the same
+// on every field are returned in the order the child returns them.  Errors of
+// the child, and of evaluating or comparing the fields, are returned.
 func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
+	iter, err := o.child.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	s := &tupleSorter{
+		desc:     o.child.Descriptor(),
+		tid:      tid,
+		keys:     o.keys,
+		compare:  o.compare,
+		maxBytes: o.maxMemory,
+	}
+	// the iterator removes the runs once it ends; there is no way to remove
+	// them sooner if it is not read to its end, so they are left for tid to
+	// remove with its other spill files
+	next, _, err := s.sort(iter)
+	return next, err
+}
 
-	iter, _ := o.child.Iterator(tid)
-	v := make([]*Tuple, 0)
-	for {
-		t, _ := iter()
-		if t == nil {
-			break
+// Return the values of the fields t is ordered by
+func (o *OrderBy) keys(t *Tuple) ([]DBValue, error) {
+	keys := make([]DBValue, len(o.orderBy))
+	for i, e := range o.orderBy {
+		v, err := e.EvalExpr(t)
+		if err != nil {
+			return nil, err
 		}
-		v = append(v, t)
+		keys[i] = v
 	}
+	return keys, nil
+}
 
-	sort.Slice(v, func(i int, j int) bool {
-		for k := 0; k < len(o.orderBy); k++ {
-			ifasc := o.asc[k]
-			ival, _ := o.orderBy[k].EvalExpr(v[i])
-			jval, _ := o.orderBy[k].EvalExpr(v[j])
-			tp := o.orderBy[k].GetExprType().Ftype
-			switch tp {
-			case IntType:
-				ivalue := ival.(IntField).Value
-				jvalue := jval.(IntField).Value
-				if ivalue == jvalue {
-					continue
-				}
-				if ifasc {
-					return ivalue < jvalue
-				} else {
-					return ivalue > jvalue
-				}
-			case StringType:
-				ivalue := ival.(StringField).Value
-				jvalue := jval.(StringField).Value
-				if ivalue == jvalue {
-					continue
-				}
-				if ifasc {
-					return ivalue < jvalue
-				} else {
-					return ivalue > jvalue
-				}
+// Compare a and b, the values of the fields of two tuples, returning -1, 0 or 1
+// as the first tuple orders before, with or after the second: by the first
+// field on which they differ, in the order of the field
+func (o *OrderBy) compare(a []DBValue, b []DBValue) (int, error) {
+	for k := range o.orderBy {
+		if isNull(a[k]) || isNull(b[k]) {
+			if isNull(a[k]) && isNull(b[k]) {
+				continue
+			}
+			if isNull(a[k]) == o.nullsFirst[k] {
+				return -1, nil
 			}
+			return 1, nil
 		}
-		return true
-	})
-
-	i := 0
-	return func() (*Tuple, error) {
-		if i == len(v) {
-			return nil, nil
+		cmp, err := compareDBValues(a[k], b[k])
+		if err != nil {
+			return 0, err
+		}
+		if cmp == 0 {
+			continue
 		}
-		ans := v[i]
-		i++
-		return ans, nil
-	}, nil //replace me
+		if !o.asc[k] {
+			cmp = -cmp
+		}
+		return cmp, nil
+	}
+	return 0, nil
 }
@@ -2,13 +2,14 @@ package godb
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
 // test the order by operator, by asking it to sort the test database
 // in ascending and descending order and verifying the result
 func TestOrderBy(t *testing.T) {
-	_, t1, t2, hf, _, tid := makeTestVars()
+	_, t1, t2, hf, _, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	hf.insertTuple(&t2, tid)
 	bs := make([]bool, 2)
@@ -105,7 +106,7 @@ func TestMultiFieldOrderBy(t *testing.T) {
 	}
 
 	bp := NewBufferPool(2)
-	os.Remove(TestingFile)
+	useTestFiles(t)
 	hf, err := NewHeapFile(TestingFile, &td, bp)
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -160,3 +161,207 @@ func TestMultiFieldOrderBy(t *testing.T) {
 	bp.CommitTransaction(tid)
 
 }
+
+// Return an operator over a tuple of (a int, b string, seq int) for each value
+// of a and b in turn, where a negative a is NULL and seq is the position of
+// the tuple
+func makeOrderByTestOp(as []int64, bs []string) *tupleListOp {
+	desc := TupleDesc{Fields: []FieldType{{Fname: "a", Ftype: IntType}, {Fname: "b", Ftype: StringType}, {Fname: "seq", Ftype: IntType}}}
+	op := &tupleListOp{desc: desc}
+	for i := range as {
+		var a DBValue = IntField{as[i]}
+		if as[i] < 0 {
+			a = NullField{}
+		}
+		op.tuples = append(op.tuples, &Tuple{desc, []DBValue{a, StringField{bs[i]}, IntField{int64(i)}}, nil})
+	}
+	return op
+}
+
+func TestOrderByExternal(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	var as []int64
+	var bs []string
+	for i := 0; i < 1000; i++ {
+		// a repeats, and is NULL every 10 tuples; b repeats within a
+		a := int64((i * 7) % 50)
+		if i%10 == 9 {
+			a = -1
+		}
+		as = append(as, a)
+		bs = append(bs, string(rune('a'+(i*3)%4)))
+	}
+	child := makeOrderByTestOp(as, bs)
+	exprs := []Expr{&FieldExpr{child.desc.Fields[0]}, &FieldExpr{child.desc.Fields[1]}}
+
+	// a buffer of 128 bytes, of 7 tuples of 19 bytes, sorts the input in more
+	// runs than are merged at once
+	for _, size := range []int{128, 1 << 20} {
+		for _, asc := range [][]bool{{true, false}, {false, true}} {
+			oby, err := NewOrderByBuffered(exprs, child, asc, []bool{false, false}, size)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			iter, err := oby.Iterator(NewTID())
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			var got []*Tuple
+			for {
+				tup, err := iter()
+				if err != nil {
+					t.Fatalf("%s", err)
+				}
+				if tup == nil {
+					break
+				}
+				got = append(got, tup)
+			}
+			if len(got) != len(as) {
+				t.Fatalf("expected %d tuples with a buffer of %d bytes, got %d", len(as), size, len(got))
+			}
+			for i := 1; i < len(got); i++ {
+				cmp, err := oby.compare(got[i-1].Fields[:2], got[i].Fields[:2])
+				if err != nil {
+					t.Fatalf("%s", err)
+				}
+				// tuples that order the same keep the order of the child
+				if cmp > 0 || (cmp == 0 && got[i-1].Fields[2].(IntField).Value > got[i].Fields[2].(IntField).Value) {
+					t.Fatalf("tuples %v and %v are out of order with a buffer of %d bytes", got[i-1].Fields, got[i].Fields, size)
+				}
+			}
+			if !isNull(got[len(got)-1].Fields[0]) {
+				t.Errorf("expected NULLs last, got %v", got[len(got)-1].Fields)
+			}
+		}
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+}
+
+func TestOrderByCompare(t *testing.T) {
+	child := makeOrderByTestOp(nil, nil)
+	oby, err := NewOrderByNulls([]Expr{&FieldExpr{child.desc.Fields[0]}}, child, []bool{false}, []bool{true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	cases := []struct {
+		a, b DBValue
+		want int
+	}{
+		{IntField{1}, IntField{1}, 0},
+		{IntField{1}, IntField{2}, 1},
+		{NullField{}, IntField{2}, -1},
+		{NullField{}, NullField{}, 0},
+	}
+	for _, c := range cases {
+		if got, err := oby.compare([]DBValue{c.a}, []DBValue{c.b}); err != nil || got != c.want {
+			t.Errorf("expected %v and %v to compare as %d, got %d (%v)", c.a, c.b, c.want, got, err)
+		}
+	}
+	if _, err := oby.compare([]DBValue{IntField{1}}, []DBValue{StringField{"a"}}); err == nil {
+		t.Errorf("expected values that cannot be compared to fail")
+	}
+}
+
+func TestOrderByErrors(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	as := make([]int64, 100)
+	bs := make([]string, 100)
+	for _, size := range []int{100, 1 << 20} {
+		child := makeOrderByTestOp(as, bs)
+		child.err, child.failAt = GoDBError{MalformedDataError, "bad page"}, 50
+		oby, _ := NewOrderByBuffered([]Expr{&FieldExpr{child.desc.Fields[0]}}, child, []bool{true}, []bool{false}, size)
+		if _, err := oby.Iterator(NewTID()); err == nil {
+			t.Errorf("expected the error of the child to be returned with a buffer of %d bytes", size)
+		}
+
+		// a field the tuples do not have
+		child.err = nil
+		missing := &FieldExpr{FieldType{Fname: "missing", Ftype: IntType}}
+		oby, _ = NewOrderByBuffered([]Expr{missing}, child, []bool{true}, []bool{false}, size)
+		if _, err := oby.Iterator(NewTID()); err == nil {
+			t.Errorf("expected the error of evaluating the field to be returned with a buffer of %d bytes", size)
+		}
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the spill files to be removed, found %d", len(files))
+	}
+}
+
+func TestOrderByUnderLimit(t *testing.T) {
+	spillDir = t.TempDir()
+	defer func() { spillDir = "" }()
+	as := make([]int64, 200)
+	bs := make([]string, 200)
+	for i := range as {
+		as[i] = int64(len(as) - i)
+	}
+	child := makeOrderByTestOp(as, bs)
+	oby, err := NewOrderByBuffered([]Expr{&FieldExpr{child.desc.Fields[0]}}, child, []bool{true}, []bool{false}, 100)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	bp := NewBufferPool(10)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := NewLimitOp(&ConstExpr{IntField{3}, IntType}, oby).Iterator(tid)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for want := int64(1); ; want++ {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if tup == nil {
+			if want != 4 {
+				t.Errorf("expected 3 tuples, got %d", want-1)
+			}
+			break
+		}
+		if tup.Fields[0] != (IntField{want}) {
+			t.Errorf("expected %d, got %v", want, tup.Fields[0])
+		}
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) == 0 {
+		t.Fatalf("expected the sort to spill runs")
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if files, _ := os.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("expected the runs to be removed once the transaction commits, found %d", len(files))
+	}
+}
+
+func TestOrderBySortMemory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte("s (a int)\n"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, bp := range []*BufferPool{NewBufferPool(10), NewBufferPool(10, WithSortMemory(4096))} {
+		c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		t.Cleanup(func() { c.bp.logFile.Close() })
+		_, plan, err := Parse(c, "select a from s order by a")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		oby, ok := plan.(*OrderBy)
+		if project, isProject := plan.(*Project); isProject {
+			oby, ok = project.child.(*OrderBy)
+		}
+		if !ok {
+			t.Fatalf("expected an ORDER BY in the plan")
+		}
+		if oby.maxMemory != bp.sortMemory {
+			t.Errorf("expected the sort to hold %d bytes in memory, got %d", bp.sortMemory, oby.maxMemory)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+// Values too large to be stored on a heap page with the rest of their tuple
+// are stored out of line, in the style of PostgreSQL's TOAST.  Every HeapFile
+// has an overflow file next to it (see overflowFileName), itself a HeapFile
+// whose tuples are chunks of such values.  A value is split into chunks that
+// fill a page each, and each chunk holds the Rid of the next one, so that the
+// chunks of a value form a chain that starts from the reference stored in the
+// tuple in place of the value.
+//
+// Chunks are inserted, deleted, locked, logged and versioned like any other
+// tuple, on behalf of the transaction that inserts or deletes the tuple that
+// refers to them.  So they are read from the same snapshot as that tuple, they
+// are rolled back with it, and the slots of deleted chunks are reused once the
+// deleting transaction commits.
+//
+// Spill files (see spill.go) store large values out of line in the same way,
+// in chunks of their own that are neither locked nor logged.
+
+const (
+	// length of a string or byte string that marks a reference to a value
+	// stored out of line (see [Tuple.writeTo])
+	overflowMarker = math.MaxUint16
+	// size of a serialized overflowRef
+	overflowRefSize = 2 + 4 + 4 + 8
+	// tuples that take more than this many bytes on a page have their largest
+	// strings and byte strings stored out of line
+	overflowThreshold = maxTupleSize / 4
+	// bytes of a value stored in each chunk, so that a chunk, along with its
+	// byte of bitmap of NULL fields and the Rid of the next one, fills a page
+	overflowChunkSize = maxTupleSize - 1 - 2*8 - 2
+)
+
+// The TupleDesc of overflow files: the page and slot of the next chunk of the
+// value, or -1 for the last chunk, and the bytes of the chunk
+var overflowDesc = TupleDesc{Fields: []FieldType{
+	{Fname: "next_page", Ftype: IntType},
+	{Fname: "next_slot", Ftype: IntType},
+	{Fname: "chunk", Ftype: BytesType},
+}}
+
+// A reference to a value stored out of line: the Rid of its first chunk in the
+// overflow file, and its length.  Stands for the value in the tuples of heap
+// pages, and is replaced by the value when the tuple is read.
+type overflowRef struct {
+	pageNo int32
+	slot   int32
+	length int64
+}
+
+func (r overflowRef) first() Rid {
+	return Rid{int(r.pageNo), int(r.slot)}
+}
+
+func (r overflowRef) writeTo(b *bytes.Buffer) {
+	binary.Write(b, binary.LittleEndian, uint16(overflowMarker))
+	binary.Write(b, binary.LittleEndian, r.pageNo)
+	binary.Write(b, binary.LittleEndian, r.slot)
+	binary.Write(b, binary.LittleEndian, r.length)
+}
+
+func readOverflowRef(b *bytes.Buffer) (overflowRef, error) {
+	var marker uint16
+	var r overflowRef
+	for _, v := range []any{&marker, &r.pageNo, &r.slot, &r.length} {
+		if err := binary.Read(b, binary.LittleEndian, v); err != nil {
+			return r, err
+		}
+	}
+	if marker != overflowMarker {
+		return r, GoDBError{MalformedDataError, "not a reference to an overflow chunk"}
+	}
+	return r, nil
+}
+
+// Return the name of the overflow file of the heap file stored in fileName
+func overflowFileName(fileName string) string {
+	return fileName + ".ovf"
+}
+
+// Return the overflow file of f, creating it if it does not exist
+func (f *HeapFile) overflowFile() (*HeapFile, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.overflow == nil {
+		ovf, err := NewHeapFile(overflowFileName(f.Filename), &overflowDesc, f.bufPool)
+		if err != nil {
+			return nil, err
+		}
+		f.overflow = ovf
+	}
+	return f.overflow, nil
+}
+
+// Return the number of bytes v takes in a serialized tuple
+func storedSize(v DBValue) int {
+	switch v := v.(type) {
+	case StringField:
+		return 2 + len(v.Value)
+	case BytesField:
+		return 2 + len(v.Value)
+	case overflowRef:
+		return overflowRefSize
+	case NullField:
+		return 0
+	case IntervalField:
+		return 16
+	case BoolField:
+		return 1
+	}
+	return 8
+}
+
+// Return t, or a copy of it in which the largest strings and byte strings are
+// replaced by references to chunks written to the overflow file on behalf of
+// tid, until the tuple takes no more than overflowThreshold bytes
+func (f *HeapFile) storeOverflow(t *Tuple, tid TransactionID) (*Tuple, error) {
+	return storeOutOfLine(t, func(value []byte) (overflowRef, error) {
+		return f.writeOverflow(value, tid)
+	})
+}
+
+// Return the number of bytes the values of t take in a serialized tuple
+func storedTupleSize(t *Tuple) int {
+	size := 0
+	for _, v := range t.Fields {
+		size += storedSize(v)
+	}
+	return size
+}
+
+// Return t, or a copy of it in which the largest strings and byte strings are
+// replaced by the references write returns for them, until the tuple takes no
+// more than overflowThreshold bytes
+func storeOutOfLine(t *Tuple, write func(value []byte) (overflowRef, error)) (*Tuple, error) {
+	size := storedTupleSize(t)
+	if size <= overflowThreshold {
+		return t, nil
+	}
+	stored := &Tuple{Desc: t.Desc, Fields: append([]DBValue{}, t.Fields...)}
+	for size > overflowThreshold {
+		largest := -1
+		for i, v := range stored.Fields {
+			if _, ok := v.(overflowRef); ok || storedSize(v) <= overflowRefSize {
+				continue
+			}
+			if largest < 0 || storedSize(v) > storedSize(stored.Fields[largest]) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			break
+		}
+		var value []byte
+		switch v := stored.Fields[largest].(type) {
+		case StringField:
+			value = []byte(v.Value)
+		case BytesField:
+			value = v.Value
+		}
+		ref, err := write(value)
+		if err != nil {
+			return nil, err
+		}
+		size += overflowRefSize - storedSize(stored.Fields[largest])
+		stored.Fields[largest] = ref
+	}
+	return stored, nil
+}
+
+// Write value to a chain of chunks in the overflow file on behalf of tid, and
+// return a reference to it
+func (f *HeapFile) writeOverflow(value []byte, tid TransactionID) (overflowRef, error) {
+	ovf, err := f.overflowFile()
+	if err != nil {
+		return overflowRef{}, err
+	}
+	return writeChunks(value, func(chunk *Tuple) (Rid, error) {
+		if err := ovf.insertStored(chunk, tid); err != nil {
+			return Rid{}, err
+		}
+		return chunk.Rid.(Rid), nil
+	})
+}
+
+// Split value into a chain of chunks, which insert stores, returning the Rid of
+// each, and return a reference to it.  Chunks are inserted last first, so that
+// each one can refer to the next.
+func writeChunks(value []byte, insert func(chunk *Tuple) (Rid, error)) (overflowRef, error) {
+	next := Rid{-1, -1}
+	for start := (len(value) - 1) / overflowChunkSize * overflowChunkSize; start >= 0; start -= overflowChunkSize {
+		end := start + overflowChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		chunk := &Tuple{Desc: overflowDesc, Fields: []DBValue{
+			IntField{int64(next.pageid)},
+			IntField{int64(next.slotid)},
+			BytesField{value[start:end]},
+		}}
+		rid, err := insert(chunk)
+		if err != nil {
+			return overflowRef{}, err
+		}
+		next = rid
+	}
+	return overflowRef{int32(next.pageid), int32(next.slotid), int64(len(value))}, nil
+}
+
+// Return the Rid of the chunk after chunk, or a Rid with a negative page number
+// if chunk is the last one
+func nextChunk(chunk *Tuple) Rid {
+	return Rid{int(chunk.Fields[0].(IntField).Value), int(chunk.Fields[1].(IntField).Value)}
+}
+
+// Read the value ref refers to from the snapshot of tid
+func (f *HeapFile) readOverflow(ref overflowRef, tid TransactionID) ([]byte, error) {
+	ovf, err := f.overflowFile()
+	if err != nil {
+		return nil, err
+	}
+	return readChunks(ref, func(rid Rid) (*Tuple, error) {
+		hp, err := f.bufPool.getPageForScan(ovf, rid.pageid, tid)
+		if err != nil {
+			return nil, err
+		}
+		return f.bufPool.visibleTuple(tid, (*hp).(*heapPage), rid.slotid)
+	})
+}
+
+// Read the value ref refers to, given chunkAt, which returns the chunk with a
+// Rid, or nil if it is missing
+func readChunks(ref overflowRef, chunkAt func(rid Rid) (*Tuple, error)) ([]byte, error) {
+	value := make([]byte, 0, ref.length)
+	for rid := ref.first(); rid.pageid >= 0 && int64(len(value)) < ref.length; {
+		chunk, err := chunkAt(rid)
+		if err != nil {
+			return nil, err
+		}
+		if chunk == nil {
+			return nil, GoDBError{MalformedDataError, "overflow chunk is missing"}
+		}
+		value = append(value, chunk.Fields[2].(BytesField).Value...)
+		rid = nextChunk(chunk)
+	}
+	if int64(len(value)) != ref.length {
+		return nil, GoDBError{MalformedDataError, "overflow value has the wrong length"}
+	}
+	return value, nil
+}
+
+// Replace the references to values stored out of line in t, a tuple read from
+// a page of f, by the values, read from the snapshot of tid
+func (f *HeapFile) fetchOverflow(t *Tuple, tid TransactionID) error {
+	return fetchOutOfLine(t, func(ref overflowRef) ([]byte, error) {
+		return f.readOverflow(ref, tid)
+	})
+}
+
+// Replace the references to values stored out of line in t by the values read
+// returns for them
+func fetchOutOfLine(t *Tuple, read func(ref overflowRef) ([]byte, error)) error {
+	for i, v := range t.Fields {
+		ref, ok := v.(overflowRef)
+		if !ok {
+			continue
+		}
+		value, err := read(ref)
+		if err != nil {
+			return err
+		}
+		if t.Desc.Fields[i].Ftype == BytesType {
+			t.Fields[i] = BytesField{value}
+		} else {
+			t.Fields[i] = StringField{string(value)}
+		}
+	}
+	return nil
+}
+
+// Delete the chunks of the values stored out of line that stored, a tuple
+// deleted from a page of f, refers to, on behalf of tid
+func (f *HeapFile) freeOverflow(stored *Tuple, tid TransactionID) error {
+	for _, v := range stored.Fields {
+		ref, ok := v.(overflowRef)
+		if !ok {
+			continue
+		}
+		ovf, err := f.overflowFile()
+		if err != nil {
+			return err
+		}
+		for rid := ref.first(); rid.pageid >= 0; {
+			chunk, err := ovf.deleteStored(rid, tid)
+			if err != nil {
+				return err
+			}
+			rid = nextChunk(chunk)
+		}
+	}
+	return nil
+}
+
+// Remove the overflow file of the heap file stored in fileName, if it has one
+func removeOverflowFile(fileName string) {
+	os.Remove(overflowFileName(fileName))
+}
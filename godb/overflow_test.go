@@ -0,0 +1,189 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+// A heap file of (name string, data bytes) tuples, in a buffer pool large
+// enough for the chunks of a few large values
+func makeOverflowTestVars(t *testing.T) (TupleDesc, *HeapFile, *BufferPool) {
+	td := TupleDesc{Fields: []FieldType{
+		{Fname: "name", Ftype: StringType},
+		{Fname: "data", Ftype: BytesType},
+	}}
+	useTestFiles(t)
+	bp := NewBufferPool(20)
+	hf, err := NewHeapFile(TestingFile, &td, bp)
+	if err != nil {
+		t.Fatalf("failed to create heap file: %s", err)
+	}
+	return td, hf, bp
+}
+
+// Return a tuple with a name and a byte string of n bytes, which include zeros
+func largeTuple(td TupleDesc, name string, n int) Tuple {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return Tuple{Desc: td, Fields: []DBValue{StringField{name}, BytesField{data}}}
+}
+
+// Return the only tuple of hf visible to tid
+func onlyTuple(t *testing.T, hf *HeapFile, tid TransactionID) *Tuple {
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	tup, err := iter()
+	if err != nil || tup == nil {
+		t.Fatalf("expected a tuple, got %v", err)
+	}
+	if next, _ := iter(); next != nil {
+		t.Fatalf("expected one tuple, got another")
+	}
+	return tup
+}
+
+func TestOverflowRoundTrip(t *testing.T) {
+	td, hf, bp := makeOverflowTestVars(t)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	tup := largeTuple(td, strings.Repeat("description ", 1000), 3*PageSize)
+	if err := hf.insertTuple(&tup, tid); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	bp.CommitTransaction(tid)
+
+	ovf, _ := hf.overflowFile()
+	if ovf.NumPages() < 6 {
+		t.Errorf("expected both values to be stored in overflow pages, got %d pages", ovf.NumPages())
+	}
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if got := onlyTuple(t, hf, tid); !got.equals(&tup) {
+		t.Errorf("large values did not round trip")
+	}
+	bp.CommitTransaction(tid)
+
+	// values that fit on a page with the rest of their tuple stay inline
+	small := largeTuple(td, "small", 100)
+	pages := ovf.NumPages()
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	hf.insertTuple(&small, tid)
+	bp.CommitTransaction(tid)
+	if ovf.NumPages() != pages {
+		t.Errorf("expected small values not to be stored in overflow pages")
+	}
+}
+
+// The chunks of a deleted value are freed, and reused by later values
+func TestOverflowDeleteFreesChunks(t *testing.T) {
+	td, hf, bp := makeOverflowTestVars(t)
+	tup := largeTuple(td, "doc", 2*PageSize)
+	for i := 0; i < 3; i++ {
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		if err := hf.insertTuple(&tup, tid); err != nil {
+			t.Fatalf("insert failed: %s", err)
+		}
+		bp.CommitTransaction(tid)
+		tid = NewTID()
+		bp.BeginTransaction(tid)
+		if err := hf.deleteTuple(onlyTuple(t, hf, tid), tid); err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+		bp.CommitTransaction(tid)
+	}
+	ovf, _ := hf.overflowFile()
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	if cnt := countVisible(t, ovf, tid); cnt != 0 {
+		t.Errorf("expected no chunks to be left, got %d", cnt)
+	}
+	if ovf.NumPages() > 3 {
+		t.Errorf("expected freed chunks to be reused, got %d pages", ovf.NumPages())
+	}
+}
+
+// A snapshot taken before a large value is deleted still reads the value
+func TestOverflowSnapshotRead(t *testing.T) {
+	td, hf, bp := makeOverflowTestVars(t)
+	tup := largeTuple(td, "doc", 2*PageSize)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	hf.insertTuple(&tup, tid)
+	bp.CommitTransaction(tid)
+
+	reader, writer := NewTID(), NewTID()
+	bp.BeginTransaction(reader)
+	bp.BeginTransaction(writer)
+	finishesSoon(t, func() error { return hf.deleteTuple(onlyTuple(t, hf, writer), writer) })
+	if err := bp.CommitTransaction(writer); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	if got := onlyTuple(t, hf, reader); !got.equals(&tup) {
+		t.Errorf("expected the snapshot to read the deleted value")
+	}
+}
+
+// Chunks are logged and rolled back like any other tuple
+func TestOverflowAbortAndRecovery(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{Fname: "name", Ftype: StringType}, {Fname: "data", Ftype: BytesType}}}
+	useTestFiles(t)
+	useTestLog(t)
+	bp := NewBufferPool(3)
+	if err := bp.OpenLog(TestingLogFile); err != nil {
+		t.Fatalf("failed to open log: %s", err)
+	}
+	hf, _ := NewHeapFile(TestingFile, &td, bp)
+	tup := largeTuple(td, "doc", 4*PageSize)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	if err := hf.insertTuple(&tup, tid); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	bp.AbortTransaction(tid)
+	ovf, _ := hf.overflowFile()
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if cnt := countVisible(t, ovf, tid); cnt != 0 {
+		t.Errorf("expected aborted chunks to be rolled back, got %d", cnt)
+	}
+	if err := hf.insertTuple(&tup, tid); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	bp.CommitTransaction(tid)
+	bp.logFile.Close()
+
+	bp = NewBufferPool(3)
+	if err := bp.OpenLog(TestingLogFile); err != nil {
+		t.Fatalf("recovery failed: %s", err)
+	}
+	defer bp.logFile.Close()
+	hf, _ = NewHeapFile(TestingFile, &td, bp)
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if got := onlyTuple(t, hf, tid); !got.equals(&tup) {
+		t.Errorf("expected large value to be recovered")
+	}
+}
+
+func TestBytesFieldCompareAndPrint(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{Fname: "data", Ftype: BytesType}}}
+	t1 := Tuple{Desc: td, Fields: []DBValue{BytesField{[]byte{0, 1}}}}
+	t2 := Tuple{Desc: td, Fields: []DBValue{BytesField{[]byte{0, 2}}}}
+	if t1.equals(&t2) || !t1.equals(&Tuple{Desc: td, Fields: []DBValue{BytesField{[]byte{0, 1}}}}) {
+		t.Errorf("byte strings compared wrong for equality")
+	}
+	expr := &FieldExpr{td.Fields[0]}
+	if order, err := t1.compareField(&t2, expr); err != nil || order != OrderedLessThan {
+		t.Errorf("expected %v to order before %v", t1.Fields[0], t2.Fields[0])
+	}
+	if s := t1.PrettyPrintString(false); s != "0x0001" {
+		t.Errorf("expected byte string to print as hex, got %s", s)
+	}
+}
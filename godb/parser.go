@@ -3,6 +3,7 @@ package godb
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -40,6 +41,8 @@ type LogicalSelectNode struct {
 	value       string
 	args        []*LogicalSelectNode //for functions other than aggregates
 	cachedField *FieldType
+	null        bool //for constants, whether the constant is NULL
+	boolean     bool //for constants, whether the constant is TRUE or FALSE
 }
 
 func NewFieldSelectNode(table string, field string, alias string) LogicalSelectNode {
@@ -57,6 +60,16 @@ func NewConstSelectNode(value string, alias string) LogicalSelectNode {
 	lsn.alias = alias
 	return lsn
 }
+func NewNullSelectNode(alias string) LogicalSelectNode {
+	lsn := NewConstSelectNode("null", alias)
+	lsn.null = true
+	return lsn
+}
+func NewBoolSelectNode(value bool, alias string) LogicalSelectNode {
+	lsn := NewConstSelectNode(strconv.FormatBool(value), alias)
+	lsn.boolean = true
+	return lsn
+}
 func NewStarSelectNode(table string) LogicalSelectNode {
 	lsn := LogicalSelectNode{}
 	lsn.exprType = ExprStar
@@ -167,8 +180,9 @@ type GroupBy struct {
 }
 
 type OrderByNode struct {
-	expr      *LogicalSelectNode
-	ascending bool
+	expr       *LogicalSelectNode
+	ascending  bool
+	nullsFirst bool
 }
 
 type LogicalPlan struct {
@@ -198,8 +212,14 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 	switch expr := expr.(type) {
 	case *sqlparser.AndExpr:
 		//print("got and")
-		filterListLeft, joinListLeft, _ := parseWhere(c, subqueries, ts, expr.Left)
-		filterListRight, joinListRight, _ := parseWhere(c, subqueries, ts, expr.Right)
+		filterListLeft, joinListLeft, err := parseWhere(c, subqueries, ts, expr.Left)
+		if err != nil {
+			return nil, nil, err
+		}
+		filterListRight, joinListRight, err := parseWhere(c, subqueries, ts, expr.Right)
+		if err != nil {
+			return nil, nil, err
+		}
 		filterExprs := append(filterListLeft, filterListRight...)
 		joinExprs := append(joinListLeft, joinListRight...)
 		return filterExprs, joinExprs, nil
@@ -240,9 +260,59 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 			lf[0] = &filter
 			return lf, nil, nil
 		}
+	case *sqlparser.IsExpr:
+		op, ok := BoolOpMap[expr.Operator]
+		if !ok {
+			// IS TRUE and the like
+			return parseBoolFilter(c, subqueries, ts, expr)
+		}
+		left, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		filter := LogicalFilterNode{*left, NewNullSelectNode(""), op}
+		return []*LogicalFilterNode{&filter}, nil, nil
+	case *sqlparser.ParenExpr:
+		return parseWhere(c, subqueries, ts, expr.Expr)
 	default:
-		return nil, nil, GoDBError{ParseError, "where expression with non value or column on RHS (disjunctions and nested where expressions are not supported)"}
+		return parseBoolFilter(c, subqueries, ts, expr)
+	}
+}
+
+// Parse a boolean expression of a where clause other than a conjunction or a
+// comparison, like a disjunction, as a filter that keeps the tuples for which
+// it is true
+func parseBoolFilter(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, expr sqlparser.Expr) ([]*LogicalFilterNode, []*LogicalJoinNode, error) {
+	e, err := parseExpr(c, expr, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	tables := make(map[string]bool)
+	if err := e.fieldTables(c, subqueries, ts, tables); err != nil {
+		return nil, nil, err
+	}
+	if len(tables) > 1 {
+		return nil, nil, GoDBError{ParseError, "where expressions other than conjunctions of comparisons may only refer to one table"}
+	}
+	filter := LogicalFilterNode{*e, NewBoolSelectNode(true, ""), OpEq}
+	return []*LogicalFilterNode{&filter}, nil, nil
+}
+
+// Add the names of the tables of the fields that lsn refers to to tables
+func (lsn *LogicalSelectNode) fieldTables(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, tables map[string]bool) error {
+	if lsn.exprType == ExprField {
+		tabName, _, err := lsn.getTableField(c, subqueries, ts)
+		if err != nil {
+			return err
+		}
+		tables[tabName] = true
 	}
+	for _, arg := range lsn.args {
+		if err := arg.fieldTables(c, subqueries, ts, tables); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*LogicalPlan, []*LogicalJoinNode, error) {
@@ -267,6 +337,10 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 			tableName := strings.ToLower(sqlparser.GetTableName(tableEx.Expr).CompliantName())
 			//fmt.Printf("got simple table, name %s\n", tableName)
 			dbFile, err := c.GetTable(tableName)
+			if err != nil && tableName == "dual" {
+				// a SELECT with no FROM clause, which selects a single row
+				return nil, nil, nil, nil
+			}
 			if err != nil {
 				return nil, nil, nil, err
 			}
@@ -320,7 +394,7 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 }
 
 func isAgg(funcName string) bool {
-	aggs := []string{"count", "sum", "avg", "min", "max"}
+	aggs := []string{"count", "sum", "avg", "min", "max", "bool_and", "bool_or"}
 	for _, s := range aggs {
 		if s == funcName {
 			return true
@@ -383,6 +457,36 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		exprList[1] = right
 		outer := NewFuncSelectNode(opname, exprList, alias)
 		return &outer, nil
+	case *sqlparser.ComparisonExpr:
+		// comparisons are functions that return booleans (see boolean.go)
+		op := strings.ToLower(expr.Operator)
+		if op == "!=" {
+			op = "<>"
+		}
+		if _, ok := funcs[op]; !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported comparison %s", expr.Operator)}
+		}
+		return parseFuncExpr(c, op, alias, expr.Left, expr.Right)
+	case *sqlparser.AndExpr:
+		return parseFuncExpr(c, "and", alias, expr.Left, expr.Right)
+	case *sqlparser.OrExpr:
+		return parseFuncExpr(c, "or", alias, expr.Left, expr.Right)
+	case *sqlparser.NotExpr:
+		return parseFuncExpr(c, "not", alias, expr.Expr)
+	case *sqlparser.IsExpr:
+		return parseFuncExpr(c, strings.ToLower(expr.Operator), alias, expr.Expr)
+	case sqlparser.BoolVal:
+		field := NewBoolSelectNode(bool(expr), alias)
+		return &field, nil
+	case *sqlparser.IntervalExpr:
+		// INTERVAL '90' DAY is the function interval(90, 'day')
+		n, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		unit := NewConstSelectNode(strings.ToLower(expr.Unit), "")
+		outer := NewFuncSelectNode("interval", []*LogicalSelectNode{n, &unit}, alias)
+		return &outer, nil
 	case *sqlparser.ParenExpr:
 		return parseExpr(c, expr.Expr, alias)
 	case *sqlparser.ColName:
@@ -403,11 +507,29 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		}
 		field := NewConstSelectNode(str, alias)
 		return &field, nil
+	case *sqlparser.NullVal:
+		field := NewNullSelectNode(alias)
+		return &field, nil
 	default:
 		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported expression type %s in select list", reflect.TypeOf(expr))}
 	}
 
 }
+
+// Parse the function op, like and, of the expressions args
+func parseFuncExpr(c *Catalog, op string, alias string, args ...sqlparser.Expr) (*LogicalSelectNode, error) {
+	exprList := make([]*LogicalSelectNode, len(args))
+	for i, arg := range args {
+		e, err := parseExpr(c, arg, "")
+		if err != nil {
+			return nil, err
+		}
+		exprList[i] = e
+	}
+	outer := NewFuncSelectNode(op, exprList, alias)
+	return &outer, nil
+}
+
 func parseSelect(c *Catalog, stmt sqlparser.SelectExpr) (*LogicalSelectNode, error) {
 	star, ok := stmt.(*sqlparser.StarExpr)
 	if ok {
@@ -499,11 +621,23 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 	}
 
 	for _, oby := range s.OrderBy {
+		if col, ok := oby.Expr.(*sqlparser.ColName); ok && col.Qualifier.IsEmpty() && len(orderBys) > 0 {
+			// the marker of a NULLS FIRST or NULLS LAST (see markNullsOrder)
+			switch col.Name.Lowered() {
+			case nullsFirstMarker:
+				orderBys[len(orderBys)-1].nullsFirst = true
+				continue
+			case nullsLastMarker:
+				orderBys[len(orderBys)-1].nullsFirst = false
+				continue
+			}
+		}
 		expr, err := parseExpr(c, oby.Expr, "")
 		if err != nil {
 			return nil, err
 		}
-		orderBys = append(orderBys, &OrderByNode{expr, oby.Direction == sqlparser.AscScr})
+		asc := oby.Direction == sqlparser.AscScr
+		orderBys = append(orderBys, &OrderByNode{expr, asc, !asc})
 
 	}
 
@@ -629,6 +763,20 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		e := FieldExpr{field}
 		return &e, fieldName, nil
 	case ExprConst:
+		if s.null {
+			fieldName := s.value
+			if s.alias != "" {
+				fieldName = s.alias
+			}
+			return &ConstExpr{NullField{}, UnknownType}, fieldName, nil
+		}
+		if s.boolean {
+			fieldName := s.value
+			if s.alias != "" {
+				fieldName = s.alias
+			}
+			return &ConstExpr{BoolField{s.value == "true"}, BoolType}, fieldName, nil
+		}
 
 		var fval any
 		constType := StringType
@@ -636,6 +784,12 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		if e == nil {
 			constType = IntType
 			fval = IntField{int64(intFval)}
+		} else if d, t, ok := decimalLiteral(s.value); ok {
+			constType = t
+			fval = d
+		} else if f, ok := floatLiteral(s.value); ok {
+			constType = FloatType
+			fval = f
 		} else {
 			fval = StringField{s.value}
 		}
@@ -658,6 +812,13 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 			}
 			exprs[i] = &newExpr
 		}
+		if tableFuncs[*s.funcOp] {
+			fe, err := newTableFuncExpr(c, *s.funcOp, exprs)
+			if err != nil {
+				return nil, "", err
+			}
+			return fe, fieldName, nil
+		}
 
 		fe := FuncExpr{*s.funcOp, exprs}
 		return &fe, fieldName, nil
@@ -684,6 +845,8 @@ func exprToStr(e Expr) string {
 			argStr += fmt.Sprintf("%s,", exprToStr(*arg))
 		}
 		return fmt.Sprintf("%s(%s)", ex.op, argStr)
+	case *TableFuncExpr:
+		return fmt.Sprintf("%s(%s)", ex.op, ex.file.Filename)
 	default:
 		return fmt.Sprintf("%+v, ", e)
 	}
@@ -705,6 +868,10 @@ func opToStr(op BoolOp) string {
 		return "<"
 	case OpLike:
 		return " LIKE "
+	case OpIsNull:
+		return " IS NULL"
+	case OpIsNotNull:
+		return " IS NOT NULL"
 
 	}
 	return "??"
@@ -766,8 +933,23 @@ func PrintPhysicalPlan(o Operator, indent string) {
 		fmt.Printf("%sFilter %s %s %s\n", indent, exprToStr(op.left), opToStr(op.op), exprToStr(op.right))
 		indent = indent + "\t"
 		PrintPhysicalPlan(op.child, indent)
+	case *Filter[float64]:
+		fmt.Printf("%sFilter %s %s %s\n", indent, exprToStr(op.left), opToStr(op.op), exprToStr(op.right))
+		indent = indent + "\t"
+		PrintPhysicalPlan(op.child, indent)
 	case *HeapFile:
 		fmt.Printf("%sHeap Scan %v\n", indent, getStrFromObj(op))
+	case *IndexScan:
+		fmt.Printf("%sIndex Scan %v using %s, %v to %v\n", indent, getStrFromObj(op.table), op.index.Name, op.lo, op.hi)
+	case *SortMergeJoin:
+		fmt.Printf("%sSort Merge Join, %+v == %+v\n", indent, exprToStr(op.leftField), exprToStr(op.rightField))
+		indent = indent + "\t"
+		PrintPhysicalPlan(op.left, indent)
+		PrintPhysicalPlan(op.right, indent)
+	case *IndexJoin:
+		fmt.Printf("%sIndex Join %s = %v using %s\n", indent, exprToStr(op.outerField), getStrFromObj(op.table), op.index.Name)
+		indent = indent + "\t"
+		PrintPhysicalPlan(op.outer, indent)
 	case *OrderBy:
 		orderStr := ""
 		for _, ex := range op.orderBy {
@@ -780,6 +962,8 @@ func PrintPhysicalPlan(o Operator, indent string) {
 		fmt.Printf("%sLimit %s\n", indent, exprToStr(op.limitTups))
 		indent = indent + "\t"
 		PrintPhysicalPlan(op.child, indent)
+	case *TableCount:
+		fmt.Printf("%sTable Count %v\n", indent, getStrFromObj(op.file))
 	case *Aggregator:
 		gbyStr := ""
 		if len(op.groupByFields) > 0 {
@@ -849,23 +1033,23 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		}
 
 		op := node.op
+		if scan := indexScanFor(op, leftExpr, f.predOp, rightExpr); scan != nil {
+			op = scan
+		}
 		desc := *op.Descriptor()
 		desc.setTableAlias(tabName)
 
-		switch leftExpr.GetExprType().Ftype {
-		case IntType:
-			newOp, err := NewIntFilter(rightExpr, f.predOp, leftExpr, op)
-			if err != nil {
-				return nil, err
-			}
-			tableMap[leftExpr.GetExprType().TableQualifier] = &PlanNode{newOp, &desc}
-		case StringType:
-			newOp, err := NewStringFilter(rightExpr, f.predOp, leftExpr, op)
-			if err != nil {
-				return nil, err
-			}
-			tableMap[leftExpr.GetExprType().TableQualifier] = &PlanNode{newOp, &desc}
+		newOp, err := newFilterOp(rightExpr, f.predOp, leftExpr, op)
+		if err != nil {
+			return nil, err
+		}
+		// a boolean expression over other expressions has no qualifier of
+		// its own, so fall back on the table its fields were found in
+		key := leftExpr.GetExprType().TableQualifier
+		if key == "" {
+			key = tabName
 		}
+		tableMap[key] = &PlanNode{newOp, &desc}
 	}
 	//finally apply joins
 	for _, j := range plan.joins {
@@ -913,11 +1097,22 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		var (
 			newOp Operator
 		)
-		switch leftExpr.GetExprType().Ftype {
-		case IntType:
-			newOp, err = NewIntJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
-		case StringType:
-			newOp, err = NewStringJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+		mergeJoin := (sortedOn(op1, leftExpr) && sortedOn(op2, rightExpr)) ||
+			orderedByJoin(c, plan, lTabName, lFieldName, rTabName, rFieldName)
+		if join := indexJoinFor(op1, leftExpr, op2, rightExpr); join != nil {
+			newOp = join
+		} else if mergeJoin {
+			newOp, err = NewSortMergeJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+		} else {
+			switch leftExpr.GetExprType().Ftype {
+			case IntType:
+				newOp, err = NewIntJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+			case StringType:
+				newOp, err = NewStringJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+			default:
+				// only a merge join joins values of other types
+				newOp, err = NewSortMergeJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+			}
 		}
 		if err != nil {
 			return nil, err
@@ -951,6 +1146,10 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		}
 	}
 
+	if first {
+		// no tables: the select list is evaluated once
+		curOp = NewValueOp([][]Expr{{}})
+	}
 	topOp := curOp
 
 	//var fieldList []FieldType
@@ -1001,17 +1200,46 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 					return nil, err
 				}
 
-				switch aggExpr.GetExprType().Ftype {
+				switch aggType := aggExpr.GetExprType().Ftype; aggType.kind() {
 				case IntType:
 					getter = intAggGetter
 				case StringType:
 					getter = stringAggGetter
+				case FloatType:
+					getter = floatAggGetter
+				case DecimalType:
+					getter = decimalAggGetter(aggType.scale())
+				case DateType, TimestampType:
+					getter = timeAggGetter
+				case BoolType:
+					getter = boolAggGetter
+				default:
+					if *s.funcOp != "count" {
+						return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compute %s of a field of this type", *s.funcOp)}
+					}
+				}
+				switch ft := aggExpr.GetExprType().Ftype; *s.funcOp {
+				case "sum", "avg":
+					if !ft.isNumeric() {
+						return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compute %s of a field of type %s", *s.funcOp, typeName(ft))}
+					}
+				case "min", "max":
+					if ft == BoolType {
+						return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compute %s of a field of type %s", *s.funcOp, typeName(ft))}
+					}
+				case "bool_and", "bool_or":
+					if ft != BoolType {
+						return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compute %s of a field of type %s", *s.funcOp, typeName(ft))}
+					}
 				}
 
+				float := aggExpr.GetExprType().Ftype.kind() == FloatType
 				switch *s.funcOp {
 				case "max":
 					if aggExpr.GetExprType().Ftype == StringType {
 						as = &MaxAggState[string]{}
+					} else if float {
+						as = &MaxAggState[float64]{}
 					} else {
 						as = &MaxAggState[int64]{}
 					}
@@ -1019,15 +1247,33 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 				case "min":
 					if aggExpr.GetExprType().Ftype == StringType {
 						as = &MinAggState[string]{}
+					} else if float {
+						as = &MinAggState[float64]{}
 					} else {
 						as = &MinAggState[int64]{}
 					}
 				case "avg":
-					as = &AvgAggState[int64]{}
+					if float {
+						as = &AvgAggState[float64]{}
+					} else {
+						as = &AvgAggState[int64]{}
+					}
 				case "sum":
-					as = &SumAggState[int64]{}
+					if float {
+						as = &SumAggState[float64]{}
+					} else {
+						as = &SumAggState[int64]{}
+					}
+				case "bool_and":
+					as = &BoolAggState{}
+				case "bool_or":
+					as = &BoolAggState{or: true}
 				case "count":
 					as = &CountAggState{}
+					if s.args[0].field == "*" {
+						// count every tuple, not those with a non NULL field
+						aggExpr = nil
+					}
 				default:
 					return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown aggregate function %s", *s.funcOp)}
 				}
@@ -1052,7 +1298,13 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		}
 
 		if len(gbys) == 0 {
-			topOp = NewAggregator(aggs, topOp)
+			agg := NewAggregator(aggs, topOp)
+			topOp = agg
+			if hf, ok := agg.child.(*HeapFile); ok {
+				if count := NewTableCount(hf, agg); count != nil {
+					topOp = count
+				}
+			}
 		} else {
 			topOp = NewGroupedAggregator(aggs, gbys, topOp)
 		}
@@ -1082,7 +1334,7 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 	}
 
 	if len(plan.orderByFields) > 0 {
-		var ascs []bool
+		var ascs, nullsFirst []bool
 
 		exprs := make([]Expr, len(plan.orderByFields))
 		for i, oby := range plan.orderByFields {
@@ -1092,10 +1344,11 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 			}
 			exprs[i] = expr
 			ascs = append(ascs, oby.ascending)
+			nullsFirst = append(nullsFirst, oby.nullsFirst)
 
 		}
 		var err error
-		topOp, err = NewOrderBy(exprs, topOp, ascs)
+		topOp, err = NewOrderByBuffered(exprs, topOp, ascs, nullsFirst, c.bp.sortMemory)
 		if err != nil {
 			return nil, err
 		}
@@ -1112,10 +1365,33 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 	return topOp, nil
 }
 
-func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
-	if insStmt.Columns != nil {
-		return nil, GoDBError{ParseError, "GoDB doesn't support inserts of incomplete tuples"}
+// Return an expression for each field of desc, in order: the expression in
+// exprs inserted into the field by an INSERT with the specified column list, or
+// NULL for fields that are not in the list
+func insertColumns(desc *TupleDesc, columns sqlparser.Columns, exprs []Expr) ([]Expr, error) {
+	if len(columns) != len(exprs) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected %d values to insert, got %d", len(columns), len(exprs))}
+	}
+	fields := make([]Expr, len(desc.Fields))
+	for i, col := range columns {
+		fieldNo, err := findFieldInTd(FieldType{col.Lowered(), "", UnknownType}, desc)
+		if err != nil {
+			return nil, GoDBError{ParseError, fmt.Sprintf("no column %s to insert into", col.String())}
+		}
+		if fields[fieldNo] != nil {
+			return nil, GoDBError{ParseError, fmt.Sprintf("column %s is inserted into twice", col.String())}
+		}
+		fields[fieldNo] = exprs[i]
 	}
+	for i := range fields {
+		if fields[i] == nil {
+			fields[i] = &ConstExpr{NullField{}, UnknownType}
+		}
+	}
+	return fields, nil
+}
+
+func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 	tab := insStmt.Table.Name
 	file, err := c.GetTable(sqlparser.String(tab))
 	if err != nil {
@@ -1138,6 +1414,12 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 				}
 				tupAr = append(tupAr, exprOp)
 			}
+			if insStmt.Columns != nil {
+				tupAr, err = insertColumns(file.Descriptor(), insStmt.Columns, tupAr)
+				if err != nil {
+					return nil, err
+				}
+			}
 			exprAr = append(exprAr, tupAr)
 		}
 		iterOp := NewValueOp(exprAr)
@@ -1153,6 +1435,24 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 		if err != nil {
 			return nil, err
 		}
+		if insStmt.Columns != nil {
+			var selected []Expr
+			for _, f := range op.Descriptor().Fields {
+				selected = append(selected, &FieldExpr{f})
+			}
+			exprs, err := insertColumns(file.Descriptor(), insStmt.Columns, selected)
+			if err != nil {
+				return nil, err
+			}
+			var names []string
+			for _, f := range file.Descriptor().Fields {
+				names = append(names, f.Fname)
+			}
+			op, err = NewProjectOp(exprs, names, false, op)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		insertOp := NewInsertOp(file, op)
 		return insertOp, nil
@@ -1211,24 +1511,127 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 		//op := node.op
 		//dbField, _ := fieldNameToField(f.table, f.field, &PlanNode{op, &desc})
 
-		switch leftExpr.GetExprType().Ftype {
-		case IntType:
-			//newInt, _ := strconv.Atoi(f.constVal)
-			newOp, err = NewIntFilter(rightExpr, f.predOp, leftExpr, newOp)
-			if err != nil {
-				return nil, err
-			}
-		case StringType:
-			newOp, err = NewStringFilter(rightExpr, f.predOp, leftExpr, newOp)
-			if err != nil {
-				return nil, err
-			}
+		newOp, err = newFilterOp(rightExpr, f.predOp, leftExpr, newOp)
+		if err != nil {
+			return nil, err
 		}
 	}
 	return NewDeleteOp(*tables[0].file, newOp), nil
 
 }
 
+// Return an IndexScan of the tuples of op, a heap table, that may satisfy the
+// predicate field op constExpr, if field is a column of the table with an index
+// and constExpr a constant it can be looked up by, or nil.  The predicate must
+// still be applied to the tuples of the scan.
+func indexScanFor(op Operator, field Expr, predOp BoolOp, constExpr Expr) *IndexScan {
+	hf, ok := op.(*HeapFile)
+	fe, fok := field.(*FieldExpr)
+	ce, cok := constExpr.(*ConstExpr)
+	if !ok || !fok || !cok {
+		return nil
+	}
+	idx := hf.indexOn(fe.selectField.Fname)
+	if idx == nil {
+		return nil
+	}
+	v, err := ce.EvalExpr(nil)
+	if err != nil || isNull(v) {
+		return nil
+	}
+	var lo, hi DBValue
+	switch predOp {
+	case OpEq:
+		lo, hi = v, v
+	case OpGt, OpGe:
+		lo = v
+	case OpLt, OpLe:
+		hi = v
+	default:
+		return nil
+	}
+	if idx.Method == HashIndex && predOp != OpEq {
+		return nil
+	}
+	scan, err := NewIndexScan(hf, idx, lo, hi)
+	if err != nil {
+		return nil
+	}
+	return scan
+}
+
+// Return an IndexJoin of op1 and op2 on leftExpr = rightExpr if either of them
+// is a heap table with a hash index on the column it is joined on, probing the
+// index of op2 if both are, or nil
+func indexJoinFor(op1 Operator, leftExpr Expr, op2 Operator, rightExpr Expr) *IndexJoin {
+	if hf, idx := hashIndexOn(op2, rightExpr); idx != nil {
+		if join, err := NewIndexJoin(op1, leftExpr, hf, idx, false); err == nil {
+			return join
+		}
+	}
+	if hf, idx := hashIndexOn(op1, leftExpr); idx != nil {
+		if join, err := NewIndexJoin(op2, rightExpr, hf, idx, true); err == nil {
+			return join
+		}
+	}
+	return nil
+}
+
+// Return true if the results of plan are ordered first by one of the columns
+// of a join, lField of lTab or rField of rTab, in ascending order, with no
+// aggregate in between, so that a [SortMergeJoin] returns its tuples in the
+// order they are sorted by
+func orderedByJoin(c *Catalog, plan *LogicalPlan, lTab string, lField string, rTab string, rField string) bool {
+	if len(plan.orderByFields) == 0 || len(plan.aggs) > 0 || len(plan.groupByFields) > 0 {
+		return false
+	}
+	first := plan.orderByFields[0]
+	if !first.ascending || first.expr.exprType != ExprField {
+		return false
+	}
+	tab, field, err := first.expr.getTableField(c, plan.subqueries, plan.tables)
+	return err == nil && ((tab == lTab && field == lField) || (tab == rTab && field == rField))
+}
+
+// Return op, if it is a heap table, and its hash index on field, if field is
+// one of its columns and it has one
+func hashIndexOn(op Operator, field Expr) (*HeapFile, *Index) {
+	hf, ok := op.(*HeapFile)
+	fe, fok := field.(*FieldExpr)
+	if !ok || !fok {
+		return nil, nil
+	}
+	if idx := hf.indexOn(fe.selectField.Fname); idx != nil && idx.Method == HashIndex {
+		return hf, idx
+	}
+	return nil, nil
+}
+
+// Return a filter of child on the predicate field op constExpr, which compares
+// numbers of different types as the more general of their types
+func newFilterOp(constExpr Expr, op BoolOp, field Expr, child Operator) (Operator, error) {
+	t := field.GetExprType().Ftype
+	if c := constExpr.GetExprType().Ftype; t.isNumeric() && c.isNumeric() {
+		t = commonNumericKind(t, c)
+	}
+	if t.isTemporal() || constExpr.GetExprType().Ftype.isTemporal() {
+		return NewTimeFilter(constExpr, op, field, child)
+	}
+	switch t.kind() {
+	case IntType:
+		return NewIntFilter(constExpr, op, field, child)
+	case StringType:
+		return NewStringFilter(constExpr, op, field, child)
+	case DecimalType:
+		return NewDecimalFilter(constExpr, op, field, child)
+	case FloatType:
+		return NewFloatFilter(constExpr, op, field, child)
+	case BoolType:
+		return NewBoolFilter(constExpr, op, field, child)
+	}
+	return nil, GoDBError{TypeMismatchError, "cannot filter on a field of this type"}
+}
+
 type QueryType int
 
 const (
@@ -1238,6 +1641,13 @@ const (
 	AbortXactionType     QueryType = iota
 	CreateTableQueryType QueryType = iota
 	DropTableQueryType   QueryType = iota
+	CheckpointQueryType  QueryType = iota
+	SetIsolationType     QueryType = iota
+	SavepointType        QueryType = iota
+	RollbackToType       QueryType = iota
+	ReleaseSavepointType QueryType = iota
+	CreateIndexQueryType QueryType = iota
+	DropIndexQueryType   QueryType = iota
 	UnknownQueryType     QueryType = iota
 )
 
@@ -1253,7 +1663,7 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 		for i, col := range ddl.TableSpec.Columns {
 			var colType DBType
 			colName := sqlparser.String(col.Name)
-			switch col.Type.Type {
+			switch strings.ToLower(col.Type.Type) {
 			case "int":
 				colType = IntType
 			case "string":
@@ -1262,6 +1672,37 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 				fallthrough
 			case "varchar":
 				colType = StringType
+				if col.Type.Comment != nil {
+					switch string(col.Type.Comment.Val) {
+					case columnTypeMarker + "interval":
+						colType = IntervalType
+					case columnTypeMarker + "bool", columnTypeMarker + "boolean":
+						colType = BoolType
+					}
+				}
+			case "blob":
+				fallthrough
+			case "varbinary":
+				colType = BytesType
+			case "float", "double", "real":
+				colType = FloatType
+			case "date":
+				colType = DateType
+			case "timestamp", "datetime":
+				colType = TimestampType
+			case "decimal", "numeric":
+				var precision, scale []byte
+				if col.Type.Length != nil {
+					precision = col.Type.Length.Val
+				}
+				if col.Type.Scale != nil {
+					scale = col.Type.Scale.Val
+				}
+				t, err := parseDecimalType(string(precision), string(scale))
+				if err != nil {
+					return UnknownQueryType, err
+				}
+				colType = t
 			default:
 				return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported column type %s", col.Type.Type)}
 
@@ -1284,8 +1725,224 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 	}
 }
 
+// The lower case words of a statement that sqlparser does not understand
+type statementWords []string
+
+func splitStatement(query string) *statementWords {
+	words := statementWords(strings.Fields(strings.ToLower(strings.TrimSuffix(strings.TrimSpace(query), ";"))))
+	return &words
+}
+
+// Consume the next word if it is word
+func (w *statementWords) skip(word string) bool {
+	if len(*w) > 0 && (*w)[0] == word {
+		*w = (*w)[1:]
+		return true
+	}
+	return false
+}
+
+// Parse BEGIN [TRANSACTION | WORK] ISOLATION LEVEL <level> (a BeginXactionType
+// statement) and SET [SESSION] TRANSACTION ISOLATION LEVEL <level> (a
+// SetIsolationType statement), which sqlparser does not understand.  ok is
+// false if query is neither.
+func parseIsolationStatement(query string) (qtype QueryType, level IsolationLevel, ok bool, err error) {
+	words := splitStatement(query)
+	switch {
+	case words.skip("begin"):
+		qtype = BeginXactionType
+		if !words.skip("transaction") {
+			words.skip("work")
+		}
+	case words.skip("set"):
+		qtype = SetIsolationType
+		words.skip("session")
+		if !words.skip("transaction") {
+			return UnknownQueryType, DefaultIsolationLevel, false, nil
+		}
+	default:
+		return UnknownQueryType, DefaultIsolationLevel, false, nil
+	}
+	if !words.skip("isolation") || !words.skip("level") {
+		return UnknownQueryType, DefaultIsolationLevel, false, nil
+	}
+	level, ok = isolationLevelNamed(*words)
+	if !ok {
+		return UnknownQueryType, level, true, GoDBError{ParseError, fmt.Sprintf("unknown isolation level %s", strings.Join(*words, " "))}
+	}
+	return qtype, level, true, nil
+}
+
+// Parse SAVEPOINT <name> (a SavepointType statement), ROLLBACK [WORK] TO
+// [SAVEPOINT] <name> (RollbackToType) and RELEASE [SAVEPOINT] <name>
+// (ReleaseSavepointType), which sqlparser does not understand.  ok is false if
+// query is none of them.
+func parseSavepointStatement(query string) (qtype QueryType, name string, ok bool, err error) {
+	words := splitStatement(query)
+	switch {
+	case words.skip("savepoint"):
+		qtype = SavepointType
+	case words.skip("rollback"):
+		words.skip("work")
+		if !words.skip("to") {
+			return UnknownQueryType, "", false, nil
+		}
+		qtype = RollbackToType
+		words.skip("savepoint")
+	case words.skip("release"):
+		qtype = ReleaseSavepointType
+		words.skip("savepoint")
+	default:
+		return UnknownQueryType, "", false, nil
+	}
+	if len(*words) != 1 {
+		return UnknownQueryType, "", true, GoDBError{ParseError, "expected a savepoint name"}
+	}
+	return qtype, (*words)[0], true, nil
+}
+
+// Matches CREATE INDEX <name> ON <table> (<column>) and DROP INDEX <name> [ON
+// <table>]
+var (
+	createIndexRegexp = regexp.MustCompile(`(?i)^\s*create\s+index\s+(\w+)\s+on\s+(\w+)(?:\s+using\s+(\w+))?\s*\(\s*(\w+)\s*\)(?:\s*using\s+(\w+))?\s*;?\s*$`)
+	dropIndexRegexp   = regexp.MustCompile(`(?i)^\s*drop\s+index\s+(\w+)(?:\s+on\s+(\w+))?\s*;?\s*$`)
+)
+
+// Create or drop an index for a CREATE INDEX (a CreateIndexQueryType statement)
+// or DROP INDEX statement (DropIndexQueryType), whose column lists sqlparser
+// does not keep.  The method of the index is given by USING, before or after
+// the column list.  ok is false if query is neither.
+func parseIndexStatement(c *Catalog, query string) (qtype QueryType, ok bool, err error) {
+	if m := createIndexRegexp.FindStringSubmatch(query); m != nil {
+		name, table, column := strings.ToLower(m[1]), strings.ToLower(m[2]), strings.ToLower(m[4])
+		method := BTreeIndex
+		if using := strings.ToLower(m[3] + m[5]); using != "" {
+			var ok bool
+			if method, ok = indexMethodNamed(using); !ok {
+				return UnknownQueryType, true, GoDBError{ParseError, fmt.Sprintf("unknown index method '%s'", using)}
+			}
+		}
+		if err := c.CreateIndex(name, table, column, method); err != nil {
+			return UnknownQueryType, true, err
+		}
+		return CreateIndexQueryType, true, nil
+	}
+	if m := dropIndexRegexp.FindStringSubmatch(query); m != nil {
+		name := strings.ToLower(m[1])
+		if t, _ := c.findIndex(name); t != nil && m[2] != "" && t.name != strings.ToLower(m[2]) {
+			return UnknownQueryType, true, GoDBError{NoSuchTableError, fmt.Sprintf("no index '%s' on table '%s'", name, m[2])}
+		}
+		if err := c.DropIndex(name); err != nil {
+			return UnknownQueryType, true, err
+		}
+		return DropIndexQueryType, true, nil
+	}
+	return UnknownQueryType, false, nil
+}
+
+// Return the name of the savepoint set, rolled back to or released by query, if
+// it is a SAVEPOINT, ROLLBACK TO or RELEASE statement (see Parse)
+func ParseSavepointName(query string) (string, bool) {
+	_, name, ok, err := parseSavepointStatement(query)
+	return name, ok && err == nil
+}
+
+// Return the isolation level set by a BEGIN or SET TRANSACTION statement, if
+// query is one that names an isolation level (see Parse)
+func ParseIsolationLevel(query string) (IsolationLevel, bool) {
+	_, level, ok, err := parseIsolationStatement(query)
+	return level, ok && err == nil
+}
+
+// Names of the columns that stand for NULLS FIRST and NULLS LAST in the ORDER
+// BY lists of queries rewritten by markNullsOrder
+const (
+	nullsFirstMarker = "__godb_nulls_first"
+	nullsLastMarker  = "__godb_nulls_last"
+)
+
+// Matches string literals, which are left alone, and NULLS FIRST or NULLS LAST
+var nullsOrderRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.)*"|(?i)\bnulls\s+(first|last)\b`)
+
+// Rewrite each NULLS FIRST or NULLS LAST that follows an ORDER BY expression,
+// which sqlparser does not understand, as an extra ORDER BY expression naming a
+// marker column (see parseStatement)
+func markNullsOrder(query string) string {
+	return nullsOrderRegexp.ReplaceAllStringFunc(query, func(m string) string {
+		if m[0] == '\'' || m[0] == '"' {
+			return m
+		}
+		if strings.HasSuffix(strings.ToLower(m), "first") {
+			return ", " + nullsFirstMarker
+		}
+		return ", " + nullsLastMarker
+	})
+}
+
+// The prefix of the comment that marks the varchar columns of CREATE TABLE
+// statements rewritten by rewriteTypeSyntax that are of another type, whose name
+// follows it
+const columnTypeMarker = "__godb_type_"
+
+// Matches string literals, which are left alone, DATE and TIMESTAMP literals
+// and the start of EXTRACT(unit FROM ...)
+var typeSyntaxRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.)*"|(?i)\b(date|timestamp)\s+('(?:[^'\\]|\\.|'')*')|\bextract\s*\(\s*(\w+)\s+from\b`)
+
+// Matches the start of a CREATE TABLE statement
+var createTableRegexp = regexp.MustCompile(`(?i)^\s*create\s+table\b`)
+
+// Matches string literals, which are left alone, and the definition of a
+// column of type INTERVAL or BOOLEAN in a CREATE TABLE statement: the name of
+// the column followed by the type, at the end of the definition
+var columnTypeRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.)*"|(?i)\b(\w+\s+)(interval|bool|boolean)(\s*[,)])`)
+
+// Rewrite the syntax of types that sqlparser does not understand: DATE
+// '2024-01-31' and TIMESTAMP '...' as the functions date('2024-01-31') and
+// timestamp('...'), EXTRACT(unit FROM ...) as extract('unit', ...), and, in a
+// CREATE TABLE statement, a column of type INTERVAL or BOOLEAN as a varchar
+// marked with columnTypeMarker (see processDDL).  Elsewhere, these type names
+// are left alone, as they may name columns.
+func rewriteTypeSyntax(query string) string {
+	query = typeSyntaxRegexp.ReplaceAllStringFunc(query, func(m string) string {
+		sub := typeSyntaxRegexp.FindStringSubmatch(m)
+		switch {
+		case sub[1] != "":
+			return sub[1] + "(" + sub[2] + ")"
+		case sub[3] != "":
+			return "extract('" + sub[3] + "',"
+		}
+		return m
+	})
+	if !createTableRegexp.MatchString(query) {
+		return query
+	}
+	return columnTypeRegexp.ReplaceAllStringFunc(query, func(m string) string {
+		sub := columnTypeRegexp.FindStringSubmatch(m)
+		if sub[2] == "" {
+			return m
+		}
+		return sub[1] + "varchar comment '" + columnTypeMarker + strings.ToLower(sub[2]) + "'" + sub[3]
+	})
+}
+
 func Parse(c *Catalog, query string) (QueryType, Operator, error) {
-	stmt, err := sqlparser.Parse(query)
+	// not understood by sqlparser
+	if strings.EqualFold(strings.TrimSpace(query), "checkpoint") {
+		if err := c.Checkpoint(); err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return CheckpointQueryType, nil, nil
+	}
+	if qtype, _, ok, err := parseIsolationStatement(query); ok {
+		return qtype, nil, err
+	}
+	if qtype, _, ok, err := parseSavepointStatement(query); ok {
+		return qtype, nil, err
+	}
+	if qtype, ok, err := parseIndexStatement(c, query); ok {
+		return qtype, nil, err
+	}
+	stmt, err := sqlparser.Parse(rewriteTypeSyntax(markNullsOrder(query)))
 	if err != nil {
 		return UnknownQueryType, nil, err
 	}
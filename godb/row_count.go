@@ -0,0 +1,377 @@
+package godb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Every heap file keeps the number of rows committed to it in a header, so
+// that an unfiltered COUNT(*) (see [TableCount]) and the table_rows function
+// need not scan the file.  The header is stored in a file of its own next to
+// the heap file (see headerFileName), so that page numbers are not shifted by
+// it.  It holds the row count along with the number of pages of the file it was
+// written with; a header that does not match the file, or that is missing, is
+// rebuilt by summing the numbers of used slots in the headers of the pages of
+// the file.
+//
+// The buffer pool reads the count of a file before the file is first changed,
+// and keeps it up to date as transactions commit: the inserts and deletes a
+// transaction made are its changes in the version store (see
+// transactionChanges), so rolling back to a savepoint or aborting takes them
+// back as well.  The header is written whenever a transaction that changed the
+// file commits, to a temporary file that then replaces it, so that a crash
+// never leaves a header half written (see writeRowCount).  After a crash, the headers of the files named in the log may
+// be out of date, so recovery removes them (see [LogFile.Recover]).
+
+const (
+	// the format version of header files, which begin with pageMagic
+	rowCountVersion = 1
+	rowCountSize    = 2 + 2 + 4 + 8
+)
+
+// The number of rows committed to a heap file, and the value of commitSeq
+// (see mvcc.go) when it last changed
+type tableRowCount struct {
+	rows int64
+	seq  int64
+}
+
+// Return the name of the header file of the heap file stored in fileName
+func headerFileName(fileName string) string {
+	return fileName + ".hdr"
+}
+
+// Remove the header file of the heap file stored in fileName, if it has one
+func removeHeaderFile(fileName string) {
+	os.Remove(headerFileName(fileName))
+}
+
+// Return the number of rows in the header of the heap file stored in fileName,
+// rebuilding it from the pages of the file if the header is missing or out of
+// date.  The pages on disk must hold the committed rows of the file.
+func readRowCount(fileName string) (int64, error) {
+	pages := 0
+	if info, err := os.Stat(fileName); err == nil {
+		pages = int(info.Size() / int64(PageSize))
+	}
+	hdr, err := os.ReadFile(headerFileName(fileName))
+	if err == nil && len(hdr) == rowCountSize &&
+		binary.LittleEndian.Uint16(hdr[0:2]) == pageMagic &&
+		binary.LittleEndian.Uint16(hdr[2:4]) == rowCountVersion &&
+		int(binary.LittleEndian.Uint32(hdr[4:8])) == pages {
+		return int64(binary.LittleEndian.Uint64(hdr[8:16])), nil
+	}
+	return sumUsedSlots(fileName, pages)
+}
+
+// Sum the numbers of used slots of the first pages pages of the heap file stored
+// in fileName
+func sumUsedSlots(fileName string, pages int) (int64, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var rows int64
+	img := make([]byte, pageHeaderSize)
+	for pageNo := 0; pageNo < pages; pageNo++ {
+		if _, err := file.ReadAt(img, int64(pageNo*PageSize)); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if isZeroImage(img) {
+			// a page that was never written
+			continue
+		}
+		if err := checkImageFormat(img); err != nil {
+			return 0, err
+		}
+		_, useds := imageHeader(img)
+		rows += int64(useds)
+	}
+	return rows, nil
+}
+
+// Write the header of the heap file stored in fileName, which has the specified
+// number of pages and rows.  The header is written and synced to a temporary
+// file first, which is then renamed over the header, so that the header is
+// either the old one or the new one after a crash.
+func writeRowCount(fileName string, pages int, rows int64) error {
+	hdr := make([]byte, rowCountSize)
+	binary.LittleEndian.PutUint16(hdr[0:2], pageMagic)
+	binary.LittleEndian.PutUint16(hdr[2:4], rowCountVersion)
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(pages))
+	binary.LittleEndian.PutUint64(hdr[8:16], uint64(rows))
+
+	tmpName := headerFileName(fileName) + ".tmp"
+	out, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+	defer out.Close()
+	if _, err := out.Write(hdr); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, headerFileName(fileName))
+}
+
+// Return the row count of the heap file stored in fileName, reading it from
+// the header of the file if it has not been read yet.  The caller must hold
+// poolLock.
+func (bp *BufferPool) rowCount(fileName string) (*tableRowCount, error) {
+	if count, ok := bp.tableRows[fileName]; ok {
+		return count, nil
+	}
+	rows, err := readRowCount(fileName)
+	if err != nil {
+		return nil, err
+	}
+	count := &tableRowCount{rows, 0}
+	bp.tableRows[fileName] = count
+	return count, nil
+}
+
+// Make sure the row count of f has been read before a transaction changes f,
+// since the pages of f on disk no longer hold its committed rows once it has
+func (bp *BufferPool) trackRows(f *HeapFile) error {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	_, err := bp.rowCount(f.Filename)
+	return err
+}
+
+// Record that the heap file stored in fileName, which has just been created or
+// emptied, has no rows.  The caller must not hold poolLock.
+func (bp *BufferPool) resetRows(fileName string) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	if count, ok := bp.tableRows[fileName]; ok {
+		count.rows = 0
+	}
+	removeHeaderFile(fileName)
+}
+
+// Forget the row count of the heap file stored in fileName, which has been
+// removed, along with its header
+func (bp *BufferPool) forgetRows(fileName string) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	delete(bp.tableRows, fileName)
+	removeHeaderFile(fileName)
+}
+
+// Return the number of rows tid has inserted into the file stored in fileName,
+// less those it has deleted from it.  The caller must hold poolLock.
+func (bp *BufferPool) rowDelta(tid TransactionID, fileName string) int64 {
+	var delta int64
+	for _, c := range bp.transactionChanges[tid] {
+		if c.page.FileName != fileName {
+			continue
+		}
+		if c.rtype == InsertRecord {
+			delta++
+		} else {
+			delta--
+		}
+	}
+	return delta
+}
+
+// Add the rows tid, which is committing with sequence number seq, inserted and
+// deleted to the row counts of the files it changed, and write their headers.
+// Must be called before the changes of tid are forgotten.  The caller must hold
+// poolLock.
+//
+// tid has committed by now, so a header that cannot be written is removed
+// instead, to be rebuilt from the pages of its file the next time it is read.
+func (bp *BufferPool) commitRowCounts(tid TransactionID, seq int64) {
+	changed := make(map[string]bool)
+	for _, c := range bp.transactionChanges[tid] {
+		changed[c.page.FileName] = true
+	}
+	for fileName := range changed {
+		count, ok := bp.tableRows[fileName]
+		if !ok {
+			// not a table, but the overflow file of one
+			continue
+		}
+		count.rows += bp.rowDelta(tid, fileName)
+		count.seq = seq
+		pages := 0
+		if info, err := os.Stat(fileName); err == nil {
+			pages = int(info.Size() / int64(PageSize))
+		}
+		if err := writeRowCount(fileName, pages, count.rows); err != nil {
+			removeHeaderFile(fileName)
+		}
+	}
+}
+
+// Return the number of rows of f tid sees, from the row count of f, or false
+// if it must be counted by scanning f instead.
+//
+// A transaction sees the committed rows, along with the changes it made
+// itself, unless its scans read from a snapshot that does not see the last
+// commit that changed f, or it reads uncommitted rows (see isolation.go).
+// Under Serializable, f is locked in shared mode first, as a scan would, so no
+// other transaction has changes to it that have not committed.
+func (bp *BufferPool) countRows(f *HeapFile, tid TransactionID) (int64, bool, error) {
+	bp.beginScan(tid)
+	bp.poolLock.Lock()
+	if _, ok := bp.aliveTransactions[tid]; !ok {
+		bp.poolLock.Unlock()
+		return 0, false, GoDBError{IllegalTransactionError, "transaction is not running"}
+	}
+	level := bp.isolationLevel(tid)
+	bp.poolLock.Unlock()
+	if level == ReadUncommitted {
+		return 0, false, nil
+	}
+	if level == Serializable {
+		if err := bp.lockManager.Acquire(tid, tableLockKey{f.Filename}, Shared); err != nil {
+			return 0, false, bp.abortOnError(tid, err)
+		}
+	}
+
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	count, err := bp.rowCount(f.Filename)
+	if err != nil {
+		return 0, false, err
+	}
+	if level != Serializable && count.seq > bp.snapshots[tid] {
+		return 0, false, nil
+	}
+	return count.rows + bp.rowDelta(tid, f.Filename), true, nil
+}
+
+// Return the number of rows committed to f
+func (bp *BufferPool) committedRows(f *HeapFile) (int64, error) {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	count, err := bp.rowCount(f.Filename)
+	if err != nil {
+		return 0, err
+	}
+	return count.rows, nil
+}
+
+// TableCount answers a COUNT(*) of every row of a heap file, with no WHERE
+// clause or GROUP BY, from the row count of the file, or by running the
+// aggregate it replaces when the row count cannot be used (see
+// [BufferPool.countRows])
+type TableCount struct {
+	file *HeapFile
+	agg  *Aggregator
+}
+
+// Return a TableCount for agg, an aggregate of the rows of file, or nil if agg
+// does not only count the rows of file
+func NewTableCount(file *HeapFile, agg *Aggregator) *TableCount {
+	if agg.child != Operator(file) || agg.groupByFields != nil {
+		return nil
+	}
+	for _, as := range agg.newAggState {
+		if count, ok := as.(*CountAggState); !ok || count.expr != nil {
+			return nil
+		}
+	}
+	return &TableCount{file, agg}
+}
+
+func (c *TableCount) Descriptor() *TupleDesc {
+	return c.agg.Descriptor()
+}
+
+// Return the single tuple of the counts, one for each COUNT(*) of the aggregate
+func (c *TableCount) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	rows, ok, err := c.file.bufPool.countRows(c.file, tid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return c.agg.Iterator(tid)
+	}
+	desc := c.Descriptor()
+	done := false
+	return func() (*Tuple, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
+		fields := make([]DBValue, len(desc.Fields))
+		for i := range fields {
+			fields[i] = IntField{rows}
+		}
+		return &Tuple{*desc, fields, nil}, nil
+	}, nil
+}
+
+// The functions of tables, which take the name of a table: table_rows returns
+// the number of rows committed to it, table_pages the number of pages of its
+// heap file, and table_size the number of bytes its heap file and the overflow
+// file next to it take on disk
+var tableFuncs = map[string]bool{"table_rows": true, "table_pages": true, "table_size": true}
+
+// A call of one of tableFuncs, on a table found in the catalog as the query was
+// planned
+type TableFuncExpr struct {
+	op   string
+	file *HeapFile
+}
+
+// Return the expression for a call of the table function op with the
+// specified arguments, which must be the name of a table of c
+func newTableFuncExpr(c *Catalog, op string, args []*Expr) (*TableFuncExpr, error) {
+	if len(args) != 1 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected 1 args", op)}
+	}
+	name, ok := (*args[0]).(*ConstExpr)
+	if !ok || name.constType != StringType {
+		return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected the name of a table", op)}
+	}
+	table, err := c.GetTable(name.val.(StringField).Value)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := table.(*HeapFile)
+	if !ok {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("function %s expected a heap table", op)}
+	}
+	return &TableFuncExpr{op, file}, nil
+}
+
+func (e *TableFuncExpr) GetExprType() FieldType {
+	return FieldType{e.op, "", IntType}
+}
+
+func (e *TableFuncExpr) EvalExpr(_ *Tuple) (DBValue, error) {
+	switch e.op {
+	case "table_rows":
+		rows, err := e.file.bufPool.committedRows(e.file)
+		if err != nil {
+			return nil, err
+		}
+		return IntField{rows}, nil
+	case "table_pages":
+		return IntField{int64(e.file.NumPages())}, nil
+	case "table_size":
+		size := int64(e.file.NumPages() * PageSize)
+		if info, err := os.Stat(overflowFileName(e.file.Filename)); err == nil {
+			size += info.Size()
+		}
+		return IntField{size}, nil
+	}
+	return nil, GoDBError{ParseError, fmt.Sprintf("unknown function %s", e.op)}
+}
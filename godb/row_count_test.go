@@ -0,0 +1,168 @@
+package godb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Return the number of rows counted by scanning hf, and from its row count, on
+// behalf of a new transaction
+func testCounts(t *testing.T, hf *HeapFile) (int, int64) {
+	tid := NewTID()
+	hf.bufPool.BeginTransaction(tid)
+	defer hf.bufPool.CommitTransaction(tid)
+	scanned := 0
+	iter, _ := hf.Iterator(tid)
+	for tup, _ := iter(); tup != nil; tup, _ = iter() {
+		scanned++
+	}
+	rows, ok, err := hf.bufPool.countRows(hf, tid)
+	if err != nil || !ok {
+		t.Fatalf("expected the row count to be used, got %v", err)
+	}
+	return scanned, rows
+}
+
+func TestRowCountMaintained(t *testing.T) {
+	_, hf, _, bp := makeIndexTestVars(t)
+	insertAges(t, hf, 1, 2, 3, 4, 5, 6)
+	if scanned, rows := testCounts(t, hf); scanned != 6 || rows != 6 {
+		t.Errorf("expected 6 rows, scanned %d and counted %d", scanned, rows)
+	}
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	deleteAge(t, hf, 2, tid)
+	bp.Savepoint(tid, "sp")
+	deleteAge(t, hf, 3, tid)
+	tup := Tuple{Desc: *hf.Descriptor(), Fields: []DBValue{StringField{"joe"}, IntField{7}}}
+	hf.insertTuple(&tup, tid)
+	bp.RollbackToSavepoint(tid, "sp")
+	if rows, _, _ := bp.countRows(hf, tid); rows != 5 {
+		t.Errorf("expected the transaction to count its own delete, got %d rows", rows)
+	}
+	bp.CommitTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	deleteAge(t, hf, 4, tid)
+	tup = Tuple{Desc: *hf.Descriptor(), Fields: []DBValue{StringField{"joe"}, IntField{8}}}
+	hf.insertTuple(&tup, tid)
+	hf.insertTuple(&tup, tid)
+	bp.AbortTransaction(tid)
+	if scanned, rows := testCounts(t, hf); scanned != 5 || rows != 5 {
+		t.Errorf("expected 5 rows after the abort, scanned %d and counted %d", scanned, rows)
+	}
+
+	// the count is read back from the header, or rebuilt from the pages
+	reopened, _ := NewHeapFile(hf.Filename, hf.Descriptor(), NewBufferPool(10))
+	if rows, err := reopened.bufPool.committedRows(reopened); err != nil || rows != 5 {
+		t.Errorf("expected 5 rows in the header, got %d (%v)", rows, err)
+	}
+	writeRowCount(hf.Filename, hf.NumPages()+1, 100)
+	reopened, _ = NewHeapFile(hf.Filename, hf.Descriptor(), NewBufferPool(10))
+	if rows, err := reopened.bufPool.committedRows(reopened); err != nil || rows != 5 {
+		t.Errorf("expected 5 rows to be counted from the pages, got %d (%v)", rows, err)
+	}
+	os.Remove(hf.Filename)
+	reopened, _ = NewHeapFile(hf.Filename, hf.Descriptor(), bp)
+	if rows, err := bp.committedRows(reopened); err != nil || rows != 0 {
+		t.Errorf("expected a new file to have no rows, got %d (%v)", rows, err)
+	}
+}
+
+func TestRowCountSnapshot(t *testing.T) {
+	_, hf, _, bp := makeIndexTestVars(t)
+	insertAges(t, hf, 1, 2, 3)
+
+	old := NewTID()
+	bp.BeginTransaction(old)
+	insertAges(t, hf, 4)
+	if _, ok, _ := bp.countRows(hf, old); ok {
+		t.Errorf("expected a snapshot that does not see the last insert not to use the row count")
+	}
+	agg := NewAggregator([]AggState{&CountAggState{alias: "count"}}, hf)
+	count := NewTableCount(hf, agg)
+	iter, err := count.Iterator(old)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if tup, _ := iter(); tup == nil || tup.Fields[0].(IntField).Value != 3 {
+		t.Errorf("expected the snapshot to count 3 rows, got %v", tup)
+	}
+	bp.CommitTransaction(old)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid, WithIsolationLevel(ReadCommitted))
+	if rows, ok, _ := bp.countRows(hf, tid); !ok || rows != 4 {
+		t.Errorf("expected 4 rows from the row count, got %d", rows)
+	}
+	bp.CommitTransaction(tid)
+	tid = NewTID()
+	bp.BeginTransaction(tid, WithIsolationLevel(ReadUncommitted))
+	if _, ok, _ := bp.countRows(hf, tid); ok {
+		t.Errorf("expected a transaction that reads uncommitted rows not to use the row count")
+	}
+	bp.CommitTransaction(tid)
+
+	filtered := NewAggregator([]AggState{&CountAggState{alias: "count", expr: &FieldExpr{hf.Descriptor().Fields[1]}}}, hf)
+	if NewTableCount(hf, filtered) != nil {
+		t.Errorf("expected a count of a field not to be answered from the row count")
+	}
+}
+
+func TestCountStarStatement(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte("f (name string, age int)\n"), 0644)
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(20), dir)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %s", err)
+	}
+	defer c.bp.logFile.Close()
+	runTestQuery(t, c, "insert into f values ('sam', 25), ('joe', 30), ('bob', 30)")
+	_, del, _ := Parse(c, "delete from f where name = 'joe'")
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	iter, _ := del.Iterator(tid)
+	if _, err := iter(); err != nil {
+		t.Fatalf("delete failed: %s", err)
+	}
+	c.bp.CommitTransaction(tid)
+
+	_, plan, err := Parse(c, "select count(*), count(*) as n from f")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !planUses[*TableCount](plan) {
+		t.Errorf("expected the count to be answered from the row count")
+	}
+	got := runTestQuery(t, c, "select count(*), count(*) as n from f")
+	if len(got) != 1 || got[0].Fields[0].(IntField).Value != 2 || got[0].Fields[1].(IntField).Value != 2 {
+		t.Errorf("expected a count of 2, got %v", got)
+	}
+	_, plan, _ = Parse(c, "select count(*) from f where age > 26")
+	if planUses[*TableCount](plan) {
+		t.Errorf("expected a filtered count to scan the table")
+	}
+
+	got = runTestQuery(t, c, "select table_rows('f'), table_pages('f'), table_size('f')")
+	if len(got) != 1 {
+		t.Fatalf("expected a single row, got %d", len(got))
+	}
+	if rows := got[0].Fields[0].(IntField).Value; rows != 2 {
+		t.Errorf("expected table_rows to return 2, got %d", rows)
+	}
+	if pages := got[0].Fields[1].(IntField).Value; pages != 1 {
+		t.Errorf("expected table_pages to return 1, got %d", pages)
+	}
+	if size := got[0].Fields[2].(IntField).Value; size != int64(PageSize) {
+		t.Errorf("expected table_size to return %d, got %d", PageSize, size)
+	}
+	if _, _, err := Parse(c, "select table_rows('g')"); err == nil {
+		t.Errorf("expected table_rows of a table that does not exist to fail")
+	}
+	if _, _, err := Parse(c, "select table_rows(age) from f"); err == nil {
+		t.Errorf("expected table_rows of a field to fail")
+	}
+}
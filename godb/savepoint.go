@@ -0,0 +1,93 @@
+package godb
+
+// Savepoints mark a point in a transaction that it can roll back to without
+// aborting: [BufferPool.RollbackToSavepoint] undoes the inserts and deletes the
+// transaction made since the savepoint was set, and keeps those made before.
+// Locks acquired since then are kept until the transaction ends.  Savepoints
+// form a stack, and setting a savepoint with the name of an existing one hides
+// the older one until the newer one is released.
+
+// A savepoint of a transaction: how many changes (see transactionChanges),
+// logged updates (see transactionUpdates) and index changes (see indexChanges)
+// it had made when it was set
+type savepoint struct {
+	name         string
+	changes      int
+	updates      int
+	indexChanges int
+}
+
+// Set a savepoint called name in tid.  Returns an IllegalTransactionError if
+// tid is not running.
+func (bp *BufferPool) Savepoint(tid TransactionID, name string) error {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	if _, ok := bp.aliveTransactions[tid]; !ok {
+		return GoDBError{IllegalTransactionError, "transaction is not running"}
+	}
+	sp := savepoint{name, len(bp.transactionChanges[tid]), len(bp.transactionUpdates[tid]), len(bp.indexChanges[tid])}
+	bp.savepoints[tid] = append(bp.savepoints[tid], sp)
+	return nil
+}
+
+// Return the position in the stack of tid of its newest savepoint called name
+func (bp *BufferPool) findSavepoint(tid TransactionID, name string) (int, error) {
+	if _, ok := bp.aliveTransactions[tid]; !ok {
+		return 0, GoDBError{IllegalTransactionError, "transaction is not running"}
+	}
+	stack := bp.savepoints[tid]
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].name == name {
+			return i, nil
+		}
+	}
+	return 0, GoDBError{NoSuchSavepointError, "savepoint " + name + " does not exist"}
+}
+
+// Undo the changes tid made since it set the savepoint called name, and
+// release the savepoints set after it.  The savepoint itself is kept, so tid
+// can roll back to it again.  Returns a NoSuchSavepointError if there is no
+// such savepoint.
+//
+// Without a log, the pages tid has changed are still in the buffer pool, and
+// the changes are undone there.  With a log, they are undone from the insert
+// and delete records of tid, like when it aborts (see rollbackPages).
+func (bp *BufferPool) RollbackToSavepoint(tid TransactionID, name string) error {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	i, err := bp.findSavepoint(tid, name)
+	if err != nil {
+		return err
+	}
+	sp := bp.savepoints[tid][i]
+	if bp.logFile == nil {
+		changes := bp.transactionChanges[tid]
+		for j := len(changes) - 1; j >= sp.changes; j-- {
+			if e, ok := bp.pool[changes[j].page]; ok {
+				if err := undoOnPage((*e.Value.(pair).value).(*heapPage), changes[j]); err != nil {
+					return err
+				}
+			}
+		}
+	} else if _, err := bp.undoLoggedUpdates(tid, sp.updates); err != nil {
+		return err
+	}
+	bp.rollbackVersions(tid, sp.changes)
+	bp.rollbackIndexChanges(tid, sp.indexChanges)
+	bp.savepoints[tid] = bp.savepoints[tid][:i+1]
+	return nil
+}
+
+// Release the savepoint of tid called name, and the savepoints set after it,
+// keeping the changes made since.  Returns a NoSuchSavepointError if there is
+// no such savepoint.
+func (bp *BufferPool) ReleaseSavepoint(tid TransactionID, name string) error {
+	bp.poolLock.Lock()
+	defer bp.poolLock.Unlock()
+	i, err := bp.findSavepoint(tid, name)
+	if err != nil {
+		return err
+	}
+	bp.savepoints[tid] = bp.savepoints[tid][:i]
+	return nil
+}
@@ -0,0 +1,127 @@
+package godb
+
+import (
+	"testing"
+)
+
+// Insert 5 tuples, set savepoint a, insert 5 more and delete the first row,
+// then roll back to a; tid should see the 5 tuples it inserted before the
+// savepoint
+func testRollbackToSavepoint(t *testing.T, hf *HeapFile, bp *BufferPool, t1 Tuple, tid TransactionID) {
+	finishesSoon(t, insertN(hf, t1, tid, 5))
+	if err := bp.Savepoint(tid, "a"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	for i := 0; i < 2; i++ {
+		finishesSoon(t, insertN(hf, t1, tid, 5))
+		finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 0), tid) })
+		if cnt := countVisible(t, hf, tid); cnt != 9 {
+			t.Fatalf("expected 9 tuples before rolling back, got %d", cnt)
+		}
+		// the savepoint survives the rollback, so it can be rolled back to again
+		if err := bp.RollbackToSavepoint(tid, "a"); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if cnt := countVisible(t, hf, tid); cnt != 5 {
+			t.Fatalf("expected 5 tuples after rolling back, got %d", cnt)
+		}
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+}
+
+func TestRollbackToSavepoint(t *testing.T) {
+	td, t1, _, hf, bp, tid := makeTestVars(t)
+	testRollbackToSavepoint(t, hf, bp, t1, tid)
+	if cnt := countOnDisk(t, &td); cnt != 5 {
+		t.Errorf("expected 5 tuples on disk, got %d", cnt)
+	}
+}
+
+func TestRollbackToSavepointWithLog(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	testRollbackToSavepoint(t, hf, bp, t1, tid)
+	bp.logFile.Close()
+	if cnt := countAfterRecovery(t, &td); cnt != 5 {
+		t.Errorf("expected 5 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestAbortAfterRollbackToSavepoint(t *testing.T) {
+	td, t1, hf, bp := makeLoggedTestVars(t)
+	defer bp.logFile.Close()
+	insertAndCommit(t, hf, bp, t1, 10)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 0), tid) })
+	bp.Savepoint(tid, "a")
+	finishesSoon(t, func() error { return hf.deleteTuple(rowAt(t1, 1), tid) })
+	finishesSoon(t, insertN(hf, t1, tid, 3))
+	if err := bp.RollbackToSavepoint(tid, "a"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	bp.AbortTransaction(tid)
+	bp.FlushAllPages()
+	if cnt := countOnDisk(t, &td); cnt != 10 {
+		t.Errorf("expected abort to restore all 10 tuples, got %d", cnt)
+	}
+	if len(bp.versions) != 0 {
+		t.Errorf("expected no versions to be left, got %d rows", len(bp.versions))
+	}
+}
+
+func TestReleaseSavepoint(t *testing.T) {
+	_, _, _, _, bp, tid := makeTestVars(t)
+	for _, name := range []string{"a", "b", "a", "c"} {
+		bp.Savepoint(tid, name)
+	}
+	// releasing the newer a releases c too, and uncovers the older a
+	if err := bp.ReleaseSavepoint(tid, "a"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := bp.RollbackToSavepoint(tid, "c"); err == nil || err.(GoDBError).code != NoSuchSavepointError {
+		t.Errorf("expected released savepoint to be gone, got %v", err)
+	}
+	if err := bp.ReleaseSavepoint(tid, "a"); err != nil {
+		t.Errorf("expected older savepoint to remain, got %v", err)
+	}
+	if err := bp.RollbackToSavepoint(tid, "b"); err == nil {
+		t.Errorf("expected savepoints set after a to be released")
+	}
+	bp.CommitTransaction(tid)
+	if err := bp.Savepoint(tid, "d"); err == nil {
+		t.Errorf("expected savepoint in a committed transaction to fail")
+	}
+}
+
+func TestParseSavepoints(t *testing.T) {
+	cases := []struct {
+		query string
+		qtype QueryType
+		name  string
+	}{
+		{"savepoint step1", SavepointType, "step1"},
+		{"ROLLBACK TO SAVEPOINT step1;", RollbackToType, "step1"},
+		{"rollback work to step1", RollbackToType, "step1"},
+		{"RELEASE SAVEPOINT Step1", ReleaseSavepointType, "step1"},
+		{"release step1", ReleaseSavepointType, "step1"},
+	}
+	for _, c := range cases {
+		qtype, _, err := Parse(nil, c.query)
+		if err != nil || qtype != c.qtype {
+			t.Errorf("%s: expected query type %d, got %d (%v)", c.query, c.qtype, qtype, err)
+		}
+		if name, ok := ParseSavepointName(c.query); !ok || name != c.name {
+			t.Errorf("%s: expected savepoint %s, got %s", c.query, c.name, name)
+		}
+	}
+	if qtype, _, _ := Parse(nil, "rollback"); qtype != AbortXactionType {
+		t.Errorf("expected plain rollback to abort")
+	}
+	if _, _, err := Parse(nil, "savepoint"); err == nil {
+		t.Errorf("expected savepoint without a name to fail to parse")
+	}
+}
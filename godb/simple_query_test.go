@@ -7,11 +7,11 @@ import (
 func TestSimpleQuery(t *testing.T) {
 
 	bp := NewBufferPool(10000)
-	MakeTestDatabaseEasy(bp)
+	dir, _ := MakeTestDatabaseEasy(t, bp)
 
 	catName := "catalog.txt"
 
-	c, err := NewCatalogFromFile(catName, bp, "./")
+	c, err := NewCatalogFromFile(catName, bp, dir)
 	if err != nil {
 		t.Fatalf("failed load catalog, %s", err.Error())
 	}
@@ -0,0 +1,315 @@
+package godb
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Operators that order their input, an [OrderBy] or a [SortMergeJoin] of
+// unsorted inputs, sort it with a tupleSorter, an external merge sort: tuples
+// are read into memory until the memory budget is used up, sorted, and written
+// out as a run to a spill file (see spill.go).  Once the input ends, the runs
+// are merged, at most sortFanin at a time, until the last merge returns the
+// tuples in order.  An input that fits in memory is sorted without spilling.
+
+// the number of runs merged at once, each of which holds a page in memory
+const sortFanin = 16
+
+// The number of bytes of tuples an ORDER BY sorts in memory before it spills
+// runs of them to disk, unless the BufferPool says otherwise (see
+// [WithSortMemory])
+const DefaultSortMemory int = 64 << 20
+
+// A tupleSorter sorts tuples by the values keys returns for them, in the order
+// of compare.  Sorts are stable: tuples whose keys compare equal are returned
+// in the order they were read.
+type tupleSorter struct {
+	// descriptor of the tuples, which runs are written with
+	desc *TupleDesc
+	// transaction runs are written on behalf of
+	tid  TransactionID
+	keys func(t *Tuple) ([]DBValue, error)
+	// Compare keys a and b, returning -1, 0 or 1 as a orders before, with or
+	// after b, or an error if they cannot be compared
+	compare func(a []DBValue, b []DBValue) (int, error)
+	// The number of tuples, and of bytes of them (see storedTupleSize), the
+	// sort may hold in memory; a limit that is not positive does not apply.
+	// Runs hold at least one tuple either way.
+	maxTuples int
+	maxBytes  int
+}
+
+// A tuple to sort and its keys
+type keyedTuple struct {
+	t    *Tuple
+	keys []DBValue
+}
+
+// Return a function that iterates through the tuples iter returns, in order,
+// and one that removes the runs of the sort before the iterator is read to its
+// end, after which the iterator must not be called.  Runs that are not removed
+// either way are removed once the transaction of the sort commits or aborts.
+// The input is read to its end, and any runs written, before this returns.
+func (s *tupleSorter) sort(iter func() (*Tuple, error)) (func() (*Tuple, error), func(), error) {
+	var runs []*spillFile
+	var buf []keyedTuple
+	size := 0
+	for done := false; !done; {
+		t, err := iter()
+		if err != nil {
+			removeSpillFiles(runs)
+			return nil, nil, err
+		}
+		if t != nil {
+			keys, err := s.keys(t)
+			if err != nil {
+				removeSpillFiles(runs)
+				return nil, nil, err
+			}
+			buf = append(buf, keyedTuple{t, keys})
+			size += storedTupleSize(t)
+		}
+		done = t == nil
+		if (!done && !s.full(len(buf), size)) || len(buf) == 0 {
+			continue
+		}
+		if err := s.sortBuffer(buf); err != nil {
+			removeSpillFiles(runs)
+			return nil, nil, err
+		}
+		if done && len(runs) == 0 {
+			return sliceIterator(buf), func() {}, nil
+		}
+		run, err := s.writeRun(buf)
+		if err != nil {
+			removeSpillFiles(runs)
+			return nil, nil, err
+		}
+		runs = append(runs, run)
+		buf, size = nil, 0
+	}
+
+	for len(runs) > sortFanin {
+		var err error
+		if runs, err = s.mergePass(runs); err != nil {
+			return nil, nil, err
+		}
+	}
+	next, err := s.merge(runs)
+	if err != nil {
+		removeSpillFiles(runs)
+		return nil, nil, err
+	}
+	return func() (*Tuple, error) {
+		if next == nil {
+			return nil, nil
+		}
+		t, err := next()
+		if t == nil || err != nil {
+			removeSpillFiles(runs)
+			next = nil
+		}
+		return t, err
+	}, func() { removeSpillFiles(runs) }, nil
+}
+
+// Return whether a buffer of n tuples taking size bytes has used up the memory
+// of the sort
+func (s *tupleSorter) full(n int, size int) bool {
+	return (s.maxTuples > 0 && n >= s.maxTuples) || (s.maxBytes > 0 && size >= s.maxBytes)
+}
+
+// Merge each sortFanin consecutive runs of runs into one, and return the
+// merged runs, in order.  The runs are removed, even if this fails.
+func (s *tupleSorter) mergePass(runs []*spillFile) ([]*spillFile, error) {
+	var merged []*spillFile
+	for i := 0; i < len(runs); i += sortFanin {
+		group := runs[i:]
+		if len(group) > sortFanin {
+			group = group[:sortFanin]
+		}
+		if len(group) == 1 {
+			merged = append(merged, group[0])
+			continue
+		}
+		next, err := s.merge(group)
+		if err != nil {
+			removeSpillFiles(append(merged, runs[i:]...))
+			return nil, err
+		}
+		run, err := newSpillFile(s.desc, s.tid)
+		if err == nil {
+			merged = append(merged, run)
+			err = appendAll(run, next)
+		}
+		if err != nil {
+			removeSpillFiles(append(merged, runs[i:]...))
+			return nil, err
+		}
+		removeSpillFiles(group)
+	}
+	return merged, nil
+}
+
+// Sort buf in place, stably
+func (s *tupleSorter) sortBuffer(buf []keyedTuple) error {
+	var err error
+	sort.SliceStable(buf, func(i int, j int) bool {
+		if err != nil {
+			return false
+		}
+		cmp, cerr := s.compare(buf[i].keys, buf[j].keys)
+		if cerr != nil {
+			err = cerr
+		}
+		return cmp < 0
+	})
+	return err
+}
+
+// Write the tuples of buf to a new spill file, in order
+func (s *tupleSorter) writeRun(buf []keyedTuple) (*spillFile, error) {
+	run, err := newSpillFile(s.desc, s.tid)
+	if err != nil {
+		return nil, err
+	}
+	for _, kt := range buf {
+		if err := run.append(kt.t); err != nil {
+			run.remove()
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+// Append every tuple iter returns to s
+func appendAll(s *spillFile, iter func() (*Tuple, error)) error {
+	for {
+		t, err := iter()
+		if t == nil || err != nil {
+			return err
+		}
+		if err := s.append(t); err != nil {
+			return err
+		}
+	}
+}
+
+// Return a function that iterates through the tuples of buf
+func sliceIterator(buf []keyedTuple) func() (*Tuple, error) {
+	return func() (*Tuple, error) {
+		if len(buf) == 0 {
+			return nil, nil
+		}
+		t := buf[0].t
+		buf = buf[1:]
+		return t, nil
+	}
+}
+
+// Return a function that iterates through the tuples of runs, each of which is
+// sorted, in order.  Tuples whose keys compare equal are returned in the order
+// of their runs.
+func (s *tupleSorter) merge(runs []*spillFile) (func() (*Tuple, error), error) {
+	m := &runMerge{compare: s.compare}
+	for i, run := range runs {
+		next, err := run.iterator()
+		if err != nil {
+			return nil, err
+		}
+		h := &mergeHead{next: next, run: i}
+		if ok, err := s.advance(h); err != nil {
+			return nil, err
+		} else if ok {
+			m.heads = append(m.heads, h)
+		}
+	}
+	heap.Init(m)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return func() (*Tuple, error) {
+		if len(m.heads) == 0 {
+			return nil, nil
+		}
+		h := m.heads[0]
+		t := h.kt.t
+		ok, err := s.advance(h)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			heap.Fix(m, 0)
+		} else {
+			heap.Pop(m)
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+		return t, nil
+	}, nil
+}
+
+// Read the next tuple of the run of h into h, returning false if the run has
+// ended
+func (s *tupleSorter) advance(h *mergeHead) (bool, error) {
+	t, err := h.next()
+	if t == nil || err != nil {
+		return false, err
+	}
+	keys, err := s.keys(t)
+	if err != nil {
+		return false, err
+	}
+	h.kt = keyedTuple{t, keys}
+	return true, nil
+}
+
+// The next tuple of a run being merged
+type mergeHead struct {
+	kt   keyedTuple
+	next func() (*Tuple, error)
+	// position of the run among those merged, which orders tuples whose keys
+	// compare equal
+	run int
+}
+
+// A heap of the next tuples of the runs being merged, implementing
+// [heap.Interface].  The first error compare returns is kept in err.
+type runMerge struct {
+	heads   []*mergeHead
+	compare func(a []DBValue, b []DBValue) (int, error)
+	err     error
+}
+
+func (m *runMerge) Len() int {
+	return len(m.heads)
+}
+
+func (m *runMerge) Less(i int, j int) bool {
+	cmp, err := m.compare(m.heads[i].kt.keys, m.heads[j].kt.keys)
+	if err != nil {
+		if m.err == nil {
+			m.err = err
+		}
+		return false
+	}
+	if cmp == 0 {
+		return m.heads[i].run < m.heads[j].run
+	}
+	return cmp < 0
+}
+
+func (m *runMerge) Swap(i int, j int) {
+	m.heads[i], m.heads[j] = m.heads[j], m.heads[i]
+}
+
+func (m *runMerge) Push(x any) {
+	m.heads = append(m.heads, x.(*mergeHead))
+}
+
+func (m *runMerge) Pop() any {
+	h := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return h
+}
@@ -0,0 +1,223 @@
+package godb
+
+import (
+	"os"
+	"sync"
+)
+
+// Operators whose state does not fit in memory, such as an [EqualityJoin] of
+// large inputs, spill tuples to temporary heap files.  A spillFile is only
+// ever read and written by the operator that created it, so its pages are
+// written straight to disk rather than through the BufferPool, and are neither
+// locked nor logged: tuples are appended to a single page in memory, which is
+// written to the end of the file once it is full, and the file is read back a
+// page at a time.  Values that make a tuple too large for a page are stored out
+// of line, as in heap files (see overflow.go), in chunks appended to a second
+// spill file.
+//
+// Spill files are removed once the operator is done with them.  Those of an
+// iterator that is not read to its end, such as one under a LIMIT, are removed
+// when the transaction that created them commits or aborts.
+
+// The directory spill files are created in, or "" for the default directory for
+// temporary files (see [os.TempDir])
+var spillDir = ""
+
+// The spill files of each transaction that have not been removed yet
+var transactionSpills = struct {
+	sync.Mutex
+	files map[TransactionID]map[*spillFile]struct{}
+}{files: make(map[TransactionID]map[*spillFile]struct{})}
+
+type spillFile struct {
+	file *HeapFile
+	// transaction the file was created by
+	tid TransactionID
+	// page tuples are appended to, or nil if none has been appended since the
+	// last page was written
+	page  *heapPage
+	pages int
+	// number of tuples appended to the file
+	count int
+	// file holding the chunks of the values stored out of line; nil until
+	// needed
+	overflow *spillFile
+}
+
+// Create an empty spill file for tuples of desc, on behalf of tid
+func newSpillFile(desc *TupleDesc, tid TransactionID) (*spillFile, error) {
+	f, err := os.CreateTemp(spillDir, "godb-spill-*.dat")
+	if err != nil {
+		return nil, err
+	}
+	name := f.Name()
+	f.Close()
+	hf, err := NewHeapFile(name, desc, nil)
+	if err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+	s := &spillFile{file: hf, tid: tid}
+	transactionSpills.Lock()
+	defer transactionSpills.Unlock()
+	if transactionSpills.files[tid] == nil {
+		transactionSpills.files[tid] = make(map[*spillFile]struct{})
+	}
+	transactionSpills.files[tid][s] = struct{}{}
+	return s, nil
+}
+
+// Append a copy of t to the file
+func (s *spillFile) append(t *Tuple) error {
+	stored, err := storeOutOfLine(t, s.writeOverflow)
+	if err != nil {
+		return err
+	}
+	if _, err := s.insert(&Tuple{Desc: t.Desc, Fields: stored.Fields}); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// Insert t, whose values are all stored inline, on the last page of the file,
+// and return its Rid
+func (s *spillFile) insert(t *Tuple) (Rid, error) {
+	if s.page != nil {
+		rid, err := s.page.insertTuple(t)
+		if err == nil {
+			return rid.(Rid), nil
+		}
+		if gerr, ok := err.(GoDBError); !ok || gerr.code != PageFullError {
+			return Rid{}, err
+		}
+		if err := s.flush(); err != nil {
+			return Rid{}, err
+		}
+	}
+	s.page = newHeapPage(s.file.desc, s.pages, s.file)
+	rid, err := s.page.insertTuple(t)
+	if err != nil {
+		return Rid{}, err
+	}
+	return rid.(Rid), nil
+}
+
+// Write value in chunks to the overflow file of s, and return a reference to it
+func (s *spillFile) writeOverflow(value []byte) (overflowRef, error) {
+	if s.overflow == nil {
+		var err error
+		if s.overflow, err = newSpillFile(&overflowDesc, s.tid); err != nil {
+			return overflowRef{}, err
+		}
+	}
+	return writeChunks(value, s.overflow.insert)
+}
+
+// Return the tuple of the file with the specified Rid, which may be on the page
+// tuples are appended to
+func (s *spillFile) tupleAt(rid Rid) (*Tuple, error) {
+	page := s.page
+	if page == nil || rid.pageid != s.pages {
+		p, err := s.file.readPage(rid.pageid)
+		if err != nil {
+			return nil, err
+		}
+		page = (*p).(*heapPage)
+	}
+	return page.tupleAt(rid.slotid)
+}
+
+// Write the page tuples are appended to, if any, to the end of the file
+func (s *spillFile) flush() error {
+	if s.page == nil {
+		return nil
+	}
+	var p Page = s.page
+	if err := s.file.flushPage(&p); err != nil {
+		return err
+	}
+	s.page = nil
+	s.pages++
+	return nil
+}
+
+// Return a function that iterates through the tuples of the file, in the order
+// they were appended.  Tuples may be appended to the file while it is read, but
+// are not returned by iterators that already read the last page.
+func (s *spillFile) iterator() (func() (*Tuple, error), error) {
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	pageNo := 0
+	var next func() (*Tuple, error)
+	return func() (*Tuple, error) {
+		for {
+			if next != nil {
+				t, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if t != nil {
+					return t, s.fetchOverflow(t)
+				}
+				next = nil
+			}
+			if pageNo >= s.pages {
+				return nil, nil
+			}
+			p, err := s.file.readPage(pageNo)
+			if err != nil {
+				return nil, err
+			}
+			next = (*p).(*heapPage).tupleIter()
+			pageNo++
+		}
+	}, nil
+}
+
+// Replace the references to values stored out of line in t, a tuple read from
+// the file, by the values
+func (s *spillFile) fetchOverflow(t *Tuple) error {
+	if s.overflow == nil {
+		return nil
+	}
+	return fetchOutOfLine(t, func(ref overflowRef) ([]byte, error) {
+		return readChunks(ref, s.overflow.tupleAt)
+	})
+}
+
+// Remove the file, and its overflow file
+func (s *spillFile) remove() {
+	os.Remove(s.file.Filename)
+	if s.overflow != nil {
+		s.overflow.remove()
+	}
+	transactionSpills.Lock()
+	defer transactionSpills.Unlock()
+	delete(transactionSpills.files[s.tid], s)
+	if len(transactionSpills.files[s.tid]) == 0 {
+		delete(transactionSpills.files, s.tid)
+	}
+}
+
+// Remove every file of files that is not nil
+func removeSpillFiles(files []*spillFile) {
+	for _, s := range files {
+		if s != nil {
+			s.remove()
+		}
+	}
+}
+
+// Remove the spill files of tid that have not been removed yet, once it has
+// committed or aborted
+func removeTransactionSpills(tid TransactionID) {
+	transactionSpills.Lock()
+	files := make([]*spillFile, 0, len(transactionSpills.files[tid]))
+	for s := range transactionSpills.files[tid] {
+		files = append(files, s)
+	}
+	transactionSpills.Unlock()
+	removeSpillFiles(files)
+}
@@ -1,13 +1,140 @@
 package godb
 
-type TransactionID *int
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-var nextTid = 0
+// A transaction, identified by its id.  Ids are handed out by a
+// TransactionManager in increasing order, so they can be written to the log
+// and compared to tell which of two transactions is older.  Id 0 stands for no
+// transaction in the log and in the version store (see mvcc.go).
+type TransactionID int64
 
+type TransactionStatus int
+
+const (
+	TransactionActive    TransactionStatus = iota
+	TransactionCommitted TransactionStatus = iota
+	TransactionAborted   TransactionStatus = iota
+)
+
+func (s TransactionStatus) String() string {
+	switch s {
+	case TransactionActive:
+		return "active"
+	case TransactionCommitted:
+		return "committed"
+	case TransactionAborted:
+		return "aborted"
+	}
+	return "unknown"
+}
+
+// What a TransactionManager knows about a transaction
+type TransactionInfo struct {
+	ID     int64
+	Start  time.Time
+	Status TransactionStatus
+}
+
+// How many finished transactions a TransactionManager keeps in its registry,
+// by default
+const DefaultTransactionHistory = 1000
+
+// TransactionManager hands out transaction ids, and keeps a registry of the
+// transactions that have begun and not finished yet, and of the most recently
+// finished ones.  It is safe for
+// concurrent use.
+type TransactionManager struct {
+	lastID atomic.Int64
+
+	sync.Mutex
+	transactions map[int64]*TransactionInfo
+	// ids of the finished transactions in the registry, oldest first
+	finished []int64
+	history  int
+}
+
+func NewTransactionManager() *TransactionManager {
+	return &TransactionManager{transactions: make(map[int64]*TransactionInfo), history: DefaultTransactionHistory}
+}
+
+// The TransactionManager used by NewTID, and by buffer pools unless they are
+// given another one (see [WithTransactionManager])
+var DefaultTransactionManager = NewTransactionManager()
+
+// Return a new transaction id from DefaultTransactionManager
 func NewTID() TransactionID {
-	id := nextTid
-	nextTid++
-	return &id
+	return DefaultTransactionManager.NewTID()
+}
+
+// Return a new transaction id, greater than any handed out before.  The
+// transaction is only registered once it begins (see
+// [BufferPool.BeginTransaction]), so that ids that are never used take no room
+// in the registry.
+func (tm *TransactionManager) NewTID() TransactionID {
+	return TransactionID(tm.lastID.Add(1))
+}
+
+// Register tid as active, as of now, if the registry does not know it
+func (tm *TransactionManager) begin(tid TransactionID) {
+	tm.Lock()
+	defer tm.Unlock()
+	if _, ok := tm.transactions[int64(tid)]; !ok {
+		tm.transactions[int64(tid)] = &TransactionInfo{int64(tid), time.Now(), TransactionActive}
+	}
+	tm.observe(int64(tid))
+}
+
+// Record that tid has finished with the given status, forgetting the oldest
+// finished transactions beyond the history kept
+func (tm *TransactionManager) finish(tid TransactionID, status TransactionStatus) {
+	tm.Lock()
+	defer tm.Unlock()
+	info, ok := tm.transactions[int64(tid)]
+	if !ok || info.Status != TransactionActive {
+		return
+	}
+	info.Status = status
+	tm.finished = append(tm.finished, int64(tid))
+	for len(tm.finished) > tm.history {
+		delete(tm.transactions, tm.finished[0])
+		tm.finished = tm.finished[1:]
+	}
+}
+
+// Make sure that ids handed out from now on are greater than id
+func (tm *TransactionManager) observe(id int64) {
+	for {
+		last := tm.lastID.Load()
+		if last >= id || tm.lastID.CompareAndSwap(last, id) {
+			return
+		}
+	}
 }
 
-//var tid TransactionID = NewTID()
+// Return the status of tid, if it is still in the registry
+func (tm *TransactionManager) Status(tid TransactionID) (TransactionStatus, bool) {
+	tm.Lock()
+	defer tm.Unlock()
+	info, ok := tm.transactions[int64(tid)]
+	if !ok {
+		return TransactionActive, false
+	}
+	return info.Status, true
+}
+
+// Return the transactions in the registry, oldest first
+func (tm *TransactionManager) Transactions() []TransactionInfo {
+	tm.Lock()
+	defer tm.Unlock()
+	infos := make([]TransactionInfo, 0, len(tm.transactions))
+	for _, info := range tm.transactions {
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
@@ -2,13 +2,99 @@ package godb
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
+func TestTransactionManagerConcurrentIDs(t *testing.T) {
+	tm := NewTransactionManager()
+	var wg sync.WaitGroup
+	ids := make([][]int64, 20)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ids[i] = append(ids[i], int64(tm.NewTID()))
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[int64]bool)
+	for _, list := range ids {
+		for j, id := range list {
+			if seen[id] {
+				t.Fatalf("id %d handed out twice", id)
+			}
+			seen[id] = true
+			if j > 0 && id <= list[j-1] {
+				t.Fatalf("expected ids to increase, got %d after %d", id, list[j-1])
+			}
+		}
+	}
+	if len(tm.Transactions()) != 0 {
+		t.Errorf("expected transactions that never began not to be registered, got %d", len(tm.Transactions()))
+	}
+}
+
+func TestTransactionManagerStatus(t *testing.T) {
+	tm := NewTransactionManager()
+	tm.history = 2
+	bp := NewBufferPool(3, WithTransactionManager(tm))
+	tids := make([]TransactionID, 4)
+	for i := range tids {
+		tids[i] = tm.NewTID()
+		bp.BeginTransaction(tids[i])
+	}
+	bp.CommitTransaction(tids[0])
+	bp.AbortTransaction(tids[1])
+	if status, _ := tm.Status(tids[0]); status != TransactionCommitted {
+		t.Errorf("expected committed, got %s", status)
+	}
+	if status, _ := tm.Status(tids[1]); status != TransactionAborted {
+		t.Errorf("expected aborted, got %s", status)
+	}
+	if status, _ := tm.Status(tids[2]); status != TransactionActive {
+		t.Errorf("expected active, got %s", status)
+	}
+
+	// only the 2 most recently finished transactions are remembered
+	bp.CommitTransaction(tids[3])
+	if _, ok := tm.Status(tids[0]); ok {
+		t.Errorf("expected oldest finished transaction to be forgotten")
+	}
+	infos := tm.Transactions()
+	if len(infos) != 3 || infos[0].ID != int64(tids[1]) || infos[2].ID != int64(tids[3]) {
+		t.Errorf("expected transactions 1 to 3 oldest first, got %v", infos)
+	}
+	if infos[0].Start.After(infos[2].Start) {
+		t.Errorf("expected older transaction to start first")
+	}
+}
+
+func TestRecoveryAdvancesTransactionIDs(t *testing.T) {
+	_, t1, hf, bp := makeLoggedTestVars(t)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	finishesSoon(t, insertN(hf, t1, tid, 1))
+	bp.logFile.Close()
+
+	tm := NewTransactionManager()
+	bp = NewBufferPool(3, WithTransactionManager(tm))
+	if err := bp.OpenLog(TestingLogFile); err != nil {
+		t.Fatalf("recovery failed: %s", err)
+	}
+	defer bp.logFile.Close()
+	if id := tm.NewTID(); id <= tid {
+		t.Errorf("expected new id to be greater than the logged id %d, got %d", tid, id)
+	}
+}
+
 func TestTid(t *testing.T) {
 	tid := NewTID()
 	tid2 := NewTID()
@@ -99,7 +185,7 @@ func writeXaction(hf *HeapFile, bp *BufferPool, writeTuple Tuple, wg *sync.WaitG
 
 func TestTransactions(t *testing.T) {
 
-	_, t1, t2, _, _, _ := makeTestVars()
+	_, t1, t2, _, _, _ := makeTestVars(t)
 	bp := NewBufferPool(20)
 	tid := NewTID()
 	bp.BeginTransaction(tid)
@@ -142,14 +228,54 @@ func TestTransactions(t *testing.T) {
 	wg.Wait()
 }
 
+// Return a temporary copy of csvFile, a test file of tuples of desc, a string
+// and an int, with their strings padded with spaces so that perPage of the
+// tuples fill a page, up to StringLength bytes.  The test files are sized for
+// pages of fixed length tuples, which the padding stands in for.
+func padTestCSV(t *testing.T, csvFile *os.File, desc *TupleDesc, perPage int) *os.File {
+	data, err := io.ReadAll(csvFile)
+	if err != nil {
+		t.Fatalf("error reading test file: %s", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.SplitN(line, ",", 2)
+		tup, err := encodeTuple(&Tuple{Desc: *desc, Fields: []DBValue{StringField{fields[0]}, IntField{0}}})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		width := len(fields[0]) + (PageSize-pageHeaderSize)/perPage - slotEntrySize - len(tup)
+		if width > StringLength {
+			width = StringLength
+		}
+		if width > len(fields[0]) {
+			fields[0] += strings.Repeat(" ", width-len(fields[0]))
+		}
+		lines = append(lines, strings.Join(fields, ","))
+	}
+	padded, err := os.CreateTemp(t.TempDir(), "txn_test_*.csv")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	t.Cleanup(func() { padded.Close() })
+	if _, err := padded.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := padded.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return padded
+}
+
 func transactionTestSetUpVarLen(t *testing.T, tupCnt int, pgCnt int) (*BufferPool, *HeapFile, TransactionID, TransactionID, Tuple, Tuple) {
-	_, t1, t2, hf, bp, _ := makeTestVars()
+	_, t1, t2, hf, bp, _ := makeTestVars(t)
 
 	csvFile, err := os.Open(fmt.Sprintf("txn_test_%d_%d.csv", tupCnt, pgCnt))
 	if err != nil {
 		t.Fatalf("error opening test file")
 	}
-	hf.LoadFromCSV(csvFile, false, ",", false)
+	defer csvFile.Close()
+	hf.LoadFromCSV(padTestCSV(t, csvFile, hf.Descriptor(), (tupCnt+pgCnt-1)/pgCnt), false, ",", false)
 	if hf.NumPages() != pgCnt {
 		t.Fatalf("error making test vars; unexpected number of pages")
 	}
@@ -258,7 +384,7 @@ func validateTransactions(t *testing.T, threads int) {
 		// Wait for the signal to start
 		<-startChan
 
-		for tid := TransactionID(nil); ; bp.AbortTransaction(tid) {
+		for tid := TransactionID(0); ; bp.AbortTransaction(tid) {
 			tid = NewTID()
 			bp.BeginTransaction(tid)
 			iter1, err := hf.Iterator(tid)
@@ -356,7 +482,7 @@ func TestFiveThreads(t *testing.T) {
 // }
 
 func TestAllDirtyFails(t *testing.T) {
-	td, t1, _, hf, bp, tid := makeTestVars()
+	td, t1, _, hf, bp, tid := makeTestVars(t)
 
 	for hf.NumPages() < 3 {
 		hf.insertTuple(&t1, tid)
@@ -401,7 +527,7 @@ func TestAbortEviction(t *testing.T) {
 		return false, nil
 	}
 
-	_, t1, _, hf, bp, tid := makeTestVars()
+	_, t1, _, hf, bp, tid := makeTestVars(t)
 	hf.insertTuple(&t1, tid)
 	if exists, err := tupExists(t1, tid, hf); !(exists == true && err == nil) {
 		t.Errorf("Tuple should exist")
@@ -6,7 +6,9 @@ package godb
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/mitchellh/hashstructure/v2"
@@ -17,12 +19,25 @@ import (
 type DBType int
 
 const (
-	IntType     DBType = iota
-	StringType  DBType = iota
-	UnknownType DBType = iota //used internally, during parsing, because sometimes the type is unknown
+	IntType        DBType = iota
+	StringType     DBType = iota
+	UnknownType    DBType = iota //used internally, during parsing, because sometimes the type is unknown
+	BytesType      DBType = iota
+	FloatType      DBType = iota
+	DecimalType    DBType = iota //with a precision and scale, see decimalType
+	NumericType    DBType = iota //used in the signatures of functions, for arguments of any numeric type
+	DateType       DBType = iota //see datetime.go
+	TimestampType  DBType = iota
+	IntervalType   DBType = iota
+	ArithmeticType DBType = iota //used in the signatures of functions, for numbers, dates, timestamps and intervals
+	TemporalType   DBType = iota //used in the signatures of functions, for dates, timestamps and intervals
+	BoolType       DBType = iota //see boolean.go
+	AnyType        DBType = iota //used in the signatures of functions, for arguments of any type
 )
 
-var typeNames map[DBType]string = map[DBType]string{IntType: "int", StringType: "string"}
+var typeNames map[DBType]string = map[DBType]string{IntType: "int", StringType: "string", BytesType: "bytes", FloatType: "float", NumericType: "number",
+	DateType: "date", TimestampType: "timestamp", IntervalType: "interval", ArithmeticType: "number|time", TemporalType: "time",
+	BoolType: "bool", AnyType: "any"}
 
 // FieldType is the type of a field in a tuple, e.g., its name, table, and [godb.DBType].
 // TableQualifier may or may not be an emtpy string, depending on whether the table
@@ -161,11 +176,41 @@ type IntField struct {
 	Value int64
 }
 
+// Floating point field value
+type FloatField struct {
+	Value float64
+}
+
+// Fixed point field value, which is Value / 10^Scale (see numeric.go)
+type DecimalField struct {
+	Value int64
+	Scale int
+}
+
 // String field value
 type StringField struct {
 	Value string
 }
 
+// Binary field value
+type BytesField struct {
+	Value []byte
+}
+
+// Boolean field value
+type BoolField struct {
+	Value bool
+}
+
+// The SQL NULL value, which a field of any type may hold
+type NullField struct{}
+
+// Return whether v is NULL
+func isNull(v DBValue) bool {
+	_, ok := v.(NullField)
+	return ok
+}
+
 // Tuple represents the contents of a tuple read from a database
 // It includes the tuple descriptor, and the value of the fields
 // Tuple表示从数据库读取的元组的内容
@@ -180,103 +225,209 @@ type Tuple struct {
 type recordID interface {
 }
 
-// Serialize the contents of the tuple into a byte array Since all tuples are of
-// fixed size, this method should simply write the fields in sequential order
-// into the supplied buffer.
-//
-// See the function [binary.Write].  Objects should be serialized in little
-// endian oder.
+// Serialize the contents of the tuple into the supplied buffer: a bitmap with a
+// bit set for each NULL field (the first field in the low bit of the first
+// byte), followed by the other fields in sequential order.
 //
-// Strings can be converted to byte arrays by casting to []byte. Note that all
-// strings need to be padded to StringLength bytes (set in types.go). For
-// example if StringLength is set to 5, the string 'mit' should be written as
-// 'm', 'i', 't', 0, 0
+// See the function [binary.Write].  Objects are serialized in little endian
+// order.  Integers are written as 64 bit integers, floats as 64 bit floats,
+// and decimals as their value multiplied by 10^scale, as a 64 bit integer, the
+// scale being that of their field.  Dates and timestamps are written as 64 bit
+// integers, and intervals as two, their months and microseconds (see
+// datetime.go).  Booleans are written as a byte, 1 for true.  Strings and byte
+// strings are written as a 16 bit length followed by their bytes, so that they
+// take no more space than they need and read back exactly as they were written,
+// whatever bytes they end with.  Values stored out of line (see overflow.go)
+// are written as a reference to their first chunk instead.
 //
-// May return an error if the buffer has insufficient capacity to store the
-// tuple.
+// Returns an error if a value is too long to be written inline.
 /*
-// 将元组的内容序列化为字节数组 由于所有元组都是固定大小的，因此此方法应该简单地将字段按顺序写入到提供的缓冲区中。
+// 将元组的内容序列化到提供的缓冲区中：首先是一个位图，其中每个 NULL 字段对应的位被置位
+// （第一个字段对应第一个字节的最低位），然后按顺序写入其他字段。
+//
+// 请参阅函数 [binary.Write]。 对象以小端顺序序列化。整数写为 64 位整数，浮点数写为 64 位浮点数，
+// 定点数写为其值乘以 10^scale 后的 64 位整数，scale 即其字段的 scale。
+// 日期和时间戳写为 64 位整数，时间间隔写为两个 64 位整数，即其月数和微秒数（参见 datetime.go）。
+// 布尔值写为一个字节，true 为 1。
+// 字符串和字节串写为 16 位长度加上其字节，因此不占用多余的空间，
+// 并且无论以什么字节结尾，读回时都与写入时完全相同。
+// 存储在行外的值（参见 overflow.go）写为对其第一个块的引用。
 //
-  请参阅函数 [binary.Write]。 对象应该以小端顺序序列化。
-	可以通过转换为 []byte 将字符串转换为字节数组。
-	请注意，所有字符串都需要填充为 StringLength 字节（在 types.go 中设置）。
-	 例如，如果 StringLength 设置为 5，
-	 则字符串 'mit' 应写为 'm', 'i', 't', 0, 0 如果缓冲区没有足够的容量来存储元组，
-	 则可能会返回错误。
+// 如果某个值太长而无法内联写入，则返回错误。
 */
 func (t *Tuple) writeTo(b *bytes.Buffer) error {
-	// TODO: some code goes here
+	nulls := make([]byte, nullBitmapSize(len(t.Fields)))
+	for i, f := range t.Fields {
+		if isNull(f) {
+			nulls[i/8] |= 1 << (i % 8)
+		}
+	}
+	if _, err := b.Write(nulls); err != nil {
+		return err
+	}
 	for i := 0; i < len(t.Fields); i++ {
-		if t.Desc.Fields[i].Ftype == IntType {
-
-			err := binary.Write(b, binary.LittleEndian, int64(t.Fields[i].(IntField).Value))
-			if err != nil {
+		var value []byte
+		switch f := t.Fields[i].(type) {
+		case NullField:
+			continue
+		case IntField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
 				return err
 			}
-		} else {
-			str := t.Fields[i].(StringField).Value
-			for i := len(str); i < StringLength; i++ {
-				str += "0"
+			continue
+		case FloatField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
 			}
-			err := binary.Write(b, binary.LittleEndian, []byte(str))
-			if err != nil {
+			continue
+		case DecimalField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+			continue
+		case DateField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+			continue
+		case TimestampField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
 				return err
 			}
+			continue
+		case IntervalField:
+			if err := binary.Write(b, binary.LittleEndian, []int64{f.Months, f.Micros}); err != nil {
+				return err
+			}
+			continue
+		case BoolField:
+			if err := binary.Write(b, binary.LittleEndian, f.Value); err != nil {
+				return err
+			}
+			continue
+		case overflowRef:
+			f.writeTo(b)
+			continue
+		case StringField:
+			value = []byte(f.Value)
+		case BytesField:
+			value = f.Value
+		default:
+			return GoDBError{TypeMismatchError, fmt.Sprintf("cannot store value %v", f)}
+		}
+		if len(value) >= overflowMarker {
+			return GoDBError{MalformedDataError, "value is too long to be stored inline"}
+		}
+		if err := binary.Write(b, binary.LittleEndian, uint16(len(value))); err != nil {
+			return err
+		}
+		if _, err := b.Write(value); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
 // Read the contents of a tuple with the specified [TupleDesc] from the
-// specified buffer, returning a Tuple.
-//
-// See [binary.Read]. Objects should be deserialized in little endian oder.
+// specified buffer, as written by [Tuple.writeTo], returning a Tuple.
 //
-// All strings are stored as StringLength byte objects.
+// See [binary.Read]. Objects are deserialized in little endian order.
 //
-// Strings with length < StringLength will be padded with zeros, and these
-// trailing zeros should be removed from the strings.  A []byte can be cast
-// directly to string.
-//
-// May return an error if the buffer has insufficent data to deserialize the
+// Returns an error if the buffer has insufficent data to deserialize the
 // tuple.
 /*
-// 从指定的[TupleDesc]中读取元组的内容
-// 指定缓冲区，返回一个Tuple。
-//
-// 请参阅 [binary.Read]。 对象应该以小端顺序反序列化。
-//
-// 所有字符串都存储为 StringLength 字节对象。
+// 从指定缓冲区中读取由 [Tuple.writeTo] 写入的、具有指定 [TupleDesc] 的元组的内容，返回一个 Tuple。
 //
-// 长度< StringLength 的字符串将用零填充，并且这些尾随零应该从字符串中删除。 []byte 可以直接转换为字符串。
+// 请参阅 [binary.Read]。 对象以小端顺序反序列化。
 //
-// 如果缓冲区没有足够的数据来反序列化元组，可能会返回错误。
+// 如果缓冲区没有足够的数据来反序列化元组，则返回错误。
 */
 func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
-	// TODO: some code goes here
-	temp := new(Tuple)
-	temp.Desc.Fields = append(temp.Desc.Fields, desc.Fields...)
-	intbuf := make([]byte, 8)
-	strbuf := make([]byte, StringLength)
+	n := nullBitmapSize(len(desc.Fields))
+	if b.Len() < n {
+		return nil, GoDBError{MalformedDataError, "tuple is cut short"}
+	}
+	return readFieldsFrom(b, desc, b.Next(n))
+}
+
+// Return the number of bytes of the bitmap of NULL fields of a tuple with the
+// specified number of fields
+func nullBitmapSize(fields int) int {
+	return (fields + 7) / 8
+}
+
+// Read the fields of a tuple written by [Tuple.writeTo] that follow its bitmap
+// of NULL fields, nulls, which may be nil if no field is NULL
+func readFieldsFrom(b *bytes.Buffer, desc *TupleDesc, nulls []byte) (*Tuple, error) {
+	t := new(Tuple)
+	t.Desc.Fields = append(t.Desc.Fields, desc.Fields...)
 	for i := 0; i < len(desc.Fields); i++ {
-		if desc.Fields[i].Ftype == IntType {
-			binary.Read(b, binary.LittleEndian, intbuf)
-			temp.Fields = append(temp.Fields, IntField{int64(binary.LittleEndian.Uint64(intbuf))})
-		} else {
-			binary.Read(b, binary.LittleEndian, strbuf)
-			str := string(strbuf)
-			for len(str) > 0 {
-				if str[len(str)-1] != '0' {
-					break
-				}
-				str = str[:len(str)-1]
+		if nulls != nil && nulls[i/8]&(1<<(i%8)) != 0 {
+			t.Fields = append(t.Fields, NullField{})
+			continue
+		}
+		switch ftype := desc.Fields[i].Ftype; ftype.kind() {
+		case IntType, DecimalType, DateType, TimestampType:
+			var v int64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			switch ftype.kind() {
+			case DecimalType:
+				t.Fields = append(t.Fields, DecimalField{v, ftype.scale()})
+			case DateType:
+				t.Fields = append(t.Fields, DateField{v})
+			case TimestampType:
+				t.Fields = append(t.Fields, TimestampField{v})
+			default:
+				t.Fields = append(t.Fields, IntField{v})
+			}
+			continue
+		case IntervalType:
+			var v [2]int64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			t.Fields = append(t.Fields, IntervalField{v[0], v[1]})
+			continue
+		case BoolType:
+			var v bool
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			t.Fields = append(t.Fields, BoolField{v})
+			continue
+		case FloatType:
+			var v float64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			t.Fields = append(t.Fields, FloatField{v})
+			continue
+		}
+		if b.Len() >= 2 && binary.LittleEndian.Uint16(b.Bytes()) == overflowMarker {
+			ref, err := readOverflowRef(b)
+			if err != nil {
+				return nil, err
 			}
-			temp.Fields = append(temp.Fields, StringField{str})
+			t.Fields = append(t.Fields, ref)
+			continue
+		}
+		var n uint16
+		if err := binary.Read(b, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		if b.Len() < int(n) {
+			return nil, GoDBError{MalformedDataError, "value is cut short"}
+		}
+		value := b.Next(int(n))
+		if desc.Fields[i].Ftype == BytesType {
+			t.Fields = append(t.Fields, BytesField{append([]byte{}, value...)})
+		} else {
+			t.Fields = append(t.Fields, StringField{string(value)})
 		}
 	}
-
-	return temp, nil //replace me
-
+	return t, nil
 }
 
 // Compare two tuples for equality.  Equality means that the TupleDescs are equal
@@ -296,7 +447,12 @@ func (t1 *Tuple) equals(t2 *Tuple) bool {
 		return false
 	}
 	for i := 0; i < len(t1.Fields); i++ {
-		if t1.Fields[i] != t2.Fields[i] {
+		if b1, ok := t1.Fields[i].(BytesField); ok {
+			// slices cannot be compared with ==
+			if b2, ok := t2.Fields[i].(BytesField); !ok || !bytes.Equal(b1.Value, b2.Value) {
+				return false
+			}
+		} else if t1.Fields[i] != t2.Fields[i] {
 			return false
 		}
 	}
@@ -365,36 +521,25 @@ func (t *Tuple) compareField(t2 *Tuple, field Expr) (orderByState, error) {
 	if err2 != nil {
 		return -1, err2
 	}
-	switch tvalue.(type) {
-	case IntField:
-		_, ok := t2value.(IntField)
-		if ok {
-			if tvalue.(IntField).Value > t2value.(IntField).Value {
-				return OrderedGreaterThan, nil
-			}
-			if tvalue.(IntField).Value == t2value.(IntField).Value {
-				return OrderedEqual, nil
-			}
-			return OrderedLessThan, nil
-		} else {
-			return -1, fmt.Errorf("cannot compare")
-		}
-	case StringField:
-		_, ok := t2value.(StringField)
-		if ok {
-			if tvalue.(StringField).Value > t2value.(StringField).Value {
-				return OrderedGreaterThan, nil
-			}
-			if tvalue.(StringField).Value == t2value.(StringField).Value {
-				return OrderedEqual, nil
-			}
-			return OrderedLessThan, nil
-		} else {
-			return -1, fmt.Errorf("cannot compare")
+	// NULL orders after every other value, as in ascending orders by default
+	if isNull(tvalue) || isNull(t2value) {
+		switch {
+		case isNull(tvalue) && isNull(t2value):
+			return OrderedEqual, nil
+		case isNull(tvalue):
+			return OrderedGreaterThan, nil
 		}
+		return OrderedLessThan, nil
 	}
-
-	return -1, nil // replace me
+	switch cmp, err := compareDBValues(tvalue, t2value); {
+	case err != nil:
+		return -1, err
+	case cmp > 0:
+		return OrderedGreaterThan, nil
+	case cmp == 0:
+		return OrderedEqual, nil
+	}
+	return OrderedLessThan, nil
 }
 
 // Project out the supplied fields from the tuple. Should return a new Tuple
@@ -512,8 +657,18 @@ func (t *Tuple) PrettyPrintString(aligned bool) string {
 		switch f := f.(type) {
 		case IntField:
 			str = fmt.Sprintf("%d", f.Value)
+		case FloatField:
+			str = strconv.FormatFloat(f.Value, 'g', -1, 64)
+		case DecimalField, DateField, TimestampField, IntervalField:
+			str = fmt.Sprint(f)
 		case StringField:
 			str = f.Value
+		case BytesField:
+			str = "0x" + hex.EncodeToString(f.Value)
+		case BoolField:
+			str = strconv.FormatBool(f.Value)
+		case NullField:
+			str = "NULL"
 		}
 		if aligned {
 			outstr = fmt.Sprintf("%s %s", outstr, fmtCol(str, len(t.Fields)))
@@ -34,7 +34,7 @@ func CheckIfOutputMatches(f func() (*Tuple, error), ts []*Tuple) bool {
 
 // Unit test for Tuple.writeTo() and Tuple.readTupleFrom()
 func TestTupleSerialization(t *testing.T) {
-	td, t1, _, _, _, _ := makeTestVars()
+	td, t1, _, _, _, _ := makeTestVars(t)
 	b := new(bytes.Buffer)
 	t1.writeTo(b)
 	t3, err := readTupleFrom(b, &td)
@@ -47,9 +47,28 @@ func TestTupleSerialization(t *testing.T) {
 
 }
 
+// Strings that end in zeros, or hold zero bytes, read back exactly as written
+func TestTupleSerializationTrailingZeros(t *testing.T) {
+	td, _, _, _, _, _ := makeTestVars(t)
+	for _, s := range []string{"1000", "zip 90210", "0", "", "a\x00b\x00"} {
+		t1 := Tuple{Desc: td, Fields: []DBValue{StringField{s}, IntField{10}}}
+		b := new(bytes.Buffer)
+		if err := t1.writeTo(b); err != nil {
+			t.Fatalf("%s", err)
+		}
+		t2, err := readTupleFrom(b, &td)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !t2.equals(&t1) {
+			t.Errorf("expected %q, got %q", s, t2.Fields[0].(StringField).Value)
+		}
+	}
+}
+
 // Unit test for Tuple.compareField()
 func TestTupleExpr(t *testing.T) {
-	td, t1, t2, _, _, _ := makeTestVars()
+	td, t1, t2, _, _, _ := makeTestVars(t)
 	ft := td.Fields[0]
 	f := FieldExpr{ft}
 	result, err := t1.compareField(&t2, &f) // compare "sam" to "george jones"
@@ -63,7 +82,7 @@ func TestTupleExpr(t *testing.T) {
 
 // Unit test for Tuple.project()
 func TestTupleProject(t *testing.T) {
-	_, t1, _, _, _, _ := makeTestVars()
+	_, t1, _, _, _, _ := makeTestVars(t)
 	tNew, err := t1.project([]FieldType{t1.Desc.Fields[0]})
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -82,7 +101,7 @@ func TestTupleProject(t *testing.T) {
 
 // Unit test for Tuple.joinTuples()
 func TestTupleJoin(t *testing.T) {
-	_, t1, t2, _, _, _ := makeTestVars()
+	_, t1, t2, _, _, _ := makeTestVars(t)
 	tNew := joinTuples(&t1, &t2)
 	if len(tNew.Fields) != 4 {
 		t.Fatalf("unexpected number of fields after join")
@@ -141,7 +160,7 @@ func TestTupleDescEquals(t *testing.T) {
 	TDAssertEquals(t, intString2, intString)
 
 	stringInt := TupleDesc{Fields: []FieldType{{Ftype: StringType}, {Ftype: IntType}}}
-	_, t1, _, _, _, _ := makeTestVars()
+	_, t1, _, _, _, _ := makeTestVars(t)
 	TDAssertNotEquals(t, t1.Desc, stringInt) // diff in only Fname
 }
 
@@ -185,8 +204,8 @@ func TestTupleDescMerge(t *testing.T) {
 
 // Unit test for Tuple.equals()
 func TestTupleEquals(t *testing.T) {
-	_, t1, t2, _, _, _ := makeTestVars()
-	_, t1Dup, _, _, _, _ := makeTestVars()
+	_, t1, t2, _, _, _ := makeTestVars(t)
+	_, t1Dup, _, _, _, _ := makeTestVars(t)
 
 	var stringTup = Tuple{
 		Desc: TupleDesc{Fields: []FieldType{{Ftype: StringType}}},
@@ -1,6 +1,7 @@
 package godb
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
 	"strings"
@@ -24,6 +25,9 @@ const (
 	IllegalOperationError   GoDBErrorCode = iota
 	DeadlockError           GoDBErrorCode = iota
 	IllegalTransactionError GoDBErrorCode = iota
+	SerializationError      GoDBErrorCode = iota
+	NoSuchSavepointError    GoDBErrorCode = iota
+	NumericRangeError       GoDBErrorCode = iota
 )
 
 type GoDBError struct {
@@ -46,6 +50,12 @@ type Page interface {
 	isDirty() bool
 	setDirty(dirty bool)
 	getFile() *DBFile
+
+	//these methods are used by the write-ahead log
+	//to record before and after images of pages
+	toBuffer() (*bytes.Buffer, error)
+	getBeforeImage() []byte
+	setBeforeImage() error
 }
 
 type DBFile interface {
@@ -75,6 +85,9 @@ const (
 	OpEq   BoolOp = iota
 	OpNeq  BoolOp = iota
 	OpLike BoolOp = iota
+	// unary operators, which ignore their second operand
+	OpIsNull    BoolOp = iota
+	OpIsNotNull BoolOp = iota
 )
 
 var BoolOpMap = map[string]BoolOp{
@@ -86,9 +99,45 @@ var BoolOpMap = map[string]BoolOp{
 	"<>":   OpNeq,
 	"!=":   OpNeq,
 	"like": OpLike,
+	// IsExpr operators
+	"is null":     OpIsNull,
+	"is not null": OpIsNotNull,
+}
+
+// Truth is the value of a predicate in SQL's three-valued logic, in which a
+// comparison with NULL is neither true nor false, but unknown
+type Truth int
+
+const (
+	TruthFalse   Truth = iota
+	TruthTrue    Truth = iota
+	TruthUnknown Truth = iota
+)
+
+func truthOf(b bool) Truth {
+	if b {
+		return TruthTrue
+	}
+	return TruthFalse
+}
+
+// Apply op to v1 and v2, using getter to read the values to compare from them.
+// The result is unknown if either value is NULL, except for OpIsNull and
+// OpIsNotNull, which test whether v1 is NULL.
+func evalPred[T constraints.Ordered](v1 DBValue, v2 DBValue, op BoolOp, getter func(DBValue) T) Truth {
+	switch op {
+	case OpIsNull:
+		return truthOf(isNull(v1))
+	case OpIsNotNull:
+		return truthOf(!isNull(v1))
+	}
+	if isNull(v1) || isNull(v2) {
+		return TruthUnknown
+	}
+	return truthOf(compareValues(getter(v1), getter(v2), op))
 }
 
-func evalPred[T constraints.Ordered](i1 T, i2 T, op BoolOp) bool {
+func compareValues[T constraints.Ordered](i1 T, i2 T, op BoolOp) bool {
 	switch op {
 	case OpEq:
 		return i1 == i2
@@ -29,6 +29,7 @@ Available shell commands:
 	\d : List tables and fields in the current database
 	\f : List available functions for use in queries
 	\a : Toggle aligned vs csv output
+	\t : List running and recently finished transactions
 	\l table path/to/file [sep] [hasHeader]: Append csv file to end of table.  Default to sep = ',', hasHeader = 'true'`
 
 /*func printCatalog(fname string) {
@@ -49,6 +50,16 @@ Available shell commands:
 	f.Close()
 }*/
 
+// Return the position of the newest savepoint called name in stack
+func lastSavepoint(stack []string, name string) int {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
 func printCatalog(c *godb.Catalog) {
 	s := c.CatalogString()
 	fmt.Printf("\033[34m%s\n\033[0m", s)
@@ -90,6 +101,14 @@ func main() {
 		fmt.Printf("failed load catalog, %s", err.Error())
 		return
 	}
+	stopCheckpointer := bp.StartCheckpointer(godb.DefaultCheckpointLogSize, godb.DefaultCheckpointInterval)
+	defer func() {
+		if err := stopCheckpointer(); err != nil {
+			fmt.Printf("checkpoint failed, %s\n", err.Error())
+		}
+	}()
+	stopVacuum := bp.StartVacuum(godb.DefaultVacuumInterval)
+	defer stopVacuum()
 	rl, err := readline.New("> ")
 	if err != nil {
 		panic(err)
@@ -116,6 +135,19 @@ func main() {
 	query := ""
 	var autocommit bool = true
 	var tid godb.TransactionID
+	// the isolation level of transactions started from now on
+	isolation := godb.DefaultIsolationLevel
+	// the names of the savepoints of the current transaction, oldest first
+	var savepoints []string
+	// Abort tid, the transaction of a statement run in autocommit mode.  If
+	// the abort fails, the transaction is still running, so it is kept open,
+	// as if it had been begun with BEGIN, for ABORT to retry.
+	abortStatement := func() {
+		if err := bp.AbortTransaction(tid); err != nil {
+			fmt.Printf("\033[31;1m%s; the transaction is still open, ABORT it again\033[0m\n", err.Error())
+			autocommit = false
+		}
+	}
 	aligned := true
 	for {
 
@@ -171,6 +203,11 @@ func main() {
 					fmt.Println("Output unaligned")
 				}
 
+			case 't':
+				for _, info := range godb.DefaultTransactionManager.Transactions() {
+					fmt.Printf("\033[34m  %d\t%s\t%s\n\033[0m", info.ID, info.Status, info.Start.Format(time.RFC3339))
+				}
+
 			case '?':
 				fallthrough
 			case 'h':
@@ -227,6 +264,7 @@ func main() {
 
 		queryType, plan, err := godb.Parse(c, query)
 		//fmt.Println(query)
+		parsed := query
 		query = ""
 		nresults := 0
 
@@ -266,13 +304,16 @@ func main() {
 			}
 			if autocommit {
 				tid = godb.NewTID()
-				bp.BeginTransaction(tid)
+				bp.BeginTransaction(tid, godb.WithIsolationLevel(isolation))
 			}
 			start := time.Now()
 
 			iter, err := plan.Iterator(tid)
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				if autocommit {
+					abortStatement()
+				}
 				continue
 			}
 
@@ -282,7 +323,10 @@ func main() {
 				tup, err := iter()
 				if err != nil {
 					fmt.Printf("%s\n", err.Error())
-					break
+					if autocommit {
+						abortStatement()
+					}
+					goto outer
 				}
 				if tup == nil {
 					break
@@ -293,13 +337,21 @@ func main() {
 				select {
 				case <-alarm:
 					fmt.Println("Aborting")
+					if autocommit {
+						abortStatement()
+					}
 					goto outer
 				default:
 
 				}
 			}
 			if autocommit {
-				bp.CommitTransaction(tid)
+				if err := bp.CommitTransaction(tid); err != nil {
+					// keep the transaction open, as if it had been begun
+					// with BEGIN, for COMMIT to retry or ABORT to end
+					fmt.Printf("\033[31;1m%s; the transaction is still open, COMMIT or ABORT it\033[0m\n", err.Error())
+					autocommit = false
+				}
 			}
 		outer:
 			fmt.Printf("\033[32;1m(%d results)\033[0m\n", nresults)
@@ -310,8 +362,12 @@ func main() {
 			if !autocommit {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot start transaction while in transaction")
 			} else {
+				level := isolation
+				if l, ok := godb.ParseIsolationLevel(parsed); ok {
+					level = l
+				}
 				tid = godb.NewTID()
-				bp.BeginTransaction(tid)
+				bp.BeginTransaction(tid, godb.WithIsolationLevel(level))
 				autocommit = false
 				fmt.Printf("\033[32;1mBEGIN\033[0m\n\n")
 			}
@@ -319,8 +375,13 @@ func main() {
 			if autocommit {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot abort transaction unless in transaction")
 			} else {
-				bp.AbortTransaction(tid)
+				if err := bp.AbortTransaction(tid); err != nil {
+					// the transaction is still running; ABORT may be retried
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					continue
+				}
 				autocommit = true
+				savepoints = nil
 				fmt.Printf("\033[32;1mABORT\033[0m\n\n")
 			}
 
@@ -328,8 +389,14 @@ func main() {
 			if autocommit {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot commit transaction unless in transaction")
 			} else {
-				bp.CommitTransaction(tid)
+				if err := bp.CommitTransaction(tid); err != nil {
+					// the transaction is still running; COMMIT may be
+					// retried, or the transaction aborted
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					continue
+				}
 				autocommit = true
+				savepoints = nil
 				fmt.Printf("\033[32;1mCOMMIT\033[0m\n\n")
 			}
 		case godb.CreateTableQueryType:
@@ -344,6 +411,52 @@ func main() {
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 			}
+		case godb.CreateIndexQueryType:
+			fmt.Printf("\033[32;1mCREATE INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.DropIndexQueryType:
+			fmt.Printf("\033[32;1mDROP INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.CheckpointQueryType:
+			fmt.Printf("\033[32;1mCHECKPOINT\033[0m\n\n")
+		case godb.SavepointType, godb.RollbackToType, godb.ReleaseSavepointType:
+			if autocommit {
+				fmt.Printf("\033[31;1m%s\033[0m\n", "Savepoints can only be used in a transaction")
+				break
+			}
+			name, _ := godb.ParseSavepointName(parsed)
+			var err error
+			switch queryType {
+			case godb.SavepointType:
+				if err = bp.Savepoint(tid, name); err == nil {
+					savepoints = append(savepoints, name)
+					fmt.Printf("\033[32;1mSAVEPOINT\033[0m\n\n")
+				}
+			case godb.RollbackToType:
+				if err = bp.RollbackToSavepoint(tid, name); err == nil {
+					savepoints = savepoints[:lastSavepoint(savepoints, name)+1]
+					fmt.Printf("\033[32;1mROLLBACK\033[0m\n\n")
+				}
+			case godb.ReleaseSavepointType:
+				if err = bp.ReleaseSavepoint(tid, name); err == nil {
+					savepoints = savepoints[:lastSavepoint(savepoints, name)]
+					fmt.Printf("\033[32;1mRELEASE\033[0m\n\n")
+				}
+			}
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			} else if len(savepoints) > 0 {
+				fmt.Printf("\033[32mSavepoints: %s\033[0m\n\n", strings.Join(savepoints, ", "))
+			}
+		case godb.SetIsolationType:
+			isolation, _ = godb.ParseIsolationLevel(parsed)
+			fmt.Printf("\033[32;1mSET %s\033[0m\n\n", isolation)
 		}
 
 	}